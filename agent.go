@@ -3,109 +3,694 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	ollama "github.com/ollama/ollama/api"
 )
 
 const defaultModel = "qwen3"
 
+const (
+	defaultMaxTokens = 4096   // Used when no max tokens is configured
+	maxMaxTokens     = 131072 // Largest max tokens allowed
+)
+
+// agentConfig holds the user-configurable generation settings.
+type agentConfig struct {
+	SystemPrompt string   // Default system prompt for chats without their own
+	Temperature  *float64 // Sampling temperature (0-1); model default if nil
+	TopP         *float64 // Nucleus sampling probability (0-1); model default if nil
+	MaxTokens    int      // Most tokens to generate per response (defaultMaxTokens if 0)
+	LLMTitles    bool     // Ask the model to title new chats (rather than using the first few words)
+	ReadOnly     bool     // Only give the model tools that read the graph
+	Offline      bool     // Don't connect to a model at all (chats and the graph can still be browsed)
+	EmbedModel   string   // Model used to embed text for semantic search
+	BaseURL      string   // Ollama server URL (falls back to $OLLAMA_HOST, then the default)
+	RAGTopK      int      // Number of relevant nodes to add to each request (0 disables)
+	Debug        bool     // Store the model's raw responses on messages
+	ExpandEnv    bool     // Expand ${AGNT_VAR_*} references in props in tool results
+	ChatTools    bool     // Let the model create and list chats
+	DryRun       bool     // Don't run write tools; just tell the model what they'd have done
+	Stream       bool     // Stream responses, running each tool call as soon as it arrives
+	ConfirmDel   bool     // Wait for the user to approve the model's deletes (showing what they'd remove)
+	AllowedTools []string // Only let the model use these tools (all of them if nil)
+
+	RequestTimeout time.Duration // Longest to wait for each model request (0 waits forever)
+	RateLimit      int           // Most chat requests to send the model per minute (0 is unlimited)
+	AutoTruncate   bool          // Drop the oldest messages of chats too long for the model, instead of failing
+
+	CompactThreshold int // Summarize old messages once a chat has more than this many (0 disables)
+	CompactKeep      int // Number of recent messages to leave out of the summary
+}
+
+func (cfg agentConfig) validate() error {
+	if t := cfg.Temperature; t != nil && (*t < 0 || *t > 1) {
+		return fmt.Errorf("temperature must be between 0 and 1, got %v", *t)
+	}
+	if p := cfg.TopP; p != nil && (*p < 0 || *p > 1) {
+		return fmt.Errorf("top_p must be between 0 and 1, got %v", *p)
+	}
+	if cfg.MaxTokens < 0 || cfg.MaxTokens > maxMaxTokens {
+		return fmt.Errorf("max tokens must be between 0 and %d, got %d", maxMaxTokens, cfg.MaxTokens)
+	}
+	if cfg.BaseURL != "" {
+		if _, err := parseBaseURL(cfg.BaseURL); err != nil {
+			return err
+		}
+	}
+	if cfg.RequestTimeout < 0 {
+		return fmt.Errorf("request timeout must not be negative, got %s", cfg.RequestTimeout)
+	}
+	if cfg.RateLimit < 0 {
+		return fmt.Errorf("rate limit must not be negative, got %d", cfg.RateLimit)
+	}
+	if cfg.RAGTopK < 0 {
+		return fmt.Errorf("rag k must not be negative, got %d", cfg.RAGTopK)
+	}
+	return nil
+}
+
+// maxGenerateSteps caps how many tool calls a single request to
+// generate (from the TUI's workers or the server) will run before
+// handing back.
+const maxGenerateSteps = 10
+
+// errTooManySteps is returned when the model keeps calling
+// tools past maxGenerateSteps without replying.
+var errTooManySteps = fmt.Errorf("stopped after %d tool calls without a reply", maxGenerateSteps)
+
+// genRequest asks the worker to generate a response for a chat.
+type genRequest struct {
+	cid   int
+	model string // Model to use for this generation only (the default if empty)
+}
+
 type agent struct {
-	ol *ollama.Client
-	c  *client
-	gc chan struct{ cid int }
+	ol  atomic.Pointer[ollama.Client] // Connected client (nil while offline)
+	c   *client
+	cfg agentConfig
+	log *slog.Logger
+	gc  chan genRequest
+
+	tools map[string]Tool // Tools the model can call, by name
+	limit *rateLimiter    // Paces chat requests (shared by all the workers)
+
+	mu      sync.Mutex
+	cancels map[int]context.CancelFunc // In-flight generations, by chat ID
+
+	retryMu   sync.Mutex
+	retry     *ollama.Client // Client to reconnect with, if ollama wasn't running at startup
+	lastRetry time.Time      // When reconnecting was last tried
 }
 
-func newAgent(ctx context.Context, c *client) (*agent, error) {
-	// Connect to the ollama client
-	ol, err := ollama.ClientFromEnvironment()
+func newAgent(ctx context.Context, c *client, cfg agentConfig, log *slog.Logger) (*agent, error) {
+	// Validate the config
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid agent config: %w", err)
+	}
+
+	a := &agent{
+		c:   c,
+		cfg: cfg,
+		log: log,
+		gc:  make(chan genRequest),
+
+		tools:   make(map[string]Tool),
+		limit:   newRateLimiter(cfg.RateLimit, 1), // Spread requests out evenly
+		cancels: make(map[int]context.CancelFunc),
+	}
+
+	// Connect to ollama, unless we're staying offline. If it isn't
+	// running, go offline rather than failing.
+	if !cfg.Offline {
+		ol, err := newOllamaClient(cfg.BaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to ollama: %w", err)
+		}
+		if err := ol.Heartbeat(ctx); err != nil {
+			log.Warn("ollama is not running, continuing offline", "error", err)
+			a.retry, a.lastRetry = ol, time.Now()
+		} else {
+			a.ol.Store(ol)
+		}
+	}
+
+	// Register the built-in tools
+	for _, t := range a.graphTools() {
+		a.registerTool(t)
+	}
+	if cfg.ChatTools {
+		for _, t := range a.chatTools() {
+			a.registerTool(t)
+		}
+	}
+	for _, t := range a.askTools() {
+		a.registerTool(t)
+	}
+
+	// Catch typos in the allowlist
+	known := map[string]bool{}
+	for _, t := range slices.Concat(a.graphTools(), a.chatTools(), a.askTools()) {
+		known[t.Name] = true
+	}
+	for _, name := range cfg.AllowedTools {
+		if !known[name] {
+			return nil, fmt.Errorf("invalid agent config: unknown tool %q in allowed tools", name)
+		}
+	}
+	return a, nil
+}
+
+// newOllamaClient creates an ollama client for the server at baseURL,
+// or the one set by $OLLAMA_HOST if baseURL is empty. Requests go
+// through the proxy set by $HTTPS_PROXY/$HTTP_PROXY (if any).
+func newOllamaClient(baseURL string) (*ollama.Client, error) {
+	if baseURL == "" {
+		return ollama.ClientFromEnvironment()
+	}
+	u, err := parseBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = http.ProxyFromEnvironment
+	return ollama.NewClient(u, &http.Client{Transport: tr}), nil
+}
+
+// parseBaseURL parses and checks a server URL.
+func parseBaseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to ollama: %w", err)
+		return nil, fmt.Errorf("invalid ollama URL %q: %w", s, err)
 	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid ollama URL %q: must be an http(s) URL with a host", s)
+	}
+	return u, nil
+}
+
+// errOffline is returned when something needs the model
+// but the agent is running offline.
+var errOffline = errors.New("no model configured (running offline)")
+
+// errBusy is wrapped by the error returned when asked to
+// generate for a chat that's already generating.
+var errBusy = errors.New("is already generating")
+
+// reconnectInterval is how long the agent waits between tries to
+// reconnect to ollama, and reconnectTimeout how long each try waits.
+const (
+	reconnectInterval = 10 * time.Second
+	reconnectTimeout  = 2 * time.Second
+)
 
-	// Check that ollama is running
-	if err := ol.Heartbeat(ctx); err != nil {
-		return nil, fmt.Errorf("ollama is not running: %w", err)
+// online reports whether the agent is connected to a model. If
+// ollama wasn't running at startup, it tries to reconnect first
+// (at most once every reconnectInterval).
+func (a *agent) online() bool {
+	if a.ol.Load() != nil {
+		return true
 	}
-	return &agent{
-		ol: ol,
-		c:  c,
-		gc: make(chan struct{ cid int }),
-	}, nil
+	return a.reconnect()
 }
 
-func (a *agent) getChatHistory(cid int) ([]ollama.Message, error) {
+// reconnect tries to connect to ollama again, if it wasn't running
+// at startup and it's been long enough since the last try.
+func (a *agent) reconnect() bool {
+	if a.retry == nil {
+		return false // Offline on purpose
+	}
+	a.retryMu.Lock()
+	defer a.retryMu.Unlock()
+	if a.ol.Load() != nil {
+		return true // Another caller got there first
+	}
+	if time.Since(a.lastRetry) < reconnectInterval {
+		return false
+	}
+	a.lastRetry = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), reconnectTimeout)
+	defer cancel()
+	if err := a.retry.Heartbeat(ctx); err != nil {
+		a.log.Debug("ollama is still not running", "error", err)
+		return false
+	}
+	a.log.Info("reconnected to ollama")
+	a.ol.Store(a.retry)
+	return true
+}
+
+// requestContext returns the context for a single request to the
+// model, which is cancelled once the request timeout is up.
+func (a *agent) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.cfg.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, a.cfg.RequestTimeout)
+}
+
+// cancel aborts the in-flight generation for a chat, if there is one,
+// and reports whether anything was cancelled.
+func (a *agent) cancel(cid int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cancel, ok := a.cancels[cid]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// work runs n workers generating responses for the requests sent on
+// the agent's queue, calling done with the result of each request. Each worker handles one
+// chat at a time, carrying on through the model's tool calls until it
+// replies (see run), and a request for a chat that's already
+// generating is dropped. It returns once ctx is done and every worker
+// has finished what it was doing.
+func (a *agent) work(ctx context.Context, n int, done func(genRequest, error)) {
+	var wg sync.WaitGroup
+	for range max(n, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case g := <-a.gc:
+					a.log.Debug("got generate msg in channel", "chat", g.cid)
+					err := a.run(ctx, g)
+					if errors.Is(err, errBusy) || errors.Is(err, errAwaitingAnswer) || errors.Is(err, errAwaitingApproval) {
+						a.log.Debug("dropped generate msg", "chat", g.cid, "reason", err)
+						continue
+					}
+					if err != nil {
+						a.log.Error("failed to generate response", "chat", g.cid, "error", err)
+						a.recordError(g.cid, err)
+					}
+					a.log.Debug("completed generate msg in channel", "chat", g.cid)
+					done(g, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// run generates a chat's response, carrying on through the model's
+// tool calls until it replies, a tool call waits on the user, or
+// maxGenerateSteps calls have been made. Every step uses g's model.
+func (a *agent) run(ctx context.Context, g genRequest) error {
+	for i := range maxGenerateSteps {
+		m, err := a.generate(ctx, g.cid, g.model)
+		if err != nil {
+			// Stopping to wait on the user part way through is fine
+			if i > 0 && (errors.Is(err, errAwaitingAnswer) || errors.Is(err, errAwaitingApproval)) {
+				return nil
+			}
+			return err
+		}
+		if m.MType != "tool" {
+			return nil
+		}
+	}
+	return errTooManySteps
+}
+
+// recordError adds a message to a chat saying generation failed, so
+// the failure shows up in the chat's history. Cancellations (which the
+// user asked for) and requests for busy chats (or ones waiting on the
+// user) aren't recorded.
+func (a *agent) recordError(cid int, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, errBusy) || errors.Is(err, errAwaitingAnswer) || errors.Is(err, errAwaitingApproval) {
+		return
+	}
+	if _, err := a.c.CreateMessage(Message{
+		ChatID:   cid,
+		MType:    "error",
+		ErrorMsg: &struct{ Text string }{Text: err.Error()},
+	}); err != nil {
+		a.log.Error("failed to record generation error", "chat", cid, "error", err)
+	}
+}
+
+// options returns the model options to send with a chat request,
+// leaving out any settings that weren't configured.
+func (a *agent) options() map[string]any {
+	opts := map[string]any{"num_predict": defaultMaxTokens}
+	if a.cfg.MaxTokens > 0 {
+		opts["num_predict"] = a.cfg.MaxTokens
+	}
+	if a.cfg.Temperature != nil {
+		opts["temperature"] = *a.cfg.Temperature
+	}
+	if a.cfg.TopP != nil {
+		opts["top_p"] = *a.cfg.TopP
+	}
+	return opts
+}
+
+// getChatHistory returns a chat's messages as they're sent to the
+// model, along with which of them are pinned (see fitContext).
+func (a *agent) getChatHistory(cid int) ([]ollama.Message, []bool, error) {
 	// Get the messages in the chat
 	ms, err := a.c.ListMessages(cid)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list messages: %w", err)
+		return nil, nil, fmt.Errorf("failed to list messages: %w", err)
 	}
 
-	// Convert them to ollama messages
-	var hs []ollama.Message
+	// Convert them to ollama messages. Summaries of compacted
+	// messages go first, since they cover the oldest part of the chat.
+	// Pinned messages are kept even once they've been compacted.
+	var sums, hs []ollama.Message
+	var pins []bool
 	for _, m := range ms {
+		if m.Compacted && !m.Pinned {
+			continue
+		}
+		if !m.Valid() {
+			a.log.Warn("skipping malformed message", "chat", cid, "message", m.MessageID, "type", m.MType)
+			continue
+		}
 		switch m.MType {
 		case "user":
 			hs = append(hs, ollama.Message{
 				Role:    "user",
 				Content: m.UserMsg.Text,
 			})
+			pins = append(pins, m.Pinned)
 		case "agent":
 			hs = append(hs, ollama.Message{
 				Role:    "assistant",
 				Content: m.AgentMsg.Text,
 			})
+			pins = append(pins, m.Pinned)
 		case "tool":
 			// NOTE: Tool calls internally are one message
 			// but to ollama they're two – the agent's call
-			// and the tool's response.
+			// and the tool's response. Both come from the
+			// same record and are emitted back-to-back, so
+			// each result always follows its own call.
 			hs = append(hs, ollama.Message{
 				Role: "assistant",
 				ToolCalls: []ollama.ToolCall{
 					{Function: ollama.ToolCallFunction{
-						Index:     0, // TODO: Change this?
+						Index:     0, // Only ever one call per message
 						Name:      m.ToolMsg.ToolName,
 						Arguments: m.ToolMsg.ToolArgs,
 					}},
 				},
 			})
+			// Errors go back to the model too, so it can recover
+			// (e.g. from calling a tool that doesn't exist)
+			result := m.ToolMsg.ToolResult
+			if m.ToolMsg.ToolError != "" {
+				result = "Error: " + m.ToolMsg.ToolError
+			}
 			hs = append(hs, ollama.Message{
 				Role:    "tool",
-				Content: m.ToolMsg.ToolResult,
+				Content: result,
+			})
+			pins = append(pins, m.Pinned, m.Pinned) // The call and its result
+		case "error":
+			// Only shown to the user
+			continue
+		case "summary":
+			sums = append(sums, ollama.Message{
+				Role:    "system",
+				Content: "Summary of the earlier conversation:\n" + m.SummaryMsg.Text,
 			})
 		default:
-			return nil, fmt.Errorf("unknown message type %q", m.MType)
+			return nil, nil, fmt.Errorf("unknown message type %q", m.MType)
+		}
+	}
+	return append(sums, hs...), append(make([]bool, len(sums)), pins...), nil
+}
+
+// systemPrompt returns the system prompt for a chat, falling back
+// to the default when the chat doesn't set its own.
+func (a *agent) systemPrompt(cid int) (string, error) {
+	ci, err := a.c.GetChat(cid)
+	if err != nil {
+		return "", fmt.Errorf("failed to get chat: %w", err)
+	}
+	if ci.SystemPrompt != "" {
+		return ci.SystemPrompt, nil
+	}
+	return a.cfg.SystemPrompt, nil
+}
+
+// maybeTitleChat renames a chat that still has the placeholder name
+// based on its first user message. Failures are logged, not returned,
+// since a missing title shouldn't block generation.
+func (a *agent) maybeTitleChat(ctx context.Context, cid int) {
+	ci, err := a.c.GetChat(cid)
+	if err != nil {
+		a.log.Error("failed to get chat for titling", "chat", cid, "error", err)
+		return
+	}
+	if !ci.Untitled {
+		return
+	}
+
+	// Only title once the first user message is in
+	ms, err := a.c.ListMessagesByType(cid, "user")
+	if err != nil {
+		a.log.Error("failed to list messages for titling", "chat", cid, "error", err)
+		return
+	}
+	if len(ms) != 1 || ms[0].UserMsg == nil {
+		return
+	}
+	text := ms[0].UserMsg.Text
+
+	// Try the model first (if enabled), then fall back to the heuristic
+	var title string
+	if a.cfg.LLMTitles {
+		if title, err = a.generateTitle(ctx, text); err != nil {
+			a.log.Warn("failed to generate chat title", "chat", cid, "error", err)
 		}
 	}
-	return hs, nil
+	if title == "" {
+		title = titleFromText(text)
+	}
+	if title == "" {
+		return
+	}
+	if err := a.c.RenameChat(cid, title); err != nil {
+		a.log.Error("failed to rename chat", "chat", cid, "error", err)
+	}
 }
 
-func (a *agent) generate(ctx context.Context, cid int, onupdate func()) (*Message, error) {
+// generateTitle asks the model for a short title for a conversation
+// that starts with the given text.
+func (a *agent) generateTitle(ctx context.Context, text string) (string, error) {
+	if err := a.limit.Wait(ctx); err != nil {
+		return "", err
+	}
+	ctx, cancel := a.requestContext(ctx)
+	defer cancel()
+
+	var title string
+	if err := a.ol.Load().Chat(ctx, &ollama.ChatRequest{
+		Model: defaultModel,
+		Messages: []ollama.Message{
+			{
+				Role:    "system",
+				Content: "Write a short title (at most 6 words) for a conversation that starts with the user's message. Reply with only the title.",
+			},
+			{Role: "user", Content: text},
+		},
+		Stream: new(bool), // Always false
+	}, func(resp ollama.ChatResponse) error {
+		title += resp.Message.Content
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimSpace(title), `"'`), nil
+}
+
+// titleFromText builds a chat title from the first few words of text.
+func titleFromText(text string) string {
+	const maxWords = 6
+	ws := strings.Fields(text)
+	if len(ws) > maxWords {
+		return strings.Join(ws[:maxWords], " ") + "..."
+	}
+	return strings.Join(ws, " ")
+}
+
+// lockChat marks a chat as busy until the returned func is called,
+// so only one generation (or approval) runs for it at a time. Stopping
+// the chat calls cancel.
+func (a *agent) lockChat(cid int, cancel context.CancelFunc) (func(), error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.cancels[cid]; ok {
+		return nil, fmt.Errorf("chat %d %w", cid, errBusy)
+	}
+	a.cancels[cid] = cancel
+	return func() {
+		a.mu.Lock()
+		delete(a.cancels, cid)
+		a.mu.Unlock()
+	}, nil
+}
+
+// generate gets the model's next response in a chat. If model isn't
+// empty it's used instead of the default, for this response only.
+func (a *agent) generate(ctx context.Context, cid int, model string) (*Message, error) {
+	if !a.online() {
+		return nil, errOffline
+	}
+	if model == "" {
+		model = defaultModel
+	}
+
+	// Make this generation cancelable on its own, and
+	// make sure it's the only one running for the chat
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	unlock, err := a.lockChat(cid, cancel)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Don't carry on until the user has answered the agent's
+	// question (or approved its delete)
+	ms, err := a.c.ListMessages(cid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	if _, ok := pendingQuestion(ms); ok {
+		return nil, fmt.Errorf("chat %d %w", cid, errAwaitingAnswer)
+	}
+	if _, ok := pendingApproval(ms); ok {
+		return nil, fmt.Errorf("chat %d %w", cid, errAwaitingApproval)
+	}
+
+	// Mark the chat as running until we're done
+	if err := a.c.SetChatState(cid, "running"); err != nil {
+		return nil, fmt.Errorf("failed to set chat state: %w", err)
+	}
+	defer func() {
+		if err := a.c.SetChatState(cid, "idle"); err != nil {
+			a.log.Error("failed to reset chat state", "chat", cid, "error", err)
+		}
+	}()
+
+	// Work on the chat's own graph, if it has one
+	g, err := a.c.ForChat(cid)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, graphKey{}, g)
+
+	// Give the chat a title, if it still needs one
+	a.maybeTitleChat(ctx, cid)
+
+	// Summarize old messages if the chat is getting long
+	if err := a.maybeCompact(ctx, cid); err != nil {
+		return nil, fmt.Errorf("failed to compact chat: %w", err)
+	}
+
 	// Get the previous messages from the conversation
-	h, err := a.getChatHistory(cid)
+	h, pinned, err := a.getChatHistory(cid)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get messages: %w", err)
 	}
 
-	// Generate a response using ollama
+	// Add the system prompt, if there is one
+	sp, err := a.systemPrompt(cid)
+	if err != nil {
+		return nil, err
+	}
+	if sp != "" {
+		h = append([]ollama.Message{{Role: "system", Content: sp}}, h...)
+		pinned = append([]bool{false}, pinned...)
+	}
+
+	// Add any relevant nodes from the graph. Like titling, this is
+	// best-effort and shouldn't block generation.
+	if rc, err := a.ragContext(ctx, h); err != nil {
+		a.log.Warn("failed to retrieve graph context", "chat", cid, "error", err)
+	} else if rc != nil {
+		h = append([]ollama.Message{*rc}, h...)
+		pinned = append([]bool{false}, pinned...)
+	}
+
+	// Make sure it fits in the model's context window, rather
+	// than letting the model reject (or silently cut) it
+	tools := a.getTools(ctx)
+	h, dropped, err := fitContext(model, h, pinned, tools, a.cfg.AutoTruncate)
+	if err != nil {
+		return nil, err
+	}
+	if dropped > 0 {
+		a.log.Warn("truncated chat to fit the context window", "chat", cid, "model", model, "dropped", dropped)
+	}
+
+	// Generate a response using ollama (once the rate limit allows)
+	if err := a.limit.Wait(ctx); err != nil {
+		return nil, err
+	}
+	rctx, rcancel := a.requestContext(ctx)
+	defer rcancel()
 	var m *Message
-	if err := a.ol.Chat(ctx, &ollama.ChatRequest{
-		Model:    defaultModel,
+	var stream *responseStream
+	if a.cfg.Stream {
+		stream = &responseStream{a: a, ctx: ctx, cid: cid, model: model}
+	}
+	if err := a.ol.Load().Chat(rctx, &ollama.ChatRequest{
+		Model:    model,
 		Messages: h,
-		Stream:   new(bool), // Always false
-		Tools:    a.getTools(),
+		Stream:   &a.cfg.Stream,
+		Tools:    tools,
+		Options:  a.options(),
 	}, func(resp ollama.ChatResponse) error {
-		// Trigger when done
-		defer onupdate()
+		// Streaming? Then the stream handles it (and any tool calls).
+		if stream != nil {
+			err := stream.add(resp)
+			m = stream.last
+			return err
+		}
+
+		// Keep the raw response around, if we're debugging
+		var raw json.RawMessage
+		if a.cfg.Debug {
+			var err error
+			if raw, err = json.Marshal(resp); err != nil {
+				return fmt.Errorf("failed to marshal raw response: %w", err)
+			}
+		}
 
 		// Has the message already been created? Then update it.
 		if m != nil && m.MType == "agent" {
 			m.AgentMsg.Text += resp.Message.Content
+			m.InputTokens += resp.PromptEvalCount
+			m.OutputTokens += resp.EvalCount
+			if resp.Done {
+				m.AgentMsg.StopReason = resp.DoneReason
+			}
+			if raw != nil {
+				m.RawMeta = raw
+			}
 			return a.c.UpdateMessage(*m)
 		}
 		if m != nil && m.MType == "tool" {
-			fmt.Fprintf(os.Stderr, "Getting tool call update: %#v\n", resp)
+			a.log.Debug("got tool call update", "chat", cid, "response", resp)
 			// m.ToolMsg.ToolName += resp.Message.Content
 			// return a.c.UpdateMessage(*m)
 		}
@@ -115,24 +700,44 @@ func (a *agent) generate(ctx context.Context, cid int, onupdate func()) (*Messag
 			ChatID: cid,
 			// MessageID: 0, // Intentionally not set
 			MType: "agent",
-			AgentMsg: &struct{ Text string }{
+			AgentMsg: &struct {
+				Text         string
+				StopReason   string
+				CitedNodeIDs []int `json:",omitempty"`
+			}{
 				Text: resp.Message.Content,
 			},
+			InputTokens:  resp.PromptEvalCount,
+			OutputTokens: resp.EvalCount,
+			Model:        model,
+			RawMeta:      raw,
 		}
 		if len(resp.Message.ToolCalls) > 0 {
-			fmt.Fprintln(os.Stderr, "Creating tool call: "+resp.Message.ToolCalls[0].Function.Name)
+			a.log.Debug("creating tool call", "chat", cid, "tool", resp.Message.ToolCalls[0].Function.Name)
 			m.MType = "tool"
 			m.ToolMsg = &struct {
-				ToolDone   bool
-				ToolName   string
-				ToolArgs   map[string]any
-				ToolResult string
-				ToolError  string
+				ToolDone    bool
+				ToolName    string
+				ToolArgs    map[string]any
+				ToolResult  string
+				ToolError   string
+				ToolPreview string `json:",omitempty"`
 			}{
 				ToolDone: resp.Done,
 				ToolName: resp.Message.ToolCalls[0].Function.Name,
 				ToolArgs: resp.Message.ToolCalls[0].Function.Arguments,
 			}
+		} else {
+			if resp.Done {
+				m.AgentMsg.StopReason = resp.DoneReason
+			}
+
+			// Note which nodes the reply drew on
+			ms, err := a.c.ListMessages(cid)
+			if err != nil {
+				return fmt.Errorf("failed to list messages: %w", err)
+			}
+			m.AgentMsg.CitedNodeIDs = citedNodeIDs(ms, m.AgentMsg.Text)
 		}
 
 		// Create the message
@@ -143,411 +748,57 @@ func (a *agent) generate(ctx context.Context, cid int, onupdate func()) (*Messag
 		m = msg
 		return nil
 	}); err != nil {
+		// Cancelled (or timed out) part way through? Don't leave
+		// a partial message behind. (A stream's tool calls were
+		// complete, and are rolled back if they didn't finish.)
+		if stream != nil && rctx.Err() != nil {
+			a.rollback(cid)
+		}
+		if rctx.Err() != nil && m != nil && (stream == nil || m.MType == "agent") {
+			if err := a.c.DeleteMessage(cid, m.MessageID); err != nil {
+				a.log.Error("failed to delete partial message", "chat", cid, "error", err)
+			}
+		}
+		if ctx.Err() == nil && errors.Is(rctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("model didn't respond within %s: %w", a.cfg.RequestTimeout, rctx.Err())
+		}
 		return nil, fmt.Errorf("failed to generate response: %w", err)
 	}
+	if m == nil {
+		return nil, fmt.Errorf("no response from model")
+	}
+
+	// Cancelled before the tool could run? Stop here, without
+	// leaving the call behind.
+	if err := ctx.Err(); err != nil {
+		a.rollback(cid)
+		return nil, fmt.Errorf("generation cancelled: %w", err)
+	}
 
-	// Handle the tool call
-	if m.MType == "tool" && m.ToolMsg.ToolDone {
-		fmt.Fprintln(os.Stderr, "Calling the tool")
+	// Handle the tool call (unless the stream already did)
+	if stream == nil && m.MType == "tool" && m.ToolMsg.ToolDone {
+		a.log.Debug("calling the tool", "chat", cid, "tool", m.ToolMsg.ToolName)
 		// NOTE: This will update the message in the client
-		if err := a.handleToolCall(m); err != nil {
+		if err := a.handleToolCall(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				a.rollback(cid)
+			}
 			return nil, fmt.Errorf("failed to handle tool call: %w", err)
 		}
 	}
 	return m, nil
 }
 
-func (a *agent) getTools() []ollama.Tool {
-	return []ollama.Tool{
-		{
-			Type: "function",
-			Function: ollama.ToolFunction{
-				Name:        "get_node",
-				Description: "Retrieves a single graph node by its ID. Returns the node's ID, type, and properties.",
-				Parameters: struct {
-					Type       string   `json:"type"`
-					Defs       any      `json:"$defs,omitempty"`
-					Items      any      `json:"items,omitempty"`
-					Required   []string `json:"required"`
-					Properties map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					} `json:"properties"`
-				}{
-					Type:     "object",
-					Required: []string{"id"},
-					Properties: map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					}{
-						"id": {
-							Type:        []string{"integer"},
-							Description: "The unique identifier of the node to retrieve.",
-						},
-					},
-				},
-			},
-		},
-		{
-			Type: "function",
-			Function: ollama.ToolFunction{
-				Name:        "list_nodes",
-				Description: "Lists all graph nodes of a specific type. If no type is provided, returns all nodes.",
-				Parameters: struct {
-					Type       string   `json:"type"`
-					Defs       any      `json:"$defs,omitempty"`
-					Items      any      `json:"items,omitempty"`
-					Required   []string `json:"required"`
-					Properties map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					} `json:"properties"`
-				}{
-					Type: "object",
-					Properties: map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					}{
-						"node_type": {
-							Type:        []string{"string"},
-							Description: "The type of nodes to list. If empty, all nodes will be returned.",
-						},
-					},
-				},
-			},
-		},
-		{
-			Type: "function",
-			Function: ollama.ToolFunction{
-				Name:        "create_node",
-				Description: "Creates a new graph node with the specified type and properties. Returns the created node with its assigned ID.",
-				Parameters: struct {
-					Type       string   `json:"type"`
-					Defs       any      `json:"$defs,omitempty"`
-					Items      any      `json:"items,omitempty"`
-					Required   []string `json:"required"`
-					Properties map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					} `json:"properties"`
-				}{
-					Type:     "object",
-					Required: []string{"type"},
-					Properties: map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					}{
-						"type": {
-							Type:        []string{"string"},
-							Description: "The type of the node to create. For example, 'person', 'document', etc.",
-						},
-						"props": {
-							Type:        []string{"object"},
-							Description: "A map of properties to store with the node. For example, {\"name\": \"John\", \"age\": 30}.",
-						},
-					},
-				},
-			},
-		},
-		{
-			Type: "function",
-			Function: ollama.ToolFunction{
-				Name:        "delete_node",
-				Description: "Deletes a graph node by its ID. Note that this will also delete all edges connected to this node.",
-				Parameters: struct {
-					Type       string   `json:"type"`
-					Defs       any      `json:"$defs,omitempty"`
-					Items      any      `json:"items,omitempty"`
-					Required   []string `json:"required"`
-					Properties map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					} `json:"properties"`
-				}{
-					Type:     "object",
-					Required: []string{"id"},
-					Properties: map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					}{
-						"id": {
-							Type:        []string{"integer"},
-							Description: "The unique identifier of the node to delete.",
-						},
-					},
-				},
-			},
-		},
-		{
-			Type: "function",
-			Function: ollama.ToolFunction{
-				Name:        "get_edge",
-				Description: "Retrieves a single graph edge by its ID. Returns the edge's ID, type, and the IDs of its connected nodes.",
-				Parameters: struct {
-					Type       string   `json:"type"`
-					Defs       any      `json:"$defs,omitempty"`
-					Items      any      `json:"items,omitempty"`
-					Required   []string `json:"required"`
-					Properties map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					} `json:"properties"`
-				}{
-					Type:     "object",
-					Required: []string{"id"},
-					Properties: map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					}{
-						"id": {
-							Type:        []string{"integer"},
-							Description: "The unique identifier of the edge to retrieve.",
-						},
-					},
-				},
-			},
-		},
-		{
-			Type: "function",
-			Function: ollama.ToolFunction{
-				Name:        "list_edges",
-				Description: "Lists graph edges based on optional filters. Can filter by edge type, source node ID, and/or target node ID.",
-				Parameters: struct {
-					Type       string   `json:"type"`
-					Defs       any      `json:"$defs,omitempty"`
-					Items      any      `json:"items,omitempty"`
-					Required   []string `json:"required"`
-					Properties map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					} `json:"properties"`
-				}{
-					Type: "object",
-					Properties: map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					}{
-						"type": {
-							Type:        []string{"string"},
-							Description: "Filter edges by this type. For example, 'knows', 'contains', etc.",
-						},
-						"from_id": {
-							Type:        []string{"integer"},
-							Description: "Filter edges that originate from this node ID.",
-						},
-						"to_id": {
-							Type:        []string{"integer"},
-							Description: "Filter edges that point to this node ID.",
-						},
-					},
-				},
-			},
-		},
-		{
-			Type: "function",
-			Function: ollama.ToolFunction{
-				Name:        "create_edge",
-				Description: "Creates a new graph edge connecting two nodes. Specify the edge type and the IDs of the source and target nodes.",
-				Parameters: struct {
-					Type       string   `json:"type"`
-					Defs       any      `json:"$defs,omitempty"`
-					Items      any      `json:"items,omitempty"`
-					Required   []string `json:"required"`
-					Properties map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					} `json:"properties"`
-				}{
-					Type:     "object",
-					Required: []string{"type", "from_id", "to_id"},
-					Properties: map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					}{
-						"type": {
-							Type:        []string{"string"},
-							Description: "The type of the edge to create. For example, 'knows', 'contains', etc.",
-						},
-						"from_id": {
-							Type:        []string{"integer"},
-							Description: "The ID of the source node where the edge starts.",
-						},
-						"to_id": {
-							Type:        []string{"integer"},
-							Description: "The ID of the target node where the edge ends.",
-						},
-					},
-				},
-			},
-		},
-		{
-			Type: "function",
-			Function: ollama.ToolFunction{
-				Name:        "delete_edge",
-				Description: "Deletes a graph edge by its ID.",
-				Parameters: struct {
-					Type       string   `json:"type"`
-					Defs       any      `json:"$defs,omitempty"`
-					Items      any      `json:"items,omitempty"`
-					Required   []string `json:"required"`
-					Properties map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					} `json:"properties"`
-				}{
-					Type:     "object",
-					Required: []string{"id"},
-					Properties: map[string]struct {
-						Type        ollama.PropertyType `json:"type"`
-						Items       any                 `json:"items,omitempty"`
-						Description string              `json:"description"`
-						Enum        []any               `json:"enum,omitempty"`
-					}{
-						"id": {
-							Type:        []string{"integer"},
-							Description: "The unique identifier of the edge to delete.",
-						},
-					},
-				},
-			},
-		},
-	}
-}
-
-func (a *agent) handleToolCall(m *Message) error {
-	if m.MType != "tool" || m.ToolMsg == nil {
-		return fmt.Errorf("not a tool message")
-	}
-
-	// Mark as handled
-	m.ToolMsg.ToolDone = true
-
-	var err error
-	var result any
-
-	fmt.Fprintf(os.Stderr, "Handling tool call %q\n", m.ToolMsg.ToolName)
-
-	switch m.ToolMsg.ToolName {
-	case "get_node":
-		id, ok := m.ToolMsg.ToolArgs["id"].(float64)
-		if !ok {
-			return fmt.Errorf("invalid id parameter")
-		}
-		result, err = a.c.GetNode(int(id))
-
-	case "list_nodes":
-		nodeType, _ := m.ToolMsg.ToolArgs["node_type"].(string)
-		result, err = a.c.ListNodes(nodeType)
-
-	case "create_node":
-		typ, ok := m.ToolMsg.ToolArgs["type"].(string)
-		if !ok {
-			return fmt.Errorf("invalid type parameter")
-		}
-		props, _ := m.ToolMsg.ToolArgs["props"].(map[string]any)
-		result, err = a.c.CreateNode(typ, props)
-
-	case "delete_node":
-		id, ok := m.ToolMsg.ToolArgs["id"].(float64)
-		if !ok {
-			return fmt.Errorf("invalid id parameter")
-		}
-		err = a.c.DeleteNode(int(id))
-		if err == nil {
-			result = map[string]bool{"success": true}
-		}
-
-	case "get_edge":
-		id, ok := m.ToolMsg.ToolArgs["id"].(float64)
-		if !ok {
-			return fmt.Errorf("invalid id parameter")
-		}
-		result, err = a.c.GetEdge(int(id))
-
-	case "list_edges":
-		filter := EdgeFilter{}
-		if typ, ok := m.ToolMsg.ToolArgs["type"].(string); ok {
-			filter.Type = typ
-		}
-		if fromID, ok := m.ToolMsg.ToolArgs["from_id"].(float64); ok {
-			filter.FromID = int(fromID)
-		}
-		if toID, ok := m.ToolMsg.ToolArgs["to_id"].(float64); ok {
-			filter.ToID = int(toID)
-		}
-		result, err = a.c.ListEdges(filter)
-
-	case "create_edge":
-		typ, ok := m.ToolMsg.ToolArgs["type"].(string)
-		if !ok {
-			return fmt.Errorf("invalid type parameter")
-		}
-		fromID, ok := m.ToolMsg.ToolArgs["from_id"].(float64)
-		if !ok {
-			return fmt.Errorf("invalid from_id parameter")
-		}
-		toID, ok := m.ToolMsg.ToolArgs["to_id"].(float64)
-		if !ok {
-			return fmt.Errorf("invalid to_id parameter")
-		}
-		result, err = a.c.CreateEdge(typ, int(fromID), int(toID))
-
-	case "delete_edge":
-		id, ok := m.ToolMsg.ToolArgs["id"].(float64)
-		if !ok {
-			return fmt.Errorf("invalid id parameter")
-		}
-		err = a.c.DeleteEdge(int(id))
-		if err == nil {
-			result = map[string]bool{"success": true}
-		}
-
-	default:
-		return fmt.Errorf("unknown tool: %s", m.ToolMsg.ToolName)
-	}
-
+// rollback cleans up after a cancelled generation by deleting any
+// tool calls it left at the end of the chat without a result, so the
+// chat's history stays valid.
+func (a *agent) rollback(cid int) {
+	n, err := a.c.DeleteUnfinishedToolCalls(cid)
 	if err != nil {
-		m.ToolMsg.ToolError = err.Error()
-		return a.c.UpdateMessage(*m)
+		a.log.Error("failed to roll back cancelled generation", "chat", cid, "error", err)
+		return
 	}
-
-	// Convert result to JSON-encoded string
-	jsonResult, err := json.Marshal(result)
-	if err != nil {
-		m.ToolMsg.ToolError = fmt.Sprintf("failed to encode result: %v", err)
-		return a.c.UpdateMessage(*m)
+	if n > 0 {
+		a.log.Info("rolled back unfinished tool calls", "chat", cid, "count", n)
 	}
-
-	m.ToolMsg.ToolResult = string(jsonResult)
-	return a.c.UpdateMessage(*m)
 }
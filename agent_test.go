@@ -0,0 +1,901 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+// newTestClient opens a client on a fresh database in a temp dir.
+func newTestClient(t *testing.T) *client {
+	t.Helper()
+	c, err := newClient(context.Background(), t.TempDir(), clientConfig{})
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// newTestAgent creates an agent talking to the (fake) ollama
+// server at url, with the given config on top.
+func newTestAgent(t *testing.T, c *client, url string, cfg agentConfig) *agent {
+	t.Helper()
+	cfg.BaseURL = url
+	a, err := newAgent(context.Background(), c, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+	return a
+}
+
+// fakeOllama serves ollama's chat endpoint, answering each chat
+// request with reply, and its embed endpoint, with fakeEmbedding.
+// Other endpoints (like the heartbeat) just succeed.
+func fakeOllama(t *testing.T, reply func(req ollama.ChatRequest) ollama.ChatResponse) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/embed" {
+			var req ollama.EmbedRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			text, _ := req.Input.(string)
+			json.NewEncoder(w).Encode(ollama.EmbedResponse{Embeddings: [][]float32{fakeEmbedding(text)}})
+			return
+		}
+		if r.URL.Path != "/api/chat" {
+			return
+		}
+		var req ollama.ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := reply(req)
+		resp.Done = true
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// fakeEmbedding embeds text as a bag of its (lowercased) words,
+// hashed into a few dimensions, so texts sharing words are similar.
+func fakeEmbedding(text string) []float32 {
+	vec := make([]float32, 64)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		h := fnv.New32a()
+		h.Write([]byte(w))
+		vec[h.Sum32()%uint32(len(vec))]++
+	}
+	return vec
+}
+
+// toolCall is a response calling a tool.
+func toolCall(name string, args map[string]any) ollama.ChatResponse {
+	var resp ollama.ChatResponse
+	resp.Message.Role = "assistant"
+	resp.Message.ToolCalls = []ollama.ToolCall{{Function: ollama.ToolCallFunction{Name: name, Arguments: args}}}
+	return resp
+}
+
+// textReply is a response with just text.
+func textReply(text string) ollama.ChatResponse {
+	var resp ollama.ChatResponse
+	resp.Message.Role = "assistant"
+	resp.Message.Content = text
+	return resp
+}
+
+// addUserMessage adds a user message to a chat.
+func addUserMessage(t *testing.T, c *client, cid int, text string) {
+	t.Helper()
+	if _, err := c.CreateMessage(Message{ChatID: cid, MType: "user", UserMsg: &struct{ Text string }{text}}); err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+}
+
+func TestWorkersRunChatsInParallel(t *testing.T) {
+	// Each chat's first request waits until the other chat's has
+	// arrived too, which only happens if they run at the same time
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	var once sync.Map
+	url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == "tool" {
+			return textReply("done")
+		}
+		if _, loaded := once.LoadOrStore(last.Content, true); !loaded {
+			arrived.Done()
+			ch := make(chan struct{})
+			go func() { arrived.Wait(); close(ch) }()
+			select {
+			case <-ch:
+			case <-time.After(5 * time.Second):
+				return textReply("timed out waiting for the other chat")
+			}
+		}
+		return toolCall("list_nodes", map[string]any{})
+	})
+
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{})
+	var cids []int
+	for _, name := range []string{"a", "b"} {
+		ci, err := c.CreateChat(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addUserMessage(t, c, ci.ID, "hello from "+name)
+		cids = append(cids, ci.ID)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make(chan error, 2)
+	go a.work(ctx, 2, func(g genRequest, err error) { results <- err })
+	for _, cid := range cids {
+		a.gc <- genRequest{cid: cid}
+	}
+	for range cids {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Fatalf("generate failed: %v", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the workers")
+		}
+	}
+
+	// Both chats went through their tool call to a reply
+	for _, cid := range cids {
+		ms, err := c.ListMessages(cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		for _, m := range ms {
+			types = append(types, m.MType)
+		}
+		if got, want := strings.Join(types, ","), "user,tool,agent"; got != want {
+			t.Errorf("chat %d has messages %s, want %s", cid, got, want)
+		}
+		if last := ms[len(ms)-1]; last.MType == "agent" && last.AgentMsg.Text != "done" {
+			t.Errorf("chat %d replied %q, want %q", cid, last.AgentMsg.Text, "done")
+		}
+	}
+}
+
+func TestRunStopsAfterMaxSteps(t *testing.T) {
+	url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+		return toolCall("list_nodes", map[string]any{})
+	})
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{})
+	ci, err := c.CreateChat("loop", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "go")
+
+	if err := a.run(context.Background(), genRequest{cid: ci.ID}); err != errTooManySteps {
+		t.Fatalf("run returned %v, want %v", err, errTooManySteps)
+	}
+	ms, err := c.ListMessages(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(ms) - 1; got != maxGenerateSteps {
+		t.Errorf("made %d tool calls, want %d", got, maxGenerateSteps)
+	}
+}
+
+func TestGenerateRecordsModel(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		for _, model := range []string{"", "llama3"} {
+			url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+				return textReply("hi from " + req.Model)
+			})
+			c := newTestClient(t)
+			a := newTestAgent(t, c, url, agentConfig{Stream: stream})
+			ci, err := c.CreateChat("model", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addUserMessage(t, c, ci.ID, "hi")
+
+			m, err := a.generate(context.Background(), ci.ID, model)
+			if err != nil {
+				t.Fatalf("generate failed: %v", err)
+			}
+			want := model
+			if want == "" {
+				want = defaultModel
+			}
+			if m.Model != want {
+				t.Errorf("stream=%v: message model = %q, want %q", stream, m.Model, want)
+			}
+		}
+	}
+}
+
+func TestRunRecoversFromUnknownTool(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		var toolErr string
+		url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+			if last := req.Messages[len(req.Messages)-1]; last.Role == "tool" {
+				toolErr = last.Content
+				return textReply("sorry")
+			}
+			return toolCall("make_coffee", map[string]any{})
+		})
+		c := newTestClient(t)
+		a := newTestAgent(t, c, url, agentConfig{Stream: stream})
+		ci, err := c.CreateChat("unknown", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addUserMessage(t, c, ci.ID, "coffee please")
+
+		if err := a.run(context.Background(), genRequest{cid: ci.ID}); err != nil {
+			t.Fatalf("stream=%v: run failed: %v", stream, err)
+		}
+		ms, err := c.ListMessages(ci.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := messageTypes(ms); got != "uta" {
+			t.Fatalf("stream=%v: messages = %s, want uta", stream, got)
+		}
+		if got, want := ms[1].ToolMsg.ToolError, "unknown tool: make_coffee"; got != want {
+			t.Errorf("stream=%v: tool error = %q, want %q", stream, got, want)
+		}
+		if !strings.Contains(toolErr, "unknown tool: make_coffee") {
+			t.Errorf("stream=%v: model was sent %q, want the error", stream, toolErr)
+		}
+	}
+}
+
+func TestFailedGenerationRecordsError(t *testing.T) {
+	var fail bool
+	var sent []ollama.Message
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			return
+		}
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "model exploded"})
+			return
+		}
+		var req ollama.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		sent = req.Messages
+		resp := textReply("hi")
+		resp.Done = true
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := newTestClient(t)
+	a := newTestAgent(t, c, srv.URL, agentConfig{})
+	ci, err := c.CreateChat("errors", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "hello")
+
+	// Run a worker for one request, returning its error
+	generate := func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		errs := make(chan error, 1)
+		go a.work(ctx, 1, func(_ genRequest, err error) { errs <- err })
+		a.gc <- genRequest{cid: ci.ID}
+		return <-errs
+	}
+
+	fail = true
+	if err := generate(); err == nil {
+		t.Fatal("generation didn't fail")
+	}
+	ms, err := c.ListMessages(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageTypes(ms); got != "ue" {
+		t.Fatalf("messages = %s, want ue", got)
+	}
+	if text := ms[1].ErrorMsg.Text; !strings.Contains(text, "model exploded") {
+		t.Errorf("error message = %q", text)
+	}
+
+	// The error isn't sent to the model next time
+	fail = false
+	if err := generate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range sent {
+		if strings.Contains(m.Content, "model exploded") {
+			t.Errorf("error was sent to the model: %+v", m)
+		}
+	}
+
+	// Cancelled generations (which the user asked for) aren't recorded
+	a.recordError(ci.ID, fmt.Errorf("failed to chat: %w", context.Canceled))
+	if ms, err = c.ListMessages(ci.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := messageTypes(ms); got != "uea" {
+		t.Errorf("messages = %s, want uea", got)
+	}
+}
+
+func TestParseBaseURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"http://localhost:11434", false},
+		{"https://gateway.example.com/ollama", false},
+		{"localhost:11434", true},
+		{"ftp://example.com", true},
+		{"http://", true},
+		{"http://bad host", true},
+	}
+	for _, tt := range tests {
+		_, err := parseBaseURL(tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBaseURL(%q) error = %v, want error: %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewOllamaClient(t *testing.T) {
+	// A gateway serving ollama under a path
+	var paths []string
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		json.NewEncoder(w).Encode(textReply("through the gateway"))
+	}))
+	t.Cleanup(srv.Close)
+
+	ol, err := newOllamaClient(srv.URL + "/ollama")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := false
+	var got string
+	if err := ol.Chat(context.Background(), &ollama.ChatRequest{Model: "test", Stream: &stream}, func(r ollama.ChatResponse) error {
+		got = r.Message.Content
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "through the gateway" {
+		t.Errorf("reply = %q, want the server's", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 1 || paths[0] != "/ollama/api/chat" {
+		t.Errorf("server got requests for %v, want /ollama/api/chat", paths)
+	}
+
+	// A bad URL is caught when the agent is made
+	_, err = newAgent(context.Background(), newTestClient(t), agentConfig{BaseURL: "localhost:11434"}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err == nil || !strings.Contains(err.Error(), "invalid ollama URL") {
+		t.Errorf("newAgent() error = %v, want an invalid URL", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	// The server never answers (until the test's done)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	c := newTestClient(t)
+	a := newTestAgent(t, c, srv.URL, agentConfig{RequestTimeout: 50 * time.Millisecond})
+	ci, err := c.CreateChat("slow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "hello")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go a.work(ctx, 1, func(_ genRequest, err error) { errs <- err })
+	a.gc <- genRequest{cid: ci.ID}
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "didn't respond within 50ms") {
+			t.Errorf("error = %v, want a timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("generation didn't time out")
+	}
+
+	// The chat is left with the error, ready to try again
+	ms, err := c.ListMessages(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageTypes(ms); got != "ue" {
+		t.Fatalf("messages = %s, want ue", got)
+	}
+	if text := ms[1].ErrorMsg.Text; !strings.Contains(text, "didn't respond") {
+		t.Errorf("error message = %q", text)
+	}
+	got, err := c.GetChat(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State == "running" {
+		t.Error("chat is still running")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	var sent []ollama.Message
+	url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+		sent = req.Messages
+		return textReply("done")
+	})
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{})
+	ci, err := c.CreateChat("rollback", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cancelled generation left a call without a result
+	ms := seedMessages(t, c, ci.ID, "uatt")
+	ms[3].ToolMsg.ToolResult = ""
+	if err := c.UpdateMessage(ms[3]); err != nil {
+		t.Fatal(err)
+	}
+	a.rollback(ci.ID)
+	if got := messageIDsOf(t, c, ci.ID); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("messages after rolling back = %v, want [1 2 3]", got)
+	}
+
+	// So the next request doesn't send it to the model
+	if _, err := a.generate(context.Background(), ci.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range sent {
+		if m.Role == "tool" && m.Content == "" {
+			t.Errorf("sent an empty tool result: %+v", sent)
+		}
+	}
+	if n := len(sent); n == 0 || sent[n-1].Role != "tool" {
+		t.Errorf("sent %+v, want it to end with the finished call", sent)
+	}
+}
+
+func TestStopReason(t *testing.T) {
+	cut := textReply("It was the best of")
+	cut.DoneReason = "length"
+	done := textReply("The end.")
+	done.DoneReason = "stop"
+	tests := []struct {
+		name   string
+		stream bool
+		reply  ollama.ChatResponse
+		want   string
+	}{
+		{"cut off", false, cut, "length"},
+		{"finished", false, done, "stop"},
+		{"cut off streaming", true, cut, "length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var url string
+			if tt.stream {
+				url = fakeOllamaStream(t, tt.reply)
+			} else {
+				url = fakeOllama(t, func(ollama.ChatRequest) ollama.ChatResponse { return tt.reply })
+			}
+			c := newTestClient(t)
+			a := newTestAgent(t, c, url, agentConfig{Stream: tt.stream})
+			ci, err := c.CreateChat("stop", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addUserMessage(t, c, ci.ID, "tell me a story")
+			if _, err := a.generate(context.Background(), ci.ID, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			ms, err := c.ListMessages(ci.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := ms[len(ms)-1]
+			if last.MType != "agent" || last.AgentMsg.StopReason != tt.want {
+				t.Fatalf("last message = %+v, want a reply stopped for %q", last, tt.want)
+			}
+
+			// The TUI points out replies that were cut off
+			m := newTestModel(t, c, url, ci.ID, uiConfig{})
+			cutOff := strings.Contains(m.vp.View(), "cut off at the token limit")
+			if want := tt.want == "length"; cutOff != want {
+				t.Errorf("shows the reply was cut off: %v, want %v", cutOff, want)
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	temp, topP := 0.5, 0.9
+	tests := []struct {
+		name string
+		cfg  agentConfig
+		want map[string]any
+	}{
+		{"defaults", agentConfig{}, map[string]any{"num_predict": defaultMaxTokens}},
+		{"max tokens", agentConfig{MaxTokens: 1024}, map[string]any{"num_predict": 1024}},
+		{"temperature", agentConfig{Temperature: &temp}, map[string]any{"num_predict": defaultMaxTokens, "temperature": 0.5}},
+		{"top p", agentConfig{TopP: &topP}, map[string]any{"num_predict": defaultMaxTokens, "top_p": 0.9}},
+		{"sampling", agentConfig{Temperature: &temp, TopP: &topP}, map[string]any{"num_predict": defaultMaxTokens, "temperature": 0.5, "top_p": 0.9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent map[string]any
+			url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+				sent = req.Options
+				return textReply("ok")
+			})
+			c := newTestClient(t)
+			a := newTestAgent(t, c, url, tt.cfg)
+			ci, err := c.CreateChat("options", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addUserMessage(t, c, ci.ID, "hi")
+			if _, err := a.generate(context.Background(), ci.ID, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			// Numbers come through as float64s
+			if len(sent) != len(tt.want) {
+				t.Errorf("options = %v, want %v", sent, tt.want)
+			}
+			for k, v := range tt.want {
+				if fmt.Sprint(sent[k]) != fmt.Sprint(v) {
+					t.Errorf("option %s = %v, want %v", k, sent[k], v)
+				}
+			}
+		})
+	}
+
+	// Limits outside the bounds are rejected
+	for _, n := range []int{-1, maxMaxTokens + 1} {
+		cfg := agentConfig{BaseURL: fakeOllama(t, nil), MaxTokens: n}
+		if _, err := newAgent(context.Background(), newTestClient(t), cfg, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+			t.Errorf("newAgent() accepted max tokens %d", n)
+		}
+	}
+	for _, v := range []float64{-0.1, 1.5} {
+		for name, cfg := range map[string]agentConfig{"temperature": {Temperature: &v}, "top_p": {TopP: &v}} {
+			cfg.BaseURL = fakeOllama(t, nil)
+			if _, err := newAgent(context.Background(), newTestClient(t), cfg, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+				t.Errorf("newAgent() accepted %s %v", name, v)
+			}
+		}
+	}
+}
+
+func TestDebugStoresRawResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    agentConfig
+		stored bool
+	}{
+		{"off by default", agentConfig{}, false},
+		{"debug", agentConfig{Debug: true}, true},
+		{"debug streaming", agentConfig{Debug: true, Stream: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := textReply("hi there")
+			reply.Model = "llama3"
+			reply.DoneReason = "stop"
+			reply.EvalCount = 3
+			var url string
+			if tt.cfg.Stream {
+				url = fakeOllamaStream(t, reply)
+			} else {
+				url = fakeOllama(t, func(ollama.ChatRequest) ollama.ChatResponse { return reply })
+			}
+			c := newTestClient(t)
+			a := newTestAgent(t, c, url, tt.cfg)
+			ci, err := c.CreateChat("debug", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addUserMessage(t, c, ci.ID, "hello")
+			if _, err := a.generate(context.Background(), ci.ID, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := c.GetMessage(ci.ID, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.stored {
+				if len(m.RawMeta) != 0 {
+					t.Errorf("raw response stored without debug: %s", m.RawMeta)
+				}
+				return
+			}
+			var raw ollama.ChatResponse
+			if err := json.Unmarshal(m.RawMeta, &raw); err != nil {
+				t.Fatalf("failed to decode raw response %q: %v", m.RawMeta, err)
+			}
+			if raw.Model != "llama3" || raw.Message.Content != "hi there" || raw.DoneReason != "stop" || raw.EvalCount != 3 {
+				t.Errorf("raw response = %s", m.RawMeta)
+			}
+		})
+	}
+}
+
+func TestGetChatHistoryPairsToolCalls(t *testing.T) {
+	c := newTestClient(t)
+	a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{})
+	ci, err := c.CreateChat("pairs", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "who are they?")
+	for _, raw := range []string{
+		`{"MType": "tool", "ToolMsg": {"ToolDone": true, "ToolName": "get_node", "ToolArgs": {"id": 1}, "ToolResult": "alice"}}`,
+		`{"MType": "tool", "ToolMsg": {"ToolDone": true, "ToolName": "get_node", "ToolArgs": {"id": 2}, "ToolError": "node with ID 2 not found"}}`,
+		`{"MType": "tool", "ToolMsg": {"ToolDone": true, "ToolName": "list_nodes", "ToolArgs": {}, "ToolResult": "[]"}}`,
+	} {
+		m := toolMessage(t, raw)
+		m.ChatID = ci.ID
+		if _, err := c.CreateMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hs, _, err := a.getChatHistory(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each call is followed straight away by its own result
+	want := []struct {
+		role, call, args, content string
+	}{
+		{"user", "", "", "who are they?"},
+		{"assistant", "get_node", `{"id":1}`, ""},
+		{"tool", "", "", "alice"},
+		{"assistant", "get_node", `{"id":2}`, ""},
+		{"tool", "", "", "Error: node with ID 2 not found"},
+		{"assistant", "list_nodes", `{}`, ""},
+		{"tool", "", "", "[]"},
+	}
+	if len(hs) != len(want) {
+		t.Fatalf("history has %d messages, want %d: %+v", len(hs), len(want), hs)
+	}
+	for i, w := range want {
+		h := hs[i]
+		var call, args string
+		if len(h.ToolCalls) > 0 {
+			call = h.ToolCalls[0].Function.Name
+			data, _ := json.Marshal(h.ToolCalls[0].Function.Arguments)
+			args = string(data)
+		}
+		if h.Role != w.role || call != w.call || args != w.args || h.Content != w.content {
+			t.Errorf("message %d = %s %s(%s) %q, want %s %s(%s) %q", i, h.Role, call, args, h.Content, w.role, w.call, w.args, w.content)
+		}
+	}
+}
+
+func TestCancelGeneration(t *testing.T) {
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			return
+		}
+		// Hang until the request is given up on (which the
+		// server only notices once it's read the body)
+		io.Copy(io.Discard, r.Body)
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	c := newTestClient(t)
+	a := newTestAgent(t, c, srv.URL, agentConfig{})
+	ci, err := c.CreateChat("cancel", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "hi")
+
+	// Cancelling the chat aborts its in-flight request
+	errs := make(chan error, 1)
+	go func() {
+		_, err := a.generate(context.Background(), ci.ID, "")
+		errs <- err
+	}()
+	<-started
+	if !a.cancel(ci.ID) {
+		t.Fatal("cancel() found no generation to cancel")
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("generate() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("generate() didn't return after being cancelled")
+	}
+
+	// Leaving the chat idle, with nothing half-written
+	got, err := c.GetChat(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != "idle" {
+		t.Errorf("chat state = %q, want idle", got.State)
+	}
+	if ids := messageIDsOf(t, c, ci.ID); !slices.Equal(ids, []int{1}) {
+		t.Errorf("messages after cancelling = %v, want [1]", ids)
+	}
+	if a.cancel(ci.ID) {
+		t.Error("cancel() still found the finished generation")
+	}
+
+	// Shutting down (cancelling the parent context) aborts it too
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, err := a.generate(ctx, ci.ID, "")
+		errs <- err
+	}()
+	<-started
+	cancel()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("generate() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("generate() didn't return on shutdown")
+	}
+}
+
+func TestSystemPrompt(t *testing.T) {
+	var sent []ollama.Message
+	url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+		sent = req.Messages
+		return textReply("ok")
+	})
+	tests := []struct {
+		name       string
+		def, chat  string
+		wantPrompt string
+	}{
+		{"chat prompt", "You are helpful.", "You are a research assistant.", "You are a research assistant."},
+		{"falls back to default", "You are helpful.", "", "You are helpful."},
+		{"chat prompt without default", "", "You are a code helper.", "You are a code helper."},
+		{"neither", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			a := newTestAgent(t, c, url, agentConfig{SystemPrompt: tt.def})
+			ci, err := c.CreateChat("prompt", tt.chat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := a.systemPrompt(ci.ID); err != nil || got != tt.wantPrompt {
+				t.Errorf("systemPrompt() = %q, %v, want %q", got, err, tt.wantPrompt)
+			}
+
+			// The resolved prompt is what's sent to the model
+			addUserMessage(t, c, ci.ID, "hi")
+			if _, err := a.generate(context.Background(), ci.ID, ""); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			if len(sent) > 0 && sent[0].Role == "system" {
+				got = sent[0].Content
+			}
+			if got != tt.wantPrompt {
+				t.Errorf("sent system prompt %q, want %q", got, tt.wantPrompt)
+			}
+		})
+	}
+
+	// Setting a chat's prompt overrides the default, and
+	// clearing it falls back again
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{SystemPrompt: "default"})
+	ci, err := c.CreateChat("set", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"custom", ""} {
+		if err := c.SetChatPrompt(ci.ID, p); err != nil {
+			t.Fatal(err)
+		}
+		want := cmp.Or(p, "default")
+		if got, err := a.systemPrompt(ci.ID); err != nil || got != want {
+			t.Errorf("after SetChatPrompt(%q), systemPrompt() = %q, %v, want %q", p, got, err, want)
+		}
+	}
+}
+
+func TestGetChatHistorySkipsMalformed(t *testing.T) {
+	var sent []ollama.Message
+	url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+		sent = req.Messages
+		return textReply("still here")
+	})
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{})
+	ci, err := c.CreateChat("malformed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "before")
+	addRawMessages(t, c, ci.ID, malformedRecords...)
+	addUserMessage(t, c, ci.ID, "after")
+
+	// The bad records are left out of the history
+	hs, _, err := a.getChatHistory(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, h := range hs {
+		got = append(got, h.Role+": "+h.Content)
+	}
+	if want := []string{"user: before", "user: after"}; !slices.Equal(got, want) {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+
+	// So generating still works
+	if _, err := a.generate(context.Background(), ci.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 {
+		t.Errorf("sent %+v, want just the two user messages", sent)
+	}
+}
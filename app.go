@@ -3,7 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"runtime"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -15,79 +19,441 @@ func makeApp() *cli.Command {
 		Name:  "agnt",
 		Usage: "...",
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "system-prompt",
+				Usage:   "default system prompt for chats that don't set their own",
+				Sources: cli.EnvVars("AGNT_SYSTEM_PROMPT"),
+			},
+			&cli.FloatFlag{
+				Name:    "temperature",
+				Usage:   "sampling temperature between 0 and 1 (defaults to the model's setting)",
+				Sources: cli.EnvVars("AGNT_TEMPERATURE"),
+			},
+			&cli.FloatFlag{
+				Name:    "top-p",
+				Usage:   "nucleus sampling probability between 0 and 1 (defaults to the model's setting)",
+				Sources: cli.EnvVars("AGNT_TOP_P"),
+			},
+			&cli.IntFlag{
+				Name:    "workers",
+				Usage:   "number of chats that can generate responses at once",
+				Value:   4,
+				Sources: cli.EnvVars("AGNT_WORKERS"),
+			},
+			&cli.BoolFlag{
+				Name:    "vim",
+				Usage:   "enable vim-style keys (j/k, g/G, ctrl+d/ctrl+u, / to search) in the chat view",
+				Sources: cli.EnvVars("AGNT_VIM"),
+			},
+			&cli.BoolFlag{
+				Name:    "plain",
+				Usage:   "use text labels instead of emoji and skip decorative colors (for limited terminals and screen readers)",
+				Sources: cli.EnvVars("AGNT_PLAIN"),
+			},
+			&cli.StringMapFlag{
+				Name:  "role-prefix",
+				Usage: "prefix to show before a type of message, as role=prefix (roles: user, agent, tool, summary, error, malformed)",
+			},
+			&cli.BoolFlag{
+				Name:    "soft-wrap",
+				Usage:   "only wrap messages between words, letting long words (URLs, JSON) run past the edge",
+				Sources: cli.EnvVars("AGNT_SOFT_WRAP"),
+			},
+			&cli.IntFlag{
+				Name:    "wrap-margin",
+				Usage:   "columns to leave empty to the right of messages",
+				Sources: cli.EnvVars("AGNT_WRAP_MARGIN"),
+			},
+			&cli.BoolFlag{
+				Name:    "tables",
+				Usage:   "show tool results that are lists (like list_nodes') as tables instead of JSON",
+				Sources: cli.EnvVars("AGNT_TABLES"),
+			},
+			&cli.StringFlag{
+				Name:    "default-chat",
+				Usage:   "name of the chat created on first run (titled from its first message if not set)",
+				Sources: cli.EnvVars("AGNT_DEFAULT_CHAT"),
+			},
+			&cli.IntFlag{
+				Name:    "max-tokens",
+				Usage:   "most tokens the model can generate in each response",
+				Value:   defaultMaxTokens,
+				Sources: cli.EnvVars("AGNT_MAX_TOKENS"),
+			},
+			&cli.StringFlag{
+				Name:    "log-level",
+				Usage:   "minimum level to log (debug, info, warn, error)",
+				Value:   "info",
+				Sources: cli.EnvVars("AGNT_LOG_LEVEL"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "tools",
+				Usage:   "only let the model use these tools (e.g. --tools get_node,list_nodes,create_node); all of them if not set",
+				Sources: cli.EnvVars("AGNT_TOOLS"),
+			},
+			&cli.BoolFlag{
+				Name:    "dry-run",
+				Usage:   "don't run tools that change data; tell the model what would have happened instead",
+				Sources: cli.EnvVars("AGNT_DRY_RUN"),
+			},
+			&cli.BoolFlag{
+				Name:    "stream",
+				Usage:   "stream the model's responses, running each tool call as soon as it arrives",
+				Sources: cli.EnvVars("AGNT_STREAM"),
+			},
+			&cli.BoolFlag{
+				Name:    "confirm-deletes",
+				Usage:   "show what the model's delete_node and delete_edge calls would remove, and wait for approval before running them",
+				Sources: cli.EnvVars("AGNT_CONFIRM_DELETES"),
+			},
+			&cli.BoolFlag{
+				Name:    "chat-tools",
+				Usage:   "let the model create and list chats",
+				Sources: cli.EnvVars("AGNT_CHAT_TOOLS"),
+			},
+			&cli.BoolFlag{
+				Name:    "expand-env",
+				Usage:   "expand ${AGNT_VAR_*} references in node and edge props when showing them to the model (stored props are unchanged; other variables are never expanded)",
+				Sources: cli.EnvVars("AGNT_EXPAND_ENV"),
+			},
+			&cli.BoolFlag{
+				Name:    "debug",
+				Usage:   "store the model's raw responses on messages (see \"agnt messages raw\")",
+				Sources: cli.EnvVars("AGNT_DEBUG"),
+			},
+			&cli.StringFlag{
+				Name:    "log-file",
+				Usage:   "file to write logs to (logs are discarded if not set)",
+				Sources: cli.EnvVars("AGNT_LOG_FILE"),
+			},
+			&cli.BoolFlag{
+				Name:    "llm-titles",
+				Usage:   "ask the model to title new chats instead of using their first few words",
+				Sources: cli.EnvVars("AGNT_LLM_TITLES"),
+			},
+			&cli.StringFlag{
+				Name:    "ollama-url",
+				Usage:   "URL of the ollama server (defaults to $OLLAMA_HOST); $HTTPS_PROXY is honored",
+				Sources: cli.EnvVars("AGNT_OLLAMA_URL"),
+			},
+			&cli.DurationFlag{
+				Name:    "request-timeout",
+				Usage:   "how long to wait for each request to the model (0 waits forever)",
+				Value:   120 * time.Second,
+				Sources: cli.EnvVars("AGNT_REQUEST_TIMEOUT"),
+			},
+			&cli.IntFlag{
+				Name:    "rate-limit",
+				Usage:   "most requests to send the model per minute (0 is unlimited)",
+				Sources: cli.EnvVars("AGNT_RATE_LIMIT"),
+			},
+			&cli.BoolFlag{
+				Name:    "auto-truncate",
+				Usage:   "drop the oldest messages of chats too long for the model's context window, instead of failing",
+				Sources: cli.EnvVars("AGNT_AUTO_TRUNCATE"),
+			},
+			&cli.StringFlag{
+				Name:    "embed-model",
+				Usage:   "ollama model used to embed text for semantic search",
+				Value:   "nomic-embed-text",
+				Sources: cli.EnvVars("AGNT_EMBED_MODEL"),
+			},
+			&cli.IntFlag{
+				Name:    "rag-k",
+				Usage:   "add the `K` graph nodes most relevant to the latest message to each request (0 disables)",
+				Sources: cli.EnvVars("AGNT_RAG_K"),
+			},
+			&cli.BoolFlag{
+				Name:    "offline",
+				Usage:   "run without a model, just to browse chats and the graph (automatic if ollama isn't running)",
+				Sources: cli.EnvVars("AGNT_OFFLINE"),
+			},
+			&cli.BoolFlag{
+				Name:    "read-only",
+				Usage:   "only let the agent read the graph, not change it",
+				Sources: cli.EnvVars("AGNT_READ_ONLY"),
+			},
+			&cli.IntFlag{
+				Name:    "compact-threshold",
+				Usage:   "summarize old messages once a chat has more than this many (0 disables)",
+				Sources: cli.EnvVars("AGNT_COMPACT_THRESHOLD"),
+			},
+			&cli.IntFlag{
+				Name:    "compact-keep",
+				Usage:   "number of recent messages to keep out of the summary (at least 1)",
+				Value:   10,
+				Sources: cli.EnvVars("AGNT_COMPACT_KEEP"),
+			},
+			&cli.StringFlag{
+				Name:    "workspace",
+				Usage:   "workspace to use; each has its own database, so chats and graphs aren't shared between them",
+				Value:   defaultWorkspace,
+				Sources: cli.EnvVars("AGNT_WORKSPACE"),
+			},
+			&cli.DurationFlag{
+				Name:    "db-timeout",
+				Usage:   "how long to wait for another agnt process to release the database (0 waits forever)",
+				Value:   5 * time.Second,
+				Sources: cli.EnvVars("AGNT_DB_TIMEOUT"),
+			},
+			&cli.BoolFlag{
+				Name:    "db-no-sync",
+				Usage:   "skip syncing the database to disk after each write (faster, but unsafe if the system crashes)",
+				Sources: cli.EnvVars("AGNT_DB_NO_SYNC"),
+			},
+			&cli.BoolFlag{
+				Name:    "exact-numbers",
+				Usage:   "read numeric node properties without converting them to floats, so integers round-trip exactly",
+				Sources: cli.EnvVars("AGNT_EXACT_NUMBERS"),
+			},
 			&cli.BoolFlag{
-				Name: "init",
+				Name:    "no-self-loops",
+				Usage:   "reject graph edges from a node to itself",
+				Sources: cli.EnvVars("AGNT_NO_SELF_LOOPS"),
 			},
+			&cli.BoolFlag{
+				Name:    "track-access",
+				Usage:   "record when each node was last read (makes reads write to the database)",
+				Sources: cli.EnvVars("AGNT_TRACK_ACCESS"),
+			},
+			&cli.IntFlag{
+				Name:    "backup-keep",
+				Usage:   "back up the database when the TUI or server starts (and before repairing it), keeping this many backups (0 disables)",
+				Sources: cli.EnvVars("AGNT_BACKUP_KEEP"),
+			},
+			&cli.StringFlag{
+				Name:    "backup-dir",
+				Usage:   "directory to keep backups in (defaults to \"backups\" in the data directory)",
+				Sources: cli.EnvVars("AGNT_BACKUP_DIR"),
+			},
+			&cli.StringFlag{
+				Name:    "db-freelist",
+				Usage:   "database freelist backend (array or hashmap)",
+				Sources: cli.EnvVars("AGNT_DB_FREELIST"),
+			},
+			&cli.StringMapFlag{
+				Name:  "cost-rates",
+				Usage: "per-token costs keyed by model, as model=input:output (use * as the fallback)",
+			},
+		},
+		Commands: []*cli.Command{
+			chatsCommand(),
+			messagesCommand(),
+			workspacesCommand(),
+			graphCommand(),
+			auditCommand(),
+			statusCommand(),
+			doctorCommand(),
+			serveCommand(),
 		},
 		Action: func(c context.Context, cmd *cli.Command) error {
 			ctx, cancel := context.WithCancel(c)
 			defer cancel()
 
-			// Get the user's home directory
-			home, err := os.UserHomeDir()
+			// Set up the logger
+			log, closeLog, err := newLogger(cmd.String("log-level"), cmd.String("log-file"))
 			if err != nil {
 				return err
 			}
+			defer closeLog()
 
 			// Create the client...
-			client, err := newClient(ctx, home)
+			client, err := openSessionClient(ctx, cmd)
 			if err != nil {
 				return err // TODO:
 			}
 
-			// TODO: Remove me...
-			//
-			// NOTE: This is just temporary
-			if cmd.Bool("init") {
-				ch, err := client.CreateChat("test")
-				if err != nil {
-					return err
-				}
-				fmt.Printf("Chat created with ID: %d\n", ch.ID)
-				time.Sleep(time.Second)
+			// Clean up after any generations that were interrupted
+			if n, err := client.ResetRunningChats(); err != nil {
+				return err
+			} else if n > 0 {
+				log.Warn("reset chats left running", "count", n)
 			}
 
 			// Create the agent...
-			agent, err := newAgent(ctx, client)
+			agent, err := newAgent(ctx, client, agentConfigFromCmd(cmd), log)
 			if err != nil {
 				return err
 			}
 
 			// Create the model...
-			m := newModel(ctx, client, agent)
+			dirs, err := userDirs()
+			if err != nil {
+				return err
+			}
+			ui := uiConfig{
+				Vim:          cmd.Bool("vim"),
+				Plain:        cmd.Bool("plain"),
+				RolePrefixes: cmd.StringMap("role-prefix"),
+				SoftWrap:     cmd.Bool("soft-wrap"),
+				WrapMargin:   cmd.Int("wrap-margin"),
+				DefaultChat:  cmd.String("default-chat"),
+				Tables:       cmd.Bool("tables"),
+				ResultsDir:   filepath.Join(dirs.Config, resultsDir),
+			}
+			if err := ui.validate(); err != nil {
+				return fmt.Errorf("invalid ui config: %w", err)
+			}
+			m := newModel(ctx, client, agent, ui)
 			p := tea.NewProgram(m, tea.WithAltScreen())
 
-			// TODO: Run the agent queue
+			// Run the agent's workers, telling the TUI to update
+			// as each response is generated
+			wctx, stopWork := context.WithCancel(ctx)
+			defer stopWork()
+			workersDone := make(chan struct{})
 			go func() {
-				select {
-				case <-ctx.Done():
-					// Quit the TUI
-					p.Quit()
-
-					// Shut down the client
-					if err := client.Close(); err != nil {
-						panic(err)
-					}
-				case g := <-agent.gc:
-					// Run the generation without blocking...
-					fmt.Fprintln(os.Stderr, "Got generate msg in channel")
-					if _, err := agent.generate(ctx, g.cid, func() {}); err != nil {
-						panic(err)
-					}
-					fmt.Fprintln(os.Stderr, "Completed generate msg in channel")
-
-					// Then tell the TUI to update
-					m.Update(UpdateChatMsg{})
-					fmt.Fprintln(os.Stderr, "Sent refresh msg")
-				}
+				defer close(workersDone)
+				agent.work(wctx, cmd.Int("workers"), func(g genRequest, err error) {
+					p.Send(GenerateResponse{ChatID: g.cid, Error: err})
+				})
+			}()
+
+			// Quit the TUI if we're cancelled from outside
+			go func() {
+				<-ctx.Done()
+				p.Quit()
 			}()
 
 			// Run the model!
-			if _, err := p.Run(); err != nil {
-				return err
-			}
+			_, err = p.Run()
 
-			return nil
+			// Then shut down cleanly
+			stopWork()
+			shutdown(client, workersDone, shutdownGrace, log)
+			return err
 		},
 	}
 }
+
+// shutdownGrace is how long in-flight generations get to
+// stop (and clean up after themselves) when quitting.
+const shutdownGrace = 5 * time.Second
+
+// shutdown waits (up to grace) for the workers to stop once their
+// context is cancelled, makes sure no chat is left marked as running,
+// then closes the client.
+func shutdown(c *client, workersDone <-chan struct{}, grace time.Duration, log *slog.Logger) {
+	select {
+	case <-workersDone:
+	case <-time.After(grace):
+		log.Warn("generations didn't stop in time", "grace", grace)
+	}
+	if n, err := c.ResetRunningChats(); err != nil {
+		log.Error("failed to reset running chats", "error", err)
+	} else if n > 0 {
+		log.Warn("reset chats left running", "count", n)
+	}
+	if err := c.Close(); err != nil {
+		log.Error("failed to close client", "error", err)
+	}
+}
+
+// agentConfigFromCmd builds the agent's config from the command's flags.
+func agentConfigFromCmd(cmd *cli.Command) agentConfig {
+	cfg := agentConfig{
+		SystemPrompt: cmd.String("system-prompt"),
+		MaxTokens:    cmd.Int("max-tokens"),
+		LLMTitles:    cmd.Bool("llm-titles"),
+		ReadOnly:     cmd.Bool("read-only"),
+		Offline:      cmd.Bool("offline"),
+		EmbedModel:   cmd.String("embed-model"),
+		BaseURL:      cmd.String("ollama-url"),
+		RAGTopK:      cmd.Int("rag-k"),
+		Debug:        cmd.Bool("debug"),
+		ExpandEnv:    cmd.Bool("expand-env"),
+		ChatTools:    cmd.Bool("chat-tools"),
+		DryRun:       cmd.Bool("dry-run"),
+		ConfirmDel:   cmd.Bool("confirm-deletes"),
+		Stream:       cmd.Bool("stream"),
+
+		RequestTimeout: cmd.Duration("request-timeout"),
+		RateLimit:      cmd.Int("rate-limit"),
+		AutoTruncate:   cmd.Bool("auto-truncate"),
+
+		CompactThreshold: cmd.Int("compact-threshold"),
+		CompactKeep:      cmd.Int("compact-keep"),
+	}
+	if cmd.IsSet("temperature") {
+		t := cmd.Float("temperature")
+		cfg.Temperature = &t
+	}
+	if cmd.IsSet("top-p") {
+		p := cmd.Float("top-p")
+		cfg.TopP = &p
+	}
+	if cmd.IsSet("tools") {
+		cfg.AllowedTools = cmd.StringSlice("tools")
+	}
+	return cfg
+}
+
+// openClient opens the client in the user's data directory,
+// using the database settings from the command's flags.
+func openClient(ctx context.Context, cmd *cli.Command) (*client, error) {
+	return openClientWith(ctx, clientConfigFromCmd(cmd))
+}
+
+// openSessionClient opens the client like openClient, for a session
+// (the TUI or the server) rather than a one-off command, so the
+// database is backed up first if backups are on.
+func openSessionClient(ctx context.Context, cmd *cli.Command) (*client, error) {
+	cfg := clientConfigFromCmd(cmd)
+	cfg.BackupOnOpen = true
+	return openClientWith(ctx, cfg)
+}
+
+// openClientWith opens the client in the user's data directory.
+func openClientWith(ctx context.Context, cfg clientConfig) (*client, error) {
+	dirs, err := userDirs()
+	if err != nil {
+		return nil, err
+	}
+	return newClient(ctx, dirs.Data, cfg)
+}
+
+// userDirs returns where agnt's files live for the current user.
+func userDirs() (appDirs, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return appDirs{}, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return resolveDirs(runtime.GOOS, home, os.Getenv, os.Stat), nil
+}
+
+// clientConfigFromCmd builds the database settings from the command's flags.
+func clientConfigFromCmd(cmd *cli.Command) clientConfig {
+	return clientConfig{
+		LockTimeout:  cmd.Duration("db-timeout"),
+		NoSync:       cmd.Bool("db-no-sync"),
+		FreelistType: cmd.String("db-freelist"),
+		UseNumber:    cmd.Bool("exact-numbers"),
+		NoSelfLoops:  cmd.Bool("no-self-loops"),
+		TrackAccess:  cmd.Bool("track-access"),
+		BackupKeep:   cmd.Int("backup-keep"),
+		BackupDir:    cmd.String("backup-dir"),
+		Workspace:    cmd.String("workspace"),
+	}
+}
+
+// newLogger creates a logger at the given level that writes to the
+// named file, or discards everything if no file is given (so that
+// log output doesn't corrupt the TUI). The returned func closes the file.
+func newLogger(level, file string) (*slog.Logger, func() error, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	// No file? Discard the logs.
+	if file == "" {
+		return slog.New(slog.NewTextHandler(io.Discard, nil)), func() error { return nil }, nil
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	h := slog.NewTextHandler(f, &slog.HandlerOptions{Level: lvl})
+	return slog.New(h), f.Close, nil
+}
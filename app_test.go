@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "agnt.log")
+	log, closeLog, err := newLogger("warn", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Debug("debug message")
+	log.Info("info message")
+	log.Warn("warn message")
+	log.Error("error message")
+	if err := closeLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, msg := range []string{"debug message", "info message"} {
+		if strings.Contains(out, msg) {
+			t.Errorf("log has %q below the configured level:\n%s", msg, out)
+		}
+	}
+	for _, msg := range []string{"warn message", "error message"} {
+		if !strings.Contains(out, msg) {
+			t.Errorf("log is missing %q:\n%s", msg, out)
+		}
+	}
+
+	// Without a file, logs are discarded
+	log, closeLog, err = newLogger("debug", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Error("nowhere")
+	if err := closeLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := newLogger("loud", file); err == nil {
+		t.Error("newLogger() accepted an invalid level")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// errAwaitingApproval is wrapped by the error returned when asked to
+// generate for a chat whose destructive tool call is still waiting on
+// the user to approve (or reject) it.
+var errAwaitingApproval = errors.New("is waiting for the user to approve a tool call")
+
+// DeletePreview is what deleting a node (or an edge) would remove.
+type DeletePreview struct {
+	Node  *GraphNode  `json:",omitempty"` // The node (nil when previewing an edge)
+	Edges []GraphEdge // The edges connected to the node, or just the edge
+}
+
+// PreviewDeleteNode returns the node and the edges connected to it,
+// which DeleteNode would remove, without changing anything.
+func (c *client) PreviewDeleteNode(id int) (*DeletePreview, error) {
+	p := &DeletePreview{}
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		node, err := c.getNode(tx, id)
+		if err != nil {
+			return err
+		}
+		p.Node = node
+		p.Edges, err = c.incidentEdges(tx, map[int]bool{id: true})
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to preview deleting node: %w", err)
+	}
+	return p, nil
+}
+
+// PreviewDeleteEdge returns the edge DeleteEdge would remove,
+// without changing anything.
+func (c *client) PreviewDeleteEdge(id int) (*DeletePreview, error) {
+	edge, err := c.GetEdge(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to preview deleting edge: %w", err)
+	}
+	return &DeletePreview{Edges: []GraphEdge{*edge}}, nil
+}
+
+// describe summarizes what the deletion would remove, for the user
+// to approve. At most limit edges are listed.
+func (p DeletePreview) describe(limit int) string {
+	var b strings.Builder
+	if p.Node != nil {
+		fmt.Fprintf(&b, "Delete node %d (%s)", p.Node.ID, p.Node.Type)
+		switch len(p.Edges) {
+		case 0:
+			b.WriteString("? It has no edges.")
+		case 1:
+			b.WriteString(" and the 1 edge connected to it?")
+		default:
+			fmt.Fprintf(&b, " and the %d edges connected to it?", len(p.Edges))
+		}
+	} else {
+		b.WriteString("Delete this edge?")
+	}
+	for i, e := range p.Edges {
+		if i == limit {
+			fmt.Fprintf(&b, "\n  ...and %d more", len(p.Edges)-limit)
+			break
+		}
+		fmt.Fprintf(&b, "\n  edge %d: %d -[%s]-> %d", e.ID, e.FromID, e.Type, e.ToID)
+	}
+	return b.String()
+}
+
+// pendingApproval returns the tool call a chat is waiting on the user
+// to approve, if its last message is one.
+func pendingApproval(ms []Message) (*Message, bool) {
+	if len(ms) == 0 {
+		return nil, false
+	}
+	m := ms[len(ms)-1]
+	if m.MType != "tool" || m.ToolMsg == nil || m.ToolMsg.ToolPreview == "" {
+		return nil, false
+	}
+	if !m.ToolMsg.ToolDone || m.ToolMsg.ToolResult != "" || m.ToolMsg.ToolError != "" {
+		return nil, false
+	}
+	return &m, true
+}
+
+// waitsOnUser reports whether a tool call is waiting on the
+// user, either for an answer or an approval.
+func waitsOnUser(m Message) bool {
+	_, asking := pendingQuestion([]Message{m})
+	_, approving := pendingApproval([]Message{m})
+	return asking || approving
+}
+
+// approvalText describes what a tool call waiting for approval would
+// remove, listing at most limit edges.
+func approvalText(m Message, limit int) string {
+	var p DeletePreview
+	if err := json.Unmarshal([]byte(m.ToolMsg.ToolPreview), &p); err != nil {
+		return fmt.Sprintf("Run %s?", m.ToolMsg.ToolName)
+	}
+	return p.describe(limit)
+}
+
+// ApproveToolCall runs the tool call a chat is waiting on the user to
+// approve, so generation can carry on. It holds the chat's lock while it
+// does, so the same call can't be approved (and run) twice.
+func (a *agent) ApproveToolCall(ctx context.Context, chatID int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	unlock, err := a.lockChat(chatID, cancel)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ms, err := a.c.ListMessages(chatID)
+	if err != nil {
+		return err
+	}
+	m, ok := pendingApproval(ms)
+	if !ok {
+		return fmt.Errorf("tool call to approve in chat %d %w", chatID, errNotFound)
+	}
+	t, ok := a.tools[m.ToolMsg.ToolName]
+	if !ok {
+		return fmt.Errorf("unknown tool: %s", m.ToolMsg.ToolName)
+	}
+
+	// Run it on the chat's own graph, if it has one
+	g, err := a.c.ForChat(chatID)
+	if err != nil {
+		return err
+	}
+	return a.runTool(context.WithValue(ctx, graphKey{}, g), t, m)
+}
+
+// RejectToolCall tells the model the user wouldn't let the tool call
+// a chat is waiting on run, so generation can carry on without it.
+func (c *client) RejectToolCall(chatID int) error {
+	ms, err := c.ListMessages(chatID)
+	if err != nil {
+		return err
+	}
+	m, ok := pendingApproval(ms)
+	if !ok {
+		return fmt.Errorf("tool call to reject in chat %d %w", chatID, errNotFound)
+	}
+	m.ToolMsg.ToolError = "the user rejected this call, so nothing was changed"
+	if err := c.UpdateMessage(*m); err != nil {
+		return fmt.Errorf("failed to reject tool call: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestPreviewDeleteNode(t *testing.T) {
+	c := testGraph(t)
+	tests := []struct {
+		id        int
+		wantEdges []int
+	}{
+		{1, []int{1, 3, 4}},
+		{3, []int{2, 4}},
+		{5, nil},
+	}
+	for _, tt := range tests {
+		p, err := c.PreviewDeleteNode(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Node == nil || p.Node.ID != tt.id {
+			t.Errorf("PreviewDeleteNode(%d) node = %+v", tt.id, p.Node)
+		}
+		if got := slices.Sorted(slices.Values(edgeIDs(p.Edges))); !slices.Equal(got, tt.wantEdges) {
+			t.Errorf("PreviewDeleteNode(%d) edges = %v, want %v", tt.id, got, tt.wantEdges)
+		}
+	}
+	if _, err := c.PreviewDeleteNode(42); !errors.Is(err, errNotFound) {
+		t.Errorf("PreviewDeleteNode(42) = %v, want %v", err, errNotFound)
+	}
+
+	// Nothing was deleted
+	ns, err := c.ListNodes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := c.ListEdges(EdgeFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 5 || len(es) != 4 {
+		t.Errorf("previews changed the graph: %d nodes and %d edges left", len(ns), len(es))
+	}
+}
+
+func TestPreviewDeleteEdge(t *testing.T) {
+	c := testGraph(t)
+	p, err := c.PreviewDeleteEdge(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Node != nil || len(p.Edges) != 1 || p.Edges[0].ID != 3 {
+		t.Errorf("PreviewDeleteEdge(3) = %+v", p)
+	}
+	if _, err := c.PreviewDeleteEdge(42); !errors.Is(err, errNotFound) {
+		t.Errorf("PreviewDeleteEdge(42) = %v, want %v", err, errNotFound)
+	}
+	if _, err := c.GetEdge(3); err != nil {
+		t.Errorf("preview deleted the edge: %v", err)
+	}
+}
+
+func TestApproveToolCallOnce(t *testing.T) {
+	c := testGraph(t)
+	a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{ConfirmDel: true})
+	ci, err := c.CreateChat("approve", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := callTool(t, a, ci.ID, "delete_node", map[string]any{"id": 1})
+	if m.ToolMsg.ToolPreview == "" {
+		t.Fatal("delete didn't wait for approval")
+	}
+
+	// Not while the chat is busy
+	unlock, err := a.lockChat(ci.ID, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ApproveToolCall(context.Background(), ci.ID); !errors.Is(err, errBusy) {
+		t.Errorf("ApproveToolCall() on a busy chat = %v, want %v", err, errBusy)
+	}
+	unlock()
+
+	// Approving twice at once only runs the call once
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = a.ApproveToolCall(context.Background(), ci.ID)
+		}()
+	}
+	wg.Wait()
+	var ok int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			ok++
+		case !errors.Is(err, errBusy) && !errors.Is(err, errNotFound):
+			t.Errorf("ApproveToolCall() = %v", err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d approvals ran, want 1 (errors: %v)", ok, errs)
+	}
+	if _, err := c.GetNode(1); !errors.Is(err, errNotFound) {
+		t.Errorf("node wasn't deleted: %v", err)
+	}
+	ms, err := c.ListMessages(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm := ms[len(ms)-1].ToolMsg; tm.ToolResult == "" || tm.ToolError != "" {
+		t.Errorf("approved call = %+v, want a result", tm)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// hasArg reports whether a tool argument was given (and isn't null).
+func hasArg(args map[string]any, name string) bool {
+	v, ok := args[name]
+	return ok && v != nil
+}
+
+// argInt reads an integer tool argument. Models don't always send the
+// type the schema asks for, so whole floats and numeric strings (e.g.
+// "5") are accepted too.
+func argInt(args map[string]any, name string) (int, error) {
+	if !hasArg(args, name) {
+		return 0, fmt.Errorf("missing %s parameter", name)
+	}
+	switch v := args[name].(type) {
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("invalid %s parameter: %v is not a whole number", name, v)
+		}
+		return int(v), nil
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s parameter: %w", name, err)
+		}
+		return int(n), nil
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s parameter: %q is not an integer", name, v)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("invalid %s parameter: expected an integer, got %T", name, v)
+	}
+}
+
+// argString reads a string tool argument, accepting numbers
+// and bools by formatting them as strings.
+func argString(args map[string]any, name string) (string, error) {
+	if !hasArg(args, name) {
+		return "", fmt.Errorf("missing %s parameter", name)
+	}
+	switch v := args[name].(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int, int64, json.Number, bool:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("invalid %s parameter: expected a string, got %T", name, v)
+	}
+}
+
+// argMap reads an object tool argument, accepting a string
+// containing a JSON object too.
+func argMap(args map[string]any, name string) (map[string]any, error) {
+	if !hasArg(args, name) {
+		return nil, fmt.Errorf("missing %s parameter", name)
+	}
+	switch v := args[name].(type) {
+	case map[string]any:
+		return v, nil
+	case string:
+		var m map[string]any
+		if err := json.Unmarshal([]byte(v), &m); err != nil {
+			return nil, fmt.Errorf("invalid %s parameter: expected an object: %w", name, err)
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("invalid %s parameter: expected an object, got %T", name, v)
+	}
+}
+
+// argBool reads a boolean tool argument, accepting strings like
+// "true" or "false" and the numbers 0 and 1 too.
+func argBool(args map[string]any, name string) (bool, error) {
+	if !hasArg(args, name) {
+		return false, fmt.Errorf("missing %s parameter", name)
+	}
+	switch v := args[name].(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return false, fmt.Errorf("invalid %s parameter: %q is not a boolean", name, v)
+		}
+		return b, nil
+	case float64:
+		if v != 0 && v != 1 {
+			return false, fmt.Errorf("invalid %s parameter: %v is not a boolean", name, v)
+		}
+		return v == 1, nil
+	default:
+		return false, fmt.Errorf("invalid %s parameter: expected a boolean, got %T", name, v)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestArgInt(t *testing.T) {
+	tests := []struct {
+		arg     any
+		want    int
+		wantErr bool
+	}{
+		{5.0, 5, false},
+		{5, 5, false},
+		{int64(5), 5, false},
+		{json.Number("5"), 5, false},
+		{"5", 5, false},
+		{" -3 ", -3, false},
+		{5.5, 0, true},
+		{json.Number("5.5"), 0, true},
+		{"five", 0, true},
+		{true, 0, true},
+		{[]any{5}, 0, true},
+		{nil, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := argInt(map[string]any{"id": tt.arg}, "id")
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("argInt(%#v) = %d, %v, want %d (error: %v)", tt.arg, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := argInt(map[string]any{}, "id"); err == nil {
+		t.Error("argInt() of a missing arg succeeded")
+	}
+}
+
+func TestArgString(t *testing.T) {
+	tests := []struct {
+		arg     any
+		want    string
+		wantErr bool
+	}{
+		{"Alice", "Alice", false},
+		{"", "", false},
+		{5.0, "5", false},
+		{2.5, "2.5", false},
+		{7, "7", false},
+		{json.Number("12"), "12", false},
+		{true, "true", false},
+		{map[string]any{"a": 1}, "", true},
+		{[]any{"a"}, "", true},
+		{nil, "", true},
+	}
+	for _, tt := range tests {
+		got, err := argString(map[string]any{"name": tt.arg}, "name")
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("argString(%#v) = %q, %v, want %q (error: %v)", tt.arg, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestArgMap(t *testing.T) {
+	tests := []struct {
+		arg     any
+		want    map[string]any
+		wantErr bool
+	}{
+		{map[string]any{"age": 30.0}, map[string]any{"age": 30.0}, false},
+		{`{"age": 30}`, map[string]any{"age": 30.0}, false},
+		{"age: 30", nil, true},
+		{`[1, 2]`, nil, true},
+		{30.0, nil, true},
+		{[]any{"age"}, nil, true},
+		{nil, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := argMap(map[string]any{"props": tt.arg}, "props")
+		if !reflect.DeepEqual(got, tt.want) || (err != nil) != tt.wantErr {
+			t.Errorf("argMap(%#v) = %v, %v, want %v (error: %v)", tt.arg, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestArgBool(t *testing.T) {
+	tests := []struct {
+		arg     any
+		want    bool
+		wantErr bool
+	}{
+		{true, true, false},
+		{false, false, false},
+		{"true", true, false},
+		{" FALSE ", false, false},
+		{"1", true, false},
+		{1.0, true, false},
+		{0.0, false, false},
+		{2.0, false, true},
+		{"yes", false, true},
+		{map[string]any{}, false, true},
+		{nil, false, true},
+	}
+	for _, tt := range tests {
+		got, err := argBool(map[string]any{"flag": tt.arg}, "flag")
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("argBool(%#v) = %v, %v, want %v (error: %v)", tt.arg, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestToolsCoerceArgs(t *testing.T) {
+	a, cid := newToolTest(t, agentConfig{})
+	if _, err := a.c.CreateNode("person", map[string]any{"name": "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Handlers take the representations models tend to send
+	m := callTool(t, a, cid, "get_node", map[string]any{"id": "1"})
+	if m.ToolMsg.ToolError != "" {
+		t.Errorf("get_node with a string ID failed: %s", m.ToolMsg.ToolError)
+	}
+	m = callTool(t, a, cid, "update_node", map[string]any{"id": 1.0, "props": `{"age": 30}`})
+	if m.ToolMsg.ToolError != "" {
+		t.Errorf("update_node with props as a string failed: %s", m.ToolMsg.ToolError)
+	}
+
+	// And describe what's wrong with the rest
+	m = callTool(t, a, cid, "get_node", map[string]any{"id": "Alice"})
+	if want := `invalid id parameter: "Alice" is not an integer`; m.ToolMsg.ToolError != want {
+		t.Errorf("get_node error = %q, want %q", m.ToolMsg.ToolError, want)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// askUserTool is the name of the tool the model calls to ask the user
+// a question. Rather than running like the other tools, its call is
+// left waiting, pausing the chat's tool loop until the user answers.
+const askUserTool = "ask_user"
+
+// errAwaitingAnswer is wrapped by the error returned when asked to
+// generate for a chat whose question to the user is still unanswered.
+var errAwaitingAnswer = errors.New("is waiting for the user to answer a question")
+
+// askTools returns the tool the model uses to ask the user something.
+// It has no handler: handleToolCall leaves its calls waiting for
+// AnswerQuestion (or DeclineQuestion) instead.
+func (a *agent) askTools() []Tool {
+	return []Tool{
+		{
+			Name:        askUserTool,
+			Description: "Asks the user a question and waits for their answer. Use it when a request is ambiguous instead of guessing. Returns the user's answer.",
+			Required:    []string{"question"},
+			Params: map[string]ToolParam{
+				"question": {Type: "string", Description: "The question to ask the user."},
+			},
+		},
+	}
+}
+
+// pendingQuestion returns the question a chat is waiting on the user
+// to answer, if its last message is an unanswered ask_user call.
+func pendingQuestion(ms []Message) (*Message, bool) {
+	if len(ms) == 0 {
+		return nil, false
+	}
+	m := ms[len(ms)-1]
+	if m.MType != "tool" || m.ToolMsg == nil || m.ToolMsg.ToolName != askUserTool {
+		return nil, false
+	}
+	if !m.ToolMsg.ToolDone || m.ToolMsg.ToolResult != "" || m.ToolMsg.ToolError != "" {
+		return nil, false
+	}
+	return &m, true
+}
+
+// questionText returns the question an ask_user call asks.
+func questionText(m Message) string {
+	q, _ := m.ToolMsg.ToolArgs["question"].(string)
+	return q
+}
+
+// AnswerQuestion gives the user's answer to the question a chat is
+// waiting on, so generation can carry on.
+func (c *client) AnswerQuestion(chatID int, answer string) error {
+	data, err := json.Marshal(map[string]string{"answer": answer})
+	if err != nil {
+		return fmt.Errorf("failed to encode answer: %w", err)
+	}
+	return c.finishQuestion(chatID, func(m *Message) {
+		m.ToolMsg.ToolResult = string(data)
+	})
+}
+
+// DeclineQuestion tells the model the user won't answer the question
+// a chat is waiting on, so generation can carry on without it.
+func (c *client) DeclineQuestion(chatID int) error {
+	return c.finishQuestion(chatID, func(m *Message) {
+		m.ToolMsg.ToolError = "the user chose not to answer"
+	})
+}
+
+// finishQuestion applies fn to the chat's pending question and saves it.
+func (c *client) finishQuestion(chatID int, fn func(*Message)) error {
+	ms, err := c.ListMessages(chatID)
+	if err != nil {
+		return err
+	}
+	m, ok := pendingQuestion(ms)
+	if !ok {
+		return fmt.Errorf("question to answer in chat %d %w", chatID, errNotFound)
+	}
+	fn(m)
+	if err := c.UpdateMessage(*m); err != nil {
+		return fmt.Errorf("failed to answer question: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+func TestPendingQuestion(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs string
+		want bool
+	}{
+		{"no messages", `[]`, false},
+		{"unanswered", `[{"MType": "tool", "ToolMsg": {"ToolName": "ask_user", "ToolDone": true}}]`, true},
+		{"answered", `[{"MType": "tool", "ToolMsg": {"ToolName": "ask_user", "ToolDone": true, "ToolResult": "{}"}}]`, false},
+		{"declined", `[{"MType": "tool", "ToolMsg": {"ToolName": "ask_user", "ToolDone": true, "ToolError": "no"}}]`, false},
+		{"not handled yet", `[{"MType": "tool", "ToolMsg": {"ToolName": "ask_user"}}]`, false},
+		{"another tool", `[{"MType": "tool", "ToolMsg": {"ToolName": "get_node", "ToolDone": true}}]`, false},
+		{"not the last message", `[
+			{"MType": "tool", "ToolMsg": {"ToolName": "ask_user", "ToolDone": true}},
+			{"MType": "user", "UserMsg": {"Text": "hi"}}
+		]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ms []Message
+			if err := json.Unmarshal([]byte(tt.msgs), &ms); err != nil {
+				t.Fatal(err)
+			}
+			if _, got := pendingQuestion(ms); got != tt.want {
+				t.Errorf("pendingQuestion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnswerQuestion(t *testing.T) {
+	a, cid := newToolTest(t, agentConfig{})
+	c := a.c
+	if err := c.AnswerQuestion(cid, "Paris"); !errors.Is(err, errNotFound) {
+		t.Errorf("AnswerQuestion() with no question = %v, want %v", err, errNotFound)
+	}
+
+	m := callTool(t, a, cid, askUserTool, map[string]any{"question": "Which city?"})
+	if m.ToolMsg.ToolResult != "" || m.ToolMsg.ToolError != "" {
+		t.Fatalf("ask_user ran instead of waiting: %+v", m.ToolMsg)
+	}
+	if got := questionText(m); got != "Which city?" {
+		t.Errorf("questionText() = %q", got)
+	}
+	if err := c.AnswerQuestion(cid, "Paris"); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := c.ListMessages(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ms[len(ms)-1].ToolMsg.ToolResult; got != `{"answer":"Paris"}` {
+		t.Errorf("answered question's result = %q", got)
+	}
+
+	// It can only be answered once
+	if err := c.AnswerQuestion(cid, "Rome"); !errors.Is(err, errNotFound) {
+		t.Errorf("answering again = %v, want %v", err, errNotFound)
+	}
+	if err := c.DeclineQuestion(cid); !errors.Is(err, errNotFound) {
+		t.Errorf("declining an answered question = %v, want %v", err, errNotFound)
+	}
+}
+
+func TestDeclineQuestion(t *testing.T) {
+	a, cid := newToolTest(t, agentConfig{})
+	callTool(t, a, cid, askUserTool, map[string]any{"question": "Which city?"})
+	if err := a.c.DeclineQuestion(cid); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := a.c.ListMessages(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm := ms[len(ms)-1].ToolMsg; tm.ToolError == "" || tm.ToolResult != "" {
+		t.Errorf("declined question = %+v, want an error", tm)
+	}
+	if _, ok := pendingQuestion(ms); ok {
+		t.Error("declined question is still pending")
+	}
+}
+
+func TestAskUserPausesAndResumes(t *testing.T) {
+	var answer string
+	url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == "tool" {
+			answer = last.Content
+			return textReply("Thanks")
+		}
+		return toolCall(askUserTool, map[string]any{"question": "Which city?"})
+	})
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{})
+	ci, err := c.CreateChat("ask", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "Add my city")
+	ctx := context.Background()
+
+	// The model asks, and generation pauses without an error
+	if err := a.run(ctx, genRequest{cid: ci.ID}); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := c.ListMessages(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pendingQuestion(ms); !ok {
+		t.Fatalf("chat isn't waiting on an answer: %s", messageTypes(ms))
+	}
+	if ci, err := c.GetChat(ci.ID); err != nil || ci.State != "idle" {
+		t.Errorf("waiting chat's state = %q (%v), want idle", ci.State, err)
+	}
+
+	// It won't carry on until the question is answered
+	if err := a.run(ctx, genRequest{cid: ci.ID}); !errors.Is(err, errAwaitingAnswer) {
+		t.Errorf("run() while waiting = %v, want %v", err, errAwaitingAnswer)
+	}
+
+	// Then the model gets the answer and replies
+	if err := c.AnswerQuestion(ci.ID, "Paris"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(ctx, genRequest{cid: ci.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(answer, "Paris") {
+		t.Errorf("model got %q as the answer", answer)
+	}
+	ms, err = c.ListMessages(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageTypes(ms); got != "uta" {
+		t.Errorf("messages = %s, want uta", got)
+	}
+}
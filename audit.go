@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const auditBucket = "__audit"
+
+// AuditEntry records a single change made to the graph.
+type AuditEntry struct {
+	Time   time.Time
+	Op     string          // "create" | "update" | "delete" | "restore"
+	Entity string          // "node" | "edge" | "graph"
+	ID     int             // ID of the node or edge
+	Graph  string          `json:",omitempty"` // Private graph changed (empty for the shared graph)
+	Value  json.RawMessage `json:",omitempty"` // The new value (creates and updates), or the snapshot restored
+}
+
+// writeAudit appends an entry to the audit log within the transaction,
+// so it is only recorded if the change itself is committed.
+func (c *client) writeAudit(tx *bolt.Tx, op, entity string, id int, value any) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(auditBucket))
+	if err != nil {
+		return fmt.Errorf("failed to get/create audit bucket: %w", err)
+	}
+
+	e := AuditEntry{
+		Time:   time.Now().UTC(),
+		Op:     op,
+		Entity: entity,
+		ID:     id,
+		Graph:  c.graph,
+	}
+	if value != nil {
+		if e.Value, err = json.Marshal(value); err != nil {
+			return fmt.Errorf("failed to marshal audit value: %w", err)
+		}
+	}
+
+	seq, err := b.NextSequence()
+	if err != nil {
+		return fmt.Errorf("failed to get next sequence: %w", err)
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	if err := b.Put(itob(int(seq)), data); err != nil {
+		return fmt.Errorf("failed to put audit entry into db: %w", err)
+	}
+	return nil
+}
+
+// AuditLog returns up to limit of the most recent audit
+// entries (or all of them if limit <= 0), newest first.
+func (c *client) AuditLog(limit int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(auditBucket))
+		if b == nil {
+			return nil // Nothing has been logged yet
+		}
+
+		cursor := b.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			if limit > 0 && len(entries) >= limit {
+				break
+			}
+			var e AuditEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("failed to unmarshal audit entry: %w", err)
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
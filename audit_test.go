@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// auditOps summarizes audit entries (oldest first) as "op entity id".
+func auditOps(es []AuditEntry) []string {
+	ops := []string{}
+	for _, e := range slices.Backward(es) {
+		ops = append(ops, fmt.Sprintf("%s %s %d", e.Op, e.Entity, e.ID))
+	}
+	return ops
+}
+
+func TestAuditLog(t *testing.T) {
+	c := newTestClient(t)
+	if es, err := c.AuditLog(0); err != nil || len(es) != 0 {
+		t.Fatalf("AuditLog() of a new database = %v, %v, want nothing", es, err)
+	}
+
+	// Each mutation is logged
+	for _, n := range []string{"Alice", "Bob"} {
+		if _, err := c.CreateNode("person", map[string]any{"name": n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.UpdateNode(1, map[string]any{"age": 30}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateEdge("knows", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateEdge(1, "likes", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteNode(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// But not failed ones
+	if _, err := c.UpdateNode(99, map[string]any{"age": 1}); err == nil {
+		t.Fatal("updated a missing node")
+	}
+
+	es, err := c.AuditLog(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"create node 1",
+		"create node 2",
+		"update node 1",
+		"create edge 1",
+		"update edge 1",
+		"delete node 2",
+		"delete edge 1",
+	}
+	if got := auditOps(es); !slices.Equal(got, want) {
+		t.Errorf("audit log = %q, want %q", got, want)
+	}
+
+	// Writes record the new value; deletes don't
+	for _, e := range es {
+		if e.Time.IsZero() {
+			t.Errorf("entry %+v has no time", e)
+		}
+		if (e.Op == "delete") != (e.Value == nil) {
+			t.Errorf("entry %s %s %d has value %s", e.Op, e.Entity, e.ID, e.Value)
+		}
+	}
+	var n GraphNode
+	if err := json.Unmarshal(es[len(es)-3].Value, &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.ID != 1 || fmt.Sprint(n.Props["age"]) != "30" {
+		t.Errorf("logged update = %+v, want node 1 with age 30", n)
+	}
+
+	// The limit keeps the most recent entries
+	es, err = c.AuditLog(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := auditOps(es); !slices.Equal(got, want[len(want)-2:]) {
+		t.Errorf("AuditLog(2) = %q, want %q", got, want[len(want)-2:])
+	}
+}
+
+func TestAuditCommand(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	c, err := openClientWith(context.Background(), clientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := c.CreateNode("person", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.DeleteNode(1); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	out, err := runApp(t, "audit", "--limit", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "delete node 1") || !strings.Contains(lines[1], "create node 3 {") {
+		t.Errorf("audit printed:\n%s\nwant the delete of node 1, then the create of node 3", out)
+	}
+}
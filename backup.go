@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// backupDir is the directory (within the data directory)
+// startup backups go in, unless configured otherwise.
+const backupDir = "backups"
+
+// Backup writes a consistent copy of the database to the file at p,
+// which can be opened in place of the original. The database can
+// still be used while it's copied.
+func (c *client) Backup(p string) error {
+	return backupDB(c.db, p)
+}
+
+// backupDB copies the open database to the file at p.
+func backupDB(db *bolt.DB, p string) error {
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(p, 0600)
+	}); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// autoBackup backs up the database at dbp into dir as
+// "<name>.<time>.bak", then deletes all but the newest keep backups
+// of it, returning the new backup's path.
+func autoBackup(db *bolt.DB, dbp, dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	base := strings.TrimSuffix(filepath.Base(dbp), ".db")
+	p := filepath.Join(dir, base+"."+time.Now().UTC().Format("20060102T150405.000Z")+".bak")
+	if err := backupDB(db, p); err != nil {
+		return "", err
+	}
+	if err := rotateBackups(dir, base, keep); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// rotateBackups deletes the oldest of a database's backups
+// in dir, so only the newest keep are left.
+func rotateBackups(dir, base string, keep int) error {
+	// Workspace names can't have dots, so this only
+	// matches the one database's backups
+	ps, err := filepath.Glob(filepath.Join(dir, base+".*.bak"))
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	if len(ps) <= keep {
+		return nil
+	}
+
+	// The timestamps sort by name, oldest first
+	sort.Strings(ps)
+	for _, p := range ps[:len(ps)-keep] {
+		if err := os.Remove(p); err != nil {
+			return fmt.Errorf("failed to delete old backup: %w", err)
+		}
+	}
+	return nil
+}
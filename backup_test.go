@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// backups returns the names of the backups in dir, sorted.
+func backups(t *testing.T, dir string) []string {
+	t.Helper()
+	es, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range es {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestRotateBackups(t *testing.T) {
+	all := []string{
+		"agnt.20260101T000000.000Z.bak",
+		"agnt.20260102T000000.000Z.bak",
+		"agnt.20260103T000000.000Z.bak",
+		"agnt.20260104T000000.000Z.bak",
+		"agnt.20260105T000000.000Z.bak",
+	}
+	others := []string{
+		"notes.txt",
+		"work.20260101T000000.000Z.bak",
+		"work.20260102T000000.000Z.bak",
+	}
+	tests := []struct {
+		keep int
+		want []string // The default workspace's backups left
+	}{
+		{1, all[4:]},
+		{3, all[2:]},
+		{5, all},
+		{10, all},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for _, name := range append(slices.Clone(all), others...) {
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rotateBackups(dir, "agnt", tt.keep); err != nil {
+			t.Fatal(err)
+		}
+
+		// Exactly keep of them are left, and nothing else is touched
+		want := append(slices.Clone(tt.want), others...)
+		slices.Sort(want)
+		if got := backups(t, dir); !slices.Equal(got, want) {
+			t.Errorf("keeping %d left %v, want %v", tt.keep, got, want)
+		}
+	}
+}
+
+func TestBackupOnOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		session bool   // Open it like the TUI or server would
+		drop    string // Bucket to delete before reopening (leaving the database to repair)
+		want    int    // Backups made when it's reopened
+	}{
+		{"one-off command", false, "", 0},
+		{"session", true, "", 1},
+		{"repair", false, edgeBucket, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := t.TempDir()
+			dir := filepath.Join(d, backupDir)
+			cfg := clientConfig{BackupKeep: 3}
+
+			// Creating the database doesn't back it up
+			c, err := newClient(context.Background(), d, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.drop != "" {
+				if err := c.db.Update(func(tx *bolt.Tx) error {
+					return tx.DeleteBucket([]byte(tt.drop))
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c.Close()
+			if got := backups(t, dir); len(got) != 0 {
+				t.Fatalf("creating the database made backups %v", got)
+			}
+
+			cfg.BackupOnOpen = tt.session
+			c, err = newClient(context.Background(), d, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Close()
+			if got := backups(t, dir); len(got) != tt.want {
+				t.Errorf("reopening made backups %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupKeepsNewest(t *testing.T) {
+	d := t.TempDir()
+	cfg := clientConfig{BackupKeep: 2, BackupOnOpen: true}
+	for range 4 {
+		c, err := newClient(context.Background(), d, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		time.Sleep(2 * time.Millisecond) // Backups are named to the millisecond
+	}
+	if got := backups(t, filepath.Join(d, backupDir)); len(got) != 2 {
+		t.Errorf("backups = %v, want 2", got)
+	}
+}
+
+func TestCommandsDontBackUp(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+	t.Setenv("AGNT_BACKUP_KEEP", "3")
+	for range 4 {
+		if err := makeApp().Run(context.Background(), []string{"agnt", "status"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := backups(t, filepath.Join(home, "data", "agnt", backupDir)); len(got) != 0 {
+		t.Errorf("one-off commands made backups %v", got)
+	}
+}
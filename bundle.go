@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// bundleVersion is the version of the chat bundle format.
+const bundleVersion = 1
+
+// ChatBundle is a chat exported along with the part of the graph it
+// refers to, so it can be shared and imported elsewhere.
+type ChatBundle struct {
+	Version  int
+	Chat     ChatInfo
+	Messages []Message
+	Nodes    []GraphNode // Nodes created by the chat, and the nodes reachable from them
+	Edges    []GraphEdge // Edges between the bundled nodes
+}
+
+// ExportChatBundle exports a chat's messages along with the nodes it
+// created (and any nodes reachable from those by following outgoing
+// edges) as a JSON bundle.
+func (c *client) ExportChatBundle(chatID int) ([]byte, error) {
+	b := ChatBundle{Version: bundleVersion}
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		// Get the chat and its messages
+		data := tx.Bucket([]byte(chatBucket)).Get(itob(chatID))
+		if data == nil {
+			return fmt.Errorf("chat with ID %d %w", chatID, errNotFound)
+		}
+		if err := json.Unmarshal(data, &b.Chat); err != nil {
+			return fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+		mb := tx.Bucket(b.Chat.MessageBucketName())
+		if mb == nil {
+			return fmt.Errorf("chat messages bucket not found")
+		}
+		if err := mb.ForEach(func(k, v []byte) error {
+			var msg Message
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return fmt.Errorf("failed to unmarshal message: %w", err)
+			}
+			b.Messages = append(b.Messages, msg)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Load the graph (the chat's own, if it has one)
+		g := c.withGraph(b.Chat.Graph)
+		nodes := map[int]GraphNode{}
+		var order []int
+		if err := tx.Bucket(g.graphBucket(nodeBucket)).ForEach(func(k, v []byte) error {
+			var node GraphNode
+			if err := g.decodeNode(v, &node); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			nodes[node.ID] = node
+			order = append(order, node.ID)
+			return nil
+		}); err != nil {
+			return err
+		}
+		var edges []GraphEdge
+		out := map[int][]int{}
+		if err := tx.Bucket(g.graphBucket(edgeBucket)).ForEach(func(k, v []byte) error {
+			var edge GraphEdge
+			if err := json.Unmarshal(v, &edge); err != nil {
+				return fmt.Errorf("failed to unmarshal edge: %w", err)
+			}
+			edges = append(edges, edge)
+			out[edge.FromID] = append(out[edge.FromID], edge.ToID)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Walk out from the nodes the chat created (a private
+		// graph belongs to the chat, so all of it is kept)
+		keep := map[int]bool{}
+		var stack []int
+		for _, id := range order {
+			if nodes[id].SourceChatID == chatID || b.Chat.Graph != "" {
+				keep[id] = true
+				stack = append(stack, id)
+			}
+		}
+		for len(stack) > 0 {
+			id := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, next := range out[id] {
+				if _, ok := nodes[next]; ok && !keep[next] {
+					keep[next] = true
+					stack = append(stack, next)
+				}
+			}
+		}
+
+		// Collect them (in ID order) and the edges between them
+		for _, id := range order {
+			if keep[id] {
+				b.Nodes = append(b.Nodes, nodes[id])
+			}
+		}
+		for _, e := range edges {
+			if keep[e.FromID] && keep[e.ToID] {
+				b.Edges = append(b.Edges, e)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to export chat: %w", err)
+	}
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal chat bundle: %w", err)
+	}
+	return data, nil
+}
+
+// ImportChatBundle recreates a chat bundle as a new chat, along with
+// copies of its nodes and edges, all in one transaction. Everything
+// gets new IDs, and references between them are updated to match.
+func (c *client) ImportChatBundle(data []byte) (*ChatInfo, error) {
+	var b ChatBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal chat bundle: %w", err)
+	}
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported chat bundle version %d", b.Version)
+	}
+
+	var ci *ChatInfo
+	var msgs []Message
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		// Create the chat, with a new private graph if it had one
+		chat := b.Chat
+		chat.State = "idle"
+		chat.Graph = ""
+		var err error
+		if ci, err = createChat(tx, chat); err != nil {
+			return err
+		}
+		if b.Chat.Graph != "" {
+			if err := c.addPrivateGraph(tx, ci); err != nil {
+				return err
+			}
+		}
+		g := c.withGraph(ci.Graph)
+
+		// Give the messages their new IDs, so the nodes'
+		// provenance can point at them
+		mb := tx.Bucket(ci.MessageBucketName())
+		msgIDs := map[int]int{}
+		for _, msg := range b.Messages {
+			if !msg.Valid() {
+				return fmt.Errorf("message %d is malformed", msg.MessageID)
+			}
+			id, err := mb.NextSequence()
+			if err != nil {
+				return fmt.Errorf("failed to get next sequence: %w", err)
+			}
+			msgIDs[msg.MessageID] = int(id)
+		}
+
+		// Add the nodes, pointing their provenance at the new chat
+		nodeIDs := map[int]int{}
+		for _, n := range b.Nodes {
+			old := n.ID
+			if n.SourceChatID == b.Chat.ID {
+				n.SourceChatID = ci.ID
+				n.SourceMessageID = msgIDs[n.SourceMessageID]
+			} else {
+				n.SourceChatID, n.SourceMessageID = 0, 0
+			}
+			node, err := g.createNode(tx, n)
+			if err != nil {
+				return err
+			}
+			nodeIDs[old] = node.ID
+		}
+
+		// Then the messages, pointing their citations at the new
+		// nodes (and dropping any that weren't bundled)
+		for _, msg := range b.Messages {
+			msg.ChatID = ci.ID
+			msg.MessageID = msgIDs[msg.MessageID]
+			switch msg.MType {
+			case "summary":
+				msg.SummaryMsg.After = msgIDs[msg.SummaryMsg.After]
+			case "agent":
+				var cited []int
+				for _, id := range msg.AgentMsg.CitedNodeIDs {
+					if id, ok := nodeIDs[id]; ok {
+						cited = append(cited, id)
+					}
+				}
+				msg.AgentMsg.CitedNodeIDs = cited
+			}
+
+			by, err := json.Marshal(msg)
+			if err != nil {
+				return fmt.Errorf("failed to marshal message: %w", err)
+			}
+			if err := mb.Put(msg.BID(), by); err != nil {
+				return fmt.Errorf("failed to put message into db: %w", err)
+			}
+			msgs = append(msgs, msg)
+		}
+
+		// Then the edges between them
+		for _, e := range b.Edges {
+			from, ok := nodeIDs[e.FromID]
+			if !ok {
+				return fmt.Errorf("edge %d starts at node %d, which isn't in the bundle", e.ID, e.FromID)
+			}
+			to, ok := nodeIDs[e.ToID]
+			if !ok {
+				return fmt.Errorf("edge %d ends at node %d, which isn't in the bundle", e.ID, e.ToID)
+			}
+			if _, err := g.createEdge(tx, e.Type, from, to, e.Props); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to import chat: %w", err)
+	}
+	c.publish(msgs...)
+	return ci, nil
+}
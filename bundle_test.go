@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestChatBundleRoundTrip(t *testing.T) {
+	// The chat creates alice (who knows bob), and cites
+	// both of them plus an unrelated node in its reply
+	src := newTestClient(t)
+	other, err := src.CreateNode("person", map[string]any{"name": "Other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci, err := src.CreateChat("bundle", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := seedMessages(t, src, ci.ID, "ut")
+	alice, err := src.CreateNodeFromChat("person", map[string]any{"name": "Alice"}, ci.ID, ms[1].MessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := src.CreateNode("person", map[string]any{"name": "Bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.CreateEdge("knows", alice.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	var reply Message
+	if err := json.Unmarshal([]byte(`{"MType": "agent", "AgentMsg": {"Text": "Alice knows Bob"}}`), &reply); err != nil {
+		t.Fatal(err)
+	}
+	reply.ChatID = ci.ID
+	reply.AgentMsg.CitedNodeIDs = []int{alice.ID, bob.ID, other.ID}
+	if _, err := src.CreateMessage(reply); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := src.ExportChatBundle(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Import it somewhere whose IDs are already taken
+	dst := newTestClient(t)
+	for range 3 {
+		if _, err := dst.CreateNode("city", map[string]any{"name": "Somewhere"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dst.CreateChat("existing", ""); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := dst.ImportChatBundle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dst.ListMessages(imported.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types := messageTypes(got); types != "uta" {
+		t.Fatalf("imported messages = %s, want uta", types)
+	}
+
+	// The nodes came along, pointing back at the imported chat
+	people, err := dst.ListNodes("person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]GraphNode{}
+	for _, n := range people {
+		names[n.Props["name"].(string)] = n
+	}
+	newAlice, newBob := names["Alice"], names["Bob"]
+	if len(people) != 2 || newAlice.ID == 0 || newBob.ID == 0 {
+		t.Fatalf("imported people = %v, want Alice and Bob", people)
+	}
+	if newAlice.SourceChatID != imported.ID || newAlice.SourceMessageID != got[1].MessageID {
+		t.Errorf("Alice came from chat %d message %d, want %d and %d", newAlice.SourceChatID, newAlice.SourceMessageID, imported.ID, got[1].MessageID)
+	}
+	edges, err := dst.ListEdges(EdgeFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edges) != 1 || edges[0].FromID != newAlice.ID || edges[0].ToID != newBob.ID {
+		t.Errorf("imported edges = %v, want Alice knows Bob", edges)
+	}
+
+	// And the reply cites the new nodes, without the one left behind
+	if cited := got[2].AgentMsg.CitedNodeIDs; !slices.Equal(cited, []int{newAlice.ID, newBob.ID}) {
+		t.Errorf("reply cites %v, want %v", cited, []int{newAlice.ID, newBob.ID})
+	}
+}
+
+func TestImportChatBundleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", `nope`},
+		{"wrong version", `{"Version": 99}`},
+		{"malformed message", `{"Version": 1, "Messages": [{"MessageID": 1, "MType": "user"}]}`},
+		{"edge to a missing node", `{"Version": 1, "Nodes": [{"ID": 1, "Type": "person"}], "Edges": [{"ID": 1, "Type": "knows", "FromID": 1, "ToID": 2}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			if _, err := c.ImportChatBundle([]byte(tt.data)); err == nil {
+				t.Fatal("import didn't fail")
+			}
+			// Nothing was left behind
+			chats, err := c.ListChats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodes, err := c.ListNodes("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chats) != 0 || len(nodes) != 0 {
+				t.Errorf("failed import left %d chats and %d nodes", len(chats), len(nodes))
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// labelProps are the props that name a node, in order of preference.
+var labelProps = []string{"name", "title", "label"}
+
+// nodeRefPattern matches a reference to a node by ID, like "#3".
+var nodeRefPattern = regexp.MustCompile(`#(\d+)\b`)
+
+// citedNodeIDs returns the IDs of the nodes the agent's reply drew on,
+// sorted: those read or written by the tool calls in the current turn
+// (the messages after the last user message) that the reply mentions,
+// by ID (like "#3") or by name.
+func citedNodeIDs(ms []Message, reply string) []int {
+	// Find the nodes the turn's tool calls returned, with their names
+	nodes := map[int][]string{}
+	for i := len(ms) - 1; i >= 0; i-- {
+		m := ms[i]
+		if m.MType == "user" {
+			break
+		}
+		if m.MType != "tool" || m.ToolMsg == nil || m.ToolMsg.ToolError != "" || m.ToolMsg.ToolResult == "" {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader([]byte(m.ToolMsg.ToolResult)))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			continue
+		}
+		collectNodes(v, nodes)
+	}
+
+	// Then keep the ones the reply mentions
+	refs := map[int]bool{}
+	for _, m := range nodeRefPattern.FindAllStringSubmatch(reply, -1) {
+		if id, err := strconv.Atoi(m[1]); err == nil {
+			refs[id] = true
+		}
+	}
+	var out []int
+	for id, names := range nodes {
+		cited := refs[id]
+		for _, name := range names {
+			cited = cited || containsWords(reply, name)
+		}
+		if cited {
+			out = append(out, id)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// collectNodes finds the nodes in a decoded tool result (anything
+// shaped like a GraphNode: an ID, a Type, and Props, but no FromID)
+// and adds them to nodes, with the names from their label props.
+func collectNodes(v any, nodes map[int][]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		_, hasType := v["Type"]
+		props, hasProps := v["Props"].(map[string]any)
+		_, isEdge := v["FromID"]
+		if n, ok := v["ID"].(json.Number); ok && hasType && hasProps && !isEdge {
+			if id, err := n.Int64(); err == nil {
+				names := nodes[int(id)]
+				for _, k := range labelProps {
+					if s, ok := props[k].(string); ok && strings.TrimSpace(s) != "" {
+						names = append(names, s)
+					}
+				}
+				nodes[int(id)] = names
+			}
+		}
+		for _, x := range v {
+			collectNodes(x, nodes)
+		}
+	case []any:
+		for _, x := range v {
+			collectNodes(x, nodes)
+		}
+	}
+}
+
+// containsWords reports whether text contains s (ignoring case) as
+// whole words, so "Al" isn't found in "Alice".
+func containsWords(text, s string) bool {
+	text, s = strings.ToLower(text), strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return false
+	}
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for i := 0; ; {
+		j := strings.Index(text[i:], s)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(s)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !isWord(before) && !isWord(after) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+// MessageCitations returns the nodes an agent message cites, from the
+// graph its chat uses. Nodes deleted since the reply are left out.
+func (c *client) MessageCitations(chatID, messageID int) ([]GraphNode, error) {
+	msg, err := c.GetMessage(chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.MType != "agent" || msg.AgentMsg == nil {
+		return nil, nil
+	}
+	g, err := c.ForChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []GraphNode
+	for _, id := range msg.AgentMsg.CitedNodeIDs {
+		n, err := g.GetNode(id)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, *n)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+func TestCitedNodeIDs(t *testing.T) {
+	var ms []Message
+	if err := json.Unmarshal([]byte(`[
+		{"MType": "user", "UserMsg": {"Text": "earlier"}},
+		{"MType": "tool", "ToolMsg": {"ToolName": "get_node", "ToolDone": true, "ToolResult": "{\"ID\":9,\"Type\":\"person\",\"Props\":{\"name\":\"Old\"}}"}},
+		{"MType": "agent", "AgentMsg": {"Text": "Old"}},
+		{"MType": "user", "UserMsg": {"Text": "who's where?"}},
+		{"MType": "tool", "ToolMsg": {"ToolName": "list_nodes", "ToolDone": true, "ToolResult": "{\"nodes\":[{\"ID\":1,\"Type\":\"person\",\"Props\":{\"name\":\"Alice\"}},{\"ID\":2,\"Type\":\"person\",\"Props\":{\"name\":\"Al\"}},{\"ID\":3,\"Type\":\"city\",\"Props\":{\"title\":\"New York\"}},{\"ID\":4,\"Type\":\"thing\",\"Props\":{}}]}"}},
+		{"MType": "tool", "ToolMsg": {"ToolName": "get_edge", "ToolDone": true, "ToolResult": "{\"ID\":5,\"Type\":\"knows\",\"FromID\":1,\"ToID\":2,\"Props\":{}}"}},
+		{"MType": "tool", "ToolMsg": {"ToolName": "get_node", "ToolDone": true, "ToolError": "node with ID 6 not found"}}
+	]`), &ms); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		reply string
+		want  []int
+	}{
+		{"Alice lives in New York.", []int{1, 3}},
+		{"alice and NEW YORK", []int{1, 3}},
+		{"Node #4 has no name, and neither does #40.", []int{4}},
+		{"Alice's friend is Al.", []int{1, 2}},
+		{"Alfred and Alicent aren't there.", nil},
+		{"Edge #5 links them.", nil},   // Edges aren't cited
+		{"Old news.", nil},             // Only this turn's nodes
+		{"I couldn't find #6.", nil},   // Failed calls have no nodes
+		{"There are four nodes.", nil}, // Listed isn't cited
+		{"#1, #2, #3 and #4 are all here", []int{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		if got := citedNodeIDs(ms, tt.reply); !slices.Equal(got, tt.want) {
+			t.Errorf("citedNodeIDs(%q) = %v, want %v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestContainsWords(t *testing.T) {
+	tests := []struct {
+		text, s string
+		want    bool
+	}{
+		{"Alice knows Bob", "bob", true},
+		{"Alice knows Bob", "Alice knows", true},
+		{"Alicent", "Alice", false},
+		{"Al and Alice", "Alice", true},
+		{"Alicent, then Alice", "Alice", true},
+		{"Paris.", "Paris", true},
+		{"(Zürich)", "zürich", true},
+		{"anything", "", false},
+	}
+	for _, tt := range tests {
+		if got := containsWords(tt.text, tt.s); got != tt.want {
+			t.Errorf("containsWords(%q, %q) = %v, want %v", tt.text, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateCitesNodes(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+			if req.Messages[len(req.Messages)-1].Role == "tool" {
+				return textReply("Alice knows Bob.")
+			}
+			return toolCall("list_nodes", map[string]any{"node_type": "person"})
+		})
+		c := testGraph(t)
+		a := newTestAgent(t, c, url, agentConfig{Stream: stream})
+		ci, err := c.CreateChat("cite", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addUserMessage(t, c, ci.ID, "who knows who?")
+		if err := a.run(context.Background(), genRequest{cid: ci.ID}); err != nil {
+			t.Fatal(err)
+		}
+
+		ms, err := c.ListMessages(ci.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := ms[len(ms)-1]
+		if reply.AgentMsg == nil {
+			t.Fatalf("stream=%v: last message is a %s", stream, reply.MType)
+		}
+		if got, want := reply.AgentMsg.CitedNodeIDs, []int{1, 2}; !slices.Equal(got, want) {
+			t.Errorf("stream=%v: cited %v, want %v", stream, got, want)
+		}
+		nodes, err := c.MessageCitations(ci.ID, reply.MessageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := nodeIDs(nodes); !slices.Equal(got, []int{1, 2}) {
+			t.Errorf("stream=%v: MessageCitations() = %v", stream, got)
+		}
+	}
+}
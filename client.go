@@ -1,52 +1,172 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
 )
 
 const (
+	untitledChat  = "New chat"
 	confDir       = ".agnt"
 	dbFile        = "agnt.db"
 	schemaVersion = "v1"
 	metaBucket    = "__meta"
 	versionKey    = "version"
+	lastChatKey   = "last_chat"
+	startedKey    = "started" // Set once the app has started (and made its first chat)
+	draftPrefix   = "draft:"
 	chatBucket    = "chats"
 	messageBucket = "messages"
 	nodeBucket    = "graph:nodes"
 	edgeBucket    = "graph:edges"
+
+	// messagesPrefix starts the name of each chat's message
+	// bucket (followed by the chat's ID; see MessageBucketName).
+	messagesPrefix = "#MESSAGES#"
 )
 
+// requiredBuckets are the buckets every database must have,
+// created when it's opened if they're missing.
+var requiredBuckets = []string{chatBucket, nodeBucket, edgeBucket}
+
+// missingBuckets returns the names of the buckets the database should
+// have but doesn't: any of the required buckets, and each chat's
+// message bucket.
+func missingBuckets(tx *bolt.Tx) ([][]byte, error) {
+	var missing [][]byte
+	for _, name := range requiredBuckets {
+		if tx.Bucket([]byte(name)) == nil {
+			missing = append(missing, []byte(name))
+		}
+	}
+	cb := tx.Bucket([]byte(chatBucket))
+	if cb == nil {
+		return missing, nil
+	}
+	if err := cb.ForEach(func(k, v []byte) error {
+		var ci ChatInfo
+		if err := json.Unmarshal(v, &ci); err != nil {
+			return fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+		if tx.Bucket(ci.MessageBucketName()) == nil {
+			missing = append(missing, ci.MessageBucketName())
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// bucketLabel names a bucket for people, since
+// message buckets' names end in a binary chat ID.
+func bucketLabel(name []byte) string {
+	if id, ok := bytes.CutPrefix(name, []byte(messagesPrefix)); ok && len(id) == 8 {
+		return fmt.Sprintf("messages of chat %d", binary.BigEndian.Uint64(id))
+	}
+	return string(name)
+}
+
+// errNotFound is wrapped by the errors returned when a
+// chat, message, node, or edge doesn't exist.
+var errNotFound = errors.New("not found")
+
+// errSelfLoop is returned when creating an edge from a node to
+// itself while self-loops are disabled.
+var errSelfLoop = errors.New("self-loops are not allowed")
+
+// clientConfig holds the user-configurable database settings.
+type clientConfig struct {
+	LockTimeout  time.Duration // How long to wait for another process to release the database (0 waits forever)
+	NoSync       bool          // Skip syncing to disk after each commit (faster, but unsafe if the system crashes)
+	FreelistType string        // Freelist backend: "array" or "hashmap" (empty uses bolt's default)
+	UseNumber    bool          // Read numeric node properties as json.Number, so integers stay exact
+	NoSelfLoops  bool          // Reject edges from a node to itself
+	TrackAccess  bool          // Record when nodes are read (turning reads into writes)
+	Workspace    string        // Workspace whose database to open (empty for the default)
+	BackupKeep   int           // Back up the database before repairing it (or on every open, with BackupOnOpen), keeping this many backups (0 disables)
+	BackupDir    string        // Directory to put the backups in (the data directory's "backups" if empty)
+	BackupOnOpen bool          // Back up the database whenever it's opened, not just before repairs (for the TUI and server, not one-off commands)
+}
+
 // client manages state
 type client struct {
-	dbp string
-	db  *bolt.DB
+	dbp         string
+	db          *bolt.DB
+	subs        *subscribers // Listeners for new and updated messages
+	useNumber   bool         // Decode node properties' numbers as json.Number
+	noSelfLoops bool         // Reject edges from a node to itself
+	trackAccess bool         // Update nodes' LastAccessedAt when they're read
+	graph       string       // Private graph the graph methods work on (empty for the shared graph)
 }
 
-func newClient(ctx context.Context, d string) (*client, error) {
-	// Format the config dir
-	p := path.Join(d, confDir)
-
-	// Make the config directory if it doesn't exist
-	if err := os.MkdirAll(p, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create config directory: %w", err)
+// newClient opens (or creates) the database in the data directory d.
+func newClient(ctx context.Context, d string, cfg clientConfig) (*client, error) {
+	// Make the data directory if it doesn't exist
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	// Add the db name
-	p = path.Join(p, dbFile)
+	// Add the db name (which depends on the workspace)
+	f, err := workspaceDBFile(cfg.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	p := filepath.Join(d, f)
 
 	// Open the bolt database
-	db, err := bolt.Open(p, 0600, nil)
+	opts := &bolt.Options{
+		Timeout: cfg.LockTimeout,
+		NoSync:  cfg.NoSync,
+	}
+	switch ft := bolt.FreelistType(cfg.FreelistType); ft {
+	case "":
+	case bolt.FreelistArrayType, bolt.FreelistMapType:
+		opts.FreelistType = ft
+	default:
+		return nil, fmt.Errorf("unknown freelist type %q", cfg.FreelistType)
+	}
+	db, err := bolt.Open(p, 0600, opts)
+	if errors.Is(err, berrors.ErrTimeout) {
+		return nil, fmt.Errorf("database is locked by another agnt process")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
+	// Back it up first if it's about to be repaired (or whenever
+	// it's opened, if asked to), so there's a copy to go back to if
+	// anything below goes wrong
+	if cfg.BackupKeep > 0 {
+		repair, err := needsRepair(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		dir := cfg.BackupDir
+		if dir == "" {
+			dir = filepath.Join(d, backupDir)
+		}
+		if repair || cfg.BackupOnOpen {
+			if _, err := autoBackup(db, p, dir, cfg.BackupKeep); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+	}
+
 	// Check if the version key is set and if
 	// updates need to be run
 	if err := db.Update(func(tx *bolt.Tx) error {
@@ -64,45 +184,73 @@ func newClient(ctx context.Context, d string) (*client, error) {
 				return fmt.Errorf("failed to set version key: %w", err)
 			}
 
-			// Create the chat bucket
-			if _, err := tx.CreateBucket([]byte(chatBucket)); err != nil {
-				return fmt.Errorf("failed to create meta bucket: %w", err)
-			}
-
-			// Create the node bucket
-			if _, err := tx.CreateBucket([]byte(nodeBucket)); err != nil {
-				return fmt.Errorf("failed to create graph node bucket: %w", err)
-			}
-
-			// Create the edge bucket
-			if _, err := tx.CreateBucket([]byte(edgeBucket)); err != nil {
-				return fmt.Errorf("failed to create graph edge bucket: %w", err)
-			}
-
-			return nil
-
 		case versionKey:
 			// Already set? No need to do anything
-			return nil
 
 		default:
 			// Unknown! Stop here.
 			return fmt.Errorf("unknown version %q", string(v))
 		}
+
+		// Make sure the required buckets (and each chat's messages)
+		// exist. A new database gets them here, and one that was only
+		// partly set up (e.g. the version was set but creating a
+		// bucket failed) is repaired.
+		missing, err := missingBuckets(tx)
+		if err != nil {
+			return err
+		}
+		for _, name := range missing {
+			if _, err := tx.CreateBucket(name); err != nil {
+				return fmt.Errorf("failed to create %s bucket: %w", bucketLabel(name), err)
+			}
+		}
+		return nil
 	}); err != nil {
 		return nil, fmt.Errorf("failed to update database: %w", err)
 	}
 
-	// TODO: Set up channels?
-	// ...
-
 	// Return the client
 	return &client{
-		dbp: p,
-		db:  db,
+		dbp:         p,
+		db:          db,
+		subs:        &subscribers{},
+		useNumber:   cfg.UseNumber,
+		noSelfLoops: cfg.NoSelfLoops,
+		trackAccess: cfg.TrackAccess,
 	}, nil
 }
 
+// needsRepair reports whether opening an existing database will
+// change it (setting its version or adding missing buckets). A new,
+// empty database doesn't count, since there's nothing to lose.
+func needsRepair(db *bolt.DB) (bool, error) {
+	var repair bool
+	if err := db.View(func(tx *bolt.Tx) error {
+		empty := true
+		if err := tx.ForEach(func([]byte, *bolt.Bucket) error {
+			empty = false
+			return nil
+		}); err != nil {
+			return err
+		}
+		if empty {
+			return nil
+		}
+		mb := tx.Bucket([]byte(metaBucket))
+		if mb == nil || string(mb.Get([]byte(versionKey))) != versionKey {
+			repair = true
+			return nil
+		}
+		missing, err := missingBuckets(tx)
+		repair = len(missing) > 0
+		return err
+	}); err != nil {
+		return false, fmt.Errorf("failed to check database: %w", err)
+	}
+	return repair, nil
+}
+
 func (c *client) Close() error {
 	if err := c.db.Close(); err != nil {
 		return fmt.Errorf("failed to close database: %w", err)
@@ -112,8 +260,13 @@ func (c *client) Close() error {
 }
 
 type ChatInfo struct {
-	ID   int
-	Name string
+	ID           int
+	Name         string
+	State        string    // "idle" | "running" (empty means idle)
+	SystemPrompt string    // Overrides the default system prompt (if not empty)
+	Graph        string    `json:",omitempty"` // Private graph the chat's tools use (empty for the shared graph)
+	Untitled     bool      `json:",omitempty"` // Still has the placeholder name, so the agent titles it from the first message
+	UpdatedAt    time.Time `json:",omitzero"`  // When messages were last added (zero for chats from before it was tracked)
 }
 
 func (ci ChatInfo) BID() []byte {
@@ -121,7 +274,7 @@ func (ci ChatInfo) BID() []byte {
 }
 
 func (ci ChatInfo) MessageBucketName() []byte {
-	return append([]byte(`#MESSAGES#`), itob(ci.ID)...)
+	return append([]byte(messagesPrefix), itob(ci.ID)...)
 }
 
 // ListChats retrieves all chat threads from the database.
@@ -143,72 +296,554 @@ func (c *client) ListChats() ([]ChatInfo, error) {
 	return chats, nil
 }
 
-// CreateChat adds a new chat thread to the database.
-func (c *client) CreateChat(n string) (*ChatInfo, error) {
+// CreateChat adds a new chat thread to the database. The system
+// prompt may be empty to use the default.
+func (c *client) CreateChat(n, systemPrompt string) (*ChatInfo, error) {
 	var ci *ChatInfo
 	if err := c.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(chatBucket))
-		id, err := b.NextSequence()
-		if err != nil {
-			return fmt.Errorf("failed to get next sequence: %w", err)
+		var err error
+		ci, err = createChat(tx, ChatInfo{
+			Name:         n,
+			State:        "idle",
+			SystemPrompt: systemPrompt,
+		})
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read from db: %w", err)
+	}
+	return ci, nil
+}
+
+// createChat stores a new chat (assigning its ID) and creates its
+// message bucket within the transaction. A chat without a name gets
+// the placeholder untitledChat until the agent titles it.
+func createChat(tx *bolt.Tx, ci ChatInfo) (*ChatInfo, error) {
+	if ci.Name == "" {
+		ci.Name, ci.Untitled = untitledChat, true
+	}
+	b := tx.Bucket([]byte(chatBucket))
+	id, err := b.NextSequence()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get next sequence: %w", err)
+	}
+
+	// Set the ID and marshall it
+	ci.ID = int(id)
+	ci.UpdatedAt = time.Now().UTC()
+	by, err := json.Marshal(ci)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal chat info as json: %w", err)
+	}
+
+	// Store it in the database
+	if err := b.Put(ci.BID(), by); err != nil {
+		return nil, fmt.Errorf("failed to put chat info into db: %w", err)
+	}
+
+	// Create a new bucket for the chat messages
+	if _, err := tx.CreateBucket(ci.MessageBucketName()); err != nil {
+		return nil, fmt.Errorf("failed to create chat messages bucket: %w", err)
+	}
+	return &ci, nil
+}
+
+// ForkChat creates a new chat with a copy of another chat's messages
+// up to and including the given message. The fork keeps the source
+// chat's settings but is independent of it afterwards.
+func (c *client) ForkChat(chatID, upToMessageID int, name string) (*ChatInfo, error) {
+	ci, err := c.copyChat(chatID, upToMessageID, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fork chat: %w", err)
+	}
+	return ci, nil
+}
+
+// DuplicateChat creates a new chat with a full copy of another chat's
+// messages and settings.
+func (c *client) DuplicateChat(chatID int, newName string) (*ChatInfo, error) {
+	ci, err := c.copyChat(chatID, 0, newName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to duplicate chat: %w", err)
+	}
+	return ci, nil
+}
+
+// copyChat creates a new chat with the settings of an existing one and
+// copies over its messages (reassigning their IDs), stopping after the
+// message upTo (or copying them all if upTo is 0).
+func (c *client) copyChat(chatID, upTo int, name string) (*ChatInfo, error) {
+	var ci *ChatInfo
+	var copied []Message
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		// Get the source chat
+		data := tx.Bucket([]byte(chatBucket)).Get(itob(chatID))
+		if data == nil {
+			return fmt.Errorf("chat with ID %d %w", chatID, errNotFound)
+		}
+		var src ChatInfo
+		if err := json.Unmarshal(data, &src); err != nil {
+			return fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+		sb := tx.Bucket(src.MessageBucketName())
+		if sb == nil {
+			return fmt.Errorf("chat messages bucket not found")
+		}
+		if upTo > 0 && sb.Get(itob(upTo)) == nil {
+			return fmt.Errorf("message with ID %d %w", upTo, errNotFound)
 		}
 
-		// Define the new object and marshall it
-		ci = &ChatInfo{
-			ID:   int(id),
-			Name: n,
+		// Create the new chat with the same settings
+		dst := src
+		dst.Name = name
+		dst.State = "idle"
+		var err error
+		if ci, err = createChat(tx, dst); err != nil {
+			return err
 		}
-		by, err := json.Marshal(ci)
+
+		// Copy the messages over
+		copied, err = copyMessages(sb, tx.Bucket(ci.MessageBucketName()), ci.ID, upTo)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	c.publish(copied...)
+	return ci, nil
+}
+
+// copyMessages appends the messages in the bucket src to the end of
+// the chat dstID's bucket dst, in the order they're listed (see
+// orderMessages) and reassigning their IDs, stopping after the message
+// upTo (or copying them all if upTo is 0). It returns the copies.
+func copyMessages(src, dst *bolt.Bucket, dstID, upTo int) ([]Message, error) {
+	var msgs []Message
+	cursor := src.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var msg Message
+		if err := json.Unmarshal(v, &msg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	var copied []Message
+	ids := map[int]int{} // Old IDs to new
+	for _, msg := range orderMessages(msgs) {
+		old := msg.MessageID
+		id, err := dst.NextSequence()
 		if err != nil {
-			return fmt.Errorf("failed to marshal chat info as json: %w", err)
+			return nil, fmt.Errorf("failed to get next sequence: %w", err)
+		}
+		ids[old] = int(id)
+		msg.ChatID = dstID
+		msg.MessageID = int(id)
+		if msg.MType == "summary" && msg.SummaryMsg != nil {
+			msg.SummaryMsg.After = ids[msg.SummaryMsg.After]
 		}
 
-		// Store it in the database
-		if err := b.Put(ci.BID(), by); err != nil {
-			return fmt.Errorf("failed to put chat info into db: %w", err)
+		by, err := json.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal message: %w", err)
+		}
+		if err := dst.Put(msg.BID(), by); err != nil {
+			return nil, fmt.Errorf("failed to put message into db: %w", err)
+		}
+		copied = append(copied, msg)
+		if old == upTo {
+			break
+		}
+	}
+	return copied, nil
+}
+
+// MergeChats appends all of one chat's messages to the end of another,
+// in order and with new IDs, and bumps its UpdatedAt. The source chat
+// is then deleted if deleteFrom is set (in the same transaction, so
+// both or neither happen), or left as it was otherwise. Neither chat
+// may be running.
+//
+// Messages only move between chats; if the chats use different graphs,
+// the node IDs in the source's tool calls refer to its own graph.
+func (c *client) MergeChats(intoID, fromID int, deleteFrom bool) error {
+	if intoID == fromID {
+		return fmt.Errorf("failed to merge chats: can't merge chat %d into itself", intoID)
+	}
+	var copied []Message
+	var deleted []int
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(chatBucket))
+		buckets := make([]*bolt.Bucket, 2)
+		for i, id := range []int{intoID, fromID} {
+			data := b.Get(itob(id))
+			if data == nil {
+				return fmt.Errorf("chat with ID %d %w", id, errNotFound)
+			}
+			var ci ChatInfo
+			if err := json.Unmarshal(data, &ci); err != nil {
+				return fmt.Errorf("failed to unmarshal chat info: %w", err)
+			}
+			if ci.State == "running" {
+				return fmt.Errorf("chat %d is running", id)
+			}
+			if buckets[i] = tx.Bucket(ci.MessageBucketName()); buckets[i] == nil {
+				return fmt.Errorf("chat messages bucket not found")
+			}
+		}
+		var err error
+		if copied, err = copyMessages(buckets[1], buckets[0], intoID, 0); err != nil {
+			return err
+		}
+		if err := touchChat(tx, intoID); err != nil {
+			return err
+		}
+		if deleteFrom {
+			deleted, err = c.deleteChat(tx, fromID)
+			return err
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to merge chats: %w", err)
+	}
+	c.publish(copied...)
+	c.publishDeleted(fromID, deleted...)
+	return nil
+}
+
+// GetChat retrieves a chat thread's info from the database.
+func (c *client) GetChat(id int) (*ChatInfo, error) {
+	var ci *ChatInfo
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(chatBucket)).Get(itob(id))
+		if data == nil {
+			return fmt.Errorf("chat with ID %d %w", id, errNotFound)
+		}
+
+		ci = &ChatInfo{}
+		if err := json.Unmarshal(data, ci); err != nil {
+			return fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get chat: %w", err)
+	}
+	return ci, nil
+}
+
+// ClearChat deletes all of a chat's messages while keeping the chat
+// itself (and its settings). The chat must not be running.
+func (c *client) ClearChat(id int) error {
+	var deleted []int
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(chatBucket)).Get(itob(id))
+		if data == nil {
+			return fmt.Errorf("chat with ID %d %w", id, errNotFound)
+		}
+		var ci ChatInfo
+		if err := json.Unmarshal(data, &ci); err != nil {
+			return fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+		if ci.State == "running" {
+			return fmt.Errorf("chat %d is running", id)
 		}
 
-		// Create a new bucket for the chat messages
+		// Replace the message bucket with an empty one
+		if mb := tx.Bucket(ci.MessageBucketName()); mb != nil {
+			deleted = messageIDs(mb)
+		}
+		if err := tx.DeleteBucket(ci.MessageBucketName()); err != nil {
+			return fmt.Errorf("failed to delete chat messages bucket: %w", err)
+		}
 		if _, err := tx.CreateBucket(ci.MessageBucketName()); err != nil {
 			return fmt.Errorf("failed to create chat messages bucket: %w", err)
 		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to clear chat: %w", err)
+	}
+	c.publishDeleted(id, deleted...)
+	return nil
+}
+
+// RenameChat updates a chat thread's name.
+func (c *client) RenameChat(id int, name string) error {
+	if err := c.updateChat(id, func(ci *ChatInfo) {
+		ci.Name, ci.Untitled = name, false
+	}); err != nil {
+		return fmt.Errorf("failed to rename chat: %w", err)
+	}
+	return nil
+}
+
+// SetChatState updates a chat thread's state ("idle" or "running").
+func (c *client) SetChatState(id int, state string) error {
+	if err := c.updateChat(id, func(ci *ChatInfo) {
+		ci.State = state
+	}); err != nil {
+		return fmt.Errorf("failed to set chat state: %w", err)
+	}
+	return nil
+}
+
+// SetChatPrompt updates a chat thread's system prompt. An empty
+// prompt means the chat falls back to the default.
+func (c *client) SetChatPrompt(id int, prompt string) error {
+	if err := c.updateChat(id, func(ci *ChatInfo) {
+		ci.SystemPrompt = prompt
+	}); err != nil {
+		return fmt.Errorf("failed to set chat prompt: %w", err)
+	}
+	return nil
+}
+
+// SetLastChat records the chat that was open most recently,
+// so it can be reopened on the next start.
+func (c *client) SetLastChat(id int) error {
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(metaBucket)).Put([]byte(lastChatKey), itob(id))
+	}); err != nil {
+		return fmt.Errorf("failed to set last chat: %w", err)
+	}
+	return nil
+}
+
+// SaveDraft stores the unsent text in a chat's input box
+// (or removes it, if the text is empty).
+func (c *client) SaveDraft(chatID int, text string) error {
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(chatBucket)).Get(itob(chatID)) == nil {
+			return fmt.Errorf("chat with ID %d %w", chatID, errNotFound)
+		}
+		b := tx.Bucket([]byte(metaBucket))
+		if text == "" {
+			return b.Delete(draftKey(chatID))
+		}
+		return b.Put(draftKey(chatID), []byte(text))
+	}); err != nil {
+		return fmt.Errorf("failed to save draft: %w", err)
+	}
+	return nil
+}
 
+// GetDraft returns the unsent text saved for a chat
+// (or an empty string if there isn't any).
+func (c *client) GetDraft(chatID int) (string, error) {
+	var text string
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		text = string(tx.Bucket([]byte(metaBucket)).Get(draftKey(chatID)))
 		return nil
 	}); err != nil {
-		return nil, fmt.Errorf("failed to read from db: %w", err)
+		return "", fmt.Errorf("failed to get draft: %w", err)
 	}
-	return ci, nil
+	return text, nil
 }
 
-// DeleteChat removes a chat thread from the database.
-func (c *client) DeleteChat(id int) error {
+// draftKey is the meta key a chat's draft is stored under.
+func draftKey(chatID int) []byte {
+	return []byte(draftPrefix + strconv.Itoa(chatID))
+}
+
+// StartupChat returns the ID of the chat to open on start: the last
+// chat that was open if it still exists, otherwise the first chat,
+// otherwise a newly created one. Only the chat made on first run gets
+// the given name (if it isn't empty); if every chat is deleted later,
+// the one made then is untitled, like any other new chat.
+func (c *client) StartupChat(name string) (int, error) {
+	var id int
 	if err := c.db.Update(func(tx *bolt.Tx) error {
-		// Delete the record in the chat bucket
+		cb := tx.Bucket([]byte(chatBucket))
+		mb := tx.Bucket([]byte(metaBucket))
+
+		// Note that this isn't the first run any more, even if
+		// there are already chats (from before this was recorded)
+		first := mb.Get([]byte(startedKey)) == nil
+		if first {
+			if err := mb.Put([]byte(startedKey), []byte{1}); err != nil {
+				return fmt.Errorf("failed to record first run: %w", err)
+			}
+		}
+
+		// Try the last chat
+		if v := mb.Get([]byte(lastChatKey)); v != nil {
+			if last := int(binary.BigEndian.Uint64(v)); cb.Get(itob(last)) != nil {
+				id = last
+				return nil
+			}
+		}
+
+		// Then the first one
+		if k, _ := cb.Cursor().First(); k != nil {
+			id = int(binary.BigEndian.Uint64(k))
+			return nil
+		}
+
+		// Otherwise, make one
+		if !first {
+			name = ""
+		}
+		ci, err := createChat(tx, ChatInfo{Name: name, State: "idle"})
+		if err != nil {
+			return err
+		}
+		id = ci.ID
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to get startup chat: %w", err)
+	}
+	return id, nil
+}
+
+// updateChat loads a chat's info, applies fn to it, and stores it again.
+func (c *client) updateChat(id int, fn func(*ChatInfo)) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(chatBucket))
-		if err := b.Delete(itob(id)); err != nil {
-			return fmt.Errorf("failed to delete chat info from db: %w", err)
+		data := b.Get(itob(id))
+		if data == nil {
+			return fmt.Errorf("chat with ID %d %w", id, errNotFound)
 		}
 
-		// Delete the whole chat's message bucket
-		if err := tx.DeleteBucket(ChatInfo{ID: id}.MessageBucketName()); err != nil {
-			return fmt.Errorf("failed to delete chat messages bucket: %w", err)
+		var ci ChatInfo
+		if err := json.Unmarshal(data, &ci); err != nil {
+			return fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+		fn(&ci)
+
+		by, err := json.Marshal(ci)
+		if err != nil {
+			return fmt.Errorf("failed to marshal chat info as json: %w", err)
+		}
+		if err := b.Put(ci.BID(), by); err != nil {
+			return fmt.Errorf("failed to put chat info into db: %w", err)
 		}
 		return nil
+	})
+}
+
+// ResetRunningChats sets any chats left in the "running" state (e.g.
+// after a crash mid-generation) back to "idle" and returns how many
+// were reset.
+func (c *client) ResetRunningChats() (int, error) {
+	var n int
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		// Find the running chats first, since bolt doesn't
+		// allow writing to a bucket while iterating over it
+		b := tx.Bucket([]byte(chatBucket))
+		var running []ChatInfo
+		if err := b.ForEach(func(k, v []byte) error {
+			var ci ChatInfo
+			if err := json.Unmarshal(v, &ci); err != nil {
+				return fmt.Errorf("failed to unmarshal chat info: %w", err)
+			}
+			if ci.State == "running" {
+				running = append(running, ci)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, ci := range running {
+			ci.State = "idle"
+			by, err := json.Marshal(ci)
+			if err != nil {
+				return fmt.Errorf("failed to marshal chat info as json: %w", err)
+			}
+			if err := b.Put(itob(ci.ID), by); err != nil {
+				return fmt.Errorf("failed to put chat info into db: %w", err)
+			}
+		}
+		n = len(running)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to reset running chats: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteChat removes a chat thread from the database.
+func (c *client) DeleteChat(id int) error {
+	var deleted []int
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		deleted, err = c.deleteChat(tx, id)
+		return err
 	}); err != nil {
 		return fmt.Errorf("failed to delete chat from db: %w", err)
 	}
+	c.publishDeleted(id, deleted...)
+	return nil
+}
+
+// deleteChat removes a chat (along with its messages, draft, and
+// private graph) within the transaction, returning the IDs of the
+// messages it deleted.
+func (c *client) deleteChat(tx *bolt.Tx, id int) ([]int, error) {
+	// Delete the record in the chat bucket (remembering
+	// which graph it used)
+	b := tx.Bucket([]byte(chatBucket))
+	var ci ChatInfo
+	if data := b.Get(itob(id)); data != nil {
+		if err := json.Unmarshal(data, &ci); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+	}
+	if err := b.Delete(itob(id)); err != nil {
+		return nil, fmt.Errorf("failed to delete chat info from db: %w", err)
+	}
+
+	// Delete the whole chat's message bucket
+	var deleted []int
+	if mb := tx.Bucket(ChatInfo{ID: id}.MessageBucketName()); mb != nil {
+		deleted = messageIDs(mb)
+	}
+	if err := tx.DeleteBucket(ChatInfo{ID: id}.MessageBucketName()); err != nil {
+		return nil, fmt.Errorf("failed to delete chat messages bucket: %w", err)
+	}
+
+	// And any unsent draft
+	if err := tx.Bucket([]byte(metaBucket)).Delete(draftKey(id)); err != nil {
+		return nil, fmt.Errorf("failed to delete chat draft: %w", err)
+	}
+
+	// And its private graph, if nothing else uses it
+	if err := c.deletePrivateGraph(tx, ci.Graph); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// touchChat sets a chat's UpdatedAt to now within the transaction.
+func touchChat(tx *bolt.Tx, id int) error {
+	b := tx.Bucket([]byte(chatBucket))
+	data := b.Get(itob(id))
+	if data == nil {
+		return fmt.Errorf("chat with ID %d %w", id, errNotFound)
+	}
+	var ci ChatInfo
+	if err := json.Unmarshal(data, &ci); err != nil {
+		return fmt.Errorf("failed to unmarshal chat info: %w", err)
+	}
+	ci.UpdatedAt = time.Now().UTC()
+	by, err := json.Marshal(ci)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat info as json: %w", err)
+	}
+	if err := b.Put(ci.BID(), by); err != nil {
+		return fmt.Errorf("failed to put chat info into db: %w", err)
+	}
 	return nil
 }
 
 type Message struct {
 	ChatID    int
 	MessageID int
-	MType     string // "user" | "agent" | "tool"
+	MType     string // "user" | "agent" | "tool" | "summary" | "error"
 	UserMsg   *struct {
 		Text string // The text the user sent
 	}
 	AgentMsg *struct {
-		Text string // The text the agent sent
+		Text         string // The text the agent sent
+		StopReason   string // Why the model stopped (e.g. "stop", or "length" if it hit the token limit)
+		CitedNodeIDs []int  `json:",omitempty"` // Nodes from the turn's tool calls that the reply mentions
 	}
 	ToolMsg *struct {
 		ToolDone   bool
@@ -216,15 +851,73 @@ type Message struct {
 		ToolArgs   map[string]any // The arguments passed to the tool
 		ToolResult string         // The result of the tool call
 		ToolError  string         // The error message if the tool call failed
+
+		// What the call would change, while it waits for the
+		// user to approve it (JSON-encoded; see pendingApproval)
+		ToolPreview string `json:",omitempty"`
+	}
+	SummaryMsg *struct {
+		Text  string // Summary of the compacted messages
+		Count int    // How many messages were compacted into it
+		After int    `json:",omitempty"` // ID of the last message it replaced, which it's listed after (see orderMessages)
+	}
+	ErrorMsg *struct {
+		Text string // Why generating a response failed
 	}
+	Compacted    bool   // Summarized into a "summary" message and left out of the model's history
+	Pinned       bool   `json:",omitempty"` // Always kept in the model's history, even once compacted or truncated
+	InputTokens  int    // Prompt tokens used to generate the message (agent/tool only)
+	OutputTokens int    // Tokens generated for the message (agent/tool only)
+	Model        string `json:",omitempty"` // Model that generated the message (agent/tool only; empty for older messages)
+
+	RawMeta json.RawMessage `json:",omitempty"` // The model's raw response (only stored in debug mode)
 }
 
 func (m Message) BID() []byte {
 	return itob(m.MessageID)
 }
 
+// Valid reports whether the message has the payload its type calls
+// for (e.g. a "user" message has a UserMsg). Corrupt or hand-edited
+// records may not.
+func (m Message) Valid() bool {
+	switch m.MType {
+	case "user":
+		return m.UserMsg != nil
+	case "agent":
+		return m.AgentMsg != nil
+	case "tool":
+		return m.ToolMsg != nil
+	case "summary":
+		return m.SummaryMsg != nil
+	case "error":
+		return m.ErrorMsg != nil
+	default:
+		return false
+	}
+}
+
 // ListMessages retrieves all messages for a specific chat from the database.
 func (c *client) ListMessages(chatID int) ([]Message, error) {
+	return c.listMessages(chatID, nil)
+}
+
+// ListMessagesByType retrieves only the messages of the given type
+// ("user", "agent", "tool", "summary", or "error") for a specific chat from the database.
+func (c *client) ListMessagesByType(chatID int, mtype string) ([]Message, error) {
+	switch mtype {
+	case "user", "agent", "tool", "summary", "error":
+	default:
+		return nil, fmt.Errorf("unknown message type %q", mtype)
+	}
+	return c.listMessages(chatID, func(m Message) bool {
+		return m.MType == mtype
+	})
+}
+
+// listMessages reads the messages for a chat, keeping only those
+// for which keep returns true (or all of them if keep is nil).
+func (c *client) listMessages(chatID int, keep func(Message) bool) ([]Message, error) {
 	var msgs []Message
 	if err := c.db.View(func(tx *bolt.Tx) error {
 		bucketName := ChatInfo{ID: chatID}.MessageBucketName()
@@ -245,9 +938,42 @@ func (c *client) ListMessages(chatID int) ([]Message, error) {
 	}); err != nil {
 		return nil, fmt.Errorf("failed to read messages from db: %w", err)
 	}
+	msgs = orderMessages(msgs)
+	if keep != nil {
+		msgs = slices.DeleteFunc(msgs, func(m Message) bool { return !keep(m) })
+	}
 	return msgs, nil
 }
 
+// orderMessages puts each summary right after the last message it
+// replaced, taking their place in the chat, rather than where it was
+// added (after what was then the newest message). ms are in ID order.
+func orderMessages(ms []Message) []Message {
+	ids := map[int]bool{}
+	for _, m := range ms {
+		ids[m.MessageID] = true
+	}
+	after := map[int][]Message{}
+	var rest []Message
+	for _, m := range ms {
+		if m.MType == "summary" && m.SummaryMsg != nil && ids[m.SummaryMsg.After] {
+			after[m.SummaryMsg.After] = append(after[m.SummaryMsg.After], m)
+			continue
+		}
+		rest = append(rest, m)
+	}
+	if len(after) == 0 {
+		return ms
+	}
+
+	ordered := make([]Message, 0, len(ms))
+	for _, m := range rest {
+		ordered = append(ordered, m)
+		ordered = append(ordered, after[m.MessageID]...)
+	}
+	return ordered
+}
+
 // CreateMessage adds a new message to a chat thread in the database.
 func (c *client) CreateMessage(msg Message) (*Message, error) {
 	if err := c.db.Update(func(tx *bolt.Tx) error {
@@ -265,6 +991,9 @@ func (c *client) CreateMessage(msg Message) (*Message, error) {
 
 		// Set the message ID
 		msg.MessageID = int(id)
+		if err := touchChat(tx, msg.ChatID); err != nil {
+			return err
+		}
 
 		// Marshal the message
 		data, err := json.Marshal(msg)
@@ -282,6 +1011,7 @@ func (c *client) CreateMessage(msg Message) (*Message, error) {
 		return nil, fmt.Errorf("failed to create message: %w", err)
 	}
 
+	c.publish(msg)
 	return &msg, nil
 }
 
@@ -332,9 +1062,189 @@ func (c *client) UpdateMessage(msg Message) error {
 		return fmt.Errorf("failed to update message: %w", err)
 	}
 
+	c.publish(msg)
 	return nil
 }
 
+// DeleteTrailingReplies deletes the messages after a chat's last user
+// message (i.e. the response to it), returning how many were deleted.
+// Summaries and pinned messages are kept, since they stand in for (or
+// were chosen to outlive) other messages. The chat must not be running,
+// and must have a user message to reply to.
+func (c *client) DeleteTrailingReplies(chatID int) (int, error) {
+	var deleted []int
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(chatBucket)).Get(itob(chatID))
+		if data == nil {
+			return fmt.Errorf("chat with ID %d %w", chatID, errNotFound)
+		}
+		var ci ChatInfo
+		if err := json.Unmarshal(data, &ci); err != nil {
+			return fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+		if ci.State == "running" {
+			return fmt.Errorf("chat %d is running", chatID)
+		}
+
+		bucket := tx.Bucket(ci.MessageBucketName())
+		if bucket == nil {
+			return fmt.Errorf("chat messages bucket not found")
+		}
+
+		// Walk back from the end until we hit a user message
+		var keys [][]byte
+		var found bool
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var msg Message
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return fmt.Errorf("failed to unmarshal message: %w", err)
+			}
+			if msg.MType == "user" {
+				found = true
+				break
+			}
+			if msg.MType == "summary" || msg.Pinned {
+				continue
+			}
+			keys = append(keys, k)
+			deleted = append(deleted, msg.MessageID)
+		}
+		if !found {
+			deleted = nil
+			return fmt.Errorf("chat %d has no user message to reply to", chatID)
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return fmt.Errorf("failed to delete message from db: %w", err)
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to delete replies: %w", err)
+	}
+	c.publishDeleted(chatID, deleted...)
+	return len(deleted), nil
+}
+
+// DeleteUnfinishedToolCalls deletes any tool calls at the end of a chat
+// that never got a result (or an error), e.g. because generation was
+// cancelled before the tool ran. Left in place, they'd be sent back to
+// the model as calls with empty results. Returns how many were deleted.
+func (c *client) DeleteUnfinishedToolCalls(chatID int) (int, error) {
+	var deleted []int
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(chatBucket)).Get(itob(chatID))
+		if data == nil {
+			return fmt.Errorf("chat with ID %d %w", chatID, errNotFound)
+		}
+		var ci ChatInfo
+		if err := json.Unmarshal(data, &ci); err != nil {
+			return fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+		bucket := tx.Bucket(ci.MessageBucketName())
+		if bucket == nil {
+			return fmt.Errorf("chat messages bucket not found")
+		}
+
+		// Walk back from the end until we hit anything else (other
+		// than summaries, which are listed earlier on)
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var msg Message
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return fmt.Errorf("failed to unmarshal message: %w", err)
+			}
+			if msg.MType == "summary" {
+				continue
+			}
+			if msg.MType != "tool" || msg.ToolMsg == nil || msg.ToolMsg.ToolResult != "" || msg.ToolMsg.ToolError != "" {
+				break
+			}
+			keys = append(keys, k)
+			deleted = append(deleted, msg.MessageID)
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return fmt.Errorf("failed to delete message from db: %w", err)
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to delete unfinished tool calls: %w", err)
+	}
+	c.publishDeleted(chatID, deleted...)
+	return len(deleted), nil
+}
+
+// CompactMessages marks the given messages as compacted and adds a
+// "summary" message in their place, all in one transaction. The
+// original messages are kept so nothing is lost. The summary gets the
+// next ID, but is listed right after the last message it replaced.
+func (c *client) CompactMessages(chatID int, ids []int, summary string) (*Message, error) {
+	sm := Message{
+		ChatID: chatID,
+		MType:  "summary",
+		SummaryMsg: &struct {
+			Text  string
+			Count int
+			After int `json:",omitempty"`
+		}{
+			Text:  summary,
+			Count: len(ids),
+			After: slices.Max(append([]int{0}, ids...)),
+		},
+	}
+	var compacted []Message
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ChatInfo{ID: chatID}.MessageBucketName())
+		if bucket == nil {
+			return fmt.Errorf("chat messages bucket not found")
+		}
+
+		// Flag the originals
+		for _, id := range ids {
+			data := bucket.Get(itob(id))
+			if data == nil {
+				return fmt.Errorf("message with ID %d %w", id, errNotFound)
+			}
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return fmt.Errorf("failed to unmarshal message: %w", err)
+			}
+			msg.Compacted = true
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return fmt.Errorf("failed to marshal message: %w", err)
+			}
+			if err := bucket.Put(msg.BID(), data); err != nil {
+				return fmt.Errorf("failed to put message into db: %w", err)
+			}
+			compacted = append(compacted, msg)
+		}
+
+		// Add the summary
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return fmt.Errorf("failed to get next sequence: %w", err)
+		}
+		sm.MessageID = int(id)
+		data, err := json.Marshal(sm)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+		if err := bucket.Put(sm.BID(), data); err != nil {
+			return fmt.Errorf("failed to put message into db: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to compact messages: %w", err)
+	}
+	c.publish(append(compacted, sm)...)
+	return &sm, nil
+}
+
 // DeleteMessage removes a message from the database.
 func (c *client) DeleteMessage(chatID, messageID int) error {
 	if err := c.db.Update(func(tx *bolt.Tx) error {
@@ -353,59 +1263,287 @@ func (c *client) DeleteMessage(chatID, messageID int) error {
 		return fmt.Errorf("failed to delete message: %w", err)
 	}
 
+	c.publishDeleted(chatID, messageID)
+	return nil
+}
+
+// SetMessagePinned pins (or unpins) a message, so it's always sent
+// to the model, even once it's been compacted or the chat is too long
+// for the model's context window. Errors can't be pinned, since
+// they're never sent.
+func (c *client) SetMessagePinned(chatID, messageID int, pinned bool) error {
+	var msg Message
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ChatInfo{ID: chatID}.MessageBucketName())
+		if bucket == nil {
+			return fmt.Errorf("chat with ID %d %w", chatID, errNotFound)
+		}
+		data := bucket.Get(itob(messageID))
+		if data == nil {
+			return fmt.Errorf("message with ID %d %w", messageID, errNotFound)
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		if msg.MType == "error" {
+			return fmt.Errorf("message %d is an error, which is never sent to the model", messageID)
+		}
+
+		msg.Pinned = pinned
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+		if err := bucket.Put(msg.BID(), data); err != nil {
+			return fmt.Errorf("failed to put message into db: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to pin message: %w", err)
+	}
+
+	c.publish(msg)
 	return nil
 }
 
+// ClearErrorMessage deletes an "error" message so generation can be
+// retried from it. If the chat is running it does nothing and returns
+// false, since a retry would race the generation in progress.
+func (c *client) ClearErrorMessage(chatID, messageID int) (bool, error) {
+	var cleared bool
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(chatBucket)).Get(itob(chatID))
+		if data == nil {
+			return fmt.Errorf("chat with ID %d %w", chatID, errNotFound)
+		}
+		var ci ChatInfo
+		if err := json.Unmarshal(data, &ci); err != nil {
+			return fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+		if ci.State == "running" {
+			return nil
+		}
+
+		bucket := tx.Bucket(ci.MessageBucketName())
+		if bucket == nil {
+			return fmt.Errorf("chat messages bucket not found")
+		}
+		v := bucket.Get(itob(messageID))
+		if v == nil {
+			return fmt.Errorf("message with ID %d %w", messageID, errNotFound)
+		}
+		var msg Message
+		if err := json.Unmarshal(v, &msg); err != nil {
+			return fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		if msg.MType != "error" {
+			return fmt.Errorf("message %d is not an error", messageID)
+		}
+		if err := bucket.Delete(itob(messageID)); err != nil {
+			return fmt.Errorf("failed to delete message from db: %w", err)
+		}
+		cleared = true
+		return nil
+	}); err != nil {
+		return false, fmt.Errorf("failed to clear error message: %w", err)
+	}
+	if cleared {
+		c.publishDeleted(chatID, messageID)
+	}
+	return cleared, nil
+}
+
+// EstimateChatCost returns the estimated cost of a chat by multiplying
+// each message's input and output tokens by the per-token rates for
+// the model that generated it. Messages from before models were
+// recorded are priced as the default model's.
+func (c *client) EstimateChatCost(chatID int, rates func(model string) (float64, float64, error)) (float64, error) {
+	msgs, err := c.ListMessages(chatID)
+	if err != nil {
+		return 0, err
+	}
+
+	var cost float64
+	for _, m := range msgs {
+		if m.InputTokens == 0 && m.OutputTokens == 0 {
+			continue
+		}
+		model := m.Model
+		if model == "" {
+			model = defaultModel
+		}
+		in, out, err := rates(model)
+		if err != nil {
+			return 0, err
+		}
+		cost += float64(m.InputTokens)*in + float64(m.OutputTokens)*out
+	}
+	return cost, nil
+}
+
 type GraphNode struct {
 	ID    int
 	Type  string
 	Props map[string]any
+
+	// Provenance: the chat (and message) whose tool call
+	// created the node, if it was created by the agent.
+	SourceChatID    int `json:",omitempty"`
+	SourceMessageID int `json:",omitempty"`
+
+	// LastAccessedAt is when the node was created or, if the client
+	// tracks access, last read (see StaleNodes).
+	LastAccessedAt time.Time `json:",omitzero"`
+
+	// Embedding is the node's embedding vector, if it has one. It is
+	// stored separately from the node (see SetNodeEmbedding) and only
+	// filled in by GetNode.
+	Embedding []float32 `json:"-"`
+}
+
+func (n GraphNode) BID() []byte {
+	return itob(n.ID)
+}
+
+// decodeNode unmarshals a stored node, keeping numbers in its
+// properties as json.Number if the client is set to.
+func (c *client) decodeNode(data []byte, node *GraphNode) error {
+	if !c.useNumber {
+		return json.Unmarshal(data, node)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(node)
+}
+
+// GetNode retrieves a node by its ID from the graph database. If the
+// client tracks access, this also updates the node's LastAccessedAt.
+func (c *client) GetNode(id int) (*GraphNode, error) {
+	var node *GraphNode
+	run := c.db.View
+	if c.trackAccess {
+		run = c.db.Update
+	}
+	if err := run(func(tx *bolt.Tx) error {
+		var err error
+		if node, err = c.getNode(tx, id); err != nil {
+			return err
+		}
+
+		// Note that it's been used
+		if c.trackAccess {
+			return c.touchNode(tx, node)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+
+	return node, nil
+}
+
+// TouchNodes sets the LastAccessedAt of the given nodes to now, if the
+// client tracks access, so that nodes returned by a list or search count
+// as used too. The nodes' LastAccessedAt are updated to match. Nodes that
+// no longer exist are skipped.
+func (c *client) TouchNodes(nodes []GraphNode) error {
+	if !c.trackAccess || len(nodes) == 0 {
+		return nil
+	}
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		for i := range nodes {
+			node, err := c.getNode(tx, nodes[i].ID)
+			if errors.Is(err, errNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := c.touchNode(tx, node); err != nil {
+				return err
+			}
+			nodes[i].LastAccessedAt = node.LastAccessedAt
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to touch nodes: %w", err)
+	}
+	return nil
 }
 
-func (n GraphNode) BID() []byte {
-	return itob(n.ID)
+// touchNode sets a node's LastAccessedAt to now within the transaction.
+func (c *client) touchNode(tx *bolt.Tx, node *GraphNode) error {
+	node.LastAccessedAt = time.Now().UTC()
+	data, err := json.Marshal(node)
+	if err != nil {
+		return fmt.Errorf("failed to marshal node: %w", err)
+	}
+	if err := tx.Bucket(c.graphBucket(nodeBucket)).Put(node.BID(), data); err != nil {
+		return fmt.Errorf("failed to put node into db: %w", err)
+	}
+	return nil
 }
 
-// GetNode retrieves a node by its ID from the graph database.
-func (c *client) GetNode(id int) (*GraphNode, error) {
-	var node *GraphNode
-	if err := c.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(nodeBucket))
-		if bucket == nil {
-			return fmt.Errorf("node bucket not found")
-		}
-
-		data := bucket.Get(itob(id))
-		if data == nil {
-			return fmt.Errorf("node with ID %d not found", id)
-		}
-
-		node = &GraphNode{}
-		if err := json.Unmarshal(data, node); err != nil {
-			return fmt.Errorf("failed to unmarshal node: %w", err)
-		}
+// getNode reads a node (and its embedding) within the transaction.
+func (c *client) getNode(tx *bolt.Tx, id int) (*GraphNode, error) {
+	bucket := tx.Bucket(c.graphBucket(nodeBucket))
+	if bucket == nil {
+		return nil, fmt.Errorf("node bucket not found")
+	}
 
-		return nil
-	}); err != nil {
-		return nil, fmt.Errorf("failed to get node: %w", err)
+	data := bucket.Get(itob(id))
+	if data == nil {
+		return nil, fmt.Errorf("node with ID %d %w", id, errNotFound)
 	}
 
+	node := &GraphNode{}
+	if err := c.decodeNode(data, node); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal node: %w", err)
+	}
+	node.Embedding = c.getEmbedding(tx, id)
 	return node, nil
 }
 
+// Page selects a window of the results from a list method.
+type Page struct {
+	Limit  int // Maximum number of results (all of them if 0)
+	Offset int // Number of matching results to skip
+}
+
+// take reports whether the i-th (0-based) matching result is in the
+// page, and whether iteration can stop because the page is full.
+func (p Page) take(i int) (in, done bool) {
+	if i < p.Offset {
+		return false, false
+	}
+	if p.Limit > 0 && i >= p.Offset+p.Limit {
+		return false, true
+	}
+	return true, false
+}
+
 // ListNodes retrieves all nodes from the graph database.
 func (c *client) ListNodes(nodeType string) ([]GraphNode, error) {
+	nodes, _, err := c.ListNodesPage(nodeType, Page{})
+	return nodes, err
+}
+
+// ListNodesPage retrieves a page of nodes from the graph database,
+// also reporting whether there are more nodes after the page.
+func (c *client) ListNodesPage(nodeType string, page Page) ([]GraphNode, bool, error) {
 	var nodes []GraphNode
+	var more bool
 	if err := c.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(nodeBucket))
+		bucket := tx.Bucket(c.graphBucket(nodeBucket))
 		if bucket == nil {
 			return fmt.Errorf("node bucket not found")
 		}
 
+		var n int // Number of matching nodes so far
 		cursor := bucket.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
 			var node GraphNode
-			if err := json.Unmarshal(v, &node); err != nil {
+			if err := c.decodeNode(v, &node); err != nil {
 				return fmt.Errorf("failed to unmarshal node: %w", err)
 			}
 
@@ -414,102 +1552,321 @@ func (c *client) ListNodes(nodeType string) ([]GraphNode, error) {
 				continue
 			}
 
-			nodes = append(nodes, node)
+			// Apply the page
+			in, done := page.take(n)
+			n++
+			if done {
+				more = true
+				break
+			}
+			if in {
+				nodes = append(nodes, node)
+			}
 		}
 		return nil
 	}); err != nil {
-		return nil, fmt.Errorf("failed to list nodes: %w", err)
+		return nil, false, fmt.Errorf("failed to list nodes: %w", err)
 	}
-	return nodes, nil
+	return nodes, more, nil
 }
 
 // CreateNode adds a new node to the graph database.
 func (c *client) CreateNode(nodeType string, props map[string]any) (*GraphNode, error) {
 	var node *GraphNode
 	if err := c.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(nodeBucket))
-		if bucket == nil {
-			return fmt.Errorf("node bucket not found")
-		}
+		var err error
+		node, err = c.createNode(tx, GraphNode{Type: nodeType, Props: props})
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create node: %w", err)
+	}
 
-		// Get next sequence for node ID
-		id, err := bucket.NextSequence()
-		if err != nil {
-			return fmt.Errorf("failed to get next sequence: %w", err)
-		}
+	return node, nil
+}
 
-		// Create the node
-		node = &GraphNode{
-			ID:    int(id),
-			Type:  nodeType,
-			Props: props,
-		}
+// CreateNodeFromChat adds a new node to the graph database,
+// recording the chat message that created it.
+func (c *client) CreateNodeFromChat(nodeType string, props map[string]any, chatID, messageID int) (*GraphNode, error) {
+	var node *GraphNode
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		node, err = c.createNode(tx, GraphNode{
+			Type:            nodeType,
+			Props:           props,
+			SourceChatID:    chatID,
+			SourceMessageID: messageID,
+		})
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create node: %w", err)
+	}
 
-		// Marshal the node
-		data, err := json.Marshal(node)
-		if err != nil {
-			return fmt.Errorf("failed to marshal node: %w", err)
+	return node, nil
+}
+
+// ListNodesByChat retrieves the nodes created from a chat.
+func (c *client) ListNodesByChat(chatID int) ([]GraphNode, error) {
+	var nodes []GraphNode
+	if err := c.EachNode(func(n GraphNode) error {
+		if n.SourceChatID == chatID {
+			nodes = append(nodes, n)
 		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	return nodes, nil
+}
 
-		// Store it in the database
-		if err := bucket.Put(node.BID(), data); err != nil {
-			return fmt.Errorf("failed to put node into db: %w", err)
+// createNode adds a new node to the graph within the transaction,
+// assigning its ID.
+func (c *client) createNode(tx *bolt.Tx, node GraphNode) (*GraphNode, error) {
+	bucket := tx.Bucket(c.graphBucket(nodeBucket))
+	if bucket == nil {
+		return nil, fmt.Errorf("node bucket not found")
+	}
+
+	// Get next sequence for node ID
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get next sequence: %w", err)
+	}
+
+	// Create the node (keeping the access time of one being
+	// imported, if it has one)
+	node.ID = int(id)
+	if node.LastAccessedAt.IsZero() {
+		node.LastAccessedAt = time.Now().UTC()
+	}
+
+	// Marshal the node
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node: %w", err)
+	}
+
+	// Store it in the database
+	if err := bucket.Put(node.BID(), data); err != nil {
+		return nil, fmt.Errorf("failed to put node into db: %w", err)
+	}
+
+	if err := c.writeAudit(tx, "create", "node", node.ID, node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// UpdateNode merges props into a node's properties: keys in props
+// overwrite existing ones, and keys set to nil are removed. Other
+// properties are left as they are.
+func (c *client) UpdateNode(id int, props map[string]any) (*GraphNode, error) {
+	return c.updateNode(id, mergeProps(props))
+}
+
+// mergeProps returns an update that merges props into a node's
+// properties, as UpdateNode does.
+func mergeProps(props map[string]any) func(*GraphNode) {
+	return func(n *GraphNode) {
+		if n.Props == nil {
+			n.Props = map[string]any{}
+		}
+		for k, v := range props {
+			if v == nil {
+				delete(n.Props, k)
+			} else {
+				n.Props[k] = v
+			}
 		}
+	}
+}
 
-		return nil
+// ReplaceNodeProps replaces all of a node's properties with props.
+func (c *client) ReplaceNodeProps(id int, props map[string]any) (*GraphNode, error) {
+	return c.updateNode(id, func(n *GraphNode) {
+		n.Props = props
+	})
+}
+
+// updateNode loads a node, applies fn to it, and stores it again.
+func (c *client) updateNode(id int, fn func(*GraphNode)) (*GraphNode, error) {
+	var node *GraphNode
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		node, err = c.editNode(tx, id, fn)
+		return err
 	}); err != nil {
-		return nil, fmt.Errorf("failed to create node: %w", err)
+		return nil, fmt.Errorf("failed to update node: %w", err)
+	}
+
+	return node, nil
+}
+
+// editNode loads a node, applies fn to it, and stores it again
+// within the transaction.
+func (c *client) editNode(tx *bolt.Tx, id int, fn func(*GraphNode)) (*GraphNode, error) {
+	bucket := tx.Bucket(c.graphBucket(nodeBucket))
+	if bucket == nil {
+		return nil, fmt.Errorf("node bucket not found")
+	}
+
+	data := bucket.Get(itob(id))
+	if data == nil {
+		return nil, fmt.Errorf("node with ID %d %w", id, errNotFound)
+	}
+
+	node := &GraphNode{}
+	if err := c.decodeNode(data, node); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal node: %w", err)
 	}
+	fn(node)
 
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node: %w", err)
+	}
+	if err := bucket.Put(node.BID(), data); err != nil {
+		return nil, fmt.Errorf("failed to put node into db: %w", err)
+	}
+	if err := c.writeAudit(tx, "update", "node", node.ID, node); err != nil {
+		return nil, err
+	}
 	return node, nil
 }
 
 // DeleteNode removes a node from the graph database.
 func (c *client) DeleteNode(id int) error {
 	if err := c.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(nodeBucket))
-		if bucket == nil {
-			return fmt.Errorf("node bucket not found")
-		}
+		return c.deleteNode(tx, id)
+	}); err != nil {
+		return fmt.Errorf("failed to delete node: %w", err)
+	}
 
-		if err := bucket.Delete(itob(id)); err != nil {
-			return fmt.Errorf("failed to delete node from db: %w", err)
-		}
+	return nil
+}
 
-		// Also delete any related edges
-		edgeBucket := tx.Bucket([]byte(edgeBucket))
-		if edgeBucket == nil {
-			return fmt.Errorf("edge bucket not found")
+// deleteNode removes a node (along with its embedding and
+// edges) from the graph within the transaction.
+func (c *client) deleteNode(tx *bolt.Tx, id int) error {
+	bucket := tx.Bucket(c.graphBucket(nodeBucket))
+	if bucket == nil {
+		return fmt.Errorf("node bucket not found")
+	}
+
+	if err := bucket.Delete(itob(id)); err != nil {
+		return fmt.Errorf("failed to delete node from db: %w", err)
+	}
+	if err := c.writeAudit(tx, "delete", "node", id, nil); err != nil {
+		return err
+	}
+
+	// Also delete its embedding and any related edges
+	ids := map[int]bool{id: true}
+	if err := c.deleteEmbeddings(tx, ids); err != nil {
+		return err
+	}
+	return c.deleteIncidentEdges(tx, ids)
+}
+
+// DeleteNodesByType removes all nodes of the given type (and the edges
+// connected to them) from the graph database, returning how many nodes
+// were deleted.
+func (c *client) DeleteNodesByType(nodeType string) (int, error) {
+	ids := map[int]bool{}
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(c.graphBucket(nodeBucket))
+		if bucket == nil {
+			return fmt.Errorf("node bucket not found")
 		}
 
-		// Iterate through all edges and delete those connected to this node
-		cursor := edgeBucket.Cursor()
+		// Find the matching nodes
+		cursor := bucket.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			var edge GraphEdge
-			if err := json.Unmarshal(v, &edge); err != nil {
-				return fmt.Errorf("failed to unmarshal edge: %w", err)
+			var node GraphNode
+			if err := json.Unmarshal(v, &node); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			if node.Type == nodeType {
+				ids[node.ID] = true
 			}
+		}
 
-			if edge.FromID == id || edge.ToID == id {
-				if err := edgeBucket.Delete(k); err != nil {
-					return fmt.Errorf("failed to delete related edge: %w", err)
-				}
+		// Delete them
+		for id := range ids {
+			if err := bucket.Delete(itob(id)); err != nil {
+				return fmt.Errorf("failed to delete node from db: %w", err)
+			}
+			if err := c.writeAudit(tx, "delete", "node", id, nil); err != nil {
+				return err
 			}
 		}
 
-		return nil
+		// Also delete their embeddings and any related edges
+		if err := c.deleteEmbeddings(tx, ids); err != nil {
+			return err
+		}
+		return c.deleteIncidentEdges(tx, ids)
 	}); err != nil {
-		return fmt.Errorf("failed to delete node: %w", err)
+		return 0, fmt.Errorf("failed to delete nodes: %w", err)
+	}
+
+	return len(ids), nil
+}
+
+// deleteIncidentEdges deletes every edge that starts or
+// ends at one of the given nodes within the transaction.
+func (c *client) deleteIncidentEdges(tx *bolt.Tx, ids map[int]bool) error {
+	edgeBucket := tx.Bucket(c.graphBucket(edgeBucket))
+	if edgeBucket == nil {
+		return fmt.Errorf("edge bucket not found")
+	}
+
+	// Find the edges connected to the nodes. (They're collected
+	// first since deleting while iterating skips entries.)
+	edges, err := c.incidentEdges(tx, ids)
+	if err != nil {
+		return err
 	}
 
+	for _, e := range edges {
+		if err := edgeBucket.Delete(e.BID()); err != nil {
+			return fmt.Errorf("failed to delete related edge: %w", err)
+		}
+		if err := c.writeAudit(tx, "delete", "edge", e.ID, nil); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// incidentEdges returns the edges connected (either way)
+// to any of the given nodes.
+func (c *client) incidentEdges(tx *bolt.Tx, ids map[int]bool) ([]GraphEdge, error) {
+	bucket := tx.Bucket(c.graphBucket(edgeBucket))
+	if bucket == nil {
+		return nil, fmt.Errorf("edge bucket not found")
+	}
+
+	edges := []GraphEdge{}
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var edge GraphEdge
+		if err := json.Unmarshal(v, &edge); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal edge: %w", err)
+		}
+
+		if ids[edge.FromID] || ids[edge.ToID] {
+			edges = append(edges, edge)
+		}
+	}
+	return edges, nil
+}
+
 type GraphEdge struct {
 	ID     int
 	Type   string
 	FromID int
 	ToID   int
+	Props  map[string]any
 }
 
 func (e GraphEdge) BID() []byte {
@@ -527,38 +1884,53 @@ type EdgeFilter struct {
 func (c *client) GetEdge(id int) (*GraphEdge, error) {
 	var edge *GraphEdge
 	if err := c.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(edgeBucket))
-		if bucket == nil {
-			return fmt.Errorf("edge bucket not found")
-		}
+		var err error
+		edge, err = c.getEdge(tx, id)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get edge: %w", err)
+	}
 
-		data := bucket.Get(itob(id))
-		if data == nil {
-			return fmt.Errorf("edge with ID %d not found", id)
-		}
+	return edge, nil
+}
 
-		edge = &GraphEdge{}
-		if err := json.Unmarshal(data, edge); err != nil {
-			return fmt.Errorf("failed to unmarshal edge: %w", err)
-		}
+// getEdge reads an edge within the transaction.
+func (c *client) getEdge(tx *bolt.Tx, id int) (*GraphEdge, error) {
+	bucket := tx.Bucket(c.graphBucket(edgeBucket))
+	if bucket == nil {
+		return nil, fmt.Errorf("edge bucket not found")
+	}
 
-		return nil
-	}); err != nil {
-		return nil, fmt.Errorf("failed to get edge: %w", err)
+	data := bucket.Get(itob(id))
+	if data == nil {
+		return nil, fmt.Errorf("edge with ID %d %w", id, errNotFound)
 	}
 
+	edge := &GraphEdge{}
+	if err := json.Unmarshal(data, edge); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal edge: %w", err)
+	}
 	return edge, nil
 }
 
 // ListEdges retrieves all edges from the graph database.
 func (c *client) ListEdges(filter EdgeFilter) ([]GraphEdge, error) {
+	edges, _, err := c.ListEdgesPage(filter, Page{})
+	return edges, err
+}
+
+// ListEdgesPage retrieves a page of edges from the graph database,
+// also reporting whether there are more edges after the page.
+func (c *client) ListEdgesPage(filter EdgeFilter, page Page) ([]GraphEdge, bool, error) {
 	var edges []GraphEdge
+	var more bool
 	if err := c.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(edgeBucket))
+		bucket := tx.Bucket(c.graphBucket(edgeBucket))
 		if bucket == nil {
 			return fmt.Errorf("edge bucket not found")
 		}
 
+		var n int // Number of matching edges so far
 		cursor := bucket.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
 			var edge GraphEdge
@@ -577,84 +1949,167 @@ func (c *client) ListEdges(filter EdgeFilter) ([]GraphEdge, error) {
 				continue
 			}
 
-			edges = append(edges, edge)
+			// Apply the page
+			in, done := page.take(n)
+			n++
+			if done {
+				more = true
+				break
+			}
+			if in {
+				edges = append(edges, edge)
+			}
 		}
 		return nil
 	}); err != nil {
-		return nil, fmt.Errorf("failed to list edges: %w", err)
+		return nil, false, fmt.Errorf("failed to list edges: %w", err)
 	}
-	return edges, nil
+	return edges, more, nil
 }
 
 // CreateEdge adds a new edge to the graph database.
 func (c *client) CreateEdge(edgeType string, fromID, toID int) (*GraphEdge, error) {
+	return c.CreateEdgeWithProps(edgeType, fromID, toID, nil)
+}
+
+// CreateEdgeWithProps adds a new edge with properties to the graph database.
+func (c *client) CreateEdgeWithProps(edgeType string, fromID, toID int, props map[string]any) (*GraphEdge, error) {
 	var edge *GraphEdge
 	if err := c.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(edgeBucket))
-		if bucket == nil {
-			return fmt.Errorf("edge bucket not found")
-		}
+		var err error
+		edge, err = c.createEdge(tx, edgeType, fromID, toID, props)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create edge: %w", err)
+	}
 
-		// Check if the nodes exist
-		nodeBucket := tx.Bucket([]byte(nodeBucket))
-		if nodeBucket == nil {
-			return fmt.Errorf("node bucket not found")
-		}
+	return edge, nil
+}
 
-		if nodeBucket.Get(itob(fromID)) == nil {
-			return fmt.Errorf("source node not found")
+// CreateBidirectionalEdge adds a pair of edges of the same type, one
+// each way between a and b, for symmetric relationships. Either both
+// edges are created or neither is.
+func (c *client) CreateBidirectionalEdge(edgeType string, a, b int) (*GraphEdge, *GraphEdge, error) {
+	if a == b {
+		return nil, nil, fmt.Errorf("failed to create edges: a bidirectional edge needs two different nodes")
+	}
+	var ab, ba *GraphEdge
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if ab, err = c.createEdge(tx, edgeType, a, b, nil); err != nil {
+			return err
 		}
+		ba, err = c.createEdge(tx, edgeType, b, a, nil)
+		return err
+	}); err != nil {
+		return nil, nil, fmt.Errorf("failed to create edges: %w", err)
+	}
 
-		if nodeBucket.Get(itob(toID)) == nil {
-			return fmt.Errorf("target node not found")
-		}
+	return ab, ba, nil
+}
 
-		// Get next sequence for edge ID
-		id, err := bucket.NextSequence()
-		if err != nil {
-			return fmt.Errorf("failed to get next sequence: %w", err)
-		}
+// createEdge adds a new edge to the graph within the transaction,
+// checking that both of its nodes exist.
+func (c *client) createEdge(tx *bolt.Tx, edgeType string, fromID, toID int, props map[string]any) (*GraphEdge, error) {
+	if fromID == toID && c.noSelfLoops {
+		return nil, fmt.Errorf("edge from node %d to itself: %w", fromID, errSelfLoop)
+	}
 
-		// Create the edge
-		edge = &GraphEdge{
-			ID:     int(id),
-			Type:   edgeType,
-			FromID: fromID,
-			ToID:   toID,
-		}
+	bucket := tx.Bucket(c.graphBucket(edgeBucket))
+	if bucket == nil {
+		return nil, fmt.Errorf("edge bucket not found")
+	}
 
-		// Marshal the edge
-		data, err := json.Marshal(edge)
-		if err != nil {
-			return fmt.Errorf("failed to marshal edge: %w", err)
-		}
+	// Check if the nodes exist
+	nodeBucket := tx.Bucket(c.graphBucket(nodeBucket))
+	if nodeBucket == nil {
+		return nil, fmt.Errorf("node bucket not found")
+	}
 
-		// Store it in the database
-		if err := bucket.Put(edge.BID(), data); err != nil {
-			return fmt.Errorf("failed to put edge into db: %w", err)
-		}
+	if nodeBucket.Get(itob(fromID)) == nil {
+		return nil, fmt.Errorf("source node not found")
+	}
 
-		return nil
+	if nodeBucket.Get(itob(toID)) == nil {
+		return nil, fmt.Errorf("target node not found")
+	}
+
+	// Get next sequence for edge ID
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get next sequence: %w", err)
+	}
+
+	// Create the edge
+	edge := &GraphEdge{
+		ID:     int(id),
+		Type:   edgeType,
+		FromID: fromID,
+		ToID:   toID,
+		Props:  props,
+	}
+
+	// Marshal the edge
+	data, err := json.Marshal(edge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal edge: %w", err)
+	}
+
+	// Store it in the database
+	if err := bucket.Put(edge.BID(), data); err != nil {
+		return nil, fmt.Errorf("failed to put edge into db: %w", err)
+	}
+
+	if err := c.writeAudit(tx, "create", "edge", edge.ID, edge); err != nil {
+		return nil, err
+	}
+	return edge, nil
+}
+
+// UpdateEdge changes an edge's type (unless edgeType is empty) and
+// replaces its properties.
+func (c *client) UpdateEdge(id int, edgeType string, props map[string]any) (*GraphEdge, error) {
+	var edge *GraphEdge
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		edge, err = c.editEdge(tx, id, edgeType, props)
+		return err
 	}); err != nil {
-		return nil, fmt.Errorf("failed to create edge: %w", err)
+		return nil, fmt.Errorf("failed to update edge: %w", err)
 	}
 
 	return edge, nil
 }
 
+// editEdge changes an edge's type (unless edgeType is empty) and
+// replaces its properties within the transaction.
+func (c *client) editEdge(tx *bolt.Tx, id int, edgeType string, props map[string]any) (*GraphEdge, error) {
+	edge, err := c.getEdge(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if edgeType != "" {
+		edge.Type = edgeType
+	}
+	edge.Props = props
+
+	data, err := json.Marshal(edge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal edge: %w", err)
+	}
+	if err := tx.Bucket(c.graphBucket(edgeBucket)).Put(edge.BID(), data); err != nil {
+		return nil, fmt.Errorf("failed to put edge into db: %w", err)
+	}
+	if err := c.writeAudit(tx, "update", "edge", edge.ID, edge); err != nil {
+		return nil, err
+	}
+	return edge, nil
+}
+
 // DeleteEdge removes an edge from the graph database.
 func (c *client) DeleteEdge(id int) error {
 	if err := c.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(edgeBucket))
-		if bucket == nil {
-			return fmt.Errorf("edge bucket not found")
-		}
-
-		if err := bucket.Delete(itob(id)); err != nil {
-			return fmt.Errorf("failed to delete edge from db: %w", err)
-		}
-
-		return nil
+		return c.deleteEdge(tx, id)
 	}); err != nil {
 		return fmt.Errorf("failed to delete edge: %w", err)
 	}
@@ -662,6 +2117,20 @@ func (c *client) DeleteEdge(id int) error {
 	return nil
 }
 
+// deleteEdge removes an edge from the graph within the transaction.
+func (c *client) deleteEdge(tx *bolt.Tx, id int) error {
+	bucket := tx.Bucket(c.graphBucket(edgeBucket))
+	if bucket == nil {
+		return fmt.Errorf("edge bucket not found")
+	}
+
+	if err := bucket.Delete(itob(id)); err != nil {
+		return fmt.Errorf("failed to delete edge from db: %w", err)
+	}
+
+	return c.writeAudit(tx, "delete", "edge", id, nil)
+}
+
 func itob(i int) []byte {
 	b := make([]byte, 8)
 	binary.BigEndian.PutUint64(b, uint64(i))
@@ -0,0 +1,1181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestResetRunningChats(t *testing.T) {
+	c := newTestClient(t)
+	states := []string{"running", "idle", "running", "running", "error"}
+	var ids []int
+	for _, s := range states {
+		ci, err := c.CreateChat("chat", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.SetChatState(ci.ID, s); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ci.ID)
+	}
+
+	n, err := c.ResetRunningChats()
+	if err != nil {
+		t.Fatalf("ResetRunningChats() failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("reset %d chats, want 3", n)
+	}
+	for i, id := range ids {
+		ci, err := c.GetChat(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := states[i]
+		if want == "running" {
+			want = "idle"
+		}
+		if ci.State != want {
+			t.Errorf("chat %d is %q, want %q", id, ci.State, want)
+		}
+	}
+}
+
+func TestListMessagesByType(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("mixed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ms []Message
+	if err := json.Unmarshal([]byte(`[
+		{"MType": "user", "UserMsg": {"Text": "hi"}},
+		{"MType": "tool", "ToolMsg": {"ToolName": "list_nodes", "ToolDone": true}},
+		{"MType": "agent", "AgentMsg": {"Text": "hello"}},
+		{"MType": "error", "ErrorMsg": {"Text": "oops"}},
+		{"MType": "user", "UserMsg": {"Text": "again"}},
+		{"MType": "summary", "SummaryMsg": {"Text": "they said hi", "Count": 3}},
+		{"MType": "agent", "AgentMsg": {"Text": "hello again"}}
+	]`), &ms); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range ms {
+		m.ChatID = ci.ID
+		if _, err := c.CreateMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		mtype   string
+		want    []int
+		wantErr bool
+	}{
+		{mtype: "user", want: []int{1, 5}},
+		{mtype: "agent", want: []int{3, 7}},
+		{mtype: "tool", want: []int{2}},
+		{mtype: "summary", want: []int{6}},
+		{mtype: "error", want: []int{4}},
+		{mtype: "system", wantErr: true},
+		{mtype: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mtype, func(t *testing.T) {
+			got, err := c.ListMessagesByType(ci.ID, tt.mtype)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			var ids []int
+			for _, m := range got {
+				if m.MType != tt.mtype {
+					t.Errorf("got a %s message", m.MType)
+				}
+				ids = append(ids, m.MessageID)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("messages = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeChats(t *testing.T) {
+	tests := []struct {
+		name       string
+		deleteFrom bool
+		running    bool // Whether the source chat is running
+		wantErr    bool
+	}{
+		{name: "keep the source"},
+		{name: "delete the source", deleteFrom: true},
+		{name: "source is running", deleteFrom: true, running: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			into, err := c.CreateChat("into", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := c.CreateChat("from", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, text := range []string{"a1", "a2"} {
+				addUserMessage(t, c, into.ID, text)
+			}
+			for _, text := range []string{"b1", "b2"} {
+				addUserMessage(t, c, from.ID, text)
+			}
+			if tt.running {
+				if err := c.SetChatState(from.ID, "running"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before, err := c.GetChat(into.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.MergeChats(into.ID, from.ID, tt.deleteFrom)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeChats() err = %v, want error %v", err, tt.wantErr)
+			}
+
+			// Both histories, in order, after a merge (or just
+			// the original one if it failed)
+			want := []string{"a1", "a2", "b1", "b2"}
+			if tt.wantErr {
+				want = want[:2]
+			}
+			ms, err := c.ListMessages(into.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			var ids []int
+			for _, m := range ms {
+				got = append(got, m.UserMsg.Text)
+				ids = append(ids, m.MessageID)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("merged messages = %v, want %v", got, want)
+			}
+			if !slices.IsSorted(ids) || len(slices.Compact(ids)) != len(ids) {
+				t.Errorf("merged message IDs = %v, want them increasing", ids)
+			}
+
+			after, err := c.GetChat(into.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bumped := after.UpdatedAt.After(before.UpdatedAt); bumped == tt.wantErr {
+				t.Errorf("UpdatedAt went from %v to %v", before.UpdatedAt, after.UpdatedAt)
+			}
+			_, err = c.GetChat(from.ID)
+			if gone := errors.Is(err, errNotFound); gone != (tt.deleteFrom && !tt.wantErr) {
+				t.Errorf("source chat deleted: %v, want %v", gone, tt.deleteFrom && !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMergeChatErrors(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("chat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MergeChats(ci.ID, ci.ID, false); err == nil {
+		t.Error("merging a chat into itself didn't fail")
+	}
+	if err := c.MergeChats(ci.ID, 42, false); !errors.Is(err, errNotFound) {
+		t.Errorf("merging a missing chat: err = %v, want %v", err, errNotFound)
+	}
+}
+
+func TestEstimateChatCost(t *testing.T) {
+	rates := map[string]string{defaultModel: "1:2", "big": "10:20"}
+	tests := []struct {
+		name string
+		msgs []Message // Just the models and tokens
+		want float64
+		err  bool
+	}{
+		{"no usage", nil, 0, false},
+		{"default model", []Message{{InputTokens: 3, OutputTokens: 4}}, 3 + 8, false},
+		{"per model", []Message{{Model: defaultModel, InputTokens: 3, OutputTokens: 4}, {Model: "big", InputTokens: 1, OutputTokens: 1}}, 11 + 30, false},
+		{"no rates for a model", []Message{{Model: "other", InputTokens: 1}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			ci, err := c.CreateChat("cost", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addUserMessage(t, c, ci.ID, "hi")
+			for _, m := range tt.msgs {
+				var reply Message
+				if err := json.Unmarshal([]byte(`{"MType":"agent","AgentMsg":{"Text":"hello"}}`), &reply); err != nil {
+					t.Fatal(err)
+				}
+				reply.ChatID = ci.ID
+				reply.Model, reply.InputTokens, reply.OutputTokens = m.Model, m.InputTokens, m.OutputTokens
+				if _, err := c.CreateMessage(reply); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := c.EstimateChatCost(ci.ID, func(model string) (float64, float64, error) {
+				return costRates(rates, model)
+			})
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error: %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("cost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// seedMessages adds messages to a chat from a string of their types'
+// first letters (see testMessages), returning them as saved. Messages
+// whose index is in pinned are pinned.
+func seedMessages(t *testing.T, c *client, cid int, types string, pinned ...int) []Message {
+	t.Helper()
+	var ms []Message
+	for i, m := range testMessages(types) {
+		m.MessageID = 0
+		m.ChatID = cid
+		m.Pinned = slices.Contains(pinned, i)
+		payload(&m)
+		saved, err := c.CreateMessage(m)
+		if err != nil {
+			t.Fatalf("failed to add message: %v", err)
+		}
+		ms = append(ms, *saved)
+	}
+	return ms
+}
+
+// messageIDsOf returns the IDs of a chat's messages.
+func messageIDsOf(t *testing.T, c *client, cid int) []int {
+	t.Helper()
+	ms, err := c.ListMessages(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, m := range ms {
+		ids = append(ids, m.MessageID)
+	}
+	return ids
+}
+
+func TestDeleteTrailingReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		types   string
+		pinned  []int // Indexes of pinned messages
+		want    []int // IDs of the messages left
+		deleted int
+		wantErr bool
+	}{
+		{name: "the reply", types: "uaua", want: []int{1, 2, 3}, deleted: 1},
+		{name: "tool calls and reply", types: "uutta", want: []int{1, 2}, deleted: 3},
+		{name: "nothing to delete", types: "uau", want: []int{1, 2, 3}},
+		{name: "keeps summaries", types: "ccuas", want: []int{1, 2, 3, 5}, deleted: 1},
+		{name: "keeps pinned messages", types: "uata", pinned: []int{2}, want: []int{1, 3}, deleted: 2},
+		{name: "no user message", types: "aa", want: []int{1, 2}, wantErr: true},
+		{name: "pinned without a user message", types: "as", pinned: []int{0}, want: []int{1, 2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			ci, err := c.CreateChat("regen", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			seedMessages(t, c, ci.ID, tt.types, tt.pinned...)
+
+			n, err := c.DeleteTrailingReplies(ci.ID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if n != tt.deleted {
+				t.Errorf("deleted %d messages, want %d", n, tt.deleted)
+			}
+			if got := messageIDsOf(t, c, ci.ID); !slices.Equal(got, tt.want) {
+				t.Errorf("messages left = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteTrailingRepliesRunning(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("regen", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "ua")
+	if err := c.SetChatState(ci.ID, "running"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DeleteTrailingReplies(ci.ID); err == nil {
+		t.Fatal("deleted the replies of a running chat")
+	}
+}
+
+func TestOpenRepairsMissingBuckets(t *testing.T) {
+	tests := []struct {
+		name   string
+		bucket []byte
+	}{
+		{"edges", []byte(edgeBucket)},
+		{"chats", []byte(chatBucket)},
+		{"a chat's messages", ChatInfo{ID: 1}.MessageBucketName()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := t.TempDir()
+			c, err := newClient(context.Background(), d, clientConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.CreateChat("chat", ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.db.Update(func(tx *bolt.Tx) error {
+				return tx.DeleteBucket(tt.bucket)
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if repair, err := needsRepair(c.db); err != nil || !repair {
+				t.Errorf("needsRepair() = %v, %v; want true", repair, err)
+			}
+			c.Close()
+
+			// Opening it again puts the bucket back
+			c, err = newClient(context.Background(), d, clientConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if err := c.db.View(func(tx *bolt.Tx) error {
+				if tx.Bucket(tt.bucket) == nil {
+					t.Errorf("%s bucket wasn't recreated", bucketLabel(tt.bucket))
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if repair, err := needsRepair(c.db); err != nil || repair {
+				t.Errorf("needsRepair() after opening = %v, %v; want false", repair, err)
+			}
+		})
+	}
+}
+
+func TestBucketLabel(t *testing.T) {
+	if got, want := bucketLabel(ChatInfo{ID: 3}.MessageBucketName()), "messages of chat 3"; got != want {
+		t.Errorf("bucketLabel() = %q, want %q", got, want)
+	}
+	if got := bucketLabel([]byte(edgeBucket)); got != edgeBucket {
+		t.Errorf("bucketLabel() = %q, want %q", got, edgeBucket)
+	}
+}
+
+func TestStartupChat(t *testing.T) {
+	c := newTestClient(t)
+	chatNames := func() []string {
+		t.Helper()
+		chats, err := c.ListChats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, ci := range chats {
+			names = append(names, ci.Name)
+		}
+		return names
+	}
+
+	// A fresh database gets exactly one chat, with the default name
+	id, err := c.StartupChat("Welcome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		again, err := c.StartupChat("Welcome")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again != id {
+			t.Errorf("StartupChat() = %d, then %d", id, again)
+		}
+	}
+	if got := chatNames(); !slices.Equal(got, []string{"Welcome"}) {
+		t.Errorf("chats = %q, want one Welcome", got)
+	}
+
+	// Once they're all deleted, the one that replaces them isn't the default
+	if err := c.DeleteChat(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.StartupChat("Welcome"); err != nil {
+		t.Fatal(err)
+	}
+	if got := chatNames(); !slices.Equal(got, []string{untitledChat}) {
+		t.Errorf("chats = %q, want one %q", got, untitledChat)
+	}
+}
+
+func TestStartupChatExistingDatabase(t *testing.T) {
+	// A database from before first runs were recorded,
+	// with a chat but no record of the app starting
+	c := newTestClient(t)
+	ci, err := c.CreateChat("old", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.StartupChat("Welcome"); err != nil || id != ci.ID {
+		t.Fatalf("StartupChat() = %d, %v; want %d", id, err, ci.ID)
+	}
+	if err := c.DeleteChat(ci.ID); err != nil {
+		t.Fatal(err)
+	}
+	id, err := c.StartupChat("Welcome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetChat(id); err != nil || got.Name != untitledChat {
+		t.Errorf("new chat = %+v (%v), want %q", got, err, untitledChat)
+	}
+}
+
+func TestStartupChatLastChat(t *testing.T) {
+	dir := t.TempDir()
+	c := reopen(t, dir)
+	var ids []int
+	for _, name := range []string{"a", "b", "c"} {
+		ci, err := c.CreateChat(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ci.ID)
+	}
+	startup := func(want int) {
+		t.Helper()
+		if id, err := c.StartupChat("Welcome"); err != nil || id != want {
+			t.Errorf("StartupChat() = %d, %v; want %d", id, err, want)
+		}
+	}
+
+	// Without a last chat, the first one
+	startup(ids[0])
+
+	// The last chat opened, even after a restart
+	if err := c.SetLastChat(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	startup(ids[2])
+	c.Close()
+	c = reopen(t, dir)
+	startup(ids[2])
+
+	// Back to the first once it's deleted
+	if err := c.SetLastChat(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteChat(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	startup(ids[0])
+}
+
+func TestUpdateNode(t *testing.T) {
+	tests := []struct {
+		name  string
+		props map[string]any
+		merge bool
+		want  map[string]any
+	}{
+		{
+			name:  "merge sets and adds keys",
+			props: map[string]any{"city": "Rome", "job": "chef"},
+			merge: true,
+			want:  map[string]any{"name": "Alice", "city": "Rome", "job": "chef"},
+		},
+		{
+			name:  "merge deletes nil keys",
+			props: map[string]any{"city": nil, "missing": nil},
+			merge: true,
+			want:  map[string]any{"name": "Alice"},
+		},
+		{
+			name:  "merge with nothing",
+			merge: true,
+			want:  map[string]any{"name": "Alice", "city": "Paris"},
+		},
+		{
+			name:  "replace",
+			props: map[string]any{"job": "chef"},
+			want:  map[string]any{"job": "chef"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			n, err := c.CreateNode("person", map[string]any{"name": "Alice", "city": "Paris"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			update := c.ReplaceNodeProps
+			if tt.merge {
+				update = c.UpdateNode
+			}
+			got, err := update(n.ID, tt.props)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got.Props, tt.want) {
+				t.Errorf("returned props = %v, want %v", got.Props, tt.want)
+			}
+			if got.Type != "person" {
+				t.Errorf("type = %q, want it unchanged", got.Type)
+			}
+
+			// And it's what was saved
+			saved, err := c.GetNode(n.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(saved.Props, tt.want) {
+				t.Errorf("saved props = %v, want %v", saved.Props, tt.want)
+			}
+		})
+	}
+
+	c := newTestClient(t)
+	if _, err := c.UpdateNode(1, map[string]any{"a": "b"}); !errors.Is(err, errNotFound) {
+		t.Errorf("updating a missing node: error = %v, want errNotFound", err)
+	}
+}
+
+func TestListNodesPage(t *testing.T) {
+	c := newTestClient(t)
+	// 25 people, with a city after every fifth one
+	var people []int
+	for i := range 25 {
+		n, err := c.CreateNode("person", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		people = append(people, n.ID)
+		if i%5 == 4 {
+			if _, err := c.CreateNode("city", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Page through the people
+	var got []int
+	var pages []bool
+	for page := (Page{Limit: 10}); ; page.Offset += page.Limit {
+		ns, more, err := c.ListNodesPage("person", page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, nodeIDs(ns)...)
+		pages = append(pages, more)
+		if !more {
+			break
+		}
+	}
+	if !slices.Equal(got, people) {
+		t.Errorf("paged through %v, want %v", got, people)
+	}
+	if want := []bool{true, true, false}; !slices.Equal(pages, want) {
+		t.Errorf("more = %v, want %v", pages, want)
+	}
+
+	tests := []struct {
+		name     string
+		nodeType string
+		page     Page
+		want     int // Number of nodes
+		more     bool
+	}{
+		{"no limit", "", Page{}, 30, false},
+		{"exactly the rest", "person", Page{Limit: 5, Offset: 20}, 5, false},
+		{"past the end", "person", Page{Limit: 5, Offset: 25}, 0, false},
+		{"offset only", "city", Page{Offset: 3}, 2, false},
+		{"filtered", "city", Page{Limit: 2}, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns, more, err := c.ListNodesPage(tt.nodeType, tt.page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ns) != tt.want || more != tt.more {
+				t.Errorf("ListNodesPage() = %d nodes (more %v), want %d (more %v)", len(ns), more, tt.want, tt.more)
+			}
+		})
+	}
+}
+
+func TestListEdgesPage(t *testing.T) {
+	c := testGraph(t)
+	tests := []struct {
+		name   string
+		filter EdgeFilter
+		page   Page
+		want   []int
+		more   bool
+	}{
+		{"first page", EdgeFilter{}, Page{Limit: 2}, []int{1, 2}, true},
+		{"last page", EdgeFilter{}, Page{Limit: 2, Offset: 2}, []int{3, 4}, false},
+		{"filtered", EdgeFilter{Type: "knows"}, Page{Limit: 2, Offset: 1}, []int{2, 4}, false},
+		{"filtered first page", EdgeFilter{FromID: 1}, Page{Limit: 1}, []int{1}, true},
+		{"past the end", EdgeFilter{}, Page{Limit: 2, Offset: 4}, []int{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es, more, err := c.ListEdgesPage(tt.filter, tt.page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := edgeIDs(es); !slices.Equal(got, tt.want) || more != tt.more {
+				t.Errorf("ListEdgesPage() = %v (more %v), want %v (more %v)", got, more, tt.want, tt.more)
+			}
+		})
+	}
+}
+
+func TestCreateBidirectionalEdge(t *testing.T) {
+	c := testGraph(t)
+	ab, ba, err := c.CreateBidirectionalEdge("married_to", 2, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ab.FromID != 2 || ab.ToID != 5 || ba.FromID != 5 || ba.ToID != 2 {
+		t.Errorf("edges = %d->%d and %d->%d, want 2->5 and 5->2", ab.FromID, ab.ToID, ba.FromID, ba.ToID)
+	}
+	es, err := c.ListEdges(EdgeFilter{Type: "married_to"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := edgeIDs(es); !slices.Equal(got, []int{ab.ID, ba.ID}) {
+		t.Errorf("saved edges = %v, want %v", got, []int{ab.ID, ba.ID})
+	}
+
+	// Neither edge is made if either can't be
+	tests := []struct {
+		name string
+		a, b int
+	}{
+		{"missing second node", 1, 99},
+		{"missing first node", 99, 1},
+		{"same node", 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := c.CreateBidirectionalEdge("married_to", tt.a, tt.b); err == nil {
+				t.Fatal("CreateBidirectionalEdge() succeeded")
+			}
+			after, err := c.ListEdges(EdgeFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(after) != 6 {
+				t.Errorf("there are %d edges, want 6", len(after))
+			}
+		})
+	}
+
+	// Not even their IDs are used up
+	e, err := c.CreateEdge("knows", 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != ba.ID+1 {
+		t.Errorf("next edge ID = %d, want %d", e.ID, ba.ID+1)
+	}
+}
+
+func TestSelfLoops(t *testing.T) {
+	tests := []struct {
+		name        string
+		noSelfLoops bool
+	}{
+		{"allowed by default", false},
+		{"rejected", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newClient(context.Background(), t.TempDir(), clientConfig{NoSelfLoops: tt.noSelfLoops})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { c.Close() })
+			n, err := c.CreateNode("person", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = c.CreateEdge("likes", n.ID, n.ID)
+			if tt.noSelfLoops {
+				if !errors.Is(err, errSelfLoop) {
+					t.Errorf("CreateEdge() error = %v, want errSelfLoop", err)
+				}
+			} else if err != nil {
+				t.Errorf("CreateEdge() error = %v, want a self-loop", err)
+			}
+
+			// The tools follow the same rule
+			a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{})
+			ci, err := c.CreateChat("loops", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := callTool(t, a, ci.ID, "create_edge", map[string]any{"type": "likes", "from_id": n.ID, "to_id": n.ID})
+			if got := m.ToolMsg.ToolError != ""; got != tt.noSelfLoops {
+				t.Errorf("create_edge error = %q, want one: %v", m.ToolMsg.ToolError, tt.noSelfLoops)
+			}
+
+			// Edges between different nodes are fine either way
+			other, err := c.CreateNode("person", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.CreateEdge("likes", n.ID, other.ID); err != nil {
+				t.Errorf("CreateEdge() between different nodes failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestUseNumber(t *testing.T) {
+	// Too big to survive being a float64
+	const big = 9007199254740993
+	tests := []struct {
+		name      string
+		useNumber bool
+		want      any
+	}{
+		{"numbers", true, json.Number("9007199254740993")},
+		{"floats", false, float64(big)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newClient(context.Background(), t.TempDir(), clientConfig{UseNumber: tt.useNumber})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { c.Close() })
+			ci, err := c.CreateChat("numbers", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := c.CreateNodeFromChat("account", map[string]any{"id": big, "age": 30}, ci.ID, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Every way of reading it back agrees
+			got, err := c.GetNode(n.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listed, err := c.ListNodes("account")
+			if err != nil {
+				t.Fatal(err)
+			}
+			byChat, err := c.ListNodesByChat(ci.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, n := range []GraphNode{*got, listed[0], byChat[0]} {
+				if n.Props["id"] != tt.want {
+					t.Errorf("id = %#v, want %#v", n.Props["id"], tt.want)
+				}
+			}
+
+			// And the model sees the exact number when it's kept
+			a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{})
+			m := callTool(t, a, ci.ID, "get_node", map[string]any{"id": n.ID})
+			if has := strings.Contains(m.ToolMsg.ToolResult, `"id":9007199254740993`); has != tt.useNumber {
+				t.Errorf("get_node result = %s", m.ToolMsg.ToolResult)
+			}
+			if !strings.Contains(m.ToolMsg.ToolResult, `"age":30`) {
+				t.Errorf("get_node result = %s, want age 30", m.ToolMsg.ToolResult)
+			}
+		})
+	}
+}
+
+func TestDeleteUnfinishedToolCalls(t *testing.T) {
+	tests := []struct {
+		name       string
+		types      string
+		unfinished []int // Indexes of tool calls without a result
+		want       []int // IDs of the messages left
+	}{
+		{"one call", "ut", []int{1}, []int{1}},
+		{"several calls", "uatt", []int{2, 3}, []int{1, 2}},
+		{"only the trailing ones", "uttt", []int{1, 3}, []int{1, 2, 3}},
+		{"past a summary", "utts", []int{1, 2}, []int{1, 4}},
+		{"finished calls", "uatt", nil, []int{1, 2, 3, 4}},
+		{"not a tool call", "uta", []int{1}, []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			ci, err := c.CreateChat("unfinished", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ms := seedMessages(t, c, ci.ID, tt.types)
+			for _, i := range tt.unfinished {
+				ms[i].ToolMsg.ToolResult = ""
+				if err := c.UpdateMessage(ms[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			n, err := c.DeleteUnfinishedToolCalls(ci.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := len(tt.types) - len(tt.want); n != want {
+				t.Errorf("deleted %d, want %d", n, want)
+			}
+			if got := messageIDsOf(t, c, ci.ID); !slices.Equal(got, tt.want) {
+				t.Errorf("messages left = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	c := newTestClient(t)
+	if _, err := c.DeleteUnfinishedToolCalls(99); !errors.Is(err, errNotFound) {
+		t.Errorf("missing chat: error = %v, want errNotFound", err)
+	}
+}
+
+func TestClearErrorMessage(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("errors", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "uaue")
+
+	// Not while the chat's running
+	if err := c.SetChatState(ci.ID, "running"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.ClearErrorMessage(ci.ID, 4); ok || err != nil {
+		t.Errorf("ClearErrorMessage() while running = %v, %v; want false", ok, err)
+	}
+	if err := c.SetChatState(ci.ID, "idle"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ClearErrorMessage(ci.ID, 2); err == nil {
+		t.Error("ClearErrorMessage() cleared an agent message")
+	}
+	if _, err := c.ClearErrorMessage(ci.ID, 99); !errors.Is(err, errNotFound) {
+		t.Errorf("missing message: error = %v, want errNotFound", err)
+	}
+	if got := messageIDsOf(t, c, ci.ID); !slices.Equal(got, []int{1, 2, 3, 4}) {
+		t.Fatalf("messages = %v, want them all", got)
+	}
+
+	if ok, err := c.ClearErrorMessage(ci.ID, 4); !ok || err != nil {
+		t.Fatalf("ClearErrorMessage() = %v, %v; want true", ok, err)
+	}
+	if got := messageIDsOf(t, c, ci.ID); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("messages = %v, want [1 2 3]", got)
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	c := newTestClient(t)
+	var ids []int
+	for _, name := range []string{"a", "b"} {
+		ci, err := c.CreateChat(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ci.ID)
+	}
+	draft := func(cid int, want string) {
+		t.Helper()
+		if got, err := c.GetDraft(cid); err != nil || got != want {
+			t.Errorf("GetDraft(%d) = %q, %v; want %q", cid, got, err, want)
+		}
+	}
+
+	// Each chat has its own
+	draft(ids[0], "")
+	if err := c.SaveDraft(ids[0], "line one\nline two"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SaveDraft(ids[1], "other"); err != nil {
+		t.Fatal(err)
+	}
+	draft(ids[0], "line one\nline two")
+	draft(ids[1], "other")
+
+	// Saving nothing clears it
+	if err := c.SaveDraft(ids[1], ""); err != nil {
+		t.Fatal(err)
+	}
+	draft(ids[1], "")
+
+	// It goes with its chat
+	if err := c.DeleteChat(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	draft(ids[0], "")
+	if err := c.SaveDraft(ids[0], "too late"); !errors.Is(err, errNotFound) {
+		t.Errorf("SaveDraft() for a deleted chat: error = %v, want errNotFound", err)
+	}
+}
+
+func TestForkChat(t *testing.T) {
+	c := newTestClient(t)
+	src, err := c.CreateChat("source", "You are a research assistant.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, src.ID, "uatua")
+
+	// The fork has the messages up to and including the given one
+	fork, err := c.ForkChat(src.ID, 3, "fork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.ID == src.ID || fork.Name != "fork" || fork.SystemPrompt != src.SystemPrompt {
+		t.Errorf("fork = %+v, want a new chat named fork with the source's prompt", fork)
+	}
+	ms, err := c.ListMessages(fork.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageTypes(ms); got != "uat" {
+		t.Errorf("fork has messages %q, want uat", got)
+	}
+	for i, m := range ms {
+		if m.ChatID != fork.ID || m.MessageID != i+1 {
+			t.Errorf("forked message %d has chat %d, ID %d", i, m.ChatID, m.MessageID)
+		}
+	}
+
+	// Afterwards the chats are independent
+	addUserMessage(t, c, fork.ID, "a different direction")
+	if err := c.DeleteMessage(src.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := messageIDsOf(t, c, src.ID); !slices.Equal(got, []int{2, 3, 4, 5}) {
+		t.Errorf("source messages = %v, want [2 3 4 5]", got)
+	}
+	if got := messageIDsOf(t, c, fork.ID); !slices.Equal(got, []int{1, 2, 3, 4}) {
+		t.Errorf("fork messages = %v, want [1 2 3 4]", got)
+	}
+
+	// Forking at a missing message (or chat) fails
+	if _, err := c.ForkChat(src.ID, 99, "bad"); !errors.Is(err, errNotFound) {
+		t.Errorf("ForkChat() at a missing message = %v, want errNotFound", err)
+	}
+	if _, err := c.ForkChat(99, 1, "bad"); !errors.Is(err, errNotFound) {
+		t.Errorf("ForkChat() of a missing chat = %v, want errNotFound", err)
+	}
+}
+
+func TestClearChat(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("keep me", "You are a code helper.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "uatua")
+	before, err := c.GetChat(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clearing empties the messages but keeps the chat
+	if err := c.ClearChat(ci.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := messageIDsOf(t, c, ci.ID); len(got) != 0 {
+		t.Errorf("messages after clearing = %v, want none", got)
+	}
+	after, err := c.GetChat(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ID != before.ID || after.Name != before.Name || after.SystemPrompt != before.SystemPrompt {
+		t.Errorf("chat after clearing = %+v, want %+v", after, before)
+	}
+
+	// And it can carry on as before
+	addUserMessage(t, c, ci.ID, "start again")
+	if got := messageIDsOf(t, c, ci.ID); len(got) != 1 {
+		t.Errorf("messages after adding one = %v", got)
+	}
+
+	// Running chats can't be cleared
+	if err := c.SetChatState(ci.ID, "running"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ClearChat(ci.ID); err == nil {
+		t.Error("ClearChat() cleared a running chat")
+	}
+	if got := messageIDsOf(t, c, ci.ID); len(got) != 1 {
+		t.Errorf("messages after failing to clear = %v", got)
+	}
+
+	if err := c.ClearChat(99); !errors.Is(err, errNotFound) {
+		t.Errorf("ClearChat() of a missing chat = %v, want errNotFound", err)
+	}
+}
+
+func TestDuplicateChat(t *testing.T) {
+	c := newTestClient(t)
+	src, err := c.CreatePrivateChat("source", "You are a research assistant.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := seedMessages(t, c, src.ID, "uatuae", 1)
+
+	// The duplicate has all the messages and the same settings
+	dup, err := c.DuplicateChat(src.ID, "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dup.ID == src.ID || dup.Name != "snapshot" {
+		t.Errorf("duplicate = %+v, want a new chat named snapshot", dup)
+	}
+	if dup.SystemPrompt != src.SystemPrompt || dup.Graph != src.Graph {
+		t.Errorf("duplicate has prompt %q and graph %q, want %q and %q", dup.SystemPrompt, dup.Graph, src.SystemPrompt, src.Graph)
+	}
+	got, err := c.ListMessages(dup.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("duplicate has %d messages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		w := want[i]
+		w.ChatID = dup.ID
+		a, _ := json.Marshal(got[i])
+		b, _ := json.Marshal(w)
+		if string(a) != string(b) {
+			t.Errorf("message %d = %s, want %s", i, a, b)
+		}
+	}
+
+	// Changing one doesn't change the other
+	addUserMessage(t, c, src.ID, "risky experiment")
+	if err := c.DeleteMessage(dup.ID, 6); err != nil {
+		t.Fatal(err)
+	}
+	if got := messageIDsOf(t, c, src.ID); !slices.Equal(got, []int{1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("source messages = %v, want [1 2 3 4 5 6 7]", got)
+	}
+	if got := messageIDsOf(t, c, dup.ID); !slices.Equal(got, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("duplicate messages = %v, want [1 2 3 4 5]", got)
+	}
+
+	if _, err := c.DuplicateChat(99, "bad"); !errors.Is(err, errNotFound) {
+		t.Errorf("DuplicateChat() of a missing chat = %v, want errNotFound", err)
+	}
+}
+
+func TestDBPath(t *testing.T) {
+	// The data directory (and any parents) are made as needed
+	d := filepath.Join(t.TempDir(), "nested", "agnt")
+	c, err := newClient(context.Background(), d, clientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The path uses the OS's separator throughout
+	want := d + string(os.PathSeparator) + dbFile
+	if c.dbp != want {
+		t.Errorf("database path = %q, want %q", c.dbp, want)
+	}
+	if os.PathSeparator != '/' && strings.Contains(c.dbp, "/") {
+		t.Errorf("database path %q has a forward slash", c.dbp)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("database wasn't created at %q: %v", want, err)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	d := t.TempDir()
+	c, err := newClient(context.Background(), d, clientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second open gives up quickly with a clear error
+	start := time.Now()
+	_, err = newClient(context.Background(), d, clientConfig{LockTimeout: 100 * time.Millisecond})
+	if err == nil || err.Error() != "database is locked by another agnt process" {
+		t.Errorf("opening a locked database = %v, want the locked error", err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("opening a locked database took %s", took)
+	}
+
+	// And succeeds once the lock is released
+	c.Close()
+	reopen(t, d)
+}
+
+func TestOpenOptions(t *testing.T) {
+	for _, cfg := range []clientConfig{
+		{NoSync: true},
+		{FreelistType: "array"},
+		{FreelistType: "hashmap"},
+	} {
+		c, err := newClient(context.Background(), t.TempDir(), cfg)
+		if err != nil {
+			t.Errorf("newClient(%+v) failed: %v", cfg, err)
+			continue
+		}
+		if c.db.NoSync != cfg.NoSync {
+			t.Errorf("newClient(%+v) NoSync = %v", cfg, c.db.NoSync)
+		}
+		if cfg.FreelistType != "" && string(c.db.FreelistType) != cfg.FreelistType {
+			t.Errorf("newClient(%+v) freelist type = %q", cfg, c.db.FreelistType)
+		}
+		c.Close()
+	}
+
+	if _, err := newClient(context.Background(), t.TempDir(), clientConfig{FreelistType: "list"}); err == nil {
+		t.Error("newClient() accepted an unknown freelist type")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+func auditCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "audit",
+		Usage: "show recent changes made to the graph",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "limit",
+				Usage: "number of entries to show (0 for all)",
+				Value: 20,
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			client, err := openClient(ctx, cmd)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			entries, err := client.AuditLog(cmd.Int("limit"))
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				entity := e.Entity
+				if e.Graph != "" {
+					entity += "@" + e.Graph
+				}
+				fmt.Printf("%s  %-6s %s %d %s\n", e.Time.Local().Format(time.DateTime), e.Op, entity, e.ID, string(e.Value))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+func chatsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "chats",
+		Usage: "manage chat threads",
+		Commands: []*cli.Command{
+			{
+				Name:      "new",
+				Usage:     "create a new chat",
+				ArgsUsage: "[name]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "private-graph",
+						Usage: "give the chat its own graph instead of the shared one",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					name := cmd.Args().First()
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					create := client.CreateChat
+					if cmd.Bool("private-graph") {
+						create = client.CreatePrivateChat
+					}
+					ci, err := create(name, "")
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Chat created with ID: %d\n", ci.ID)
+					return nil
+				},
+			},
+			{
+				Name:      "cost",
+				Usage:     "estimate the cost of a chat from its token usage",
+				ArgsUsage: "<id>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					id, err := chatIDArg(cmd)
+					if err != nil {
+						return err
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					// Price each message by the model that generated it
+					rates := cmd.StringMap("cost-rates")
+					cost, err := client.EstimateChatCost(id, func(model string) (float64, float64, error) {
+						return costRates(rates, model)
+					})
+					if err != nil {
+						return err
+					}
+					fmt.Printf("%.6f\n", cost)
+					return nil
+				},
+			},
+			{
+				Name:      "set-prompt",
+				Usage:     "set a chat's system prompt (an empty prompt uses the default)",
+				ArgsUsage: "<id> <prompt>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					id, err := chatIDArg(cmd)
+					if err != nil {
+						return err
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					return client.SetChatPrompt(id, cmd.Args().Get(1))
+				},
+			},
+			{
+				Name:      "clear",
+				Usage:     "delete all of a chat's messages, keeping the chat",
+				ArgsUsage: "<id>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					id, err := chatIDArg(cmd)
+					if err != nil {
+						return err
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					return client.ClearChat(id)
+				},
+			},
+			{
+				Name:      "duplicate",
+				Usage:     "copy a chat and all of its messages into a new chat",
+				ArgsUsage: "<id> <name>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					id, err := chatIDArg(cmd)
+					if err != nil {
+						return err
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					ci, err := client.DuplicateChat(id, cmd.Args().Get(1))
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Chat duplicated with ID: %d\n", ci.ID)
+					return nil
+				},
+			},
+			{
+				Name:      "merge",
+				Usage:     "append all of one chat's messages to another",
+				ArgsUsage: "<into-id> <from-id>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "delete",
+						Usage: "delete the chat merged from afterwards",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					into, err := chatIDArg(cmd)
+					if err != nil {
+						return err
+					}
+					if cmd.NArg() < 2 {
+						return fmt.Errorf("missing chat id to merge from")
+					}
+					from, err := strconv.Atoi(cmd.Args().Get(1))
+					if err != nil {
+						return fmt.Errorf("invalid chat id %q: %w", cmd.Args().Get(1), err)
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					return client.MergeChats(into, from, cmd.Bool("delete"))
+				},
+			},
+			{
+				Name:      "export",
+				Usage:     "export a chat and the graph nodes it created as a json bundle (or as fine-tuning data)",
+				ArgsUsage: "<id>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "file to write the bundle to (defaults to stdout)",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "json (a bundle that can be imported) or jsonl (OpenAI fine-tuning data)",
+						Value: "json",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					id, err := chatIDArg(cmd)
+					if err != nil {
+						return err
+					}
+					format := cmd.String("format")
+					if format != "json" && format != "jsonl" {
+						return fmt.Errorf("unknown format %q (use json or jsonl)", format)
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					if format == "jsonl" {
+						w := os.Stdout
+						if p := cmd.String("output"); p != "" {
+							f, err := os.Create(p)
+							if err != nil {
+								return fmt.Errorf("failed to create output file: %w", err)
+							}
+							defer f.Close()
+							w = f
+						}
+						return client.ExportChatJSONL(id, w)
+					}
+
+					data, err := client.ExportChatBundle(id)
+					if err != nil {
+						return err
+					}
+					if p := cmd.String("output"); p != "" {
+						if err := os.WriteFile(p, data, 0644); err != nil {
+							return fmt.Errorf("failed to write bundle: %w", err)
+						}
+						return nil
+					}
+					fmt.Println(string(data))
+					return nil
+				},
+			},
+			{
+				Name:      "import",
+				Usage:     "import a chat bundle as a new chat",
+				ArgsUsage: "<file>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.NArg() < 1 {
+						return fmt.Errorf("missing bundle file")
+					}
+					data, err := os.ReadFile(cmd.Args().First())
+					if err != nil {
+						return fmt.Errorf("failed to read bundle: %w", err)
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					ci, err := client.ImportChatBundle(data)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Chat imported with ID: %d\n", ci.ID)
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// chatIDArg parses the chat ID from the command's first argument.
+func chatIDArg(cmd *cli.Command) (int, error) {
+	if cmd.NArg() < 1 {
+		return 0, fmt.Errorf("missing chat id")
+	}
+	id, err := strconv.Atoi(cmd.Args().First())
+	if err != nil {
+		return 0, fmt.Errorf("invalid chat id %q: %w", cmd.Args().First(), err)
+	}
+	return id, nil
+}
+
+// costRates resolves the input and output per-token rates for a model
+// from a map of "model" -> "input:output", falling back to the "*" key.
+func costRates(rates map[string]string, model string) (float64, float64, error) {
+	r, ok := rates[model]
+	if !ok {
+		r, ok = rates["*"]
+	}
+	if !ok {
+		return 0, 0, fmt.Errorf("no cost rates configured for model %q", model)
+	}
+
+	in, out, ok := strings.Cut(r, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid cost rates %q: expected input:output", r)
+	}
+	inRate, err := strconv.ParseFloat(in, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid input rate %q: %w", in, err)
+	}
+	outRate, err := strconv.ParseFloat(out, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid output rate %q: %w", out, err)
+	}
+	return inRate, outRate, nil
+}
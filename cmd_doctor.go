@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+func doctorCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "check that agnt is set up properly",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "no-ping",
+				Usage: "skip checking the connection to ollama",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			cfg := agentConfigFromCmd(cmd)
+			var checks []check
+
+			// Show what the settings resolve to
+			fmt.Printf("Model:     %s\n", defaultModel)
+			fmt.Printf("Ollama:    %s\n", ollamaURL(cfg.BaseURL))
+			if ws := cmd.String("workspace"); ws != "" {
+				fmt.Printf("Workspace: %s\n", ws)
+			}
+
+			// Check the database, as it is (without the repairs
+			// opening it normally would make)
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return fmt.Errorf("failed to get home directory: %w", err)
+			}
+			f, err := workspaceDBFile(cmd.String("workspace"))
+			if err != nil {
+				return err
+			}
+			p := filepath.Join(resolveDirs(runtime.GOOS, home, os.Getenv, os.Stat).Data, f)
+			fmt.Printf("Database:  %s\n", p)
+			if _, err := os.Stat(p); os.IsNotExist(err) {
+				checks = append(checks, check{Name: "database", OK: true, Detail: "not created yet (it will be on first use)"})
+			} else if client, err := openForChecks(p, cmd.Duration("db-timeout")); err != nil {
+				checks = append(checks, check{Name: "database", Detail: err.Error()})
+			} else {
+				defer client.Close()
+				checks = append(checks,
+					check{Name: "database", OK: true, Detail: "opens"},
+					checkBuckets(client),
+					checkGraph(client),
+				)
+			}
+
+			// Check the config and the model
+			if err := cfg.validate(); err != nil {
+				checks = append(checks, check{Name: "config", Detail: err.Error()})
+			} else {
+				checks = append(checks, check{Name: "config", OK: true, Detail: "valid"})
+			}
+			if !cmd.Bool("no-ping") && !cfg.Offline {
+				pctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				defer cancel()
+				checks = append(checks, checkModel(pctx, cfg.BaseURL, defaultModel))
+				if cfg.RAGTopK > 0 {
+					checks = append(checks, checkModel(pctx, cfg.BaseURL, cfg.EmbedModel))
+				}
+			}
+
+			// Sum up
+			fmt.Println()
+			failed := 0
+			for _, ch := range checks {
+				status := "PASS"
+				if !ch.OK {
+					status = "FAIL"
+					failed++
+				}
+				fmt.Printf("[%s] %s: %s\n", status, ch.Name, ch.Detail)
+			}
+			fmt.Println()
+			if failed > 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+			}
+			fmt.Printf("All %d checks passed\n", len(checks))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+func graphCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "graph",
+		Usage: "manage the knowledge graph",
+		Commands: []*cli.Command{
+			{
+				Name:  "import-csv",
+				Usage: "import nodes and/or edges from csv files",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "nodes",
+						Usage: "csv file of nodes, with a header row of property names",
+					},
+					&cli.StringFlag{
+						Name:  "node-type",
+						Usage: "type of the imported nodes",
+					},
+					&cli.StringFlag{
+						Name:  "edges",
+						Usage: "csv file of edges, with type, from_id, and to_id columns",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.String("nodes") == "" && cmd.String("edges") == "" {
+						return fmt.Errorf("nothing to import: set --nodes and/or --edges")
+					}
+					if cmd.String("nodes") != "" && cmd.String("node-type") == "" {
+						return fmt.Errorf("--node-type is required when importing nodes")
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					// Import the nodes first, so edges can refer to them.
+					// Rows that fail don't stop the rest; their errors
+					// are returned once everything has been imported.
+					var errs []error
+					if p := cmd.String("nodes"); p != "" {
+						f, err := os.Open(p)
+						if err != nil {
+							return fmt.Errorf("failed to open nodes file: %w", err)
+						}
+						defer f.Close()
+
+						nodes, err := client.ImportNodesCSV(f, cmd.String("node-type"))
+						fmt.Printf("Imported %d nodes\n", len(nodes))
+						if err != nil {
+							errs = append(errs, fmt.Errorf("some nodes weren't imported: %w", err))
+						}
+					}
+
+					if p := cmd.String("edges"); p != "" {
+						f, err := os.Open(p)
+						if err != nil {
+							return fmt.Errorf("failed to open edges file: %w", err)
+						}
+						defer f.Close()
+
+						edges, err := client.ImportEdgesCSV(f)
+						fmt.Printf("Imported %d edges\n", len(edges))
+						if err != nil {
+							errs = append(errs, fmt.Errorf("some edges weren't imported: %w", err))
+						}
+					}
+					return errors.Join(errs...)
+				},
+			},
+			{
+				Name:      "rm-type",
+				Usage:     "delete all nodes of a type, along with their edges",
+				ArgsUsage: "<type>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "don't ask for confirmation",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					typ := cmd.Args().First()
+					if typ == "" {
+						return fmt.Errorf("missing node type")
+					}
+					if !cmd.Bool("yes") && !confirm(fmt.Sprintf("Delete all %q nodes and their edges?", typ)) {
+						return nil
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					n, err := client.DeleteNodesByType(typ)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Deleted %d nodes\n", n)
+					return nil
+				},
+			},
+			{
+				Name:      "rename-type",
+				Usage:     "rename a node type (or edge type) across the graph",
+				ArgsUsage: "<old> <new>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "edges",
+						Usage: "rename an edge type instead of a node type",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					old, new := cmd.Args().Get(0), cmd.Args().Get(1)
+					if old == "" || new == "" {
+						return fmt.Errorf("missing old or new type")
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					if cmd.Bool("edges") {
+						n, err := client.RenameEdgeType(old, new)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("Renamed %d edges\n", n)
+						return nil
+					}
+					n, err := client.RenameNodeType(old, new)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Renamed %d nodes\n", n)
+					return nil
+				},
+			},
+			{
+				Name:      "rename-prop",
+				Usage:     "rename a property key on all nodes of a type",
+				ArgsUsage: "<type> <old> <new>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "rename the key on nodes of every type (don't pass a type)",
+					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "overwrite the new key on nodes that already have it (they're skipped otherwise)",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					args := cmd.Args().Slice()
+					var typ string
+					if !cmd.Bool("all") {
+						if len(args) == 0 {
+							return fmt.Errorf("missing node type")
+						}
+						typ, args = args[0], args[1:]
+					}
+					if len(args) != 2 || args[0] == "" || args[1] == "" {
+						return fmt.Errorf("missing old or new key")
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					n, conflicts, err := client.RenamePropKey(typ, args[0], args[1], cmd.Bool("force"))
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Renamed the property on %d nodes\n", n)
+					if len(conflicts) > 0 {
+						return fmt.Errorf("skipped %d nodes that already have %q (use --force to overwrite it): %v", len(conflicts), args[1], conflicts)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "check",
+				Usage: "report edges that point at missing nodes",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "fix",
+						Usage: "delete the dangling edges",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					edges, err := client.CheckIntegrity()
+					if err != nil {
+						return err
+					}
+					if len(edges) == 0 {
+						fmt.Println("No dangling edges found")
+						return nil
+					}
+					for _, e := range edges {
+						fmt.Printf("Edge %d (%s): %d -> %d\n", e.ID, e.Type, e.FromID, e.ToID)
+					}
+
+					if !cmd.Bool("fix") {
+						fmt.Printf("Found %d dangling edges (use --fix to delete them)\n", len(edges))
+						return nil
+					}
+					for _, e := range edges {
+						if err := client.DeleteEdge(e.ID); err != nil {
+							return err
+						}
+					}
+					fmt.Printf("Deleted %d dangling edges\n", len(edges))
+					return nil
+				},
+			},
+			{
+				Name:  "orphans",
+				Usage: "list nodes that aren't connected to any edges",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "delete",
+						Usage: "delete the orphan nodes",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					nodes, err := client.OrphanNodes()
+					if err != nil {
+						return err
+					}
+					for _, n := range nodes {
+						fmt.Printf("Node %d (%s): %v\n", n.ID, n.Type, n.Props)
+					}
+					if !cmd.Bool("delete") {
+						fmt.Printf("Found %d orphan nodes\n", len(nodes))
+						return nil
+					}
+					for _, n := range nodes {
+						if err := client.DeleteNode(n.ID); err != nil {
+							return err
+						}
+					}
+					fmt.Printf("Deleted %d orphan nodes\n", len(nodes))
+					return nil
+				},
+			},
+			{
+				Name:  "embed",
+				Usage: "embed nodes that don't have embeddings yet, for semantic search",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "re-embed every node, not just the missing ones",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					log, closeLog, err := newLogger(cmd.String("log-level"), cmd.String("log-file"))
+					if err != nil {
+						return err
+					}
+					defer closeLog()
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					agent, err := newAgent(ctx, client, agentConfigFromCmd(cmd), log)
+					if err != nil {
+						return err
+					}
+					n, err := agent.EmbedNodes(ctx, cmd.Bool("all"))
+					fmt.Printf("Embedded %d nodes\n", n)
+					return err
+				},
+			},
+			{
+				Name:      "query",
+				Usage:     "run a query, e.g. 'MATCH (n:person)-[:knows]->(m) WHERE n.name = \"Alice\" RETURN m'",
+				ArgsUsage: "<query>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					q := strings.Join(cmd.Args().Slice(), " ")
+					if q == "" {
+						return fmt.Errorf("missing query")
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					res, err := client.Query(q)
+					if err != nil {
+						return err
+					}
+					for _, n := range res.Nodes {
+						fmt.Printf("Node %d (%s): %v\n", n.ID, n.Type, n.Props)
+					}
+					for _, e := range res.Edges {
+						fmt.Printf("Edge %d (%s): %d -> %d %v\n", e.ID, e.Type, e.FromID, e.ToID, e.Props)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "stale",
+				Usage: "list nodes that haven't been used in a while (see --track-access)",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "unused-for",
+						Usage: "how long a node must have gone unused",
+						Value: 30 * 24 * time.Hour,
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					nodes, err := client.StaleNodes(time.Now().Add(-cmd.Duration("unused-for")))
+					if err != nil {
+						return err
+					}
+					for _, n := range nodes {
+						used := "never"
+						if !n.LastAccessedAt.IsZero() {
+							used = n.LastAccessedAt.Local().Format(time.DateTime)
+						}
+						fmt.Printf("Node %d (%s), last used %s: %v\n", n.ID, n.Type, used, n.Props)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "top",
+				Usage: "list the most connected nodes",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "number of nodes to list",
+						Value: 10,
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					ranked, err := client.TopNodesByDegree(cmd.Int("limit"))
+					if err != nil {
+						return err
+					}
+					for _, r := range ranked {
+						fmt.Printf("%4d  Node %d (%s): %v\n", r.Degree, r.Node.ID, r.Node.Type, r.Node.Props)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "snapshot",
+				Usage:     "save a copy of the graph to restore later",
+				ArgsUsage: "[label]",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					id, err := client.SnapshotGraph(strings.Join(cmd.Args().Slice(), " "))
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Created snapshot %s\n", id)
+					return nil
+				},
+			},
+			{
+				Name:  "snapshots",
+				Usage: "list the graph's snapshots",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					ss, err := client.ListSnapshots()
+					if err != nil {
+						return err
+					}
+					for _, s := range ss {
+						graph := s.Graph
+						if graph == "" {
+							graph = "shared"
+						}
+						fmt.Printf("%4s  %s  %-10s  %d nodes, %d edges  %s\n",
+							s.ID, s.Created.Local().Format(time.DateTime), graph, s.Nodes, s.Edges, s.Label)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "restore",
+				Usage:     "replace the graph with a snapshot",
+				ArgsUsage: "<snapshot>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "don't ask for confirmation",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					id := cmd.Args().First()
+					if id == "" {
+						return fmt.Errorf("missing snapshot id")
+					}
+					if !cmd.Bool("yes") && !confirm(fmt.Sprintf("Replace the graph with snapshot %s?", id)) {
+						return nil
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					if err := client.RestoreGraph(id); err != nil {
+						return err
+					}
+					fmt.Printf("Restored snapshot %s\n", id)
+					return nil
+				},
+			},
+			{
+				Name:      "rm-snapshot",
+				Usage:     "delete a snapshot",
+				ArgsUsage: "<snapshot>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					id := cmd.Args().First()
+					if id == "" {
+						return fmt.Errorf("missing snapshot id")
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					return client.DeleteSnapshot(id)
+				},
+			},
+		},
+	}
+}
+
+// confirm asks the user a yes/no question on stdin.
+func confirm(q string) bool {
+	fmt.Printf("%s [y/N] ", q)
+	var ans string
+	fmt.Scanln(&ans)
+	return strings.EqualFold(ans, "y") || strings.EqualFold(ans, "yes")
+}
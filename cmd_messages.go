@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/urfave/cli/v3"
+)
+
+func messagesCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "messages",
+		Usage: "inspect chat messages",
+		Commands: []*cli.Command{
+			{
+				Name:      "raw",
+				Usage:     "print the model's raw response for a message (stored with --debug)",
+				ArgsUsage: "<chat-id> <message-id>",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					cid, err := chatIDArg(cmd)
+					if err != nil {
+						return err
+					}
+					if cmd.NArg() < 2 {
+						return fmt.Errorf("missing message id")
+					}
+					mid, err := strconv.Atoi(cmd.Args().Get(1))
+					if err != nil {
+						return fmt.Errorf("invalid message id %q: %w", cmd.Args().Get(1), err)
+					}
+
+					client, err := openClient(ctx, cmd)
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					msg, err := client.GetMessage(cid, mid)
+					if err != nil {
+						return err
+					}
+					if len(msg.RawMeta) == 0 {
+						return fmt.Errorf("message %d has no raw response (was it generated with --debug?)", mid)
+					}
+					fmt.Println(string(msg.RawMeta))
+					return nil
+				},
+			},
+		},
+	}
+}
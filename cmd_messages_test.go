@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// runApp runs agnt with args (on a database under a temp home
+// directory), returning what it printed.
+func runApp(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	err = makeApp().Run(context.Background(), append([]string{"agnt"}, args...))
+	w.Close()
+	return <-out, err
+}
+
+func TestMessagesRaw(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	c, err := openClientWith(context.Background(), clientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci, err := c.CreateChat("raw", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "ua")
+	m, err := c.GetMessage(ci.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RawMeta = json.RawMessage(`{"model":"llama3","done_reason":"stop"}`)
+	if err := c.UpdateMessage(*m); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	out, err := runApp(t, "messages", "raw", "1", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"model":"llama3","done_reason":"stop"}`; strings.TrimSpace(out) != want {
+		t.Errorf("printed %q, want %q", out, want)
+	}
+
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"1", "1"}, "has no raw response"},
+		{[]string{"1", "9"}, "not found"},
+		{[]string{"1"}, "missing message id"},
+		{[]string{"1", "two"}, "invalid message id"},
+	}
+	for _, tt := range tests {
+		_, err := runApp(t, append([]string{"messages", "raw"}, tt.args...)...)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("messages raw %v: error = %v, want %q", tt.args, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+func serveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "serve the chats and graph over a local HTTP JSON API",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "addr",
+				Usage:   "address to listen on (binds to localhost by default, since there's no auth)",
+				Value:   "127.0.0.1:8080",
+				Sources: cli.EnvVars("AGNT_SERVE_ADDR"),
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			log, closeLog, err := newLogger(cmd.String("log-level"), cmd.String("log-file"))
+			if err != nil {
+				return err
+			}
+			defer closeLog()
+
+			client, err := openSessionClient(ctx, cmd)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			// Clean up after any generations that were interrupted
+			if n, err := client.ResetRunningChats(); err != nil {
+				return err
+			} else if n > 0 {
+				log.Warn("reset chats left running", "count", n)
+			}
+
+			agent, err := newAgent(ctx, client, agentConfigFromCmd(cmd), log)
+			if err != nil {
+				return err
+			}
+
+			srv := &http.Server{
+				Addr:              cmd.String("addr"),
+				Handler:           newServer(client, agent),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			// Stop when the context is cancelled
+			go func() {
+				<-ctx.Done()
+				sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := srv.Shutdown(sctx); err != nil {
+					log.Error("failed to shut down server", "error", err)
+				}
+			}()
+
+			fmt.Printf("Listening on http://%s\n", srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("failed to serve: %w", err)
+			}
+			return nil
+		},
+	}
+}
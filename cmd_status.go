@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+)
+
+func statusCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "status",
+		Usage: "show how big the database is",
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			client, err := openClient(ctx, cmd)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			s, err := client.Stats()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Database: %s\n", s.Path)
+			fmt.Printf("Size:     %s\n", humanBytes(s.FileSize))
+			fmt.Printf("Buckets:  %d\n", s.Buckets)
+			fmt.Printf("Chats:    %d (%d messages)\n", s.Chats, s.Messages)
+			fmt.Printf("Nodes:    %d\n", s.Nodes)
+			fmt.Printf("Edges:    %d\n", s.Edges)
+			return nil
+		},
+	}
+}
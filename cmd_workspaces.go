@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/urfave/cli/v3"
+)
+
+func workspacesCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "workspaces",
+		Usage: "list the workspaces (separate databases) that have been created",
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return fmt.Errorf("failed to get home directory: %w", err)
+			}
+			ws, err := listWorkspaces(resolveDirs(runtime.GOOS, home, os.Getenv, os.Stat).Data)
+			if err != nil {
+				return err
+			}
+			current := cmd.String("workspace")
+			for _, w := range ws {
+				mark := " "
+				if w == current {
+					mark = "*"
+				}
+				fmt.Printf("%s %s\n", mark, w)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+// maybeCompact summarizes a chat's oldest messages once it has more
+// than the configured threshold, so they no longer take up room in
+// the model's context.
+func (a *agent) maybeCompact(ctx context.Context, cid int) error {
+	if a.cfg.CompactThreshold <= 0 {
+		return nil
+	}
+
+	ms, err := a.c.ListMessages(cid)
+	if err != nil {
+		return fmt.Errorf("failed to list messages: %w", err)
+	}
+	old := selectCompaction(ms, a.cfg.CompactThreshold, a.cfg.CompactKeep)
+	if len(old) == 0 {
+		return nil
+	}
+
+	// Ask the model for a summary
+	if err := a.limit.Wait(ctx); err != nil {
+		return err
+	}
+	rctx, cancel := a.requestContext(ctx)
+	defer cancel()
+	var summary string
+	if err := a.ol.Load().Chat(rctx, &ollama.ChatRequest{
+		Model: defaultModel,
+		Messages: []ollama.Message{
+			{
+				Role:    "system",
+				Content: "Summarize the following conversation between a user and an AI agent. Keep any facts, decisions, and IDs that may be needed later. Reply with only the summary.",
+			},
+			{Role: "user", Content: transcript(old)},
+		},
+		Stream: new(bool), // Always false
+	}, func(resp ollama.ChatResponse) error {
+		summary += resp.Message.Content
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to summarize messages: %w", err)
+	}
+
+	ids := make([]int, len(old))
+	for i, m := range old {
+		ids[i] = m.MessageID
+	}
+	if _, err := a.c.CompactMessages(cid, ids, strings.TrimSpace(summary)); err != nil {
+		return err
+	}
+	a.log.Info("compacted chat", "chat", cid, "messages", len(ids))
+	return nil
+}
+
+// selectCompaction picks the oldest messages to summarize when there
+// are more than threshold messages still in the history, leaving at
+// least the most recent keep messages alone (and always at least one,
+// so the message being replied to is never summarized away). The cut
+// is moved back so
+// the kept messages start at a user message, so a turn (the user's
+// message and the agent's tool calls and reply) is never split.
+func selectCompaction(ms []Message, threshold, keep int) []Message {
+	// Only consider messages that are still in the history
+	var active []Message
+	for _, m := range ms {
+		if !m.Compacted && m.MType != "summary" && m.MType != "error" {
+			active = append(active, m)
+		}
+	}
+	if len(active) <= threshold {
+		return nil
+	}
+
+	cut := len(active) - max(keep, 1)
+	for cut > 0 && cut < len(active) && active[cut].MType != "user" {
+		cut--
+	}
+	return active[:cut]
+}
+
+// transcript renders messages as plain text for summarizing.
+func transcript(ms []Message) string {
+	var sb strings.Builder
+	for _, m := range ms {
+		if !m.Valid() {
+			continue
+		}
+		switch m.MType {
+		case "user":
+			fmt.Fprintf(&sb, "User: %s\n", m.UserMsg.Text)
+		case "agent":
+			fmt.Fprintf(&sb, "Agent: %s\n", m.AgentMsg.Text)
+		case "tool":
+			args, _ := json.Marshal(m.ToolMsg.ToolArgs)
+			fmt.Fprintf(&sb, "Agent called %s(%s): %s%s\n", m.ToolMsg.ToolName, args, m.ToolMsg.ToolResult, m.ToolMsg.ToolError)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testMessages makes messages from a string of their types' first
+// letters ("u"ser, "a"gent, "t"ool, "s"ummary, "e"rror, or "c" for a
+// compacted user message), numbering them from 1.
+func testMessages(types string) []Message {
+	names := map[rune]string{'u': "user", 'a': "agent", 't': "tool", 's': "summary", 'e': "error", 'c': "user"}
+	var ms []Message
+	for i, r := range types {
+		ms = append(ms, Message{MessageID: i + 1, MType: names[r], Compacted: r == 'c'})
+	}
+	return ms
+}
+
+func TestSelectCompaction(t *testing.T) {
+	tests := []struct {
+		name      string
+		types     string
+		threshold int
+		keep      int
+		want      []int // IDs of the messages to compact
+	}{
+		{"under the threshold", "uauaua", 6, 2, nil},
+		{"keeps whole turns", "uauaua", 4, 2, []int{1, 2, 3, 4}},
+		{"moves the cut back to a user message", "uauttaua", 4, 3, []int{1, 2}},
+		{"keeps the tool calls with their turn", "uttauttaua", 4, 5, []int{1, 2, 3, 4}},
+		{"skips old summaries, errors, and compacted messages", "ccsuaeuaua", 4, 2, []int{4, 5, 7, 8}},
+		{"doesn't count them towards the threshold", "cccccsuaua", 4, 2, nil},
+		{"always keeps the newest message", "uaua", 2, 0, []int{1, 2}},
+		{"even if it's a user message", "uau", 2, 0, []int{1, 2}},
+		{"one long turn", "uttttta", 4, 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, m := range selectCompaction(testMessages(tt.types), tt.threshold, tt.keep) {
+				got = append(got, m.MessageID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectCompaction(%s, %d, %d) = %v, want %v", tt.types, tt.threshold, tt.keep, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	var ms []Message
+	if err := json.Unmarshal([]byte(`[
+		{"MType": "user", "UserMsg": {"Text": "hi"}},
+		{"MType": "agent", "AgentMsg": {"Text": "hello"}}
+	]`), &ms); err != nil {
+		t.Fatal(err)
+	}
+	got := transcript(ms)
+	for _, want := range []string{"User: hi", "Agent: hello"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("transcript is %q, missing %q", got, want)
+		}
+	}
+}
+
+// messageTypes returns the first letters of messages' types
+// (like testMessages takes), with compacted ones as "c".
+func messageTypes(ms []Message) string {
+	var sb strings.Builder
+	for _, m := range ms {
+		if m.Compacted {
+			sb.WriteByte('c')
+			continue
+		}
+		sb.WriteByte(m.MType[0])
+	}
+	return sb.String()
+}
+
+func TestCompactMessagesTakesTheirPlace(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("compact", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "uauaua")
+	sm, err := c.CompactMessages(ci.ID, []int{1, 2, 3, 4}, "they said hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The summary comes where the messages it replaced were
+	ms, err := c.ListMessages(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := messageTypes(ms), "ccccsua"; got != want {
+		t.Errorf("messages = %s, want %s", got, want)
+	}
+	if ms[4].MessageID != sm.MessageID {
+		t.Errorf("message 4 is %d, want the summary (%d)", ms[4].MessageID, sm.MessageID)
+	}
+
+	// Forks (and merges) copy it there too
+	fork, err := c.ForkChat(ci.ID, 5, "fork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fms, err := c.ListMessages(fork.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := messageTypes(fms), "ccccsu"; got != want {
+		t.Errorf("forked messages = %s, want %s", got, want)
+	}
+	if got := fms[4].SummaryMsg.After; got != fms[3].MessageID {
+		t.Errorf("forked summary follows message %d, want %d", got, fms[3].MessageID)
+	}
+
+}
+
+func TestCompactedChatRollsBack(t *testing.T) {
+	// A tool call left unfinished when the chat was compacted
+	// is still cleaned up, even though the summary comes after it
+	c := newTestClient(t)
+	ci, err := c.CreateChat("compact", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "uaua")
+	var call Message
+	if err := json.Unmarshal([]byte(`{"MType": "tool", "ToolMsg": {"ToolName": "list_nodes", "ToolDone": true}}`), &call); err != nil {
+		t.Fatal(err)
+	}
+	call.ChatID = ci.ID
+	if _, err := c.CreateMessage(call); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CompactMessages(ci.ID, []int{1, 2}, "they said hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := c.DeleteUnfinishedToolCalls(ci.ID); err != nil || n != 1 {
+		t.Errorf("DeleteUnfinishedToolCalls() = %d, %v; want 1, nil", n, err)
+	}
+	if got := messageIDsOf(t, c, ci.ID); !slices.Equal(got, []int{1, 2, 6, 3, 4}) {
+		t.Errorf("messages = %v, want [1 2 6 3 4]", got)
+	}
+}
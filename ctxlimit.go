@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+// contextWindows are the context window sizes (in tokens) of the
+// models we know about, by name without the tag. Models that aren't
+// listed aren't checked.
+var contextWindows = map[string]int{
+	"qwen3":        40960,
+	"qwen2.5":      32768,
+	"llama3":       8192,
+	"llama3.1":     131072,
+	"llama3.2":     131072,
+	"llama3.3":     131072,
+	"mistral":      32768,
+	"mistral-nemo": 131072,
+	"gemma2":       8192,
+	"gemma3":       131072,
+	"phi4":         16384,
+	"deepseek-r1":  131072,
+}
+
+// contextWindow returns the context window of a model (e.g.
+// "qwen3:8b"), or 0 if it isn't known.
+func contextWindow(model string) int {
+	name, _, _ := strings.Cut(model, ":")
+	return contextWindows[name]
+}
+
+// errContextLimit is wrapped by the error returned when a chat is
+// too long to send to the model (and isn't being truncated to fit).
+var errContextLimit = errors.New("is too long for the model's context window")
+
+// charsPerToken is roughly how many characters of English (or JSON)
+// make up a token, for estimating without the model's tokenizer.
+const charsPerToken = 4
+
+// messageOverhead is roughly how many tokens each message's
+// role and formatting add on top of its content.
+const messageOverhead = 4
+
+// estimateTokens roughly estimates how many tokens a request's
+// messages and tools take up.
+func estimateTokens(msgs []ollama.Message, tools []ollama.Tool) int {
+	n := 0
+	for _, m := range msgs {
+		n += messageOverhead + len(m.Content)/charsPerToken
+		for _, tc := range m.ToolCalls {
+			args, _ := json.Marshal(tc.Function.Arguments)
+			n += (len(tc.Function.Name) + len(args)) / charsPerToken
+		}
+	}
+	if len(tools) > 0 {
+		data, _ := json.Marshal(tools)
+		n += len(data) / charsPerToken
+	}
+	return n
+}
+
+// fitContext checks that a request to model fits in its context
+// window. If it doesn't, the oldest messages are dropped until it
+// does when truncating, and an error is returned otherwise.
+//
+// The system messages at the start (the system prompt, graph context,
+// and summaries) are always kept, as are pinned messages (pinned[i]
+// is whether msgs[i] is) and the newest message. A tool call is
+// dropped along with its result, or kept with it if either is pinned.
+func fitContext(model string, msgs []ollama.Message, pinned []bool, tools []ollama.Tool, truncate bool) ([]ollama.Message, int, error) {
+	limit := contextWindow(model)
+	if limit == 0 {
+		return msgs, 0, nil
+	}
+	n := estimateTokens(msgs, tools)
+	if n <= limit {
+		return msgs, 0, nil
+	}
+	if !truncate {
+		return nil, 0, fmt.Errorf("chat (about %d tokens) %w (%d tokens for %s); compact it, start a new chat, or use --auto-truncate", n, errContextLimit, limit, model)
+	}
+
+	// Find where the conversation starts, after the system messages
+	start := 0
+	for start < len(msgs) && msgs[start].Role == "system" {
+		start++
+	}
+
+	// Keep the newest message (and, if it's a tool's result, its call)
+	keep := len(msgs) - 1
+	if keep > start && msgs[keep].Role == "tool" {
+		keep--
+	}
+
+	// Drop messages from there until it fits, along with any
+	// tool results that follow them (so no result loses its call)
+	drop := make([]bool, len(msgs))
+	dropped := 0
+	for i := start; n > limit && i < keep; {
+		j := i + 1
+		for j < keep && msgs[j].Role == "tool" {
+			j++
+		}
+		if !slices.Contains(pinned[i:j], true) {
+			for k := i; k < j; k++ {
+				drop[k] = true
+			}
+			n -= estimateTokens(msgs[i:j], nil)
+			dropped += j - i
+		}
+		i = j
+	}
+	if n > limit {
+		return nil, 0, fmt.Errorf("chat (about %d tokens, even truncated) %w (%d tokens for %s)", n, errContextLimit, limit, model)
+	}
+
+	out := make([]ollama.Message, 0, len(msgs)-dropped)
+	for i, m := range msgs {
+		if !drop[i] {
+			out = append(out, m)
+		}
+	}
+	return out, dropped, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+// bigMsg is a message of about n tokens.
+func bigMsg(role string, n int) ollama.Message {
+	return ollama.Message{Role: role, Content: strings.Repeat("x", (n-messageOverhead)*charsPerToken)}
+}
+
+func TestFitContext(t *testing.T) {
+	// llama3 has an 8192 token window, so 9 of these don't fit but 8 do
+	const model = "llama3"
+	turns := func(n int) []ollama.Message {
+		msgs := []ollama.Message{{Role: "system", Content: "be nice"}}
+		for i := range n {
+			role := "user"
+			if i%2 == 1 {
+				role = "assistant"
+			}
+			msgs = append(msgs, bigMsg(role, 1000))
+		}
+		return msgs
+	}
+
+	tests := []struct {
+		name     string
+		model    string
+		msgs     []ollama.Message
+		pinned   map[int]bool
+		truncate bool
+		wantKept []int // Indexes of msgs that are kept (nil for all)
+		wantErr  bool
+	}{
+		{name: "unknown model", model: "mystery", msgs: turns(20)},
+		{name: "fits", model: model, msgs: turns(8)},
+		{name: "too long", model: model, msgs: turns(9), wantErr: true},
+		{
+			name: "drops the oldest", model: model, msgs: turns(10), truncate: true,
+			wantKept: []int{0, 3, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name: "keeps pinned messages", model: model, msgs: turns(10), truncate: true,
+			pinned:   map[int]bool{1: true},
+			wantKept: []int{0, 1, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name: "drops a tool call with its result", model: model, truncate: true,
+			msgs: []ollama.Message{
+				{Role: "system", Content: "be nice"},
+				bigMsg("assistant", 1000), bigMsg("tool", 1000),
+				bigMsg("user", 3000), bigMsg("assistant", 3000), bigMsg("user", 1500),
+			},
+			wantKept: []int{0, 3, 4, 5},
+		},
+		{
+			name: "keeps a tool call with its pinned result", model: model, truncate: true,
+			msgs: []ollama.Message{
+				{Role: "system", Content: "be nice"},
+				bigMsg("assistant", 1000), bigMsg("tool", 1000),
+				bigMsg("user", 3000), bigMsg("assistant", 3000), bigMsg("user", 1500),
+			},
+			pinned:   map[int]bool{2: true},
+			wantKept: []int{0, 1, 2, 4, 5},
+		},
+		{
+			name: "keeps the newest tool result's call", model: model, truncate: true,
+			msgs: []ollama.Message{
+				{Role: "system", Content: "be nice"},
+				bigMsg("assistant", 5000), bigMsg("tool", 4000),
+			},
+			wantErr: true,
+		},
+		{
+			name: "too long even truncated", model: model, truncate: true,
+			msgs:    []ollama.Message{{Role: "system", Content: "be nice"}, bigMsg("user", 9000)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinned := make([]bool, len(tt.msgs))
+			for i := range tt.pinned {
+				pinned[i] = true
+			}
+			got, dropped, err := fitContext(tt.model, tt.msgs, pinned, nil, tt.truncate)
+			if tt.wantErr {
+				if !errors.Is(err, errContextLimit) {
+					t.Fatalf("err = %v, want %v", err, errContextLimit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := tt.msgs
+			if tt.wantKept != nil {
+				want = nil
+				for _, i := range tt.wantKept {
+					want = append(want, tt.msgs[i])
+				}
+			}
+			if len(got) != len(want) || dropped != len(tt.msgs)-len(want) {
+				t.Fatalf("kept %d messages (dropped %d), want %d", len(got), dropped, len(want))
+			}
+			for i := range got {
+				if got[i].Role != want[i].Role || len(got[i].Content) != len(want[i].Content) {
+					t.Errorf("message %d is %s (%d chars), want %s (%d chars)", i, got[i].Role, len(got[i].Content), want[i].Role, len(want[i].Content))
+				}
+			}
+		})
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"qwen3", 40960},
+		{"qwen3:8b", 40960},
+		{"llama3.1:70b", 131072},
+		{"mystery:latest", 0},
+	}
+	for _, tt := range tests {
+		if got := contextWindow(tt.model); got != tt.want {
+			t.Errorf("contextWindow(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// appDirs are the directories agnt keeps its files in.
+type appDirs struct {
+	Config string // User configuration
+	Data   string // The database and other state
+}
+
+// resolveDirs works out where agnt's files live. On Linux it follows
+// the XDG base directory spec ($XDG_CONFIG_HOME and $XDG_DATA_HOME,
+// falling back to ~/.config/agnt and ~/.local/share/agnt), unless a
+// legacy ~/.agnt directory already exists, in which case that is used
+// for everything. Other platforms always use ~/.agnt. stat is os.Stat
+// (or a fake, for tests).
+func resolveDirs(goos, home string, getenv func(string) string, stat func(string) (os.FileInfo, error)) appDirs {
+	legacy := filepath.Join(home, confDir)
+	if goos != "linux" {
+		return appDirs{Config: legacy, Data: legacy}
+	}
+	if fi, err := stat(legacy); err == nil && fi.IsDir() {
+		return appDirs{Config: legacy, Data: legacy}
+	}
+
+	// Relative paths are invalid per the spec, so ignore them
+	xdg := func(key string, fallback ...string) string {
+		if d := getenv(key); d != "" && filepath.IsAbs(d) {
+			return filepath.Join(d, "agnt")
+		}
+		return filepath.Join(append([]string{home}, fallback...)...)
+	}
+	return appDirs{
+		Config: xdg("XDG_CONFIG_HOME", ".config", "agnt"),
+		Data:   xdg("XDG_DATA_HOME", ".local", "share", "agnt"),
+	}
+}
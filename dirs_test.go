@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveDirs(t *testing.T) {
+	// Real info for a directory and a file, for the fake stat to return
+	dirInfo, err := os.Stat(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(f, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := os.Stat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const home = "/home/me"
+	legacy := filepath.Join(home, confDir)
+	tests := []struct {
+		name   string
+		goos   string
+		env    map[string]string
+		legacy os.FileInfo // What's at ~/.agnt (nil for nothing)
+		want   appDirs
+	}{
+		{
+			name: "linux defaults", goos: "linux",
+			want: appDirs{Config: "/home/me/.config/agnt", Data: "/home/me/.local/share/agnt"},
+		},
+		{
+			name: "xdg env", goos: "linux",
+			env:  map[string]string{"XDG_CONFIG_HOME": "/cfg", "XDG_DATA_HOME": "/data"},
+			want: appDirs{Config: "/cfg/agnt", Data: "/data/agnt"},
+		},
+		{
+			name: "just one xdg var", goos: "linux",
+			env:  map[string]string{"XDG_DATA_HOME": "/data"},
+			want: appDirs{Config: "/home/me/.config/agnt", Data: "/data/agnt"},
+		},
+		{
+			name: "relative xdg values are ignored", goos: "linux",
+			env:  map[string]string{"XDG_CONFIG_HOME": "cfg", "XDG_DATA_HOME": "./data"},
+			want: appDirs{Config: "/home/me/.config/agnt", Data: "/home/me/.local/share/agnt"},
+		},
+		{
+			name: "legacy dir wins", goos: "linux", legacy: dirInfo,
+			env:  map[string]string{"XDG_CONFIG_HOME": "/cfg", "XDG_DATA_HOME": "/data"},
+			want: appDirs{Config: legacy, Data: legacy},
+		},
+		{
+			name: "legacy file doesn't count", goos: "linux", legacy: fileInfo,
+			want: appDirs{Config: "/home/me/.config/agnt", Data: "/home/me/.local/share/agnt"},
+		},
+		{
+			name: "macos", goos: "darwin",
+			env:  map[string]string{"XDG_CONFIG_HOME": "/cfg", "XDG_DATA_HOME": "/data"},
+			want: appDirs{Config: legacy, Data: legacy},
+		},
+		{
+			name: "windows", goos: "windows",
+			want: appDirs{Config: legacy, Data: legacy},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stat := func(p string) (os.FileInfo, error) {
+				if p == legacy && tt.legacy != nil {
+					return tt.legacy, nil
+				}
+				return nil, os.ErrNotExist
+			}
+			getenv := func(k string) string { return tt.env[k] }
+			if got := resolveDirs(tt.goos, home, getenv, stat); got != tt.want {
+				t.Errorf("resolveDirs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// check is the outcome of one of agnt doctor's diagnostics.
+type check struct {
+	Name   string
+	OK     bool
+	Detail string // What was found (or what's wrong)
+}
+
+// openForChecks opens the database at p read-only for agnt doctor, so
+// checking it can't change it (newClient would repair it first, and
+// might back it up). The client it returns can only read.
+func openForChecks(p string, timeout time.Duration) (*client, error) {
+	db, err := bolt.Open(p, 0600, &bolt.Options{ReadOnly: true, Timeout: timeout})
+	if errors.Is(err, berrors.ErrTimeout) {
+		return nil, fmt.Errorf("database is locked by another agnt process")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return &client{dbp: p, db: db, subs: &subscribers{}}, nil
+}
+
+// checkBuckets checks that the database has all its required
+// buckets, and a message bucket for each chat.
+func checkBuckets(c *client) check {
+	ch := check{Name: "required buckets"}
+	var missing []string
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		names, err := missingBuckets(tx)
+		for _, name := range names {
+			missing = append(missing, bucketLabel(name))
+		}
+		return err
+	}); err != nil {
+		ch.Detail = err.Error()
+		return ch
+	}
+	if len(missing) > 0 {
+		ch.Detail = "missing " + strings.Join(missing, ", ")
+		return ch
+	}
+	ch.OK = true
+	ch.Detail = strings.Join(requiredBuckets, ", ") + ", and chats' messages"
+	return ch
+}
+
+// checkGraph checks that no edges point at nodes that don't exist.
+func checkGraph(c *client) check {
+	ch := check{Name: "graph integrity"}
+	edges, err := c.CheckIntegrity()
+	switch {
+	case err != nil:
+		ch.Detail = err.Error()
+	case len(edges) > 0:
+		ch.Detail = fmt.Sprintf("%d dangling edges (see agnt graph check)", len(edges))
+	default:
+		ch.OK = true
+		ch.Detail = "no dangling edges"
+	}
+	return ch
+}
+
+// checkModel checks that the ollama server at baseURL (or the one
+// from the environment, if empty) is reachable and has the model.
+func checkModel(ctx context.Context, baseURL, model string) check {
+	ch := check{Name: "model " + model}
+	ol, err := newOllamaClient(baseURL)
+	if err != nil {
+		ch.Detail = err.Error()
+		return ch
+	}
+	res, err := ol.List(ctx)
+	if err != nil {
+		ch.Detail = fmt.Sprintf("can't reach ollama: %v", err)
+		return ch
+	}
+
+	// Untagged names mean the latest version
+	want := model
+	if !strings.Contains(want, ":") {
+		want += ":latest"
+	}
+	for _, m := range res.Models {
+		if m.Name == want || m.Model == want {
+			ch.OK = true
+			ch.Detail = "available"
+			return ch
+		}
+	}
+	ch.Detail = fmt.Sprintf("not pulled (run ollama pull %s)", model)
+	return ch
+}
+
+// ollamaURL describes which ollama server the agent connects to.
+func ollamaURL(baseURL string) string {
+	if baseURL != "" {
+		return baseURL
+	}
+	if h := os.Getenv("OLLAMA_HOST"); h != "" {
+		return h + " (from $OLLAMA_HOST)"
+	}
+	return "http://127.0.0.1:11434 (default)"
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ollama "github.com/ollama/ollama/api"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDatabaseChecks(t *testing.T) {
+	tests := []struct {
+		name        string
+		breakIt     func(tx *bolt.Tx, c *client) error
+		wantBuckets bool
+		wantGraph   bool
+	}{
+		{"healthy", nil, true, true},
+		{
+			name: "missing edge bucket",
+			breakIt: func(tx *bolt.Tx, c *client) error {
+				return tx.DeleteBucket([]byte(edgeBucket))
+			},
+			wantBuckets: false,
+			wantGraph:   false,
+		},
+		{
+			name: "missing a chat's messages",
+			breakIt: func(tx *bolt.Tx, c *client) error {
+				return tx.DeleteBucket(ChatInfo{ID: 1}.MessageBucketName())
+			},
+			wantBuckets: false,
+			wantGraph:   true,
+		},
+		{
+			name: "dangling edge",
+			breakIt: func(tx *bolt.Tx, c *client) error {
+				return tx.Bucket(c.graphBucket(nodeBucket)).Delete(itob(2))
+			},
+			wantBuckets: true,
+			wantGraph:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := t.TempDir()
+			c, err := newClient(context.Background(), d, clientConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.CreateNode("person", map[string]any{"name": "Alice"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.CreateNode("person", map[string]any{"name": "Bob"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.CreateEdge("knows", 1, 2); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.CreateChat("chat", ""); err != nil {
+				t.Fatal(err)
+			}
+			if tt.breakIt != nil {
+				if err := c.db.Update(func(tx *bolt.Tx) error { return tt.breakIt(tx, c) }); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c.Close()
+
+			// Check it twice: checking mustn't have fixed anything
+			for range 2 {
+				rc, err := openForChecks(filepath.Join(d, dbFile), 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ch := checkBuckets(rc); ch.OK != tt.wantBuckets {
+					t.Errorf("checkBuckets() = %+v, want OK: %v", ch, tt.wantBuckets)
+				}
+				if ch := checkGraph(rc); ch.OK != tt.wantGraph {
+					t.Errorf("checkGraph() = %+v, want OK: %v", ch, tt.wantGraph)
+				}
+				rc.Close()
+			}
+		})
+	}
+}
+
+func TestCheckModel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ollama.ListResponse{Models: []ollama.ListModelResponse{
+			{Name: "qwen3:latest", Model: "qwen3:latest"},
+			{Name: "nomic-embed-text:v1.5", Model: "nomic-embed-text:v1.5"},
+		}})
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		url, model string
+		want       bool
+		detail     string
+	}{
+		{srv.URL, "qwen3", true, "available"},
+		{srv.URL, "qwen3:latest", true, "available"},
+		{srv.URL, "nomic-embed-text:v1.5", true, "available"},
+		{srv.URL, "nomic-embed-text", false, "not pulled"},
+		{srv.URL, "llama3", false, "not pulled"},
+		{"http://127.0.0.1:1", "qwen3", false, "can't reach ollama"},
+	}
+	for _, tt := range tests {
+		ch := checkModel(context.Background(), tt.url, tt.model)
+		if ch.OK != tt.want || !strings.Contains(ch.Detail, tt.detail) {
+			t.Errorf("checkModel(%s) = %+v, want OK: %v (%s)", tt.model, ch, tt.want, tt.detail)
+		}
+	}
+}
+
+func TestDoctorDoesntChangeDatabase(t *testing.T) {
+	home := t.TempDir()
+	data := filepath.Join(home, "data")
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", data)
+	t.Setenv("AGNT_BACKUP_KEEP", "3")
+	d := filepath.Join(data, "agnt")
+
+	// A half set up database, missing its edge bucket
+	c, err := newClient(context.Background(), d, clientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(edgeBucket))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	if err := makeApp().Run(context.Background(), []string{"agnt", "doctor", "--no-ping"}); err == nil {
+		t.Error("doctor passed a database missing a bucket")
+	}
+	rc, err := openForChecks(filepath.Join(d, dbFile), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if ch := checkBuckets(rc); ch.OK {
+		t.Error("doctor repaired the database")
+	}
+	if got := backups(t, filepath.Join(d, backupDir)); len(got) != 0 {
+		t.Errorf("doctor made backups %v", got)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	ollama "github.com/ollama/ollama/api"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	embeddingBucket = "graph:embeddings"
+	embeddingDimKey = "embedding_dim"
+)
+
+// SetNodeEmbedding stores the embedding vector for a node, replacing
+// any existing one. Every embedding in the database must have the same
+// number of dimensions; the first one stored sets it.
+func (c *client) SetNodeEmbedding(id int, vec []float32) error {
+	if len(vec) == 0 {
+		return fmt.Errorf("embedding is empty")
+	}
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		// Make sure the node exists
+		nb := tx.Bucket(c.graphBucket(nodeBucket))
+		if nb == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+		if nb.Get(itob(id)) == nil {
+			return fmt.Errorf("node with ID %d %w", id, errNotFound)
+		}
+
+		// Check the dimensions match the rest of the database
+		mb := tx.Bucket([]byte(metaBucket))
+		if mb == nil {
+			return fmt.Errorf("meta bucket not found")
+		}
+		if d := mb.Get([]byte(embeddingDimKey)); d != nil {
+			if dim := int(binary.BigEndian.Uint64(d)); dim != len(vec) {
+				return fmt.Errorf("embedding has %d dimensions, expected %d", len(vec), dim)
+			}
+		} else if err := mb.Put([]byte(embeddingDimKey), itob(len(vec))); err != nil {
+			return fmt.Errorf("failed to set embedding dimensions: %w", err)
+		}
+
+		// Store the vector
+		eb, err := tx.CreateBucketIfNotExists(c.graphBucket(embeddingBucket))
+		if err != nil {
+			return fmt.Errorf("failed to get/create embedding bucket: %w", err)
+		}
+		if err := eb.Put(itob(id), encodeVector(vec)); err != nil {
+			return fmt.Errorf("failed to put embedding into db: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to set node embedding: %w", err)
+	}
+	return nil
+}
+
+// EmbeddedNodes returns the IDs of the nodes that have embeddings.
+func (c *client) EmbeddedNodes() (map[int]bool, error) {
+	ids := map[int]bool{}
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		eb := tx.Bucket(c.graphBucket(embeddingBucket))
+		if eb == nil {
+			return nil
+		}
+		return eb.ForEach(func(k, _ []byte) error {
+			ids[int(binary.BigEndian.Uint64(k))] = true
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list embedded nodes: %w", err)
+	}
+	return ids, nil
+}
+
+// HasEmbeddings reports whether any of the graph's nodes have
+// embeddings, and so whether there's anything to search.
+func (c *client) HasEmbeddings() (bool, error) {
+	var ok bool
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		if eb := tx.Bucket(c.graphBucket(embeddingBucket)); eb != nil {
+			k, _ := eb.Cursor().First()
+			ok = k != nil
+		}
+		return nil
+	}); err != nil {
+		return false, fmt.Errorf("failed to check for embeddings: %w", err)
+	}
+	return ok, nil
+}
+
+// ScoredNode is a node along with how similar it is to a search vector.
+type ScoredNode struct {
+	Node  GraphNode
+	Score float32 // Cosine similarity (-1 to 1)
+}
+
+// SearchNodesByVector returns up to k (which must be positive) nodes
+// whose embeddings are most similar to vec, most similar first. Nodes without embeddings are
+// skipped. This scans every embedding, which is fine at the sizes
+// graphs get to here.
+func (c *client) SearchNodesByVector(vec []float32, k int) ([]ScoredNode, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	var scored []ScoredNode
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		eb := tx.Bucket(c.graphBucket(embeddingBucket))
+		if eb == nil {
+			return nil // Nothing has been embedded yet
+		}
+		nb := tx.Bucket(c.graphBucket(nodeBucket))
+		if nb == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+
+		return eb.ForEach(func(k, v []byte) error {
+			emb := decodeVector(v)
+			if len(emb) != len(vec) {
+				return fmt.Errorf("search vector has %d dimensions, expected %d", len(vec), len(emb))
+			}
+
+			// Skip embeddings left behind by deleted nodes
+			data := nb.Get(k)
+			if data == nil {
+				return nil
+			}
+			var node GraphNode
+			if err := json.Unmarshal(data, &node); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			scored = append(scored, ScoredNode{Node: node, Score: cosine(vec, emb)})
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("failed to search nodes: %w", err)
+	}
+
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].Score != scored[j].Score {
+			return scored[i].Score > scored[j].Score
+		}
+		return scored[i].Node.ID < scored[j].Node.ID
+	})
+	if len(scored) > k {
+		scored = scored[:k]
+	}
+	return scored, nil
+}
+
+// cosine returns the cosine similarity of two vectors of the same
+// length (or 0 if either is all zeros).
+func cosine(a, b []float32) float32 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(na) * math.Sqrt(nb)))
+}
+
+// embed asks the embedding model for a vector representing text.
+func (a *agent) embed(ctx context.Context, text string) ([]float32, error) {
+	if !a.online() {
+		return nil, errOffline
+	}
+	ctx, cancel := a.requestContext(ctx)
+	defer cancel()
+	resp, err := a.ol.Load().Embed(ctx, &ollama.EmbedRequest{
+		Model: a.cfg.EmbedModel,
+		Input: text,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed text: %w", err)
+	}
+	if len(resp.Embeddings) == 0 {
+		return nil, fmt.Errorf("no embedding returned")
+	}
+	return resp.Embeddings[0], nil
+}
+
+// nodeText is the text embedded for a node: its type and properties.
+func nodeText(n GraphNode) string {
+	props, _ := json.Marshal(n.Props)
+	return n.Type + " " + string(props)
+}
+
+// embedNode embeds a node's text and stores it in the graph g, so
+// it can be found by similarity.
+func (a *agent) embedNode(ctx context.Context, g *client, n GraphNode) error {
+	vec, err := a.embed(ctx, nodeText(n))
+	if err != nil {
+		return err
+	}
+	return g.SetNodeEmbedding(n.ID, vec)
+}
+
+// embedWritten embeds a node the model just created or updated. It's
+// best effort: the write already happened, so failing to embed (e.g.
+// because the embedding model isn't pulled) is only logged, and
+// agnt graph embed can fill it in later.
+func (a *agent) embedWritten(ctx context.Context, n *GraphNode) {
+	if err := a.embedNode(ctx, a.graph(ctx), *n); err != nil && !errors.Is(err, errOffline) {
+		a.log.Warn("failed to embed node", "node", n.ID, "err", err)
+	}
+}
+
+// EmbedNodes embeds the shared graph's nodes that don't have an
+// embedding yet (or all of them, to refresh ones that are out of
+// date), returning how many it embedded.
+func (a *agent) EmbedNodes(ctx context.Context, all bool) (int, error) {
+	nodes, err := a.c.ListNodes("")
+	if err != nil {
+		return 0, err
+	}
+	done, err := a.c.EmbeddedNodes()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, node := range nodes {
+		if done[node.ID] && !all {
+			continue
+		}
+		if err := a.embedNode(ctx, a.c, node); err != nil {
+			return n, fmt.Errorf("failed to embed node %d: %w", node.ID, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// getEmbedding returns the embedding stored for a node within
+// the transaction, or nil if it doesn't have one.
+func (c *client) getEmbedding(tx *bolt.Tx, id int) []float32 {
+	eb := tx.Bucket(c.graphBucket(embeddingBucket))
+	if eb == nil {
+		return nil
+	}
+	data := eb.Get(itob(id))
+	if data == nil {
+		return nil
+	}
+	return decodeVector(data)
+}
+
+// deleteEmbeddings removes the embeddings for the given
+// nodes (if they have any) within the transaction.
+func (c *client) deleteEmbeddings(tx *bolt.Tx, ids map[int]bool) error {
+	eb := tx.Bucket(c.graphBucket(embeddingBucket))
+	if eb == nil {
+		return nil
+	}
+	for id := range ids {
+		if err := eb.Delete(itob(id)); err != nil {
+			return fmt.Errorf("failed to delete embedding from db: %w", err)
+		}
+	}
+	return nil
+}
+
+// encodeVector packs a vector as little-endian float32s.
+func encodeVector(vec []float32) []byte {
+	b := make([]byte, 4*len(vec))
+	for i, f := range vec {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+// decodeVector unpacks a vector written by encodeVector.
+func decodeVector(b []byte) []float32 {
+	vec := make([]float32, len(b)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return vec
+}
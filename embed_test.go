@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	ollama "github.com/ollama/ollama/api"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCreatedNodesAreFoundBySimilarity(t *testing.T) {
+	url := fakeOllama(t, nil)
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{EmbedModel: "embed"})
+	ctx := context.Background()
+
+	// Create some nodes (and update one) the way the model would
+	for _, args := range []map[string]any{
+		{"type": "person", "props": map[string]any{"name": "Alice", "likes": "climbing"}},
+		{"type": "city", "props": map[string]any{"name": "Paris"}},
+		{"type": "person", "props": map[string]any{"name": "Bob"}},
+	} {
+		if _, err := a.tools["create_node"].Handler(ctx, args); err != nil {
+			t.Fatalf("failed to create node: %v", err)
+		}
+	}
+	if _, err := a.tools["update_node"].Handler(ctx, map[string]any{
+		"id":    3,
+		"props": map[string]any{"likes": "chess"},
+	}); err != nil {
+		t.Fatalf("failed to update node: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"climbing", 1},
+		{"paris", 2},
+		{"chess", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			res, err := a.tools["search_nodes"].Handler(ctx, map[string]any{"query": tt.query, "k": 1})
+			if err != nil {
+				t.Fatalf("failed to search: %v", err)
+			}
+			ns := res.([]ScoredNode)
+			if len(ns) != 1 || ns[0].Node.ID != tt.want {
+				t.Errorf("search found %+v, want node %d", ns, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbedNodes(t *testing.T) {
+	url := fakeOllama(t, nil)
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{EmbedModel: "embed"})
+	ctx := context.Background()
+
+	// Nodes created outside the tools (e.g. imported) have no embeddings
+	for _, name := range []string{"a", "b"} {
+		if _, err := c.CreateNode("thing", map[string]any{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := a.EmbedNodes(ctx, false); err != nil || n != 2 {
+		t.Fatalf("EmbedNodes() = %d, %v; want 2, nil", n, err)
+	}
+	if n, err := a.EmbedNodes(ctx, false); err != nil || n != 0 {
+		t.Fatalf("EmbedNodes() again = %d, %v; want 0, nil", n, err)
+	}
+	if n, err := a.EmbedNodes(ctx, true); err != nil || n != 2 {
+		t.Fatalf("EmbedNodes(all) = %d, %v; want 2, nil", n, err)
+	}
+}
+
+func TestSetNodeEmbeddingMissingNode(t *testing.T) {
+	c := newTestClient(t)
+	if err := c.SetNodeEmbedding(42, []float32{1}); !errors.Is(err, errNotFound) {
+		t.Errorf("SetNodeEmbedding() = %v, want %v", err, errNotFound)
+	}
+}
+
+// toolNames returns the names of the tools offered to the model.
+func toolNames(a *agent, ctx context.Context) []string {
+	var names []string
+	for _, t := range a.getTools(ctx) {
+		names = append(names, t.Function.Name)
+	}
+	return names
+}
+
+func TestSearchNodesNeedsEmbeddings(t *testing.T) {
+	url := fakeOllama(t, nil)
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{EmbedModel: "embed", RAGTopK: 3})
+	ctx := context.Background()
+	h := []ollama.Message{{Role: "user", Content: "tell me about alice"}}
+
+	// Nothing is embedded yet, so there's nothing to search
+	if slices.Contains(toolNames(a, ctx), "search_nodes") {
+		t.Error("search_nodes is offered before anything is embedded")
+	}
+	if rc, err := a.ragContext(ctx, h); err != nil || rc != nil {
+		t.Errorf("ragContext() = %v, %v; want nil, nil", rc, err)
+	}
+
+	if _, err := a.tools["create_node"].Handler(ctx, map[string]any{
+		"type":  "person",
+		"props": map[string]any{"name": "alice"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(toolNames(a, ctx), "search_nodes") {
+		t.Error("search_nodes isn't offered once nodes are embedded")
+	}
+	if rc, err := a.ragContext(ctx, h); err != nil || rc == nil || !strings.Contains(rc.Content, "alice") {
+		t.Errorf("ragContext() = %v, %v; want the node", rc, err)
+	}
+}
+
+func TestSearchNodesRejectsBadK(t *testing.T) {
+	url := fakeOllama(t, nil)
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{EmbedModel: "embed"})
+	ctx := context.Background()
+	if _, err := a.tools["create_node"].Handler(ctx, map[string]any{"type": "person"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		k       int
+		wantErr bool
+	}{
+		{-1, true},
+		{0, true},
+		{1, false},
+	}
+	for _, tt := range tests {
+		_, err := a.tools["search_nodes"].Handler(ctx, map[string]any{"query": "person", "k": tt.k})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("search_nodes with k=%d: err = %v, want error %v", tt.k, err, tt.wantErr)
+		}
+		if _, err := c.SearchNodesByVector([]float32{1}, tt.k); tt.wantErr && err == nil {
+			t.Errorf("SearchNodesByVector with k=%d didn't fail", tt.k)
+		}
+	}
+}
+
+// nodeEmbedding returns the embedding stored for a node (nil if none).
+func nodeEmbedding(t *testing.T, c *client, id int) []float32 {
+	t.Helper()
+	var vec []float32
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		vec = c.getEmbedding(tx, id)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return vec
+}
+
+func TestSetNodeEmbedding(t *testing.T) {
+	c := newTestClient(t)
+	for _, name := range []string{"a", "b"} {
+		if _, err := c.CreateNode("thing", map[string]any{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Embeddings are stored exactly, and replaced when set again
+	for _, vec := range [][]float32{{0.25, -1, 3.5}, {1, 2, 3}} {
+		if err := c.SetNodeEmbedding(1, vec); err != nil {
+			t.Fatal(err)
+		}
+		if got := nodeEmbedding(t, c, 1); !slices.Equal(got, vec) {
+			t.Errorf("embedding = %v, want %v", got, vec)
+		}
+	}
+	if got := nodeEmbedding(t, c, 2); got != nil {
+		t.Errorf("node 2 has embedding %v, want none", got)
+	}
+
+	// They all need the dimensions of the first one
+	for _, vec := range [][]float32{{1, 2}, {1, 2, 3, 4}, {}} {
+		if err := c.SetNodeEmbedding(2, vec); err == nil {
+			t.Errorf("SetNodeEmbedding(%v) succeeded, want a dimension error", vec)
+		}
+	}
+	if got := nodeEmbedding(t, c, 2); got != nil {
+		t.Errorf("node 2 has embedding %v after failed sets", got)
+	}
+	if err := c.SetNodeEmbedding(2, []float32{0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting the node deletes its embedding
+	if err := c.DeleteNode(1); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := c.EmbeddedNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(ids, map[int]bool{2: true}) {
+		t.Errorf("embedded nodes = %v, want just 2", ids)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// envRef matches a ${NAME} environment variable reference.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// envPrefix is the prefix of the environment variables props can
+// refer to. Anything else (like API keys) is off limits, since the
+// model could otherwise read it by writing a reference into a prop.
+const envPrefix = "AGNT_VAR_"
+
+// expandEnv replaces the ${NAME} references in s with the values of
+// the environment variables they name, if the names start with
+// envPrefix. Other references, and those to unset variables, are
+// left as they are, rather than silently becoming empty.
+func expandEnv(s string, lookup func(string) (string, bool)) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+		if !strings.HasPrefix(name, envPrefix) {
+			return ref
+		}
+		if v, ok := lookup(name); ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// expandResultEnv returns a copy of a tool result with the environment
+// variable references in its node and edge props (the values under any
+// "Props" key) expanded. Nothing else in the result is touched, and the
+// stored props aren't changed.
+func expandResultEnv(result any, lookup func(string) (string, bool)) (any, error) {
+	// Work on a generic copy of the result (keeping numbers exact)
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode result: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to decode result: %w", err)
+	}
+	return expandPropsIn(v, false, lookup), nil
+}
+
+// expandPropsIn walks a decoded JSON value, expanding the strings
+// found within props (once inProps is true).
+func expandPropsIn(v any, inProps bool, lookup func(string) (string, bool)) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			v[k] = expandPropsIn(x, inProps || k == "Props", lookup)
+		}
+		return v
+	case []any:
+		for i, x := range v {
+			v[i] = expandPropsIn(x, inProps, lookup)
+		}
+		return v
+	case string:
+		if inProps {
+			return expandEnv(v, lookup)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testEnv is a fake environment for expanding references.
+func testEnv(name string) (string, bool) {
+	v, ok := map[string]string{
+		"AGNT_VAR_HOST":  "example.com",
+		"AGNT_VAR_PORT":  "8080",
+		"OPENAI_API_KEY": "sk-secret",
+		"HOME":           "/home/me",
+	}[name]
+	return v, ok
+}
+
+func TestExpandEnv(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"no refs", "no refs"},
+		{"${AGNT_VAR_HOST}", "example.com"},
+		{"http://${AGNT_VAR_HOST}:${AGNT_VAR_PORT}/", "http://example.com:8080/"},
+		{"${AGNT_VAR_MISSING}", "${AGNT_VAR_MISSING}"},
+		{"${OPENAI_API_KEY}", "${OPENAI_API_KEY}"},
+		{"${HOME}/notes", "${HOME}/notes"},
+		{"$AGNT_VAR_HOST", "$AGNT_VAR_HOST"},
+		{"${AGNT_VAR_HOST", "${AGNT_VAR_HOST"},
+		{"${1AGNT_VAR_HOST}", "${1AGNT_VAR_HOST}"},
+	}
+	for _, tt := range tests {
+		if got := expandEnv(tt.in, testEnv); got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpandResultEnv(t *testing.T) {
+	tests := []struct {
+		name   string
+		result any
+		want   string // The expanded result, as JSON
+	}{
+		{
+			name:   "node props",
+			result: GraphNode{ID: 1, Type: "${AGNT_VAR_HOST}", Props: map[string]any{"url": "https://${AGNT_VAR_HOST}", "port": 80}},
+			want:   `{"ID":1,"Props":{"port":80,"url":"https://example.com"},"Type":"${AGNT_VAR_HOST}"}`,
+		},
+		{
+			name:   "nested and listed",
+			result: []map[string]any{{"Props": map[string]any{"hosts": []any{"${AGNT_VAR_HOST}", map[string]any{"key": "${OPENAI_API_KEY}"}}}}},
+			want:   `[{"Props":{"hosts":["example.com",{"key":"${OPENAI_API_KEY}"}]}}]`,
+		},
+		{
+			name:   "outside props",
+			result: map[string]any{"note": "${AGNT_VAR_HOST}"},
+			want:   `{"note":"${AGNT_VAR_HOST}"}`,
+		},
+		{
+			name:   "big numbers stay exact",
+			result: map[string]any{"Props": map[string]any{"id": json.Number("9007199254740993")}},
+			want:   `{"Props":{"id":9007199254740993}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandResultEnv(tt.result, testEnv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("expanded = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolsCantReadSecrets(t *testing.T) {
+	t.Setenv("AGNT_VAR_HOST", "example.com")
+	t.Setenv("OPENAI_API_KEY", "sk-secret")
+	a, cid := newToolTest(t, agentConfig{ExpandEnv: true})
+
+	// The model writes references into a node, then reads it back
+	m := callTool(t, a, cid, "create_node", map[string]any{
+		"type":  "note",
+		"props": map[string]any{"host": "${AGNT_VAR_HOST}", "key": "${OPENAI_API_KEY}"},
+	})
+	if m.ToolMsg.ToolError != "" {
+		t.Fatal(m.ToolMsg.ToolError)
+	}
+	m = callTool(t, a, cid, "get_node", map[string]any{"id": 1})
+	if m.ToolMsg.ToolError != "" {
+		t.Fatal(m.ToolMsg.ToolError)
+	}
+	if !strings.Contains(m.ToolMsg.ToolResult, `"host":"example.com"`) {
+		t.Errorf("result %s doesn't expand the allowed variable", m.ToolMsg.ToolResult)
+	}
+	if strings.Contains(m.ToolMsg.ToolResult, "sk-secret") {
+		t.Errorf("result %s leaks the API key", m.ToolMsg.ToolResult)
+	}
+
+	// The stored props are unchanged
+	n, err := a.c.GetNode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Props["host"] != "${AGNT_VAR_HOST}" {
+		t.Errorf("stored host = %v, want the reference", n.Props["host"])
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ftMessage is a message in OpenAI's chat fine-tuning format.
+type ftMessage struct {
+	Role       string       `json:"role"`
+	Content    *string      `json:"content"`
+	ToolCalls  []ftToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string       `json:"tool_call_id,omitempty"`
+	Weight     *int         `json:"weight,omitempty"` // 0 on replies from earlier turns, so they aren't trained on again
+}
+
+// ftToolCall is a tool call in OpenAI's chat fine-tuning format.
+type ftToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"` // JSON-encoded
+	} `json:"function"`
+}
+
+// ExportChatJSONL writes a chat in OpenAI's fine-tuning format: one
+// {"messages": [...]} line per turn (a user message and the agent's
+// tool calls and reply), skipping turns without a reply. Each line
+// has the conversation up to the end of its turn, with the replies
+// from earlier turns weighted 0.
+//
+// The chat's own system prompt (if it has one) starts each line. Error
+// messages, compacted messages, and tool calls that haven't finished
+// (like questions still waiting on the user) are left out; summaries
+// are kept as system messages, as they're sent to the model.
+func (c *client) ExportChatJSONL(chatID int, w io.Writer) error {
+	ci, err := c.GetChat(chatID)
+	if err != nil {
+		return err
+	}
+	ms, err := c.ListMessages(chatID)
+	if err != nil {
+		return err
+	}
+
+	var sums, hist []ftMessage
+	if ci.SystemPrompt != "" {
+		sums = append(sums, ftMessage{Role: "system", Content: &ci.SystemPrompt})
+	}
+	start := 0 // Where the current turn starts in hist
+	enc := json.NewEncoder(w)
+	flush := func() error {
+		// Only turns the agent replied in have anything to train on
+		replied := false
+		for _, m := range hist[start:] {
+			replied = replied || m.Role == "assistant"
+		}
+		if !replied {
+			return nil
+		}
+		line := struct {
+			Messages []ftMessage `json:"messages"`
+		}{Messages: append(append([]ftMessage{}, sums...), hist...)}
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("failed to write turn: %w", err)
+		}
+
+		// Don't train on this turn's replies again in later lines
+		zero := 0
+		for i := start; i < len(hist); i++ {
+			if hist[i].Role == "assistant" {
+				hist[i].Weight = &zero
+			}
+		}
+		start = len(hist)
+		return nil
+	}
+
+	for _, m := range ms {
+		if m.Compacted || !m.Valid() {
+			continue
+		}
+		switch m.MType {
+		case "user":
+			// A new turn starts, so finish the last one
+			if err := flush(); err != nil {
+				return err
+			}
+			hist = append(hist, ftMessage{Role: "user", Content: &m.UserMsg.Text})
+		case "agent":
+			hist = append(hist, ftMessage{Role: "assistant", Content: &m.AgentMsg.Text})
+		case "tool":
+			// Calls still waiting on the user have no result to train on
+			if !m.ToolMsg.ToolDone || waitsOnUser(m) {
+				continue
+			}
+			args, err := json.Marshal(m.ToolMsg.ToolArgs)
+			if err != nil {
+				return fmt.Errorf("failed to encode tool arguments: %w", err)
+			}
+			call := ftToolCall{ID: "call_" + strconv.Itoa(m.MessageID), Type: "function"}
+			call.Function.Name = m.ToolMsg.ToolName
+			call.Function.Arguments = string(args)
+			result := m.ToolMsg.ToolResult
+			if m.ToolMsg.ToolError != "" {
+				result = "Error: " + m.ToolMsg.ToolError
+			}
+			hist = append(hist,
+				ftMessage{Role: "assistant", ToolCalls: []ftToolCall{call}},
+				ftMessage{Role: "tool", ToolCallID: call.ID, Content: &result},
+			)
+		case "summary":
+			text := "Summary of the earlier conversation:\n" + m.SummaryMsg.Text
+			sums = append(sums, ftMessage{Role: "system", Content: &text})
+		}
+	}
+	return flush()
+}
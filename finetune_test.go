@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExportChatJSONL(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("finetune", "Be brief.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ms []Message
+	if err := json.Unmarshal([]byte(`[
+		{"MType": "user", "UserMsg": {"Text": "Who is Alice?"}},
+		{"MType": "tool", "ToolMsg": {"ToolName": "get_node", "ToolArgs": {"id": 1}, "ToolDone": true, "ToolResult": "{\"ID\":1}"}},
+		{"MType": "agent", "AgentMsg": {"Text": "A person."}},
+		{"MType": "error", "ErrorMsg": {"Text": "boom"}},
+		{"MType": "user", "UserMsg": {"Text": "And Bob?"}},
+		{"MType": "tool", "ToolMsg": {"ToolName": "get_node", "ToolArgs": {"id": 2}, "ToolDone": true, "ToolError": "not found"}},
+		{"MType": "agent", "AgentMsg": {"Text": "No idea."}},
+		{"MType": "user", "UserMsg": {"Text": "Add my city"}},
+		{"MType": "tool", "ToolMsg": {"ToolName": "ask_user", "ToolArgs": {"question": "Which?"}, "ToolDone": true}}
+	]`), &ms); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range ms {
+		m.ChatID = ci.ID
+		if _, err := c.CreateMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := c.ExportChatJSONL(ci.ID, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// One line per turn with a reply (so not the one waiting on the user)
+	var lines [][]ftMessage
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var line struct {
+			Messages []ftMessage `json:"messages"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("line isn't valid JSON: %v\n%s", err, sc.Text())
+		}
+		lines = append(lines, line.Messages)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+
+	// describe sums up a line's messages, e.g. "system:Be brief."
+	// "tool_calls:get_node({"id":1})" or "assistant(0):..." if weighted 0
+	describe := func(ms []ftMessage) string {
+		var parts []string
+		for _, m := range ms {
+			s := m.Role
+			if m.Weight != nil {
+				s += "(0)"
+			}
+			switch {
+			case len(m.ToolCalls) > 0:
+				tc := m.ToolCalls[0]
+				s += ":" + tc.ID + "=" + tc.Function.Name + tc.Function.Arguments
+			case m.Role == "tool":
+				s += ":" + m.ToolCallID + "=" + *m.Content
+			default:
+				s += ":" + *m.Content
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, "\n")
+	}
+	want := []string{
+		`system:Be brief.
+user:Who is Alice?
+assistant:call_2=get_node{"id":1}
+tool:call_2={"ID":1}
+assistant:A person.`,
+		`system:Be brief.
+user:Who is Alice?
+assistant(0):call_2=get_node{"id":1}
+tool:call_2={"ID":1}
+assistant(0):A person.
+user:And Bob?
+assistant:call_6=get_node{"id":2}
+tool:call_6=Error: not found
+assistant:No idea.`,
+	}
+	for i, line := range lines {
+		if got := describe(line); got != want[i] {
+			t.Errorf("line %d:\n%s\nwant:\n%s", i+1, got, want[i])
+		}
+	}
+}
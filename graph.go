@@ -0,0 +1,560 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// CheckIntegrity returns the edges whose source or target
+// node no longer exists in the graph database.
+func (c *client) CheckIntegrity() ([]GraphEdge, error) {
+	var dangling []GraphEdge
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		nb := tx.Bucket(c.graphBucket(nodeBucket))
+		if nb == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+		eb := tx.Bucket(c.graphBucket(edgeBucket))
+		if eb == nil {
+			return fmt.Errorf("edge bucket not found")
+		}
+
+		cursor := eb.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var edge GraphEdge
+			if err := json.Unmarshal(v, &edge); err != nil {
+				return fmt.Errorf("failed to unmarshal edge: %w", err)
+			}
+			if nb.Get(itob(edge.FromID)) == nil || nb.Get(itob(edge.ToID)) == nil {
+				dangling = append(dangling, edge)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to check graph integrity: %w", err)
+	}
+	return dangling, nil
+}
+
+// errNoPath is returned when two nodes aren't connected.
+var errNoPath = errors.New("no path between nodes")
+
+// WeightedShortestPath finds the cheapest directed path between two
+// nodes using Dijkstra's algorithm, where each edge costs the numeric
+// value of its weightKey prop (or 1 if the prop isn't set). It returns
+// the edges along the path and the total cost, or errNoPath if the
+// nodes aren't connected. Negative or non-numeric weights are errors.
+func (c *client) WeightedShortestPath(fromID, toID int, weightKey string) ([]GraphEdge, float64, error) {
+	// Load the graph's adjacency list
+	adj := map[int][]GraphEdge{}
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		nb := tx.Bucket(c.graphBucket(nodeBucket))
+		if nb == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+		if nb.Get(itob(fromID)) == nil {
+			return fmt.Errorf("node with ID %d %w", fromID, errNotFound)
+		}
+		if nb.Get(itob(toID)) == nil {
+			return fmt.Errorf("node with ID %d %w", toID, errNotFound)
+		}
+
+		eb := tx.Bucket(c.graphBucket(edgeBucket))
+		if eb == nil {
+			return fmt.Errorf("edge bucket not found")
+		}
+		return eb.ForEach(func(k, v []byte) error {
+			var edge GraphEdge
+			if err := json.Unmarshal(v, &edge); err != nil {
+				return fmt.Errorf("failed to unmarshal edge: %w", err)
+			}
+			adj[edge.FromID] = append(adj[edge.FromID], edge)
+			return nil
+		})
+	}); err != nil {
+		return nil, 0, fmt.Errorf("failed to load graph: %w", err)
+	}
+
+	// Run Dijkstra from the source
+	dist := map[int]float64{fromID: 0}
+	prev := map[int]GraphEdge{}
+	done := map[int]bool{}
+	pq := &pathQueue{{node: fromID}}
+	for pq.Len() > 0 {
+		cur := heap.Pop(pq).(pathItem)
+		if done[cur.node] {
+			continue
+		}
+		done[cur.node] = true
+		if cur.node == toID {
+			break
+		}
+
+		for _, e := range adj[cur.node] {
+			w, err := edgeWeight(e, weightKey)
+			if err != nil {
+				return nil, 0, err
+			}
+			d := cur.dist + w
+			if old, ok := dist[e.ToID]; !ok || d < old {
+				dist[e.ToID] = d
+				prev[e.ToID] = e
+				heap.Push(pq, pathItem{node: e.ToID, dist: d})
+			}
+		}
+	}
+	if !done[toID] {
+		return nil, 0, errNoPath
+	}
+
+	// Walk back from the target to build the path
+	var path []GraphEdge
+	for n := toID; n != fromID; {
+		e := prev[n]
+		path = append([]GraphEdge{e}, path...)
+		n = e.FromID
+	}
+	return path, dist[toID], nil
+}
+
+// edgeWeight returns the cost of an edge from its weightKey prop,
+// defaulting to 1 when the prop isn't set.
+func edgeWeight(e GraphEdge, weightKey string) (float64, error) {
+	v, ok := e.Props[weightKey]
+	if !ok || v == nil {
+		return 1, nil
+	}
+	w, ok := toFloat(v)
+	if !ok {
+		return 0, fmt.Errorf("edge %d has non-numeric weight %q: %v", e.ID, weightKey, v)
+	}
+	if w < 0 {
+		return 0, fmt.Errorf("edge %d has negative weight %q: %v", e.ID, weightKey, w)
+	}
+	return w, nil
+}
+
+// toFloat converts a numeric prop value to a float64.
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// pathItem is a node queued for a visit, with its distance from the start.
+type pathItem struct {
+	node int
+	dist float64
+}
+
+// pathQueue is a min-heap of pathItems, ordered by distance.
+type pathQueue []pathItem
+
+func (q pathQueue) Len() int           { return len(q) }
+func (q pathQueue) Less(i, j int) bool { return q[i].dist < q[j].dist }
+func (q pathQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x any)        { *q = append(*q, x.(pathItem)) }
+func (q *pathQueue) Pop() any {
+	old := *q
+	it := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return it
+}
+
+// HasCycle reports whether the directed graph (optionally only
+// considering edges of one type) contains a cycle, returning the
+// IDs of the nodes along one cycle if so.
+func (c *client) HasCycle(edgeType string) (bool, []int, error) {
+	edges, err := c.ListEdges(EdgeFilter{Type: edgeType})
+	if err != nil {
+		return false, nil, err
+	}
+
+	// Build the adjacency list
+	adj := map[int][]int{}
+	for _, e := range edges {
+		adj[e.FromID] = append(adj[e.FromID], e.ToID)
+	}
+	starts := make([]int, 0, len(adj))
+	for id := range adj {
+		starts = append(starts, id)
+	}
+	sort.Ints(starts)
+
+	// Run an iterative DFS, tracking which nodes are on the current
+	// path (visiting) and which have been fully explored (done)
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[int]int{}
+	type frame struct {
+		node int
+		next int // Index of the next neighbor to visit
+	}
+	for _, start := range starts {
+		if state[start] != 0 {
+			continue
+		}
+
+		stack := []frame{{node: start}}
+		state[start] = visiting
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.next >= len(adj[top.node]) {
+				state[top.node] = done
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			n := adj[top.node][top.next]
+			top.next++
+
+			switch state[n] {
+			case visiting:
+				// Back edge – the cycle is the path from n to here
+				var cycle []int
+				for i := len(stack) - 1; i >= 0; i-- {
+					cycle = append([]int{stack[i].node}, cycle...)
+					if stack[i].node == n {
+						break
+					}
+				}
+				return true, cycle, nil
+			case 0:
+				state[n] = visiting
+				stack = append(stack, frame{node: n})
+			}
+		}
+	}
+	return false, nil, nil
+}
+
+// ConnectedComponents groups the graph's nodes into sets that are
+// reachable from one another (treating edges as undirected). Nodes
+// without any edges are their own components. Each component is
+// sorted by node ID, and components are ordered by their first node.
+func (c *client) ConnectedComponents() ([][]int, error) {
+	// Union-find over the node IDs
+	parent := map[int]int{}
+	find := func(x int) int {
+		for parent[x] != x {
+			parent[x] = parent[parent[x]] // Path halving
+			x = parent[x]
+		}
+		return x
+	}
+
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		nb := tx.Bucket(c.graphBucket(nodeBucket))
+		if nb == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+		eb := tx.Bucket(c.graphBucket(edgeBucket))
+		if eb == nil {
+			return fmt.Errorf("edge bucket not found")
+		}
+
+		// Every node starts in its own set
+		if err := nb.ForEach(func(k, v []byte) error {
+			var node GraphNode
+			if err := json.Unmarshal(v, &node); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			parent[node.ID] = node.ID
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Then each edge joins two sets
+		return eb.ForEach(func(k, v []byte) error {
+			var edge GraphEdge
+			if err := json.Unmarshal(v, &edge); err != nil {
+				return fmt.Errorf("failed to unmarshal edge: %w", err)
+			}
+			if _, ok := parent[edge.FromID]; !ok {
+				return nil // Dangling edge
+			}
+			if _, ok := parent[edge.ToID]; !ok {
+				return nil // Dangling edge
+			}
+			parent[find(edge.FromID)] = find(edge.ToID)
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("failed to load graph: %w", err)
+	}
+
+	// Group the nodes by their root
+	groups := map[int][]int{}
+	for id := range parent {
+		r := find(id)
+		groups[r] = append(groups[r], id)
+	}
+	comps := make([][]int, 0, len(groups))
+	for _, g := range groups {
+		sort.Ints(g)
+		comps = append(comps, g)
+	}
+	sort.Slice(comps, func(i, j int) bool {
+		return comps[i][0] < comps[j][0]
+	})
+	return comps, nil
+}
+
+// OrphanNodes returns the nodes that aren't connected to any edges.
+func (c *client) OrphanNodes() ([]GraphNode, error) {
+	var orphans []GraphNode
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		nb := tx.Bucket(c.graphBucket(nodeBucket))
+		if nb == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+		eb := tx.Bucket(c.graphBucket(edgeBucket))
+		if eb == nil {
+			return fmt.Errorf("edge bucket not found")
+		}
+
+		// Find all the nodes referenced by an edge
+		linked := map[int]bool{}
+		if err := eb.ForEach(func(k, v []byte) error {
+			var edge GraphEdge
+			if err := json.Unmarshal(v, &edge); err != nil {
+				return fmt.Errorf("failed to unmarshal edge: %w", err)
+			}
+			linked[edge.FromID] = true
+			linked[edge.ToID] = true
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Then keep the ones that aren't
+		return nb.ForEach(func(k, v []byte) error {
+			var node GraphNode
+			if err := json.Unmarshal(v, &node); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			if !linked[node.ID] {
+				orphans = append(orphans, node)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("failed to find orphan nodes: %w", err)
+	}
+	return orphans, nil
+}
+
+// StaleNodes returns the nodes that haven't been used since before,
+// oldest first. Nodes are used when they're created and, if the client
+// tracks access, whenever they're read. Nodes from before access times
+// were recorded count as stale.
+func (c *client) StaleNodes(before time.Time) ([]GraphNode, error) {
+	var stale []GraphNode
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		nb := tx.Bucket(c.graphBucket(nodeBucket))
+		if nb == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+		return nb.ForEach(func(k, v []byte) error {
+			var node GraphNode
+			if err := c.decodeNode(v, &node); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			if node.LastAccessedAt.Before(before) {
+				stale = append(stale, node)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("failed to find stale nodes: %w", err)
+	}
+	sort.SliceStable(stale, func(i, j int) bool {
+		return stale[i].LastAccessedAt.Before(stale[j].LastAccessedAt)
+	})
+	return stale, nil
+}
+
+// GraphStats counts a graph's nodes and edges, in total and by type.
+type GraphStats struct {
+	Nodes     int
+	Edges     int
+	NodeTypes map[string]int // Number of nodes of each type
+	EdgeTypes map[string]int // Number of edges of each type
+}
+
+// GraphStats counts the graph's nodes and edges by type.
+func (c *client) GraphStats() (GraphStats, error) {
+	s := GraphStats{NodeTypes: map[string]int{}, EdgeTypes: map[string]int{}}
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		nb := tx.Bucket(c.graphBucket(nodeBucket))
+		if nb == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+		eb := tx.Bucket(c.graphBucket(edgeBucket))
+		if eb == nil {
+			return fmt.Errorf("edge bucket not found")
+		}
+
+		// Only the types are needed, so skip decoding the props
+		if err := nb.ForEach(func(k, v []byte) error {
+			var rec struct{ Type string }
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			s.Nodes++
+			s.NodeTypes[rec.Type]++
+			return nil
+		}); err != nil {
+			return err
+		}
+		return eb.ForEach(func(k, v []byte) error {
+			var rec struct{ Type string }
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("failed to unmarshal edge: %w", err)
+			}
+			s.Edges++
+			s.EdgeTypes[rec.Type]++
+			return nil
+		})
+	}); err != nil {
+		return s, fmt.Errorf("failed to count graph: %w", err)
+	}
+	return s, nil
+}
+
+// TypeCount is the number of nodes (or edges) of a type.
+type TypeCount struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// topTypes returns up to limit of the most common types in
+// counts, most common first (ties in name order).
+func topTypes(counts map[string]int, limit int) []TypeCount {
+	tcs := make([]TypeCount, 0, len(counts))
+	for t, n := range counts {
+		tcs = append(tcs, TypeCount{Type: t, Count: n})
+	}
+	sort.Slice(tcs, func(i, j int) bool {
+		if tcs[i].Count != tcs[j].Count {
+			return tcs[i].Count > tcs[j].Count
+		}
+		return tcs[i].Type < tcs[j].Type
+	})
+	if len(tcs) > limit {
+		tcs = tcs[:limit]
+	}
+	return tcs
+}
+
+// NodeDegree counts the edges coming into and going out of a node.
+func (c *client) NodeDegree(nodeID int) (in, out int, err error) {
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		nb := tx.Bucket(c.graphBucket(nodeBucket))
+		if nb == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+		if nb.Get(itob(nodeID)) == nil {
+			return fmt.Errorf("node with ID %d %w", nodeID, errNotFound)
+		}
+
+		eb := tx.Bucket(c.graphBucket(edgeBucket))
+		if eb == nil {
+			return fmt.Errorf("edge bucket not found")
+		}
+		return eb.ForEach(func(k, v []byte) error {
+			var edge GraphEdge
+			if err := json.Unmarshal(v, &edge); err != nil {
+				return fmt.Errorf("failed to unmarshal edge: %w", err)
+			}
+			if edge.ToID == nodeID {
+				in++
+			}
+			if edge.FromID == nodeID {
+				out++
+			}
+			return nil
+		})
+	}); err != nil {
+		return 0, 0, fmt.Errorf("failed to get node degree: %w", err)
+	}
+	return in, out, nil
+}
+
+// RankedNode is a node along with its degree (its number of edges).
+type RankedNode struct {
+	Node   GraphNode
+	Degree int
+}
+
+// TopNodesByDegree returns up to limit nodes with the most edges,
+// most connected first (ties are broken by node ID).
+func (c *client) TopNodesByDegree(limit int) ([]RankedNode, error) {
+	var ranked []RankedNode
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		nb := tx.Bucket(c.graphBucket(nodeBucket))
+		if nb == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+		eb := tx.Bucket(c.graphBucket(edgeBucket))
+		if eb == nil {
+			return fmt.Errorf("edge bucket not found")
+		}
+
+		// Count the degrees in one pass over the edges
+		deg := map[int]int{}
+		if err := eb.ForEach(func(k, v []byte) error {
+			var edge GraphEdge
+			if err := json.Unmarshal(v, &edge); err != nil {
+				return fmt.Errorf("failed to unmarshal edge: %w", err)
+			}
+			deg[edge.FromID]++
+			deg[edge.ToID]++
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		return nb.ForEach(func(k, v []byte) error {
+			var node GraphNode
+			if err := json.Unmarshal(v, &node); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			ranked = append(ranked, RankedNode{Node: node, Degree: deg[node.ID]})
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("failed to rank nodes: %w", err)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Degree != ranked[j].Degree {
+			return ranked[i].Degree > ranked[j].Degree
+		}
+		return ranked[i].Node.ID < ranked[j].Node.ID
+	})
+	if limit >= 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked, nil
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// testGraph builds a small graph for tests:
+//
+//	1 alice (person) -knows-> 2 bob (person) -knows-> 3 carol (person)
+//	1 alice -lives_in-> 4 paris (city)
+//	5 lonely (person), with no edges
+//
+// The knows edges have a "cost" prop: 5 for alice->bob and 1 for
+// bob->carol, plus a direct alice->carol edge costing 10.
+func testGraph(t *testing.T) *client {
+	t.Helper()
+	c := newTestClient(t)
+	for _, n := range []struct {
+		typ   string
+		props map[string]any
+	}{
+		{"person", map[string]any{"name": "Alice", "age": 30}},
+		{"person", map[string]any{"name": "Bob", "age": 25}},
+		{"person", map[string]any{"name": "Carol"}},
+		{"city", map[string]any{"name": "Paris"}},
+		{"person", map[string]any{"name": "Lonely"}},
+	} {
+		if _, err := c.CreateNode(n.typ, n.props); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, e := range []struct {
+		typ      string
+		from, to int
+		props    map[string]any
+	}{
+		{"knows", 1, 2, map[string]any{"cost": 5}},
+		{"knows", 2, 3, map[string]any{"cost": 1}},
+		{"lives_in", 1, 4, nil},
+		{"knows", 1, 3, map[string]any{"cost": 10}},
+	} {
+		if _, err := c.CreateEdgeWithProps(e.typ, e.from, e.to, e.props); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+// edgeIDs returns the IDs of edges.
+func edgeIDs(es []GraphEdge) []int {
+	ids := []int{}
+	for _, e := range es {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	tests := []struct {
+		name    string
+		removed []int // Nodes removed behind the graph's back (leaving their edges)
+		want    []int // IDs of the dangling edges
+	}{
+		{"healthy", nil, []int{}},
+		{"missing target", []int{4}, []int{3}},
+		{"missing source and target", []int{2, 4}, []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testGraph(t)
+			if err := c.db.Update(func(tx *bolt.Tx) error {
+				for _, id := range tt.removed {
+					if err := tx.Bucket(c.graphBucket(nodeBucket)).Delete(itob(id)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			dangling, err := c.CheckIntegrity()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := edgeIDs(dangling); !slices.Equal(got, tt.want) {
+				t.Errorf("dangling edges = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// nodeIDs returns the IDs of nodes.
+func nodeIDs(ns []GraphNode) []int {
+	ids := []int{}
+	for _, n := range ns {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+func TestWeightedShortestPath(t *testing.T) {
+	c := testGraph(t)
+	tests := []struct {
+		name      string
+		from, to  int
+		weightKey string
+		wantEdges []int
+		wantCost  float64
+		wantErr   error
+	}{
+		{"cheapest through a middle node", 1, 3, "cost", []int{1, 2}, 6, nil},
+		{"fewest hops without weights", 1, 3, "missing", []int{4}, 1, nil},
+		{"to itself", 1, 1, "cost", []int{}, 0, nil},
+		{"against the edges", 3, 1, "cost", nil, 0, errNoPath},
+		{"missing source", 42, 1, "cost", nil, 0, errNotFound},
+		{"missing target", 1, 42, "cost", nil, 0, errNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cost, err := c.WeightedShortestPath(tt.from, tt.to, tt.weightKey)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := edgeIDs(path); !slices.Equal(got, tt.wantEdges) || cost != tt.wantCost {
+				t.Errorf("path = %v (cost %v), want %v (cost %v)", got, cost, tt.wantEdges, tt.wantCost)
+			}
+		})
+	}
+}
+
+func TestHasCycle(t *testing.T) {
+	c := testGraph(t)
+	if ok, _, err := c.HasCycle(""); err != nil || ok {
+		t.Fatalf("HasCycle() = %v, %v; want false, nil", ok, err)
+	}
+	if _, err := c.CreateEdge("knows", 3, 1); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		edgeType string
+		want     bool
+	}{
+		{"", true},
+		{"knows", true},
+		{"lives_in", false},
+	}
+	for _, tt := range tests {
+		ok, cycle, err := c.HasCycle(tt.edgeType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.want {
+			t.Errorf("HasCycle(%q) = %v (%v), want %v", tt.edgeType, ok, cycle, tt.want)
+		}
+	}
+}
+
+func TestConnectedComponents(t *testing.T) {
+	c := testGraph(t)
+	comps, err := c.ConnectedComponents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comps) != 2 {
+		t.Fatalf("found %d components (%v), want 2", len(comps), comps)
+	}
+	var sizes []int
+	for _, comp := range comps {
+		sizes = append(sizes, len(comp))
+	}
+	slices.Sort(sizes)
+	if !slices.Equal(sizes, []int{1, 4}) {
+		t.Errorf("component sizes = %v, want [1 4]", sizes)
+	}
+}
+
+func TestOrphanNodes(t *testing.T) {
+	c := testGraph(t)
+	orphans, err := c.OrphanNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeIDs(orphans); !slices.Equal(got, []int{5}) {
+		t.Errorf("orphans = %v, want [5]", got)
+	}
+
+	// Paris loses its only edge
+	if err := c.DeleteEdge(3); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err = c.OrphanNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeIDs(orphans); !slices.Equal(got, []int{4, 5}) {
+		t.Errorf("orphans = %v, want [4 5]", got)
+	}
+}
+
+func TestNodeDegree(t *testing.T) {
+	c := testGraph(t)
+	tests := []struct {
+		id      int
+		in, out int
+		wantErr error
+	}{
+		{1, 0, 3, nil},
+		{2, 1, 1, nil},
+		{3, 2, 0, nil},
+		{5, 0, 0, nil},
+		{42, 0, 0, errNotFound},
+	}
+	for _, tt := range tests {
+		in, out, err := c.NodeDegree(tt.id)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("NodeDegree(%d) err = %v, want %v", tt.id, err, tt.wantErr)
+			continue
+		}
+		if in != tt.in || out != tt.out {
+			t.Errorf("NodeDegree(%d) = %d, %d; want %d, %d", tt.id, in, out, tt.in, tt.out)
+		}
+	}
+}
+
+func TestTopNodesByDegree(t *testing.T) {
+	c := testGraph(t)
+	ranked, err := c.TopNodesByDegree(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranked) != 2 || ranked[0].Node.ID != 1 {
+		t.Errorf("TopNodesByDegree(2) = %+v, want node 1 first", ranked)
+	}
+}
+
+func TestGraphStats(t *testing.T) {
+	c := testGraph(t)
+	s, err := c.GraphStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Nodes != 5 || s.Edges != 4 {
+		t.Errorf("stats = %d nodes, %d edges; want 5, 4", s.Nodes, s.Edges)
+	}
+	if s.NodeTypes["person"] != 4 || s.EdgeTypes["knows"] != 3 {
+		t.Errorf("type counts = %v, %v", s.NodeTypes, s.EdgeTypes)
+	}
+	if top := topTypes(s.NodeTypes, 1); len(top) != 1 || top[0].Type != "person" {
+		t.Errorf("topTypes = %v, want person first", top)
+	}
+}
+
+// trackingClient returns a client for the same database as c that
+// tracks when nodes are read.
+func trackingClient(c *client) *client {
+	tc := c.withGraph(c.graph)
+	tc.trackAccess = true
+	return tc
+}
+
+// staleSince waits a moment, so nodes touched from now on are newer than
+// the returned time, and returns a func listing the nodes still stale.
+func staleSince(t *testing.T, c *client) func() []int {
+	t.Helper()
+	mark := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	return func() []int {
+		t.Helper()
+		ns, err := c.StaleNodes(mark)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nodeIDs(ns)
+	}
+}
+
+func TestStaleNodes(t *testing.T) {
+	c := testGraph(t)
+	stale := staleSince(t, c)
+	if got, want := stale(), []int{1, 2, 3, 4, 5}; !slices.Equal(got, want) {
+		t.Fatalf("StaleNodes() = %v, want %v", got, want)
+	}
+
+	// Reads only count if the client tracks access
+	if _, err := c.GetNode(2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stale(), []int{1, 2, 3, 4, 5}; !slices.Equal(got, want) {
+		t.Errorf("after untracked read, StaleNodes() = %v, want %v", got, want)
+	}
+	if _, err := trackingClient(c).GetNode(2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stale(), []int{1, 3, 4, 5}; !slices.Equal(got, want) {
+		t.Errorf("after tracked read, StaleNodes() = %v, want %v", got, want)
+	}
+}
+
+func TestDeleteNodesByType(t *testing.T) {
+	tests := []struct {
+		typ       string
+		want      int
+		wantNodes []int
+		wantEdges []int
+	}{
+		{"city", 1, []int{1, 2, 3, 5}, []int{1, 2, 4}},
+		{"person", 4, []int{4}, []int{}},
+		{"planet", 0, []int{1, 2, 3, 4, 5}, []int{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			c := testGraph(t)
+			n, err := c.DeleteNodesByType(tt.typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.want {
+				t.Errorf("DeleteNodesByType() = %d, want %d", n, tt.want)
+			}
+
+			// The nodes are gone, along with every edge touching them
+			ns, err := c.ListNodes("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := nodeIDs(ns); !slices.Equal(got, tt.wantNodes) {
+				t.Errorf("nodes left = %v, want %v", got, tt.wantNodes)
+			}
+			es, err := c.ListEdges(EdgeFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := edgeIDs(es); !slices.Equal(got, tt.wantEdges) {
+				t.Errorf("edges left = %v, want %v", got, tt.wantEdges)
+			}
+		})
+	}
+}
+
+func TestRmTypeCommand(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	c, err := openClientWith(context.Background(), clientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{"person", "person", "city"} {
+		if _, err := c.CreateNode(typ, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+
+	out, err := runApp(t, "graph", "rm-type", "--yes", "person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Deleted 2 nodes\n"; out != want {
+		t.Errorf("printed %q, want %q", out, want)
+	}
+	if _, err := runApp(t, "graph", "rm-type", "--yes"); err == nil {
+		t.Error("rm-type without a type succeeded")
+	}
+}
+
+func TestEdgeProps(t *testing.T) {
+	c := testGraph(t)
+
+	// Props round-trip through the database
+	props := map[string]any{"since": 2020.0, "weight": 0.5}
+	e, err := c.CreateEdgeWithProps("knows", 2, 1, props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetEdge(e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Props, props) {
+		t.Errorf("edge props = %v, want %v", got.Props, props)
+	}
+
+	// Updating replaces them
+	updated := map[string]any{"since": 2021.0}
+	if _, err := c.UpdateEdge(e.ID, "", updated); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = c.GetEdge(e.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "knows" || !reflect.DeepEqual(got.Props, updated) {
+		t.Errorf("updated edge = %+v, want type knows with props %v", got, updated)
+	}
+
+	// Edges without props (like those stored before edges had
+	// them) still decode
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(c.graphBucket(edgeBucket)).Put(itob(99), []byte(`{"ID":99,"Type":"old","FromID":1,"ToID":2}`))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = c.GetEdge(99); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "old" || got.Props != nil {
+		t.Errorf("old edge = %+v, want type old with no props", got)
+	}
+}
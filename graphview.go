@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// loadGraphView loads the graph to show in the graph view: the whole
+// graph if root is 0, otherwise just root and its direct neighbours.
+func (c *client) loadGraphView(root int) ([]GraphNode, []GraphEdge, error) {
+	if root == 0 {
+		ns, err := c.ListNodes("")
+		if err != nil {
+			return nil, nil, err
+		}
+		es, err := c.ListEdges(EdgeFilter{})
+		if err != nil {
+			return nil, nil, err
+		}
+		return ns, es, nil
+	}
+
+	// Get the edges on either side of the root
+	out, err := c.ListEdges(EdgeFilter{FromID: root})
+	if err != nil {
+		return nil, nil, err
+	}
+	in, err := c.ListEdges(EdgeFilter{ToID: root})
+	if err != nil {
+		return nil, nil, err
+	}
+	es := out
+	for _, e := range in {
+		if e.FromID != root { // Self-loops are already in out
+			es = append(es, e)
+		}
+	}
+
+	// Then the nodes at the other ends
+	ids := map[int]bool{root: true}
+	for _, e := range es {
+		ids[e.FromID] = true
+		ids[e.ToID] = true
+	}
+	var ns []GraphNode
+	for id := range ids {
+		n, err := c.GetNode(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		ns = append(ns, *n)
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i].ID < ns[j].ID })
+	return ns, es, nil
+}
+
+// renderGraph draws each node as a box, with its outgoing
+// edges listed to the right of it.
+func renderGraph(nodes []GraphNode, edges []GraphEdge, width int) string {
+	if len(nodes) == 0 {
+		return "(empty graph)"
+	}
+
+	out := map[int][]GraphEdge{}
+	seen := map[int]bool{}
+	for _, e := range edges {
+		if seen[e.ID] {
+			continue
+		}
+		seen[e.ID] = true
+		out[e.FromID] = append(out[e.FromID], e)
+	}
+
+	box := lipgloss.
+		NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("#7D56F4")).
+		Padding(0, 1)
+	edgeStyle := lipgloss.
+		NewStyle().
+		Foreground(lipgloss.Color("#AAAFBE"))
+
+	var rows []string
+	for _, n := range nodes {
+		b := box.Render(nodeLabel(n))
+
+		var ls []string
+		for _, e := range out[n.ID] {
+			ls = append(ls, edgeStyle.Render(fmt.Sprintf("──%s──▶ #%d", e.Type, e.ToID)))
+		}
+		row := lipgloss.JoinHorizontal(lipgloss.Center, b, " ", strings.Join(ls, "\n"))
+		rows = append(rows, lipgloss.NewStyle().MaxWidth(width).Render(row))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, rows...)
+}
+
+// nodeLabel is the text shown in a node's box: its ID and
+// type, plus its name (if it has one).
+func nodeLabel(n GraphNode) string {
+	l := fmt.Sprintf("#%d %s", n.ID, n.Type)
+	for _, k := range labelProps {
+		if v, ok := n.Props[k]; ok {
+			return l + "\n" + fmt.Sprint(v)
+		}
+	}
+	return l
+}
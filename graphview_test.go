@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadGraphView(t *testing.T) {
+	c := testGraph(t)
+	loop, err := c.CreateEdge("likes", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		root      int
+		wantNodes []int
+		wantEdges []int
+	}{
+		{0, []int{1, 2, 3, 4, 5}, []int{1, 2, 3, 4, loop.ID}},
+		{1, []int{1, 2, 3, 4}, []int{1, 3, 4, loop.ID}},
+		{3, []int{1, 2, 3}, []int{2, 4}},
+		{5, []int{5}, nil},
+	}
+	for _, tt := range tests {
+		ns, es, err := c.loadGraphView(tt.root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := nodeIDs(ns); !slices.Equal(got, tt.wantNodes) {
+			t.Errorf("loadGraphView(%d) nodes = %v, want %v", tt.root, got, tt.wantNodes)
+		}
+		if got := slices.Sorted(slices.Values(edgeIDs(es))); !slices.Equal(got, tt.wantEdges) {
+			t.Errorf("loadGraphView(%d) edges = %v, want %v", tt.root, got, tt.wantEdges)
+		}
+	}
+}
+
+func TestRenderGraph(t *testing.T) {
+	if got := renderGraph(nil, nil, 80); got != "(empty graph)" {
+		t.Errorf("renderGraph() of empty graph = %q", got)
+	}
+
+	ns := []GraphNode{
+		{ID: 1, Type: "person", Props: map[string]any{"name": "Alice"}},
+		{ID: 2, Type: "thing"},
+	}
+	loop := GraphEdge{ID: 1, Type: "likes", FromID: 1, ToID: 1}
+	es := []GraphEdge{loop, {ID: 2, Type: "owns", FromID: 1, ToID: 2}, loop}
+	out := renderGraph(ns, es, 80)
+	for _, want := range []string{"#1 person", "Alice", "#2 thing", "──owns──▶ #2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("renderGraph() is missing %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "──likes──▶ #1"); n != 1 {
+		t.Errorf("self-loop is listed %d times, want 1:\n%s", n, out)
+	}
+}
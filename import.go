@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ImportNodesCSV creates one node of the given type for each data row
+// in a CSV file, using the header row as the property names. Rows that
+// can't be imported are skipped and their errors returned together
+// with the nodes that were created.
+func (c *client) ImportNodesCSV(r io.Reader, nodeType string) ([]GraphNode, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // Checked per row below
+
+	// Read the header
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+
+	var nodes []GraphNode
+	var rowErrs []error
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		for row := 2; ; row++ {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				rowErrs = append(rowErrs, fmt.Errorf("row %d: %w", row, err))
+				continue
+			}
+			if len(rec) != len(header) {
+				rowErrs = append(rowErrs, fmt.Errorf("row %d: expected %d fields, got %d", row, len(header), len(rec)))
+				continue
+			}
+
+			// Use the columns as props
+			props := make(map[string]any, len(header))
+			for i, k := range header {
+				props[k] = rec[i]
+			}
+
+			node, err := c.createNode(tx, GraphNode{Type: nodeType, Props: props})
+			if err != nil {
+				return fmt.Errorf("row %d: %w", row, err)
+			}
+			nodes = append(nodes, *node)
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("failed to import nodes: %w", err)
+	}
+	return nodes, errors.Join(rowErrs...)
+}
+
+// ImportEdgesCSV creates one edge for each data row in a CSV file with
+// "type", "from_id", and "to_id" columns. Rows that can't be imported
+// (e.g. because an endpoint doesn't exist) are skipped and their errors
+// returned together with the edges that were created.
+func (c *client) ImportEdgesCSV(r io.Reader) ([]GraphEdge, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // Checked per row below
+
+	// Read the header and find the columns
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+	cols := map[string]int{"type": -1, "from_id": -1, "to_id": -1}
+	for i, k := range header {
+		if _, ok := cols[k]; ok {
+			cols[k] = i
+		}
+	}
+	for k, i := range cols {
+		if i < 0 {
+			return nil, fmt.Errorf("csv is missing the %q column", k)
+		}
+	}
+
+	var edges []GraphEdge
+	var rowErrs []error
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		for row := 2; ; row++ {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				rowErrs = append(rowErrs, fmt.Errorf("row %d: %w", row, err))
+				continue
+			}
+			if len(rec) != len(header) {
+				rowErrs = append(rowErrs, fmt.Errorf("row %d: expected %d fields, got %d", row, len(header), len(rec)))
+				continue
+			}
+
+			fromID, err := strconv.Atoi(rec[cols["from_id"]])
+			if err != nil {
+				rowErrs = append(rowErrs, fmt.Errorf("row %d: invalid from_id: %w", row, err))
+				continue
+			}
+			toID, err := strconv.Atoi(rec[cols["to_id"]])
+			if err != nil {
+				rowErrs = append(rowErrs, fmt.Errorf("row %d: invalid to_id: %w", row, err))
+				continue
+			}
+
+			// Make sure the endpoints exist before creating the edge
+			nb := tx.Bucket(c.graphBucket(nodeBucket))
+			if nb.Get(itob(fromID)) == nil {
+				rowErrs = append(rowErrs, fmt.Errorf("row %d: source node %d not found", row, fromID))
+				continue
+			}
+			if nb.Get(itob(toID)) == nil {
+				rowErrs = append(rowErrs, fmt.Errorf("row %d: target node %d not found", row, toID))
+				continue
+			}
+
+			edge, err := c.createEdge(tx, rec[cols["type"]], fromID, toID, nil)
+			if err != nil {
+				return fmt.Errorf("row %d: %w", row, err)
+			}
+			edges = append(edges, *edge)
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("failed to import edges: %w", err)
+	}
+	return edges, errors.Join(rowErrs...)
+}
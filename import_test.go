@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	tests := []struct {
+		name      string
+		nodes     string
+		edges     string
+		wantNodes int
+		wantEdges int
+		wantErrs  []string // Rows the error should mention (none for no error)
+	}{
+		{
+			name:      "all good",
+			nodes:     "name,age\nAlice,30\nBob,25\n",
+			edges:     "type,from_id,to_id\nknows,1,2\n",
+			wantNodes: 2, wantEdges: 1,
+		},
+		{
+			name:      "bad node rows",
+			nodes:     "name,age\nAlice,30\nBob\nCarol,40,extra\nDave,50\n",
+			edges:     "type,from_id,to_id\nknows,1,2\n",
+			wantNodes: 2, wantEdges: 1,
+			wantErrs: []string{"nodes", "row 3", "row 4"},
+		},
+		{
+			name:      "bad edge rows",
+			nodes:     "name\nAlice\nBob\n",
+			edges:     "type,from_id,to_id\nknows,1,2\nknows,x,2\nknows,1,42\nlikes,2,1\n",
+			wantNodes: 2, wantEdges: 2,
+			wantErrs: []string{"edges", "row 3", "row 4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+			t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+			nodes := filepath.Join(home, "nodes.csv")
+			edges := filepath.Join(home, "edges.csv")
+			for p, data := range map[string]string{nodes: tt.nodes, edges: tt.edges} {
+				if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := makeApp().Run(context.Background(), []string{
+				"agnt", "graph", "import-csv",
+				"--nodes", nodes, "--node-type", "person", "--edges", edges,
+			})
+			if len(tt.wantErrs) == 0 && err != nil {
+				t.Fatalf("import failed: %v", err)
+			}
+			if len(tt.wantErrs) > 0 {
+				if err == nil {
+					t.Fatal("import didn't fail")
+				}
+				for _, want := range tt.wantErrs {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q doesn't mention %q", err, want)
+					}
+				}
+			}
+
+			// The good rows were still imported
+			c, err := newClient(context.Background(), filepath.Join(home, "data", "agnt"), clientConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			s, err := c.GraphStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Nodes != tt.wantNodes || s.Edges != tt.wantEdges {
+				t.Errorf("imported %d nodes and %d edges, want %d and %d", s.Nodes, s.Edges, tt.wantNodes, tt.wantEdges)
+			}
+		})
+	}
+}
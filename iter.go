@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The Each methods stream records to a callback from inside a single
+// read transaction, rather than loading them all into a slice. If the
+// callback returns an error, iteration stops and that error is
+// returned as-is. The callback must not call other client methods
+// that start a transaction (e.g. writes), since they can deadlock
+// against the open read transaction.
+
+// EachNode calls fn for every node in the graph, in ID order.
+func (c *client) EachNode(fn func(GraphNode) error) error {
+	return c.each(c.graphBucket(nodeBucket), func(v []byte) error {
+		var node GraphNode
+		if err := c.decodeNode(v, &node); err != nil {
+			return fmt.Errorf("failed to unmarshal node: %w", err)
+		}
+		return fn(node)
+	})
+}
+
+// EachEdge calls fn for every edge in the graph, in ID order.
+func (c *client) EachEdge(fn func(GraphEdge) error) error {
+	return c.each(c.graphBucket(edgeBucket), func(v []byte) error {
+		var edge GraphEdge
+		if err := json.Unmarshal(v, &edge); err != nil {
+			return fmt.Errorf("failed to unmarshal edge: %w", err)
+		}
+		return fn(edge)
+	})
+}
+
+// EachMessage calls fn for every message in a chat, oldest first.
+func (c *client) EachMessage(chatID int, fn func(Message) error) error {
+	return c.each(ChatInfo{ID: chatID}.MessageBucketName(), func(v []byte) error {
+		var msg Message
+		if err := json.Unmarshal(v, &msg); err != nil {
+			return fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		return fn(msg)
+	})
+}
+
+// each calls fn with every value in the named bucket, in key order.
+func (c *client) each(name []byte, fn func(v []byte) error) error {
+	var cbErr error
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(name)
+		if bucket == nil {
+			return fmt.Errorf("bucket %q not found", name)
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			cbErr = fn(v)
+			return cbErr
+		})
+	}); err != nil {
+		// Pass the callback's error back untouched
+		if cbErr != nil {
+			return cbErr
+		}
+		return fmt.Errorf("failed to iterate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// errStop is returned by callbacks to stop iterating.
+var errStop = errors.New("stop")
+
+func TestEach(t *testing.T) {
+	c := testGraph(t)
+	ci, err := c.CreateChat("each", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		addUserMessage(t, c, ci.ID, fmt.Sprint(i))
+	}
+
+	// Each method, as one that collects the IDs it's given
+	iters := map[string]func(ids *[]int, stop int) error{
+		"nodes": func(ids *[]int, stop int) error {
+			return c.EachNode(func(n GraphNode) error {
+				*ids = append(*ids, n.ID)
+				if n.ID == stop {
+					return errStop
+				}
+				return nil
+			})
+		},
+		"edges": func(ids *[]int, stop int) error {
+			return c.EachEdge(func(e GraphEdge) error {
+				*ids = append(*ids, e.ID)
+				if e.ID == stop {
+					return errStop
+				}
+				return nil
+			})
+		},
+		"messages": func(ids *[]int, stop int) error {
+			return c.EachMessage(ci.ID, func(m Message) error {
+				*ids = append(*ids, m.MessageID)
+				if m.MessageID == stop {
+					return errStop
+				}
+				return nil
+			})
+		},
+	}
+	tests := []struct {
+		name string
+		all  []int
+	}{
+		{"nodes", []int{1, 2, 3, 4, 5}},
+		{"edges", []int{1, 2, 3, 4}},
+		{"messages", []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Everything, in order
+			var ids []int
+			if err := iters[tt.name](&ids, 0); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ids, tt.all) {
+				t.Errorf("iterated over %v, want %v", ids, tt.all)
+			}
+
+			// Stopping early gives back the callback's error
+			ids = nil
+			if err := iters[tt.name](&ids, 2); err != errStop {
+				t.Errorf("error = %v, want the callback's", err)
+			}
+			if !slices.Equal(ids, tt.all[:2]) {
+				t.Errorf("iterated over %v after stopping, want %v", ids, tt.all[:2])
+			}
+		})
+	}
+
+	// A chat that doesn't exist has no bucket to go through
+	if err := c.EachMessage(99, func(Message) error { return nil }); err == nil {
+		t.Error("EachMessage() went through a missing chat")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// vimKeyMap holds the optional vim-style bindings for
+// moving around the viewport (enabled with --vim).
+type vimKeyMap struct {
+	Down     key.Binding
+	Up       key.Binding
+	Top      key.Binding
+	Bottom   key.Binding
+	HalfDown key.Binding
+	HalfUp   key.Binding
+	Search   key.Binding
+	Next     key.Binding
+}
+
+var vimKeys = vimKeyMap{
+	Down:     key.NewBinding(key.WithKeys("j"), key.WithHelp("j", "scroll down")),
+	Up:       key.NewBinding(key.WithKeys("k"), key.WithHelp("k", "scroll up")),
+	Top:      key.NewBinding(key.WithKeys("g"), key.WithHelp("g", "go to top")),
+	Bottom:   key.NewBinding(key.WithKeys("G"), key.WithHelp("G", "go to bottom")),
+	HalfDown: key.NewBinding(key.WithKeys("ctrl+d"), key.WithHelp("ctrl+d", "half page down")),
+	HalfUp:   key.NewBinding(key.WithKeys("ctrl+u"), key.WithHelp("ctrl+u", "half page up")),
+	Search:   key.NewBinding(key.WithKeys("/"), key.WithHelp("/", "search messages")),
+	Next:     key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "next match")),
+}
+
+// vimKey handles the vim-style viewport bindings,
+// reporting whether the key was handled.
+func (m *model) vimKey(msg tea.KeyMsg) (tea.Cmd, bool) {
+	switch {
+	case key.Matches(msg, vimKeys.Down):
+		m.vp.LineDown(1)
+	case key.Matches(msg, vimKeys.Up):
+		m.vp.LineUp(1)
+	case key.Matches(msg, vimKeys.Top):
+		m.vp.GotoTop()
+	case key.Matches(msg, vimKeys.Bottom):
+		m.vp.GotoBottom()
+	case key.Matches(msg, vimKeys.HalfDown):
+		m.vp.HalfViewDown()
+	case key.Matches(msg, vimKeys.HalfUp):
+		m.vp.HalfViewUp()
+	case key.Matches(msg, vimKeys.Search):
+		// Ask for the text to search for
+		m.searching = true
+		m.stash = m.ta.Value()
+		m.ta.SetValue("")
+		m.ta.Placeholder = "Search messages (enter to find, esc to cancel)"
+		return func() tea.Msg { return SetFocusMsg{focus: "textarea"} }, true
+	case key.Matches(msg, vimKeys.Next):
+		m.findNext()
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+// search runs the search typed into the textarea
+// and puts the textarea back how it was.
+func (m *model) search() tea.Cmd {
+	m.lastSearch = strings.TrimSpace(m.ta.Value())
+	m.stopPrompt()
+	m.findNext()
+	return func() tea.Msg { return SetFocusMsg{focus: "viewport"} }
+}
+
+// findNext selects the next message (after the selected one, wrapping
+// around) containing the last search, and scrolls to it.
+func (m *model) findNext() {
+	if m.lastSearch == "" || len(m.hist) == 0 {
+		return
+	}
+	q := strings.ToLower(m.lastSearch)
+	for n := 1; n <= len(m.hist); n++ {
+		i := (max(m.sel, -1) + n) % len(m.hist)
+		if strings.Contains(strings.ToLower(messageText(m.hist[i])), q) {
+			m.sel = i
+			m.updteVP()
+			m.vp.SetYOffset(m.msgLines[i])
+			return
+		}
+	}
+}
+
+// messageText returns the text of a message to search through.
+func messageText(msg Message) string {
+	if !msg.Valid() {
+		return ""
+	}
+	switch msg.MType {
+	case "user":
+		return msg.UserMsg.Text
+	case "agent":
+		return msg.AgentMsg.Text
+	case "tool":
+		return msg.ToolMsg.ToolName + " " + msg.ToolMsg.ToolResult + " " + msg.ToolMsg.ToolError
+	case "summary":
+		return msg.SummaryMsg.Text
+	case "error":
+		return msg.ErrorMsg.Text
+	}
+	return ""
+}
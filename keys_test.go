@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newVimTest creates a model (with vim keys if vim is set) showing
+// a chat too long to fit, with the viewport focused and at the top.
+func newVimTest(t *testing.T, vim bool) *model {
+	t.Helper()
+	c := newTestClient(t)
+	ci, err := c.CreateChat("vim", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100 {
+		addUserMessage(t, c, ci.ID, fmt.Sprintf("message %d", i))
+	}
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{Vim: vim})
+	m.Update(tea.WindowSizeMsg{Width: 80, Height: 30})
+	m.focus = "viewport"
+	m.vp.GotoTop()
+	if m.vp.Height <= 0 || m.vp.AtBottom() {
+		t.Fatalf("chat fits in the viewport (height %d)", m.vp.Height)
+	}
+	return m
+}
+
+func runeKey(r rune) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}}
+}
+
+func TestVimKeys(t *testing.T) {
+	m := newVimTest(t, true)
+	tests := []struct {
+		name string
+		key  tea.KeyMsg
+		want func() int // The expected offset afterwards
+	}{
+		{"j", runeKey('j'), func() int { return 1 }},
+		{"j again", runeKey('j'), func() int { return 2 }},
+		{"k", runeKey('k'), func() int { return 1 }},
+		{"G", runeKey('G'), func() int { return m.vp.TotalLineCount() - m.vp.Height }},
+		{"g", runeKey('g'), func() int { return 0 }},
+		{"ctrl+d", tea.KeyMsg{Type: tea.KeyCtrlD}, func() int { return m.vp.Height / 2 }},
+		{"ctrl+u", tea.KeyMsg{Type: tea.KeyCtrlU}, func() int { return 0 }},
+	}
+	for _, tt := range tests {
+		m.Update(tt.key)
+		if got, want := m.vp.YOffset, tt.want(); got != want {
+			t.Errorf("after %s, offset = %d, want %d", tt.name, got, want)
+		}
+	}
+}
+
+func TestVimSearch(t *testing.T) {
+	m := newVimTest(t, true)
+	_, cmd := m.Update(runeKey('/'))
+	if !m.searching {
+		t.Fatal("/ didn't start a search")
+	}
+	for _, msg := range runCmd(cmd) {
+		m.Update(msg)
+	}
+	if m.focus != "textarea" {
+		t.Errorf("focus = %q, want textarea", m.focus)
+	}
+
+	// Find the messages with a 3 in them, in order
+	m.ta.SetValue("3")
+	for _, msg := range runCmd(m.search()) {
+		m.Update(msg)
+	}
+	if m.searching || m.focus != "viewport" {
+		t.Errorf("still searching (focus %q)", m.focus)
+	}
+	for _, want := range []string{"message 3", "message 13", "message 23", "message 30"} {
+		if got := messageText(m.hist[m.sel]); got != want {
+			t.Errorf("selected %q, want %q", got, want)
+		}
+		m.Update(runeKey('n'))
+	}
+}
+
+func TestVimKeysOff(t *testing.T) {
+	m := newVimTest(t, false)
+	// (j, k, and ctrl+d/ctrl+u are the viewport's own keys too)
+	m.Update(runeKey('G'))
+	if m.vp.YOffset != 0 {
+		t.Errorf("offset = %d without vim keys, want 0", m.vp.YOffset)
+	}
+	m.Update(runeKey('/'))
+	if m.searching {
+		t.Error("/ started a search without vim keys")
+	}
+}
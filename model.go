@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/muesli/reflow/wordwrap"
+	"github.com/muesli/reflow/wrap"
 )
 
 var _ tea.Model = (*model)(nil)
@@ -20,15 +26,141 @@ type model struct {
 
 	chatId int
 
+	// Changes to the current chat's messages, and the func
+	// that stops them (see subscribe)
+	events <-chan MessageEvent
+	unsub  func()
+
 	ctx   context.Context
 	focus string
 
 	vp   *viewport.Model
 	ta   *textarea.Model
 	hist []Message
+	sel  int // Index of the selected message in hist (-1 if none)
+
+	expanded map[int]bool // Tool messages (by message ID) showing their args and result
+
+	scroll        map[int]int // Where each chat was scrolled to when left, by chat ID (missing if at the bottom)
+	restoreScroll bool        // Scroll back to where the chat was once it's loaded
+
+	err error // Error to show in the banner (nil if none)
+
+	graphView bool // Show the graph instead of the chat
+	graphRoot int  // Node the graph view is centred on (0 for the whole graph)
+
+	histPos int    // How many sent messages back the textarea is showing (0 for the draft)
+	draft   string // The in-progress message, saved while browsing history
+
+	regenModel string // Model overriding the default while regenerating (empty if not)
+
+	naming     bool     // The textarea is asking for a new chat's name
+	searching  bool     // The textarea is asking for text to search for
+	answering  bool     // The textarea is answering the agent's question
+	saving     *Message // The tool call whose result the textarea is asking for a file to save to
+	stash      string   // The textarea's contents from before naming, searching, answering, or saving started
+	confirmDel bool     // Waiting for the user to confirm deleting the chat
+	approving  bool     // Waiting for the user to approve the agent's delete
+
+	ui         uiConfig
+	lastSearch string // The last text searched for (vim mode only)
+	msgLines   []int  // Line each message in hist starts on in the viewport
+}
+
+// uiConfig holds the user-configurable TUI settings.
+type uiConfig struct {
+	Vim          bool              // Enable vim-style keys in the viewport
+	Plain        bool              // Use text labels instead of emoji, and skip decorative styling
+	RolePrefixes map[string]string // Custom prefixes, by message type (overriding the defaults)
+	SoftWrap     bool              // Only break lines between words, letting long ones (URLs, JSON) run past the edge
+	WrapMargin   int               // Columns to leave empty to the right of messages
+	DefaultChat  string            // Name of the chat created on first run (untitled if empty)
+	Tables       bool              // Show tool results that are lists of objects as tables
+	ResultsDir   string            // Directory tool results are saved to by default (next to the database if empty)
+}
+
+// maxPrefixWidth is the widest a custom role prefix can be
+// before it starts crowding out the messages.
+const maxPrefixWidth = 12
+
+// defaultRolePrefixes and plainRolePrefixes are the prefixes put
+// before each type of message, normally and in plain mode.
+var (
+	defaultRolePrefixes = map[string]string{
+		"user":      "👨‍💻",
+		"agent":     "🤖",
+		"tool":      "🛠️",
+		"summary":   "📝",
+		"error":     "❌",
+		"malformed": "⚠️",
+	}
+	plainRolePrefixes = map[string]string{
+		"user":      "You",
+		"agent":     "Agent",
+		"tool":      "Tool",
+		"summary":   "Summary",
+		"error":     "Error",
+		"malformed": "Warning",
+	}
+)
+
+func (ui uiConfig) validate() error {
+	if ui.WrapMargin < 0 {
+		return fmt.Errorf("wrap margin must not be negative, got %d", ui.WrapMargin)
+	}
+	for role, p := range ui.RolePrefixes {
+		if _, ok := defaultRolePrefixes[role]; !ok {
+			return fmt.Errorf("unknown role %q for prefix", role)
+		}
+		if w := lipgloss.Width(p); w > maxPrefixWidth {
+			return fmt.Errorf("prefix for %s is %d cells wide, the most is %d", role, w, maxPrefixWidth)
+		}
+	}
+	return nil
+}
+
+// prefix returns the label to put before a message of the given type.
+func (ui uiConfig) prefix(mtype string) string {
+	if _, ok := defaultRolePrefixes[mtype]; !ok {
+		mtype = "malformed"
+	}
+	if p, ok := ui.RolePrefixes[mtype]; ok {
+		return p + ": "
+	}
+	if ui.Plain {
+		return plainRolePrefixes[mtype] + ": "
+	}
+	return defaultRolePrefixes[mtype] + ": "
 }
 
-func newModel(ctx context.Context, c *client, a *agent) *model {
+// pinMark returns the mark put before pinned messages.
+func (ui uiConfig) pinMark() string {
+	if ui.Plain {
+		return "[pinned] "
+	}
+	return "📌 "
+}
+
+// wrap wraps text to width, breaking lines between words. Words too
+// long to fit on a line are broken up too, unless soft wrapping.
+func (ui uiConfig) wrap(text string, width int) string {
+	text = wordwrap.String(text, width)
+	if ui.SoftWrap {
+		return text
+	}
+	return wrap.String(text, width)
+}
+
+// color returns a style with the given foreground color
+// (or no styling at all in plain mode).
+func (ui uiConfig) color(c string) lipgloss.Style {
+	if ui.Plain {
+		return lipgloss.NewStyle()
+	}
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(c))
+}
+
+func newModel(ctx context.Context, c *client, a *agent, ui uiConfig) *model {
 	// Set a default size (this will be updated quickly)
 	w, h := 80, 24
 
@@ -41,25 +173,45 @@ func newModel(ctx context.Context, c *client, a *agent) *model {
 	// Create the viewport
 	vp := viewport.New(w, h-ta.Height())
 
-	// Load the chat history
-	hist, err := c.ListMessages(1)
+	// Combine and return
+	m := &model{
+		c:     c,
+		a:     a,
+		w:     w,
+		h:     h,
+		ctx:   ctx,
+		focus: "textarea",
+		vp:    &vp,
+		ta:    &ta,
+		sel:   -1,
+
+		expanded: map[int]bool{},
+		scroll:   map[int]int{},
+		ui:       ui,
+	}
+
+	// Pick up where we left off
+	cid, err := c.StartupChat(ui.DefaultChat)
 	if err != nil {
-		panic(err)
+		m.setErr(err)
+		return m
 	}
+	m.chatId = cid
 
-	// Combine and return
-	return &model{
-		c:      c,
-		a:      a,
-		chatId: 1,
-		w:      w,
-		h:      h,
-		ctx:    ctx,
-		focus:  "textarea",
-		vp:     &vp,
-		ta:     &ta,
-		hist:   hist,
+	// Restore any unsent draft
+	if d, err := c.GetDraft(cid); err != nil {
+		m.setErr(err)
+	} else {
+		m.ta.SetValue(d)
+	}
+
+	// Load the chat history
+	hist, err := c.ListMessages(m.chatId)
+	if err != nil {
+		m.setErr(fmt.Errorf("failed to load chat history: %w", err))
 	}
+	m.hist = hist
+	return m
 }
 
 func (m *model) Init() tea.Cmd {
@@ -67,6 +219,7 @@ func (m *model) Init() tea.Cmd {
 		func() tea.Msg {
 			return UpdateChatMsg{}
 		},
+		m.subscribe(),
 	)
 }
 
@@ -82,12 +235,87 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		// Set the viewport size
 		m.vp.Width = msg.Width
-		m.vp.Height = msg.Height - m.ta.Height()
+		m.resizeVP()
+
+		// And rewrap the messages to fit
+		m.updteVP()
 		return m, nil
 	case tea.KeyMsg:
+		// Answering the delete prompt? Anything but "y" cancels.
+		if m.confirmDel {
+			m.confirmDel = false
+			m.resizeVP()
+			if msg.String() == "y" {
+				return m, m.deleteChat()
+			}
+			return m, nil
+		}
+
+		// Approving the agent's delete? Anything but "y" rejects it.
+		if m.approving {
+			return m, m.approveToolCall(msg.String() == "y")
+		}
+
+		// Message actions only apply when the viewport is focused
+		if m.focus == "viewport" {
+			if cmd, ok := m.viewportKey(msg); ok {
+				return m, cmd
+			}
+		}
+		if m.focus == "viewport" && m.ui.Vim {
+			if cmd, ok := m.vimKey(msg); ok {
+				return m, cmd
+			}
+		}
+		if m.focus == "textarea" && !m.naming && !m.searching && !m.answering && m.saving == nil {
+			if m.textareaKey(msg) {
+				return m, nil
+			}
+		}
+
 		switch msg.String() {
 		case "ctrl+c":
+			m.saveDraft()
 			return m, tea.Quit
+		case "ctrl+n":
+			// Ask for the new chat's name
+			m.naming = true
+			m.stash = m.ta.Value()
+			m.ta.SetValue("")
+			m.ta.Placeholder = "Name the new chat (enter to create, esc to cancel)"
+			return m, func() tea.Msg { return SetFocusMsg{focus: "textarea"} }
+		case "ctrl+x":
+			// Ask before deleting the chat
+			m.confirmDel = true
+			m.resizeVP()
+			return m, nil
+		case "ctrl+g":
+			// Toggle the graph view
+			m.graphView = !m.graphView
+			m.graphRoot = 0
+			m.updteVP()
+			return m, nil
+		case "esc":
+			// Skip the agent's question
+			if m.answering {
+				return m, m.declineQuestion()
+			}
+
+			// Stop naming a new chat, searching, or saving
+			if m.naming || m.searching || m.saving != nil {
+				m.stopPrompt()
+				return m, nil
+			}
+
+			// Dismiss the error banner
+			if m.err != nil {
+				m.setErr(nil)
+				return m, nil
+			}
+
+			// Otherwise, cancel any in-flight generation
+			m.a.cancel(m.chatId)
+			return m, nil
 		case "tab":
 			if m.focus == "textarea" {
 				return m, tea.Batch(func() tea.Msg {
@@ -99,6 +327,19 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				})
 			}
 		case "enter":
+			if m.focus == "textarea" && m.naming {
+				return m, m.createChat()
+			}
+			if m.focus == "textarea" && m.searching {
+				return m, m.search()
+			}
+			if m.focus == "textarea" && m.saving != nil {
+				return m, m.saveResult()
+			}
+			if m.focus == "textarea" && m.answering {
+				text := m.ta.Value()
+				return m, func() tea.Msg { return UserAnswerMsg{text: text} }
+			}
 			if m.focus == "textarea" {
 				m.ta.Blur()
 				m.focus = "viewport"
@@ -132,84 +373,749 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.focus = "viewport"
 			m.ta.Blur()
 		}
+	case SwitchChatMsg:
+		// Keep the draft for when we come back (any question
+		// or approval is asked for again then)
+		if m.answering || m.saving != nil {
+			m.stopPrompt()
+		}
+		if m.approving {
+			m.approving = false
+			m.resizeVP()
+		}
+		m.saveDraft()
+
+		// Remember where this chat was scrolled to, unless it was
+		// following along at the bottom
+		switch {
+		case m.graphView:
+		case m.vp.AtBottom():
+			delete(m.scroll, m.chatId)
+		default:
+			m.scroll[m.chatId] = m.vp.YOffset
+		}
+		m.restoreScroll = true
+
+		m.chatId = msg.chatID
+		m.sel = -1
+		m.expanded = map[int]bool{}
+		m.histPos, m.draft = 0, ""
+		m.regenModel = ""
+		if d, err := m.c.GetDraft(m.chatId); err != nil {
+			m.setErr(err)
+		} else {
+			m.ta.SetValue(d)
+		}
+		if err := m.c.SetLastChat(m.chatId); err != nil {
+			m.setErr(err)
+		}
+		return m, tea.Batch(
+			func() tea.Msg { return UpdateChatMsg{} },
+			m.subscribe(),
+		)
 	case SendMessageMsg:
+		// Is it a command? Run it instead of sending it.
+		if strings.HasPrefix(msg.text, "/") {
+			m.ta.SetValue("")
+			return m, tea.Batch(
+				m.runCommand(msg.text),
+				func() tea.Msg { return SetFocusMsg{focus: "textarea"} },
+			)
+		}
+
+		// No model to answer? Say so, and keep the text.
+		if !m.a.online() {
+			m.setErr(errOffline)
+			return m, func() tea.Msg { return SetFocusMsg{focus: "textarea"} }
+		}
+
 		// Add the message to the database
 		if _, err := m.c.CreateMessage(Message{
 			ChatID:  m.chatId,
 			MType:   "user",
 			UserMsg: &struct{ Text string }{Text: msg.text},
 		}); err != nil {
-			panic(err)
+			m.setErr(fmt.Errorf("failed to send message: %w", err))
+			return m, func() tea.Msg { return SetFocusMsg{focus: "textarea"} }
 		}
 		m.ta.SetValue("")
+		m.histPos, m.draft = 0, ""
+		m.regenModel = ""
+		if err := m.c.SaveDraft(m.chatId, ""); err != nil {
+			m.setErr(err)
+		}
 		return m, tea.Batch(
 			func() tea.Msg { return UpdateChatMsg{} },
-			func() tea.Msg { return GenerateMsg{} },
+			m.generate(""),
 			func() tea.Msg { return SetFocusMsg{focus: "textarea"} },
 		)
 	case GenerateMsg:
-		// // TODO – Fill this in...
-		// m.a.gc <- struct{ cid int }{cid: 1}
-		// return m, nil
-
-		if _, err := m.a.generate(m.ctx, m.chatId, func() {}); err != nil {
-			panic(err)
+		// Hand the chat off to the worker, which carries
+		// on through any tool calls
+		req := genRequest{cid: msg.chatID, model: msg.model}
+		return m, func() tea.Msg {
+			m.a.gc <- req
+			return nil
+		}
+	case UserAnswerMsg:
+		// Answer the question, then carry on generating
+		if err := m.c.AnswerQuestion(m.chatId, strings.TrimSpace(msg.text)); err != nil {
+			m.setErr(err)
+			return m, nil
+		}
+		m.stopPrompt()
+		return m, tea.Batch(
+			func() tea.Msg { return UpdateChatMsg{} },
+			m.generate(m.regenModel),
+		)
+	case chatEventMsg:
+		// The chat's messages changed (e.g. a worker got further with
+		// it); show them, and wait for the next change. Events from a
+		// chat we've since switched away from are dropped.
+		if msg.events != m.events {
+			return m, nil
 		}
-		return m, tea.Batch(func() tea.Msg { return UpdateChatMsg{} })
+		return m, tea.Batch(
+			func() tea.Msg { return UpdateChatMsg{} },
+			waitForEvent(m.events),
+		)
+	case GenerateResponse:
+		// Did the worker fail? Show it (unless it was cancelled).
+		if msg.Error != nil && !errors.Is(msg.Error, context.Canceled) {
+			m.setErr(fmt.Errorf("failed to generate response: %w", msg.Error))
+		}
+		if msg.ChatID != m.chatId {
+			return m, nil
+		}
+		return m, func() tea.Msg { return UpdateChatMsg{} }
 	case UpdateChatMsg:
 		// Get the history for the chat and store it
-		hist, err := m.c.ListMessages(1)
+		hist, err := m.c.ListMessages(m.chatId)
 		if err != nil {
-			panic(err)
+			m.setErr(fmt.Errorf("failed to load chat history: %w", err))
+			return m, nil
 		}
 		m.hist = hist
+		m.sel = min(m.sel, len(hist)-1)
 
-		// Update the viewport content
+		// Update the viewport content, keeping up with new messages
+		// if it was at the bottom. A chat that was just switched to
+		// goes back to where it was left instead.
+		follow := m.vp.AtBottom()
 		m.updteVP()
+		switch off, ok := m.scroll[m.chatId]; {
+		case m.graphView:
+		case m.restoreScroll && ok:
+			m.vp.SetYOffset(off)
+		case m.restoreScroll || follow:
+			m.vp.GotoBottom()
+		}
+		m.restoreScroll = false
+
+		// Is the agent waiting on an answer? Ask for it, rather than
+		// carrying on.
+		if _, ok := pendingApproval(hist); ok {
+			if !m.approving {
+				m.approving = true
+				m.resizeVP()
+			}
+			return m, nil
+		}
+		if _, ok := pendingQuestion(hist); ok {
+			if m.answering {
+				return m, nil
+			}
+			m.answering = true
+			m.stash = m.ta.Value()
+			m.ta.SetValue("")
+			m.ta.Placeholder = "Answer the agent's question (enter to send, esc to skip)"
+			return m, func() tea.Msg { return SetFocusMsg{focus: "textarea"} }
+		}
+		if m.answering {
+			m.stopPrompt()
+		}
 
-		// Was the last message a tool call? Then keep going.
-		if n := len(hist); n > 0 && hist[n-1].MType == "tool" {
-			return m, tea.Batch(func() tea.Msg { return GenerateMsg{} })
+		// Once the agent replies, a regeneration is done
+		if n := len(hist); n > 0 && hist[n-1].MType == "agent" {
+			m.regenModel = ""
 		}
 		return m, nil
 	}
 	return m, nil
 }
 
-func (m *model) View() string {
-	return lipgloss.JoinVertical(lipgloss.Left,
-		m.vp.View(),
-		m.ta.View(),
+// runCommand runs a slash command entered in the textarea.
+func (m *model) runCommand(text string) tea.Cmd {
+	name, _, _ := strings.Cut(strings.TrimPrefix(text, "/"), " ")
+	switch name {
+	case "clear":
+		// Delete the chat's messages
+		if err := m.c.ClearChat(m.chatId); err != nil {
+			m.setErr(err)
+			return nil
+		}
+		m.sel = -1
+		return func() tea.Msg { return UpdateChatMsg{} }
+	case "regen":
+		// Regenerate the last response with another model
+		model := strings.TrimSpace(strings.TrimPrefix(text, "/regen"))
+		if model == "" {
+			m.setErr(fmt.Errorf("usage: /regen <model>"))
+			return nil
+		}
+		if !m.a.online() {
+			m.setErr(errOffline)
+			return nil
+		}
+		if _, err := m.c.DeleteTrailingReplies(m.chatId); err != nil {
+			m.setErr(err)
+			return nil
+		}
+		m.sel = -1
+		m.regenModel = model
+		return tea.Batch(
+			func() tea.Msg { return UpdateChatMsg{} },
+			m.generate(model),
+		)
+	case "graph":
+		// Show the graph around a node (or the whole graph)
+		m.graphRoot = 0
+		if arg := strings.TrimSpace(strings.TrimPrefix(text, "/graph")); arg != "" {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				m.setErr(fmt.Errorf("invalid node ID %q", arg))
+				return nil
+			}
+			m.graphRoot = id
+		}
+		m.graphView = true
+		m.updteVP()
+		return nil
+	default:
+		m.setErr(fmt.Errorf("unknown command %q", "/"+name))
+		return nil
+	}
+}
+
+// createChat creates a chat named by the textarea's contents
+// and switches to it.
+func (m *model) createChat() tea.Cmd {
+	name := strings.TrimSpace(m.ta.Value())
+	m.stopPrompt()
+	ci, err := m.c.CreateChat(name, "")
+	if err != nil {
+		m.setErr(err)
+		return nil
+	}
+	return func() tea.Msg { return SwitchChatMsg{chatID: ci.ID} }
+}
+
+// declineQuestion tells the agent the user won't answer its
+// question, then carries on generating.
+func (m *model) declineQuestion() tea.Cmd {
+	if err := m.c.DeclineQuestion(m.chatId); err != nil {
+		m.setErr(err)
+		return nil
+	}
+	m.stopPrompt()
+	return tea.Batch(
+		func() tea.Msg { return UpdateChatMsg{} },
+		m.generate(m.regenModel),
 	)
 }
 
+// approveToolCall runs (or rejects) the agent's delete that's
+// waiting for approval, then carries on generating.
+func (m *model) approveToolCall(approve bool) tea.Cmd {
+	m.approving = false
+	m.resizeVP()
+	var err error
+	if approve {
+		err = m.a.ApproveToolCall(context.Background(), m.chatId)
+	} else {
+		err = m.c.RejectToolCall(m.chatId)
+	}
+	if err != nil {
+		m.setErr(err)
+		return nil
+	}
+	return tea.Batch(
+		func() tea.Msg { return UpdateChatMsg{} },
+		m.generate(m.regenModel),
+	)
+}
+
+// stopPrompt puts the textarea back to sending messages.
+func (m *model) stopPrompt() {
+	m.naming, m.searching, m.answering = false, false, false
+	m.saving = nil
+	m.ta.SetValue(m.stash)
+	m.ta.Placeholder = ""
+}
+
+// saveDraft stores the current chat's unsent message. A chat that
+// has just been deleted has nothing to save.
+func (m *model) saveDraft() {
+	d := m.ta.Value()
+	switch {
+	case m.naming || m.searching || m.answering || m.saving != nil:
+		d = m.stash // Showing the new chat's name, a search, an answer, or a path
+	case m.histPos > 0:
+		d = m.draft // Showing a sent message
+	}
+	if err := m.c.SaveDraft(m.chatId, d); err != nil && !errors.Is(err, errNotFound) {
+		m.setErr(err)
+	}
+}
+
+// deleteChat deletes the current chat and switches to the one after
+// it (or before it, if it was the last), creating a new chat if there
+// are none left.
+func (m *model) deleteChat() tea.Cmd {
+	ci, err := m.c.GetChat(m.chatId)
+	if err != nil {
+		m.setErr(err)
+		return nil
+	}
+	if ci.State == "running" {
+		m.setErr(fmt.Errorf("chat %d is running", ci.ID))
+		return nil
+	}
+
+	// Find the neighbouring chat before it's gone
+	chats, err := m.c.ListChats()
+	if err != nil {
+		m.setErr(err)
+		return nil
+	}
+	next := 0
+	for i, c := range chats {
+		if c.ID != ci.ID {
+			continue
+		}
+		if i+1 < len(chats) {
+			next = chats[i+1].ID
+		} else if i > 0 {
+			next = chats[i-1].ID
+		}
+	}
+
+	if err := m.c.DeleteChat(ci.ID); err != nil {
+		m.setErr(err)
+		return nil
+	}
+	if next == 0 {
+		nc, err := m.c.CreateChat("", "")
+		if err != nil {
+			m.setErr(err)
+			return nil
+		}
+		next = nc.ID
+	}
+	return func() tea.Msg { return SwitchChatMsg{chatID: next} }
+}
+
+// textareaKey handles recalling previously sent messages while the
+// textarea is focused, reporting whether the key was handled. Up and
+// down only move through the history from the first and last lines,
+// so they still move the cursor within a multi-line message.
+func (m *model) textareaKey(msg tea.KeyMsg) bool {
+	var dir int
+	switch msg.String() {
+	case "up":
+		if m.ta.Line() != 0 {
+			return false
+		}
+		dir = 1
+	case "down":
+		if m.ta.Line() != m.ta.LineCount()-1 {
+			return false
+		}
+		dir = -1
+	default:
+		return false
+	}
+
+	sent := m.sentMessages()
+	pos := historyStep(m.histPos, dir, len(sent))
+	if pos == m.histPos {
+		return false
+	}
+
+	// Save the draft on the way out, and restore it on the way back
+	if m.histPos == 0 {
+		m.draft = m.ta.Value()
+	}
+	m.histPos = pos
+	if pos == 0 {
+		m.ta.SetValue(m.draft)
+	} else {
+		m.ta.SetValue(sent[len(sent)-pos])
+	}
+	return true
+}
+
+// historyStep moves pos (the number of messages back from the draft)
+// one step in dir (1 for older, -1 for newer), staying within the n
+// messages available.
+func historyStep(pos, dir, n int) int {
+	return min(max(pos+dir, 0), n)
+}
+
+// sentMessages returns the text of the user's messages
+// in the current chat, oldest first.
+func (m *model) sentMessages() []string {
+	var ts []string
+	for _, msg := range m.hist {
+		if msg.MType == "user" && msg.UserMsg != nil {
+			ts = append(ts, msg.UserMsg.Text)
+		}
+	}
+	return ts
+}
+
+// viewportKey handles the message actions available while the viewport
+// is focused, reporting whether the key was handled.
+func (m *model) viewportKey(msg tea.KeyMsg) (tea.Cmd, bool) {
+	switch msg.String() {
+	case "[":
+		// Select the previous message
+		if m.sel < 0 {
+			m.sel = len(m.hist)
+		}
+		m.sel = max(m.sel-1, 0)
+		if len(m.hist) == 0 {
+			m.sel = -1
+		}
+	case "]":
+		// Select the next message
+		m.sel = min(m.sel+1, len(m.hist)-1)
+	case "f":
+		// Fork the chat at the selected message
+		return m.forkSelected(), true
+	case "r":
+		// Retry generating from the selected error
+		return m.retrySelected(), true
+	case "s":
+		// Save the selected tool call's result to a file
+		return m.saveSelected(), true
+	case "p":
+		// Pin (or unpin) the selected message
+		return m.pinSelected(), true
+	case "enter":
+		// Expand or collapse the selected tool call
+		if m.sel < 0 || m.sel >= len(m.hist) || m.hist[m.sel].MType != "tool" {
+			return nil, false
+		}
+		id := m.hist[m.sel].MessageID
+		m.expanded[id] = !m.expanded[id]
+	default:
+		return nil, false
+	}
+	m.updteVP()
+	return nil, true
+}
+
+// pinSelected pins the selected message, so it's always sent to the
+// model, or unpins it if it's already pinned.
+func (m *model) pinSelected() tea.Cmd {
+	if m.sel < 0 || m.sel >= len(m.hist) {
+		return nil
+	}
+	msg := m.hist[m.sel]
+	if err := m.c.SetMessagePinned(m.chatId, msg.MessageID, !msg.Pinned); err != nil {
+		m.setErr(err)
+		return nil
+	}
+	return func() tea.Msg { return UpdateChatMsg{} }
+}
+
+// saveSelected asks where to save the selected tool call's full
+// result, suggesting a file in the results directory. It does nothing
+// if the selected message isn't a tool call.
+func (m *model) saveSelected() tea.Cmd {
+	if m.sel < 0 || m.sel >= len(m.hist) || m.hist[m.sel].MType != "tool" {
+		return nil
+	}
+	msg := m.hist[m.sel]
+	if _, err := toolResultJSON(msg); err != nil {
+		m.setErr(err)
+		return nil
+	}
+	m.saving = &msg
+	m.stash = m.ta.Value()
+	dir := m.ui.ResultsDir
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(m.c.dbp), resultsDir)
+	}
+	m.ta.SetValue(filepath.Join(dir, resultFileName(msg)))
+	m.ta.Placeholder = "File to save the result to (enter to save, esc to cancel)"
+	return func() tea.Msg { return SetFocusMsg{focus: "textarea"} }
+}
+
+// saveResult saves the tool call's result to the file named in
+// the textarea.
+func (m *model) saveResult() tea.Cmd {
+	msg, p := *m.saving, strings.TrimSpace(m.ta.Value())
+	m.stopPrompt()
+	if p == "" {
+		return nil
+	}
+	if err := saveToolResult(msg, p); err != nil {
+		m.setErr(err)
+		return nil
+	}
+	if m.ta.Value() == "" {
+		m.ta.Placeholder = "Saved the result to " + p
+	}
+	return nil
+}
+
+// retrySelected clears the selected error message and generates
+// the chat's response again. It does nothing if the selected message
+// isn't an error or the chat is still running.
+func (m *model) retrySelected() tea.Cmd {
+	if m.sel < 0 || m.sel >= len(m.hist) || m.hist[m.sel].MType != "error" {
+		return nil
+	}
+	if !m.a.online() {
+		m.setErr(errOffline)
+		return nil
+	}
+	ok, err := m.c.ClearErrorMessage(m.chatId, m.hist[m.sel].MessageID)
+	if err != nil {
+		m.setErr(err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	m.sel = -1
+	m.setErr(nil)
+	return tea.Batch(
+		func() tea.Msg { return UpdateChatMsg{} },
+		m.generate(m.regenModel),
+	)
+}
+
+// subscribe starts listening for changes to the current chat's
+// messages, ending any subscription to the one before.
+func (m *model) subscribe() tea.Cmd {
+	if m.unsub != nil {
+		m.unsub()
+	}
+	m.events, m.unsub = m.c.Subscribe(m.chatId)
+	return waitForEvent(m.events)
+}
+
+// waitForEvent waits for the next change on a subscription,
+// which is sent as a chatEventMsg. It stops once the
+// subscription has been ended.
+func waitForEvent(events <-chan MessageEvent) tea.Cmd {
+	return func() tea.Msg {
+		if _, ok := <-events; !ok {
+			return nil
+		}
+		return chatEventMsg{events: events}
+	}
+}
+
+// generate asks for the current chat's response (with model, if it
+// isn't empty). The chat is fixed now, so switching chats before the
+// request is handled doesn't send it to the wrong one.
+func (m *model) generate(model string) tea.Cmd {
+	cid := m.chatId
+	return func() tea.Msg { return GenerateMsg{chatID: cid, model: model} }
+}
+
+// forkSelected forks the current chat at the selected
+// message and switches to the new chat.
+func (m *model) forkSelected() tea.Cmd {
+	if m.sel < 0 || m.sel >= len(m.hist) {
+		return nil
+	}
+	ci, err := m.c.GetChat(m.chatId)
+	if err != nil {
+		m.setErr(err)
+		return nil
+	}
+	fc, err := m.c.ForkChat(m.chatId, m.hist[m.sel].MessageID, ci.Name+" (fork)")
+	if err != nil {
+		m.setErr(err)
+		return nil
+	}
+	return func() tea.Msg { return SwitchChatMsg{chatID: fc.ID} }
+}
+
+func (m *model) View() string {
+	parts := []string{m.vp.View()}
+	if m.confirmDel {
+		parts = append(parts, m.confirmView())
+	}
+	if m.approving {
+		parts = append(parts, m.approveView())
+	}
+	if m.err != nil {
+		parts = append(parts, m.bannerView())
+	}
+	parts = append(parts, m.ta.View())
+	return lipgloss.JoinVertical(lipgloss.Left, parts...)
+}
+
+// setErr sets (or clears, if nil) the error shown in the banner.
+func (m *model) setErr(err error) {
+	m.err = err
+	m.resizeVP()
+}
+
+// bannerView renders the error banner.
+func (m *model) bannerView() string {
+	return lipgloss.
+		NewStyle().
+		Width(m.w).
+		Foreground(lipgloss.Color("#FFFFFF")).
+		Background(lipgloss.Color("#C0392B")).
+		Render(wordwrap.String("Error: "+m.err.Error()+" (esc to dismiss)", m.w))
+}
+
+// confirmView renders the prompt asking to confirm deleting the chat.
+func (m *model) confirmView() string {
+	name := fmt.Sprintf("chat %d", m.chatId)
+	if ci, err := m.c.GetChat(m.chatId); err == nil {
+		name = fmt.Sprintf("%q", ci.Name)
+	}
+	return lipgloss.
+		NewStyle().
+		Width(m.w).
+		Foreground(lipgloss.Color("#000000")).
+		Background(lipgloss.Color("#F1C40F")).
+		Render(wordwrap.String("Delete "+name+" and all its messages? (y/N)", m.w))
+}
+
+// approvalEdges is the most edges the approval prompt lists.
+const approvalEdges = 5
+
+// approveView asks the user to approve the agent's delete,
+// showing what it would remove.
+func (m *model) approveView() string {
+	text := "The agent wants to delete something."
+	if msg, ok := pendingApproval(m.hist); ok {
+		text = approvalText(*msg, approvalEdges)
+	}
+	return lipgloss.
+		NewStyle().
+		Width(m.w).
+		Foreground(lipgloss.Color("#000000")).
+		Background(lipgloss.Color("#F1C40F")).
+		Render(wordwrap.String(text+"\n(y to approve, anything else to reject)", m.w))
+}
+
+// resizeVP fits the viewport into the space left over by the
+// textarea, the delete and approval prompts, and the error banner.
+func (m *model) resizeVP() {
+	h := m.h - m.ta.Height()
+	if m.confirmDel {
+		h -= lipgloss.Height(m.confirmView())
+	}
+	if m.approving {
+		h -= lipgloss.Height(m.approveView())
+	}
+	if m.err != nil {
+		h -= lipgloss.Height(m.bannerView())
+	}
+	m.vp.Height = max(h, 0)
+}
+
 func (m *model) updteVP() {
+	if m.graphView {
+		g, err := m.c.ForChat(m.chatId)
+		if err != nil {
+			m.setErr(fmt.Errorf("failed to load graph: %w", err))
+			return
+		}
+		ns, es, err := g.loadGraphView(m.graphRoot)
+		if err != nil {
+			m.setErr(fmt.Errorf("failed to load graph: %w", err))
+			return
+		}
+		m.vp.SetContent(renderGraph(ns, es, m.w))
+		return
+	}
+
 	var parts []string
-	for _, msg := range m.hist {
-		switch msg.MType {
+	m.msgLines = make([]int, len(m.hist))
+	var line int
+	for i, msg := range m.hist {
+		// Don't trust records that are missing their payload
+		mtype := msg.MType
+		if !msg.Valid() {
+			mtype = "malformed"
+		}
+
+		dim := m.ui.color("#AAAFBE")
+		prefix := m.ui.prefix(mtype)
+		if msg.Pinned {
+			prefix = dim.Render(m.ui.pinMark()) + prefix
+		}
+		width := max(m.w-lipgloss.Width(prefix)-m.ui.WrapMargin, 1)
+		switch mtype {
 		case "user":
 			parts = append(parts, lipgloss.JoinHorizontal(
 				lipgloss.Top,
-				"👨‍💻: ",
-				wordwrap.String(msg.UserMsg.Text, m.w-4),
+				prefix,
+				m.ui.wrap(msg.UserMsg.Text, width),
 			))
 		case "agent":
+			text := renderAgentText(msg.AgentMsg.Text, width, m.ui)
+			if msg.AgentMsg.StopReason == "length" {
+				// Let the user know the reply was cut short
+				text += "\n" + dim.Render("[reply cut off at the token limit]")
+			}
+			if ids := msg.AgentMsg.CitedNodeIDs; len(ids) > 0 {
+				// And which nodes it drew on
+				refs := make([]string, len(ids))
+				for i, id := range ids {
+					refs[i] = fmt.Sprintf("#%d", id)
+				}
+				text += "\n" + dim.Render(m.ui.wrap("sources: "+strings.Join(refs, ", "), width))
+			}
 			parts = append(parts, lipgloss.JoinHorizontal(
 				lipgloss.Top,
-				"🤖: ",
-				wordwrap.String(msg.AgentMsg.Text, m.w-4),
+				prefix,
+				text,
 			))
 		case "tool":
-			parts = append(parts, fmt.Sprintf(
-				"🛠️: %s",
-				lipgloss.
-					NewStyle().
-					Foreground(lipgloss.Color("#AAAFBE")).
-					Render("Calling "+msg.ToolMsg.ToolName+"()..."),
+			parts = append(parts, lipgloss.JoinHorizontal(
+				lipgloss.Top,
+				prefix,
+				renderToolMsg(msg, m.expanded[msg.MessageID], width, m.ui),
+			))
+		case "summary":
+			parts = append(parts, prefix+dim.Render(fmt.Sprintf("Summarized %d earlier messages", msg.SummaryMsg.Count)))
+		case "error":
+			parts = append(parts, lipgloss.JoinHorizontal(
+				lipgloss.Top,
+				prefix,
+				m.ui.color("#E74C3C").Render(m.ui.wrap("Failed to generate a response: "+msg.ErrorMsg.Text, width)),
 			))
 		default:
-			panic(fmt.Sprintf("unknown message type %q", msg.MType))
+			parts = append(parts, prefix+dim.Render("[malformed message]"))
+		}
+
+		// Highlight the selected message
+		if i == m.sel {
+			if m.ui.Plain {
+				parts[len(parts)-1] = lipgloss.JoinHorizontal(lipgloss.Top, "> ", parts[len(parts)-1])
+			} else {
+				parts[len(parts)-1] = lipgloss.
+					NewStyle().
+					Border(lipgloss.ThickBorder(), false, false, false, true).
+					BorderForeground(lipgloss.Color("#7D56F4")).
+					Render(parts[len(parts)-1])
+			}
 		}
+		m.msgLines[i] = line
+		line += lipgloss.Height(parts[len(parts)-1])
 	}
 
 	// Generate the text
@@ -219,6 +1125,105 @@ func (m *model) updteVP() {
 	m.vp.SetContent(s)
 }
 
+// renderAgentText renders an agent's reply. Prose is word wrapped, and
+// fenced code blocks are set apart from it (in their own color, with a
+// gutter) and hard wrapped, so indentation is kept. In plain mode the
+// fences are left in and the code isn't colored.
+func renderAgentText(text string, width int, ui uiConfig) string {
+	var out, prose []string
+	flush := func() {
+		if len(prose) > 0 {
+			out = append(out, ui.wrap(strings.Join(prose, "\n"), width))
+			prose = nil
+		}
+	}
+
+	gutter := "│ "
+	code := ui.color("#8FBCBB")
+	inCode := false
+	for _, line := range strings.Split(text, "\n") {
+		// Opening or closing a fence?
+		if t := strings.TrimSpace(line); strings.HasPrefix(t, "```") {
+			flush()
+			inCode = !inCode
+			if ui.Plain {
+				out = append(out, t)
+			} else if inCode {
+				out = append(out, ui.color("#AAAFBE").Render("┌ "+strings.TrimPrefix(t, "```")))
+			}
+			continue
+		}
+		if !inCode {
+			prose = append(prose, line)
+			continue
+		}
+
+		// Wrap long lines of code rather than dropping them
+		if ui.Plain {
+			out = append(out, wrap.String(line, width))
+			continue
+		}
+		for _, l := range strings.Split(wrap.String(line, width-lipgloss.Width(gutter)), "\n") {
+			out = append(out, ui.color("#AAAFBE").Render(gutter)+code.Render(l))
+		}
+	}
+	flush()
+	return strings.Join(out, "\n")
+}
+
+// renderToolMsg renders a tool call. Collapsed, it's just the tool's
+// name; expanded, it also shows the arguments and (once the call is
+// done) the result, or the error in red.
+func renderToolMsg(msg Message, expanded bool, width int, ui uiConfig) string {
+	dim := ui.color("#AAAFBE")
+	tm := msg.ToolMsg
+	if tm.ToolName == askUserTool && !expanded {
+		// Show the question itself, and the answer once there is one
+		text := "Asked: " + questionText(msg)
+		if _, waiting := pendingQuestion([]Message{msg}); waiting {
+			text += "\n" + dim.Render("Waiting for your answer...")
+		}
+		return ui.wrap(text, width)
+	}
+	if !expanded {
+		status := "..."
+		switch _, waiting := pendingApproval([]Message{msg}); {
+		case waiting:
+			status = " (waiting for your approval)"
+		case tm.ToolDone:
+			status = " (enter to expand)"
+		}
+		return dim.Render("Calling " + tm.ToolName + "()" + status)
+	}
+
+	args, err := json.MarshalIndent(tm.ToolArgs, "", "  ")
+	if err != nil {
+		args = []byte(fmt.Sprintf("%v", tm.ToolArgs))
+	}
+	lines := []string{
+		dim.Render("Called " + tm.ToolName + " with:"),
+		ui.wrap(string(args), width),
+	}
+	switch {
+	case !tm.ToolDone:
+		lines = append(lines, dim.Render("Waiting for the result..."))
+	case tm.ToolPreview != "" && tm.ToolResult == "" && tm.ToolError == "":
+		lines = append(lines, dim.Render("Waiting for your approval to run it:"), ui.wrap(approvalText(msg, approvalEdges), width))
+	case tm.ToolError != "":
+		lines = append(lines, ui.color("#E74C3C").Render(ui.wrap("Error: "+tm.ToolError, width)))
+	default:
+		result, ok := "", false
+		if ui.Tables {
+			result, ok = resultTable(tm.ToolResult, width)
+		}
+		if !ok {
+			result = ui.wrap(tm.ToolResult, width)
+		}
+		lines = append(lines, dim.Render("Result:"), result)
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, lines...)
+}
+
 type SendMessageMsg struct {
 	text string
 }
@@ -227,6 +1232,31 @@ type SetFocusMsg struct {
 	focus string
 }
 
-type GenerateMsg struct{}
+type SwitchChatMsg struct {
+	chatID int
+}
+
+// UserAnswerMsg carries the user's answer to the agent's question.
+type UserAnswerMsg struct {
+	text string
+}
+
+type GenerateMsg struct {
+	chatID int    // Chat to generate for
+	model  string // Model to use instead of the default (if not empty)
+}
+
+// chatEventMsg is sent when the messages of the chat being
+// listened to through events change.
+type chatEventMsg struct {
+	events <-chan MessageEvent
+}
 
 type UpdateChatMsg struct{}
+
+// GenerateResponse is sent by the worker once it has
+// finished generating a response for a chat.
+type GenerateResponse struct {
+	ChatID int
+	Error  error
+}
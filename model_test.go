@@ -0,0 +1,569 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	ollama "github.com/ollama/ollama/api"
+)
+
+// newTestModel creates a TUI model showing a chat, with an agent
+// talking to the (fake) ollama server at url.
+func newTestModel(t *testing.T, c *client, url string, cid int, ui uiConfig) *model {
+	t.Helper()
+	m := newModel(context.Background(), c, newTestAgent(t, c, url, agentConfig{}), ui)
+	if m.err != nil {
+		t.Fatalf("failed to create model: %v", m.err)
+	}
+	m.chatId = cid
+	m.Update(UpdateChatMsg{})
+	return m
+}
+
+// runCmd runs a command (and any it batches), returning the
+// messages they produce. It mustn't be given a command that waits,
+// like waitForEvent.
+func runCmd(cmd tea.Cmd) []tea.Msg {
+	if cmd == nil {
+		return nil
+	}
+	switch msg := cmd().(type) {
+	case nil:
+		return nil
+	case tea.BatchMsg:
+		var msgs []tea.Msg
+		for _, c := range msg {
+			msgs = append(msgs, runCmd(c)...)
+		}
+		return msgs
+	default:
+		return []tea.Msg{msg}
+	}
+}
+
+func TestRegenWithModel(t *testing.T) {
+	url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+		return textReply("from " + req.Model)
+	})
+	c := newTestClient(t)
+	ci, err := c.CreateChat("regen", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "ua")
+	m := newTestModel(t, c, url, ci.ID, uiConfig{})
+
+	// Regenerating drops the old reply and asks for one from the model
+	var gen *GenerateMsg
+	for _, msg := range runCmd(m.runCommand("/regen llama3")) {
+		if g, ok := msg.(GenerateMsg); ok {
+			gen = &g
+		}
+	}
+	if m.err != nil {
+		t.Fatalf("regen failed: %v", m.err)
+	}
+	if gen == nil || gen.model != "llama3" || gen.chatID != ci.ID {
+		t.Fatalf("regen asked for %+v, want llama3 for chat %d", gen, ci.ID)
+	}
+	if got := messageIDsOf(t, c, ci.ID); len(got) != 1 {
+		t.Fatalf("messages left = %v, want just the user's", got)
+	}
+
+	// Which the worker uses, for this reply only
+	if err := m.a.run(context.Background(), genRequest{cid: gen.chatID, model: gen.model}); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := c.ListMessages(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := ms[len(ms)-1]; last.Model != "llama3" || last.AgentMsg.Text != "from llama3" {
+		t.Errorf("reply came from %q (%q), want llama3", last.Model, last.AgentMsg.Text)
+	}
+	m.Update(UpdateChatMsg{})
+	if m.regenModel != "" {
+		t.Errorf("regen model is still %q after the reply", m.regenModel)
+	}
+}
+
+func TestRegenNeedsModel(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("regen", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "ua")
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{})
+	if runCmd(m.runCommand("/regen")) != nil || m.err == nil {
+		t.Error("regen without a model didn't fail")
+	}
+	if got := messageIDsOf(t, c, ci.ID); len(got) != 2 {
+		t.Errorf("messages left = %v, want both", got)
+	}
+}
+
+func TestScrollRestoredPerChat(t *testing.T) {
+	c := newTestClient(t)
+	var ids []int
+	for _, name := range []string{"a", "b"} {
+		ci, err := c.CreateChat(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 100 {
+			addUserMessage(t, c, ci.ID, fmt.Sprintf("%s %d", name, i))
+		}
+		ids = append(ids, ci.ID)
+	}
+	a, b := ids[0], ids[1]
+	m := newTestModel(t, c, fakeOllama(t, nil), a, uiConfig{})
+	m.Update(tea.WindowSizeMsg{Width: 80, Height: 30})
+	switchTo := func(cid int) {
+		t.Helper()
+		m.Update(SwitchChatMsg{chatID: cid})
+		m.Update(UpdateChatMsg{})
+		if m.chatId != cid {
+			t.Fatalf("showing chat %d, want %d", m.chatId, cid)
+		}
+	}
+
+	// A chat that hasn't been scrolled starts at the bottom
+	m.vp.SetYOffset(10)
+	switchTo(b)
+	if !m.vp.AtBottom() {
+		t.Errorf("new chat's offset = %d, want the bottom", m.vp.YOffset)
+	}
+
+	// Each chat goes back to where it was left
+	m.vp.SetYOffset(3)
+	switchTo(a)
+	if m.vp.YOffset != 10 {
+		t.Errorf("first chat's offset = %d, want 10", m.vp.YOffset)
+	}
+	m.vp.GotoBottom()
+	switchTo(b)
+	if m.vp.YOffset != 3 {
+		t.Errorf("second chat's offset = %d, want 3", m.vp.YOffset)
+	}
+
+	// A chat left at the bottom keeps following new messages
+	addUserMessage(t, c, a, "new")
+	switchTo(a)
+	if !m.vp.AtBottom() {
+		t.Errorf("followed chat's offset = %d, want the bottom", m.vp.YOffset)
+	}
+	if _, ok := m.scroll[a]; ok {
+		t.Error("saved an offset for a chat left at the bottom")
+	}
+}
+
+func TestRetrySelected(t *testing.T) {
+	url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+		return textReply("it worked this time")
+	})
+	c := newTestClient(t)
+	ci, err := c.CreateChat("retry", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "ue")
+	m := newTestModel(t, c, url, ci.ID, uiConfig{})
+
+	// Only error messages can be retried
+	m.sel = 0
+	if cmd := m.retrySelected(); cmd != nil {
+		t.Error("retried a user message")
+	}
+
+	// Nor while the chat's running
+	m.sel = 1
+	if err := c.SetChatState(ci.ID, "running"); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := m.retrySelected(); cmd != nil {
+		t.Error("retried while the chat was running")
+	}
+	if err := c.SetChatState(ci.ID, "idle"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retrying clears the error and generates again
+	var gen *GenerateMsg
+	for _, msg := range runCmd(m.retrySelected()) {
+		if g, ok := msg.(GenerateMsg); ok {
+			gen = &g
+		}
+	}
+	if gen == nil || gen.chatID != ci.ID {
+		t.Fatalf("retry asked for %+v, want a generation for chat %d", gen, ci.ID)
+	}
+	if m.sel != -1 {
+		t.Errorf("selection = %d, want none", m.sel)
+	}
+	if err := m.a.run(context.Background(), genRequest{cid: gen.chatID}); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := c.ListMessages(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageTypes(ms); got != "ua" {
+		t.Fatalf("messages = %s, want ua", got)
+	}
+	if text := ms[1].AgentMsg.Text; text != "it worked this time" {
+		t.Errorf("reply = %q", text)
+	}
+}
+
+func TestSwitchChatSetsLastChat(t *testing.T) {
+	c := newTestClient(t)
+	first, err := c.CreateChat("first", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.CreateChat("second", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newTestModel(t, c, fakeOllama(t, nil), first.ID, uiConfig{})
+	m.Update(SwitchChatMsg{chatID: second.ID})
+	if id, err := c.StartupChat(""); err != nil || id != second.ID {
+		t.Errorf("StartupChat() after switching = %d, %v; want %d", id, err, second.ID)
+	}
+}
+
+// switchedTo returns the chat msgs switch to (0 if none).
+func switchedTo(msgs []tea.Msg) int {
+	for _, msg := range msgs {
+		if s, ok := msg.(SwitchChatMsg); ok {
+			return s.chatID
+		}
+	}
+	return 0
+}
+
+func TestDeleteChat(t *testing.T) {
+	tests := []struct {
+		name   string
+		chats  int // Number of chats
+		delete int // Index of the chat to delete
+		want   int // Index of the chat to switch to (-1 for a new one)
+	}{
+		{"switches to the next chat", 3, 1, 2},
+		{"or the one before the last", 3, 2, 1},
+		{"from the first", 3, 0, 1},
+		{"makes a new chat for the only one", 1, 0, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			var ids []int
+			for i := range tt.chats {
+				ci, err := c.CreateChat(fmt.Sprint(i), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, ci.ID)
+			}
+			m := newTestModel(t, c, fakeOllama(t, nil), ids[tt.delete], uiConfig{})
+
+			// It asks first
+			if _, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlX}); cmd != nil || !m.confirmDel {
+				t.Fatal("ctrl+x didn't ask to delete the chat")
+			}
+			_, cmd := m.Update(runeKey('y'))
+			next := switchedTo(runCmd(cmd))
+
+			if _, err := c.GetChat(ids[tt.delete]); !errors.Is(err, errNotFound) {
+				t.Errorf("deleted chat: error = %v, want errNotFound", err)
+			}
+			if tt.want >= 0 {
+				if next != ids[tt.want] {
+					t.Errorf("switched to chat %d, want %d", next, ids[tt.want])
+				}
+				return
+			}
+			ci, err := c.GetChat(next)
+			if err != nil {
+				t.Fatalf("switched to chat %d: %v", next, err)
+			}
+			if !ci.Untitled {
+				t.Errorf("new chat = %+v, want an untitled one", ci)
+			}
+		})
+	}
+}
+
+func TestDeleteChatCancelled(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("keep", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{})
+
+	// Anything but "y" keeps it
+	m.Update(tea.KeyMsg{Type: tea.KeyCtrlX})
+	if _, cmd := m.Update(runeKey('n')); cmd != nil || m.confirmDel {
+		t.Error("n didn't cancel deleting the chat")
+	}
+
+	// As does it running
+	if err := c.SetChatState(ci.ID, "running"); err != nil {
+		t.Fatal(err)
+	}
+	m.Update(tea.KeyMsg{Type: tea.KeyCtrlX})
+	if _, cmd := m.Update(runeKey('y')); cmd != nil || m.err == nil {
+		t.Error("deleted a running chat")
+	}
+	if _, err := c.GetChat(ci.ID); err != nil {
+		t.Errorf("chat was deleted: %v", err)
+	}
+}
+
+func TestCreateChat(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("first", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{})
+	m.ta.SetValue("a draft")
+
+	// Cancelling gives back the draft
+	m.Update(tea.KeyMsg{Type: tea.KeyCtrlN})
+	if !m.naming || m.ta.Value() != "" {
+		t.Fatalf("ctrl+n didn't ask for a name (textarea %q)", m.ta.Value())
+	}
+	m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if m.naming || m.ta.Value() != "a draft" {
+		t.Errorf("esc left naming %v, textarea %q", m.naming, m.ta.Value())
+	}
+
+	// Naming it creates it and switches to it
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlN})
+	for _, msg := range runCmd(cmd) {
+		m.Update(msg)
+	}
+	m.ta.SetValue("  research  ")
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	next := switchedTo(runCmd(cmd))
+	nc, err := c.GetChat(next)
+	if err != nil {
+		t.Fatalf("switched to chat %d: %v", next, err)
+	}
+	if nc.Name != "research" {
+		t.Errorf("new chat's name = %q, want research", nc.Name)
+	}
+	if m.naming {
+		t.Error("still naming after creating the chat")
+	}
+}
+
+func TestHistoryStep(t *testing.T) {
+	tests := []struct {
+		pos, dir, n int
+		want        int
+	}{
+		{0, 1, 3, 1},
+		{1, 1, 3, 2},
+		{3, 1, 3, 3}, // Stops at the oldest
+		{2, -1, 3, 1},
+		{0, -1, 3, 0}, // Stops at the draft
+		{0, 1, 0, 0},  // Nothing sent yet
+	}
+	for _, tt := range tests {
+		if got := historyStep(tt.pos, tt.dir, tt.n); got != tt.want {
+			t.Errorf("historyStep(%d, %d, %d) = %d, want %d", tt.pos, tt.dir, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestHistoryRecall(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("history", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"first", "second"} {
+		addUserMessage(t, c, ci.ID, text)
+	}
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{})
+	m.ta.SetValue("half typed")
+
+	up, down := tea.KeyMsg{Type: tea.KeyUp}, tea.KeyMsg{Type: tea.KeyDown}
+	for _, tt := range []struct {
+		key  tea.KeyMsg
+		want string
+	}{
+		{up, "second"},
+		{up, "first"},
+		{up, "first"}, // Nothing older
+		{down, "second"},
+		{down, "half typed"}, // Back to the draft
+		{down, "half typed"},
+	} {
+		m.Update(tt.key)
+		if got := m.ta.Value(); got != tt.want {
+			t.Errorf("after %s, textarea = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+
+	// In a multi-line message, up moves the cursor until the first line
+	m.ta.SetValue("line one\nline two")
+	m.Update(up)
+	if got := m.ta.Value(); got != "line one\nline two" || m.ta.Line() != 0 {
+		t.Errorf("up on the last line: textarea = %q (line %d)", got, m.ta.Line())
+	}
+	m.Update(up)
+	if got := m.ta.Value(); got != "second" {
+		t.Errorf("up on the first line: textarea = %q, want second", got)
+	}
+}
+
+func TestDraftSavedPerChat(t *testing.T) {
+	c := newTestClient(t)
+	a, err := c.CreateChat("a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.CreateChat("b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fakeOllama(t, nil)
+	m := newTestModel(t, c, url, a.ID, uiConfig{})
+
+	// Switching keeps each chat's draft
+	m.ta.SetValue("draft for a")
+	m.Update(SwitchChatMsg{chatID: b.ID})
+	if got := m.ta.Value(); got != "" {
+		t.Errorf("b's textarea = %q, want it empty", got)
+	}
+	m.ta.SetValue("draft for b")
+	m.Update(SwitchChatMsg{chatID: a.ID})
+	if got := m.ta.Value(); got != "draft for a" {
+		t.Errorf("a's textarea = %q, want its draft", got)
+	}
+
+	// And quitting keeps it for next time
+	m.Update(tea.KeyMsg{Type: tea.KeyCtrlC})
+	m = newTestModel(t, c, url, a.ID, uiConfig{})
+	if got := m.ta.Value(); got != "draft for a" {
+		t.Errorf("textarea on reopening = %q, want a's draft", got)
+	}
+
+	// Until it's sent
+	m.Update(SendMessageMsg{text: "draft for a"})
+	if d, err := c.GetDraft(a.ID); err != nil || d != "" {
+		t.Errorf("draft after sending = %q, %v; want none", d, err)
+	}
+	if d, err := c.GetDraft(b.ID); err != nil || d != "draft for b" {
+		t.Errorf("b's draft = %q, %v; want it kept", d, err)
+	}
+}
+
+func TestErrorBanner(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("banner", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{})
+	m.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+
+	// A failed generation shows up in the banner
+	m.Update(GenerateResponse{ChatID: ci.ID, Error: errors.New("model exploded")})
+	if !strings.Contains(m.View(), "Error: failed to generate response: model exploded") {
+		t.Fatalf("view doesn't show the error:\n%s", m.View())
+	}
+
+	// Esc dismisses it
+	m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if m.err != nil || strings.Contains(m.View(), "Error:") {
+		t.Fatalf("banner wasn't dismissed:\n%s", m.View())
+	}
+
+	// Cancelled generations aren't errors
+	m.Update(GenerateResponse{ChatID: ci.ID, Error: context.Canceled})
+	if m.err != nil {
+		t.Errorf("cancelled generation set error %v", m.err)
+	}
+
+	// Database failures show the banner rather than crashing
+	c.Close()
+	for _, msg := range []tea.Msg{UpdateChatMsg{}, SendMessageMsg{text: "hi"}} {
+		m.setErr(nil)
+		if _, cmd := m.Update(msg); cmd != nil {
+			runCmd(cmd)
+		}
+		if m.err == nil || !strings.Contains(m.View(), "Error:") {
+			t.Errorf("%T with a closed database didn't show an error:\n%s", msg, m.View())
+		}
+	}
+}
+
+func TestForkSelected(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("branch", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "uaua")
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{})
+	m.Update(SetFocusMsg{focus: "viewport"})
+
+	// Nothing selected, nothing to fork
+	if _, cmd := m.Update(runeKey('f')); switchedTo(runCmd(cmd)) != 0 {
+		t.Fatal("forked without a selected message")
+	}
+
+	// Forking switches to a new chat with the messages so far
+	m.Update(runeKey('['))
+	m.Update(runeKey('['))
+	_, cmd := m.Update(runeKey('f'))
+	fid := switchedTo(runCmd(cmd))
+	if fid == 0 || fid == ci.ID {
+		t.Fatalf("forking switched to chat %d, want a new chat", fid)
+	}
+	fc, err := c.GetChat(fid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fc.Name != "branch (fork)" {
+		t.Errorf("fork is named %q, want %q", fc.Name, "branch (fork)")
+	}
+	if got := messageIDsOf(t, c, fid); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("fork has messages %v, want [1 2 3]", got)
+	}
+}
+
+func TestClearCommand(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("clear", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "uaua")
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{})
+	m.sel = 2
+
+	for _, msg := range runCmd(m.runCommand("/clear")) {
+		m.Update(msg)
+	}
+	if m.err != nil {
+		t.Fatal(m.err)
+	}
+	if len(m.hist) != 0 || m.sel != -1 {
+		t.Errorf("after /clear, history = %v, selected = %d, want it empty", m.hist, m.sel)
+	}
+	if _, err := c.GetChat(ci.ID); err != nil {
+		t.Errorf("chat is gone after /clear: %v", err)
+	}
+}
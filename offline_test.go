@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+func TestOfflineMode(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("offline", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "hello")
+
+	// Nothing is listening at the URL, but staying offline doesn't need it
+	a := newTestAgent(t, c, "http://127.0.0.1:1", agentConfig{Offline: true})
+	if a.online() {
+		t.Fatal("offline agent says it's online")
+	}
+	if _, err := a.generate(context.Background(), ci.ID, ""); !errors.Is(err, errOffline) {
+		t.Errorf("generate() = %v, want %v", err, errOffline)
+	}
+
+	// Chats can still be read
+	ms, err := c.ListMessages(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 1 {
+		t.Errorf("got %d messages, want 1", len(ms))
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	var up atomic.Bool
+	chat := fakeOllama(t, func(ollama.ChatRequest) ollama.ChatResponse { return textReply("hi") })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, chat+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := newTestClient(t)
+	ci, err := c.CreateChat("reconnect", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "hello")
+
+	// Ollama isn't up yet, so the agent starts offline
+	a := newTestAgent(t, c, srv.URL, agentConfig{})
+	if a.online() {
+		t.Fatal("agent is online before ollama is")
+	}
+	if _, err := a.generate(context.Background(), ci.ID, ""); !errors.Is(err, errOffline) {
+		t.Errorf("generate() = %v, want %v", err, errOffline)
+	}
+
+	// Once it's up, the agent reconnects, but only after a while
+	up.Store(true)
+	if a.online() {
+		t.Error("agent reconnected before the retry interval")
+	}
+	a.lastRetry = time.Now().Add(-reconnectInterval)
+	if !a.online() {
+		t.Fatal("agent didn't reconnect")
+	}
+	m, err := a.generate(context.Background(), ci.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.AgentMsg == nil || m.AgentMsg.Text != "hi" {
+		t.Errorf("generate() = %+v, want the reply", m)
+	}
+}
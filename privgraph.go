@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// graphBucket returns the name of one of the graph's buckets (nodes,
+// edges, or embeddings) for the graph the client works on. The shared
+// graph uses the plain names; a private graph's are suffixed with its
+// name, so its nodes and edges are kept entirely apart.
+func (c *client) graphBucket(base string) []byte {
+	if c.graph == "" {
+		return []byte(base)
+	}
+	return []byte(base + "@" + c.graph)
+}
+
+// withGraph returns a client for the same database whose graph methods
+// work on the named private graph (or the shared graph if it's empty).
+func (c *client) withGraph(graph string) *client {
+	g := *c
+	g.graph = graph
+	return &g
+}
+
+// ForChat returns a client whose graph methods work on the graph the
+// chat uses: its private graph if it has one, otherwise the shared one.
+func (c *client) ForChat(chatID int) (*client, error) {
+	ci, err := c.GetChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	return c.withGraph(ci.Graph), nil
+}
+
+// CreatePrivateChat creates a new chat with its own private graph,
+// which its tools use instead of the shared one.
+func (c *client) CreatePrivateChat(n, systemPrompt string) (*ChatInfo, error) {
+	var ci *ChatInfo
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		ci, err = createChat(tx, ChatInfo{
+			Name:         n,
+			State:        "idle",
+			SystemPrompt: systemPrompt,
+		})
+		if err != nil {
+			return err
+		}
+		return c.addPrivateGraph(tx, ci)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create private chat: %w", err)
+	}
+	return ci, nil
+}
+
+// addPrivateGraph gives a just-created chat its own private graph
+// within the transaction, named after the chat.
+func (c *client) addPrivateGraph(tx *bolt.Tx, ci *ChatInfo) error {
+	ci.Graph = fmt.Sprintf("chat-%d", ci.ID)
+	by, err := json.Marshal(ci)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat info as json: %w", err)
+	}
+	if err := tx.Bucket([]byte(chatBucket)).Put(ci.BID(), by); err != nil {
+		return fmt.Errorf("failed to put chat info into db: %w", err)
+	}
+
+	g := c.withGraph(ci.Graph)
+	for _, b := range []string{nodeBucket, edgeBucket} {
+		if _, err := tx.CreateBucketIfNotExists(g.graphBucket(b)); err != nil {
+			return fmt.Errorf("failed to create private graph bucket: %w", err)
+		}
+	}
+	return nil
+}
+
+// deletePrivateGraph deletes a private graph's buckets within the
+// transaction, unless another chat (e.g. a fork) still uses it.
+func (c *client) deletePrivateGraph(tx *bolt.Tx, graph string) error {
+	if graph == "" {
+		return nil
+	}
+	var used bool
+	if err := tx.Bucket([]byte(chatBucket)).ForEach(func(k, v []byte) error {
+		var ci ChatInfo
+		if err := json.Unmarshal(v, &ci); err != nil {
+			return fmt.Errorf("failed to unmarshal chat info: %w", err)
+		}
+		used = used || ci.Graph == graph
+		return nil
+	}); err != nil {
+		return err
+	}
+	if used {
+		return nil
+	}
+
+	g := c.withGraph(graph)
+	for _, b := range []string{nodeBucket, edgeBucket, embeddingBucket} {
+		if err := tx.DeleteBucket(g.graphBucket(b)); err != nil && !errors.Is(err, berrors.ErrBucketNotFound) {
+			return fmt.Errorf("failed to delete private graph bucket: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newPrivateGraphTest creates a client with a node in the shared graph
+// and a private chat with a node in its own graph.
+func newPrivateGraphTest(t *testing.T) (*client, int) {
+	t.Helper()
+	c := newTestClient(t)
+	if _, err := c.CreateNode("city", map[string]any{"name": "Shared"}); err != nil {
+		t.Fatal(err)
+	}
+	ci, err := c.CreatePrivateChat("private", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := c.ForChat(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.CreateNode("city", map[string]any{"name": "Private"}); err != nil {
+		t.Fatal(err)
+	}
+	return c, ci.ID
+}
+
+func nodeNames(t *testing.T, c *client) []string {
+	t.Helper()
+	ns, err := c.ListNodes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, n := range ns {
+		names = append(names, n.Props["name"].(string))
+	}
+	return names
+}
+
+func TestPrivateGraphIsolation(t *testing.T) {
+	c, cid := newPrivateGraphTest(t)
+	if got := nodeNames(t, c); len(got) != 1 || got[0] != "Shared" {
+		t.Errorf("shared graph has %v, want [Shared]", got)
+	}
+	g, err := c.ForChat(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeNames(t, g); len(got) != 1 || got[0] != "Private" {
+		t.Errorf("private graph has %v, want [Private]", got)
+	}
+
+	// A normal chat uses the shared graph
+	ci, err := c.CreateChat("shared", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.ForChat(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeNames(t, s); len(got) != 1 || got[0] != "Shared" {
+		t.Errorf("normal chat's graph has %v, want [Shared]", got)
+	}
+}
+
+func TestAuditRecordsGraph(t *testing.T) {
+	c, cid := newPrivateGraphTest(t)
+	es, err := c.AuditLog(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 {
+		t.Fatalf("got %d audit entries, want 2", len(es))
+	}
+	// Newest first
+	if want := fmt.Sprintf("chat-%d", cid); es[0].Graph != want {
+		t.Errorf("private change's graph = %q, want %q", es[0].Graph, want)
+	}
+	if es[1].Graph != "" {
+		t.Errorf("shared change's graph = %q, want none", es[1].Graph)
+	}
+}
+
+func TestGraphViewUsesChatGraph(t *testing.T) {
+	c, cid := newPrivateGraphTest(t)
+	m := newTestModel(t, c, fakeOllama(t, nil), cid, uiConfig{})
+	m.w, m.vp.Width, m.vp.Height = 80, 80, 40
+	m.graphView = true
+	m.updteVP()
+	if m.err != nil {
+		t.Fatal(m.err)
+	}
+	v := m.vp.View()
+	if !strings.Contains(v, "Private") || strings.Contains(v, "Shared") {
+		t.Errorf("graph view for private chat shows:\n%s", v)
+	}
+}
+
+func TestToolsUseChatGraph(t *testing.T) {
+	c, cid := newPrivateGraphTest(t)
+	a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{})
+	m := callTool(t, a, cid, "create_node", map[string]any{
+		"type":  "city",
+		"props": map[string]any{"name": "Also private"},
+	})
+	if m.ToolMsg.ToolError != "" {
+		t.Fatal(m.ToolMsg.ToolError)
+	}
+	if got := nodeNames(t, c); len(got) != 1 {
+		t.Errorf("tool changed the shared graph: %v", got)
+	}
+	g, err := c.ForChat(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeNames(t, g); len(got) != 2 {
+		t.Errorf("private graph has %v, want 2 nodes", got)
+	}
+}
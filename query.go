@@ -0,0 +1,497 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The graph query language is a tiny subset of Cypher:
+//
+//	query     = "MATCH" pattern [ "WHERE" condition { "AND" condition } ] "RETURN" ident
+//	pattern   = node [ edge node ]
+//	node      = "(" ident [ ":" type ] ")"
+//	edge      = "-[" [ ident ] [ ":" type ] "]->"   (left to right)
+//	          | "<-[" [ ident ] [ ":" type ] "]-"   (right to left)
+//	          | "-[" [ ident ] [ ":" type ] "]-"    (either direction)
+//	condition = ident "." key ( "=" | "!=" ) value
+//	value     = string | number | "true" | "false" | "null"
+//
+// Keywords are case-insensitive. Strings use double quotes. For
+// example:
+//
+//	MATCH (n:person)-[:knows]->(m) WHERE n.name = "Alice" RETURN m
+
+// graphQuery is a parsed query.
+type graphQuery struct {
+	From  nodePattern
+	Edge  *edgePattern // Nil if the query only matches single nodes
+	To    nodePattern
+	Where []condition
+	Ret   string // Variable to return
+}
+
+// nodePattern matches nodes, optionally by type.
+type nodePattern struct {
+	Var  string
+	Type string // Any type if empty
+}
+
+// edgePattern matches edges, optionally by type and direction.
+type edgePattern struct {
+	Var  string // May be empty
+	Type string // Any type if empty
+	Dir  int    // 1 for From->To, -1 for From<-To, 0 for either
+}
+
+// condition compares a property of a matched node or edge to a value.
+type condition struct {
+	Var   string
+	Key   string
+	Neg   bool // "!=" rather than "="
+	Value any  // string, float64, bool, or nil
+}
+
+// QueryResult holds what a query returned: nodes or edges,
+// depending on what the RETURN variable refers to.
+type QueryResult struct {
+	Nodes []GraphNode `json:",omitempty"`
+	Edges []GraphEdge `json:",omitempty"`
+}
+
+// Query parses and runs a graph query.
+func (c *client) Query(q string) (*QueryResult, error) {
+	gq, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	return c.runQuery(gq)
+}
+
+// runQuery runs a parsed query against the graph.
+func (c *client) runQuery(q *graphQuery) (*QueryResult, error) {
+	from, err := c.matchNodes(q.From, q.Where)
+	if err != nil {
+		return nil, err
+	}
+
+	// Just nodes? Then we're done.
+	if q.Edge == nil {
+		res := &QueryResult{}
+		for _, n := range from {
+			res.Nodes = append(res.Nodes, n)
+		}
+		sort.Slice(res.Nodes, func(i, j int) bool { return res.Nodes[i].ID < res.Nodes[j].ID })
+		return res, nil
+	}
+
+	to, err := c.matchNodes(q.To, q.Where)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := c.ListEdges(EdgeFilter{Type: q.Edge.Type})
+	if err != nil {
+		return nil, err
+	}
+
+	// Join the nodes through the edges, collecting whatever is returned
+	nodes := map[int]GraphNode{}
+	matched := map[int]GraphEdge{}
+	for _, e := range edges {
+		if !q.Edge.matches(e, q.Where) {
+			continue
+		}
+
+		// Try the edge each way round that the direction allows
+		var pairs [][2]int
+		if q.Edge.Dir >= 0 {
+			pairs = append(pairs, [2]int{e.FromID, e.ToID})
+		}
+		if q.Edge.Dir <= 0 && (q.Edge.Dir < 0 || e.FromID != e.ToID) {
+			pairs = append(pairs, [2]int{e.ToID, e.FromID})
+		}
+		for _, p := range pairs {
+			f, ok := from[p[0]]
+			if !ok {
+				continue
+			}
+			t, ok := to[p[1]]
+			if !ok {
+				continue
+			}
+			switch q.Ret {
+			case q.From.Var:
+				nodes[f.ID] = f
+			case q.To.Var:
+				nodes[t.ID] = t
+			default:
+				matched[e.ID] = e
+			}
+		}
+	}
+
+	res := &QueryResult{}
+	for _, n := range nodes {
+		res.Nodes = append(res.Nodes, n)
+	}
+	for _, e := range matched {
+		res.Edges = append(res.Edges, e)
+	}
+	sort.Slice(res.Nodes, func(i, j int) bool { return res.Nodes[i].ID < res.Nodes[j].ID })
+	sort.Slice(res.Edges, func(i, j int) bool { return res.Edges[i].ID < res.Edges[j].ID })
+	return res, nil
+}
+
+// matchNodes returns the nodes matching a pattern and any
+// conditions on it, by ID.
+func (c *client) matchNodes(p nodePattern, conds []condition) (map[int]GraphNode, error) {
+	ns, err := c.ListNodes(p.Type)
+	if err != nil {
+		return nil, err
+	}
+	out := map[int]GraphNode{}
+	for _, n := range ns {
+		if matchProps(p.Var, n.Props, conds) {
+			out[n.ID] = n
+		}
+	}
+	return out, nil
+}
+
+// matches reports whether an edge matches the pattern's conditions
+// (its type is already filtered by ListEdges).
+func (p *edgePattern) matches(e GraphEdge, conds []condition) bool {
+	return p.Var == "" || matchProps(p.Var, e.Props, conds)
+}
+
+// matchProps reports whether props meet every condition on v.
+func matchProps(v string, props map[string]any, conds []condition) bool {
+	for _, c := range conds {
+		if c.Var != v {
+			continue
+		}
+		if propEqual(props[c.Key], c.Value) == c.Neg {
+			return false
+		}
+	}
+	return true
+}
+
+// propEqual compares a stored property to a query value,
+// treating all numbers as equal if their values are.
+func propEqual(prop, val any) bool {
+	if f, ok := val.(float64); ok {
+		pf, ok := toFloat(prop)
+		return ok && pf == f
+	}
+	return prop == val
+}
+
+// parseQuery parses a graph query.
+func parseQuery(s string) (*graphQuery, error) {
+	toks, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{toks: toks}
+	q, err := p.query()
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	return q, nil
+}
+
+// queryToken is a single token of a query.
+type queryToken struct {
+	kind string // "ident", "string", "number", or the punctuation itself
+	text string
+	pos  int
+}
+
+// lexQuery splits a query into tokens.
+func lexQuery(s string) ([]queryToken, error) {
+	var toks []queryToken
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			// Read to the closing quote, allowing escapes
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != '"'; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("invalid query: unterminated string at %d", i)
+			}
+			toks = append(toks, queryToken{"string", sb.String(), i})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			toks = append(toks, queryToken{"number", string(rs[i:j]), i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			toks = append(toks, queryToken{"ident", string(rs[i:j]), i})
+			i = j
+		default:
+			// Two-character punctuation first
+			if i+1 < len(rs) {
+				if two := string(rs[i : i+2]); two == "->" || two == "<-" || two == "!=" {
+					toks = append(toks, queryToken{two, two, i})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("()[]:-.=,", r) {
+				return nil, fmt.Errorf("invalid query: unexpected %q at %d", r, i)
+			}
+			toks = append(toks, queryToken{string(r), string(r), i})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+// queryParser is a recursive-descent parser over query tokens.
+type queryParser struct {
+	toks []queryToken
+	i    int
+}
+
+func (p *queryParser) peek() queryToken {
+	if p.i >= len(p.toks) {
+		return queryToken{kind: "end"}
+	}
+	return p.toks[p.i]
+}
+
+// accept consumes the next token if it has the given kind.
+func (p *queryParser) accept(kind string) (queryToken, bool) {
+	t := p.peek()
+	if t.kind != kind {
+		return t, false
+	}
+	p.i++
+	return t, true
+}
+
+// expect consumes the next token, which must have the given kind.
+func (p *queryParser) expect(kind string) (queryToken, error) {
+	t, ok := p.accept(kind)
+	if !ok {
+		return t, p.unexpected(kind)
+	}
+	return t, nil
+}
+
+// keyword consumes the next token if it's the given (case-insensitive) keyword.
+func (p *queryParser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == "ident" && strings.EqualFold(t.text, kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) unexpected(want string) error {
+	t := p.peek()
+	if t.kind == "end" {
+		return fmt.Errorf("expected %s, got end of query", want)
+	}
+	return fmt.Errorf("expected %s, got %q at %d", want, t.text, t.pos)
+}
+
+func (p *queryParser) query() (*graphQuery, error) {
+	q := &graphQuery{}
+	if !p.keyword("MATCH") {
+		return nil, p.unexpected("MATCH")
+	}
+
+	// The pattern
+	var err error
+	if q.From, err = p.node(); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == "-" || t.kind == "<-" {
+		if q.Edge, err = p.edge(); err != nil {
+			return nil, err
+		}
+		if q.To, err = p.node(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check the variables are distinct
+	vars := map[string]bool{q.From.Var: true}
+	if q.Edge != nil {
+		for _, v := range []string{q.Edge.Var, q.To.Var} {
+			if v == "" {
+				continue
+			}
+			if vars[v] {
+				return nil, fmt.Errorf("variable %q is used twice", v)
+			}
+			vars[v] = true
+		}
+	}
+
+	// The conditions
+	if p.keyword("WHERE") {
+		for {
+			c, err := p.condition()
+			if err != nil {
+				return nil, err
+			}
+			if !vars[c.Var] {
+				return nil, fmt.Errorf("unknown variable %q in WHERE", c.Var)
+			}
+			q.Where = append(q.Where, c)
+			if !p.keyword("AND") {
+				break
+			}
+		}
+	}
+
+	// What to return
+	if !p.keyword("RETURN") {
+		return nil, p.unexpected("WHERE or RETURN")
+	}
+	t, err := p.expect("ident")
+	if err != nil {
+		return nil, err
+	}
+	if !vars[t.text] {
+		return nil, fmt.Errorf("unknown variable %q in RETURN", t.text)
+	}
+	q.Ret = t.text
+
+	if p.peek().kind != "end" {
+		return nil, p.unexpected("end of query")
+	}
+	return q, nil
+}
+
+// node parses "(" ident [ ":" type ] ")".
+func (p *queryParser) node() (nodePattern, error) {
+	var n nodePattern
+	if _, err := p.expect("("); err != nil {
+		return n, err
+	}
+	t, err := p.expect("ident")
+	if err != nil {
+		return n, err
+	}
+	n.Var = t.text
+	if _, ok := p.accept(":"); ok {
+		t, err := p.expect("ident")
+		if err != nil {
+			return n, err
+		}
+		n.Type = t.text
+	}
+	if _, err := p.expect(")"); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// edge parses an edge in any of its three directions.
+func (p *queryParser) edge() (*edgePattern, error) {
+	e := &edgePattern{}
+	_, left := p.accept("<-")
+	if !left {
+		if _, err := p.expect("-"); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.expect("["); err != nil {
+		return nil, err
+	}
+	if t, ok := p.accept("ident"); ok {
+		e.Var = t.text
+	}
+	if _, ok := p.accept(":"); ok {
+		t, err := p.expect("ident")
+		if err != nil {
+			return nil, err
+		}
+		e.Type = t.text
+	}
+	if _, err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	_, right := p.accept("->")
+	if !right {
+		if _, err := p.expect("-"); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case left && right:
+		return nil, fmt.Errorf("an edge can't point both ways")
+	case right:
+		e.Dir = 1
+	case left:
+		e.Dir = -1
+	}
+	return e, nil
+}
+
+// condition parses ident "." key ( "=" | "!=" ) value.
+func (p *queryParser) condition() (condition, error) {
+	var c condition
+	t, err := p.expect("ident")
+	if err != nil {
+		return c, err
+	}
+	c.Var = t.text
+	if _, err := p.expect("."); err != nil {
+		return c, err
+	}
+	if t, err = p.expect("ident"); err != nil {
+		return c, err
+	}
+	c.Key = t.text
+	if _, ok := p.accept("!="); ok {
+		c.Neg = true
+	} else if _, err := p.expect("="); err != nil {
+		return c, p.unexpected(`"=" or "!="`)
+	}
+
+	t = p.peek()
+	switch {
+	case t.kind == "string":
+		c.Value = t.text
+	case t.kind == "number":
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		c.Value = f
+	case t.kind == "ident" && strings.EqualFold(t.text, "true"):
+		c.Value = true
+	case t.kind == "ident" && strings.EqualFold(t.text, "false"):
+		c.Value = false
+	case t.kind == "ident" && strings.EqualFold(t.text, "null"):
+		c.Value = nil
+	default:
+		return c, p.unexpected("a value")
+	}
+	p.i++
+	return c, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	c := testGraph(t)
+	tests := []struct {
+		name      string
+		query     string
+		wantNodes []int
+		wantEdges []int
+	}{
+		{"nodes by type", `MATCH (n:person) RETURN n`, []int{1, 2, 3, 5}, nil},
+		{"any node", `MATCH (n) RETURN n`, []int{1, 2, 3, 4, 5}, nil},
+		{"lowercase keywords", `match (n:city) return n`, []int{4}, nil},
+		{"string condition", `MATCH (n:person) WHERE n.name = "Alice" RETURN n`, []int{1}, nil},
+		{"number condition", `MATCH (n) WHERE n.age = 25 RETURN n`, []int{2}, nil},
+		{"not equal", `MATCH (n:person) WHERE n.name != "Alice" RETURN n`, []int{2, 3, 5}, nil},
+		{"null matches missing props", `MATCH (n:person) WHERE n.age = null RETURN n`, []int{3, 5}, nil},
+		{"and", `MATCH (n) WHERE n.name != "Alice" AND n.name != "Bob" RETURN n`, []int{3, 4, 5}, nil},
+		{"outgoing edge", `MATCH (n)-[:knows]->(m) WHERE n.name = "Alice" RETURN m`, []int{2, 3}, nil},
+		{"incoming edge", `MATCH (n)<-[:knows]-(m) WHERE n.name = "Carol" RETURN m`, []int{1, 2}, nil},
+		{"either direction", `MATCH (n)-[:knows]-(m) WHERE n.name = "Bob" RETURN m`, []int{1, 3}, nil},
+		{"return the source", `MATCH (n:person)-[:lives_in]->(m:city) RETURN n`, []int{1}, nil},
+		{"return edges", `MATCH (n)-[e:knows]->(m) WHERE e.cost = 1 RETURN e`, nil, []int{2}},
+		{"no matches", `MATCH (n:planet) RETURN n`, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := c.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query(%s) failed: %v", tt.query, err)
+			}
+			gotNodes, gotEdges := nodeIDs(res.Nodes), edgeIDs(res.Edges)
+			if !slices.Equal(gotNodes, append([]int{}, tt.wantNodes...)) {
+				t.Errorf("nodes = %v, want %v", gotNodes, tt.wantNodes)
+			}
+			if !slices.Equal(gotEdges, append([]int{}, tt.wantEdges...)) {
+				t.Errorf("edges = %v, want %v", gotEdges, tt.wantEdges)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`(n) RETURN n`,
+		`MATCH (n RETURN n`,
+		`MATCH (n) RETURN`,
+		`MATCH (n) RETURN m`,
+		`MATCH (n) WHERE m.name = "x" RETURN n`,
+		`MATCH (n) WHERE n.name > 3 RETURN n`,
+		`MATCH (n) WHERE n.name = "unterminated RETURN n`,
+		`MATCH (n)-[:knows]->(n) RETURN n`,
+		`MATCH (n)<-[:knows]->(m) RETURN n`,
+		`MATCH (n) RETURN n extra`,
+		`MATCH (n) RETURN n;`,
+	}
+	for _, q := range tests {
+		if _, err := parseQuery(q); err == nil {
+			t.Errorf("parseQuery(%s) didn't fail", q)
+		}
+	}
+}
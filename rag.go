@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+// maxRAGContext caps the size (in bytes) of the retrieved
+// node context added to a request.
+const maxRAGContext = 4000
+
+// ragContext retrieves the nodes most relevant to the latest user
+// message in the history and renders them as a system message, so the
+// model can use stored memory without calling a tool. It returns nil
+// if retrieval is disabled or nothing relevant was found.
+func (a *agent) ragContext(ctx context.Context, h []ollama.Message) (*ollama.Message, error) {
+	if a.cfg.RAGTopK <= 0 {
+		return nil, nil
+	}
+
+	// Don't embed the query when there's nothing to compare it to
+	if ok, err := a.graph(ctx).HasEmbeddings(); err != nil || !ok {
+		return nil, err
+	}
+
+	// Find the latest user message
+	var query string
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].Role == "user" {
+			query = h[i].Content
+			break
+		}
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+
+	vec, err := a.embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := a.graph(ctx).SearchNodesByVector(vec, a.cfg.RAGTopK)
+	if err != nil {
+		return nil, err
+	}
+	block := renderNodeContext(ns, maxRAGContext)
+	if block == "" {
+		return nil, nil
+	}
+	return &ollama.Message{Role: "system", Content: block}, nil
+}
+
+// renderNodeContext renders nodes as a compact context block, one
+// node per line, stopping before it grows past limit bytes.
+func renderNodeContext(ns []ScoredNode, limit int) string {
+	const header = "Possibly relevant nodes from the graph (ID, type, properties):\n"
+	var sb strings.Builder
+	for _, n := range ns {
+		props, err := json.Marshal(n.Node.Props)
+		if err != nil {
+			continue
+		}
+		line := fmt.Sprintf("- [%d] %s %s\n", n.Node.ID, n.Node.Type, props)
+		if len(header)+sb.Len()+len(line) > limit {
+			break
+		}
+		sb.WriteString(line)
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return header + sb.String()
+}
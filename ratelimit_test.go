@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute int
+		burst     int
+		calls     int           // Calls made before the one checked
+		wantMin   time.Duration // Least the checked call should wait
+		wantMax   time.Duration // Most it should wait
+	}{
+		{"first call", 60, 1, 0, 0, 0},
+		{"within the burst", 60, 3, 2, 0, 0},
+		{"just past the burst", 60, 3, 3, 900 * time.Millisecond, time.Second},
+		{"queued behind others", 60, 1, 3, 2900 * time.Millisecond, 3 * time.Second},
+		{"faster rate", 600, 1, 1, 90 * time.Millisecond, 100 * time.Millisecond},
+		{"burst of at least one", 60, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.perMinute, tt.burst)
+			for range tt.calls {
+				l.reserve()
+			}
+			if d := l.reserve(); d < tt.wantMin || d > tt.wantMax {
+				t.Errorf("wait = %v, want between %v and %v", d, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	// No limit
+	var none *rateLimiter
+	if l := newRateLimiter(0, 5); l != nil {
+		t.Errorf("newRateLimiter(0, 5) = %v, want nil", l)
+	}
+	if err := none.Wait(context.Background()); err != nil {
+		t.Errorf("nil limiter's Wait() = %v, want nil", err)
+	}
+
+	// Cancelling a wait gives its token back
+	l := newRateLimiter(60, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := l.reserve(); d > time.Second {
+		t.Errorf("wait after a cancelled one = %v, want at most 1s", d)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// RenameNodeType changes the type of every node of type old to
+// new, returning how many nodes were changed.
+func (c *client) RenameNodeType(old, new string) (int, error) {
+	if new == "" {
+		return 0, fmt.Errorf("failed to rename node type: new type is empty")
+	}
+	var n int
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(c.graphBucket(nodeBucket))
+		if bucket == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+
+		// Find the matching nodes (bolt doesn't allow
+		// changing a bucket while iterating over it)
+		var nodes []GraphNode
+		if err := bucket.ForEach(func(k, v []byte) error {
+			var node GraphNode
+			if err := c.decodeNode(v, &node); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			if node.Type == old {
+				nodes = append(nodes, node)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Rename them
+		for _, node := range nodes {
+			node.Type = new
+			data, err := json.Marshal(node)
+			if err != nil {
+				return fmt.Errorf("failed to marshal node: %w", err)
+			}
+			if err := bucket.Put(node.BID(), data); err != nil {
+				return fmt.Errorf("failed to put node into db: %w", err)
+			}
+			if err := c.writeAudit(tx, "update", "node", node.ID, node); err != nil {
+				return err
+			}
+		}
+		n = len(nodes)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to rename node type: %w", err)
+	}
+	return n, nil
+}
+
+// RenameEdgeType changes the type of every edge of type old to
+// new, returning how many edges were changed.
+func (c *client) RenameEdgeType(old, new string) (int, error) {
+	if new == "" {
+		return 0, fmt.Errorf("failed to rename edge type: new type is empty")
+	}
+	var n int
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(c.graphBucket(edgeBucket))
+		if bucket == nil {
+			return fmt.Errorf("edge bucket not found")
+		}
+
+		// Find the matching edges
+		var edges []GraphEdge
+		if err := bucket.ForEach(func(k, v []byte) error {
+			var edge GraphEdge
+			if err := json.Unmarshal(v, &edge); err != nil {
+				return fmt.Errorf("failed to unmarshal edge: %w", err)
+			}
+			if edge.Type == old {
+				edges = append(edges, edge)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Rename them
+		for _, edge := range edges {
+			edge.Type = new
+			data, err := json.Marshal(edge)
+			if err != nil {
+				return fmt.Errorf("failed to marshal edge: %w", err)
+			}
+			if err := bucket.Put(edge.BID(), data); err != nil {
+				return fmt.Errorf("failed to put edge into db: %w", err)
+			}
+			if err := c.writeAudit(tx, "update", "edge", edge.ID, edge); err != nil {
+				return err
+			}
+		}
+		n = len(edges)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to rename edge type: %w", err)
+	}
+	return n, nil
+}
+
+// RenamePropKey moves the value of property oldKey to newKey on every
+// node of a type (or of any type if nodeType is empty), returning how
+// many nodes were changed. Nodes without oldKey are left alone. Nodes
+// that already have newKey are skipped, and their IDs returned as
+// conflicts, unless force is set, in which case newKey's value is
+// replaced.
+func (c *client) RenamePropKey(nodeType, oldKey, newKey string, force bool) (int, []int, error) {
+	if newKey == "" {
+		return 0, nil, fmt.Errorf("failed to rename property: new key is empty")
+	}
+	if oldKey == newKey {
+		return 0, nil, nil
+	}
+	var n int
+	var conflicts []int
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(c.graphBucket(nodeBucket))
+		if bucket == nil {
+			return fmt.Errorf("node bucket not found")
+		}
+
+		// Find the nodes with the old key
+		var nodes []GraphNode
+		if err := bucket.ForEach(func(k, v []byte) error {
+			var node GraphNode
+			if err := c.decodeNode(v, &node); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			if nodeType != "" && node.Type != nodeType {
+				return nil
+			}
+			if _, ok := node.Props[oldKey]; !ok {
+				return nil
+			}
+			if _, ok := node.Props[newKey]; ok && !force {
+				conflicts = append(conflicts, node.ID)
+				return nil
+			}
+			nodes = append(nodes, node)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Move the values over
+		for _, node := range nodes {
+			node.Props[newKey] = node.Props[oldKey]
+			delete(node.Props, oldKey)
+			data, err := json.Marshal(node)
+			if err != nil {
+				return fmt.Errorf("failed to marshal node: %w", err)
+			}
+			if err := bucket.Put(node.BID(), data); err != nil {
+				return fmt.Errorf("failed to put node into db: %w", err)
+			}
+			if err := c.writeAudit(tx, "update", "node", node.ID, node); err != nil {
+				return err
+			}
+		}
+		n = len(nodes)
+		return nil
+	}); err != nil {
+		return 0, nil, fmt.Errorf("failed to rename property: %w", err)
+	}
+	return n, conflicts, nil
+}
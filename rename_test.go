@@ -0,0 +1,170 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestRenamePropKey(t *testing.T) {
+	// Each node's props, by ID
+	nodes := []struct {
+		typ   string
+		props map[string]any
+	}{
+		{"person", map[string]any{"name": "Alice"}},                      // 1: only the old key
+		{"person", map[string]any{"age": 25}},                            // 2: neither key
+		{"person", map[string]any{"name": "Carol", "full_name": "C. X"}}, // 3: both keys
+		{"city", map[string]any{"name": "Paris"}},                        // 4: another type
+	}
+	tests := []struct {
+		name      string
+		nodeType  string
+		force     bool
+		renamed   int
+		conflicts []int
+		want      map[int]map[string]any // Props afterwards
+	}{
+		{
+			name: "one type", nodeType: "person", renamed: 1, conflicts: []int{3},
+			want: map[int]map[string]any{
+				1: {"full_name": "Alice"},
+				2: {"age": float64(25)},
+				3: {"name": "Carol", "full_name": "C. X"},
+				4: {"name": "Paris"},
+			},
+		},
+		{
+			name: "every type", renamed: 2, conflicts: []int{3},
+			want: map[int]map[string]any{
+				1: {"full_name": "Alice"},
+				2: {"age": float64(25)},
+				3: {"name": "Carol", "full_name": "C. X"},
+				4: {"full_name": "Paris"},
+			},
+		},
+		{
+			name: "forced", nodeType: "person", force: true, renamed: 2,
+			want: map[int]map[string]any{
+				1: {"full_name": "Alice"},
+				2: {"age": float64(25)},
+				3: {"full_name": "Carol"},
+				4: {"name": "Paris"},
+			},
+		},
+		{
+			name: "no matching type", nodeType: "animal",
+			want: map[int]map[string]any{
+				1: {"name": "Alice"},
+				2: {"age": float64(25)},
+				3: {"name": "Carol", "full_name": "C. X"},
+				4: {"name": "Paris"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			for _, n := range nodes {
+				if _, err := c.CreateNode(n.typ, maps.Clone(n.props)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			renamed, conflicts, err := c.RenamePropKey(tt.nodeType, "name", "full_name", tt.force)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if renamed != tt.renamed || !slices.Equal(conflicts, tt.conflicts) {
+				t.Errorf("renamed %d (conflicts %v), want %d (conflicts %v)", renamed, conflicts, tt.renamed, tt.conflicts)
+			}
+			for id, want := range tt.want {
+				n, err := c.GetNode(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !maps.Equal(n.Props, want) {
+					t.Errorf("node %d props = %v, want %v", id, n.Props, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenamePropKeyErrors(t *testing.T) {
+	c := newTestClient(t)
+	if _, err := c.CreateNode("person", map[string]any{"name": "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.RenamePropKey("person", "name", "", false); err == nil {
+		t.Error("renamed to an empty key")
+	}
+	if n, conflicts, err := c.RenamePropKey("person", "name", "name", false); err != nil || n != 0 || conflicts != nil {
+		t.Errorf("renaming to the same key = %d, %v, %v; want nothing", n, conflicts, err)
+	}
+}
+
+func TestRenameNodeType(t *testing.T) {
+	c := testGraph(t)
+	n, err := c.RenameNodeType("person", "human")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("RenameNodeType() = %d, want 4", n)
+	}
+	ns, err := c.ListNodes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range ns {
+		want := "human"
+		if node.ID == 4 {
+			want = "city" // Untouched
+		}
+		if node.Type != want {
+			t.Errorf("node %d type = %q, want %q", node.ID, node.Type, want)
+		}
+	}
+	if len(ns[0].Props) != 2 || ns[0].Props["name"] != "Alice" {
+		t.Errorf("renaming changed node 1's props: %v", ns[0].Props)
+	}
+
+	// Nothing left to rename
+	if n, err := c.RenameNodeType("person", "human"); err != nil || n != 0 {
+		t.Errorf("RenameNodeType() again = %d, %v; want 0", n, err)
+	}
+	if _, err := c.RenameNodeType("city", ""); err == nil {
+		t.Error("RenameNodeType() to an empty type succeeded")
+	}
+}
+
+func TestRenameEdgeType(t *testing.T) {
+	c := testGraph(t)
+	n, err := c.RenameEdgeType("knows", "friend_of")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("RenameEdgeType() = %d, want 3", n)
+	}
+	es, err := c.ListEdges(EdgeFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range es {
+		want := "friend_of"
+		if e.ID == 3 {
+			want = "lives_in" // Untouched
+		}
+		if e.Type != want {
+			t.Errorf("edge %d type = %q, want %q", e.ID, e.Type, want)
+		}
+	}
+	if e := es[0]; e.FromID != 1 || e.ToID != 2 || e.Props["cost"] == nil {
+		t.Errorf("renaming changed edge 1: %+v", e)
+	}
+	if _, err := c.RenameEdgeType("lives_in", ""); err == nil {
+		t.Error("RenameEdgeType() to an empty type succeeded")
+	}
+}
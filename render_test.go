@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestRenderToolMsg(t *testing.T) {
+	const (
+		done    = `{"MessageID": 2, "MType": "tool", "ToolMsg": {"ToolDone": true, "ToolName": "get_node", "ToolArgs": {"id": 4}, "ToolResult": "{\"ID\":4,\"Type\":\"city\"}"}}`
+		failed  = `{"MessageID": 2, "MType": "tool", "ToolMsg": {"ToolDone": true, "ToolName": "get_node", "ToolArgs": {"id": 9}, "ToolError": "node with ID 9 not found"}}`
+		running = `{"MessageID": 2, "MType": "tool", "ToolMsg": {"ToolName": "get_node", "ToolArgs": {"id": 4}}}`
+	)
+	tests := []struct {
+		name     string
+		msg      string
+		expanded bool
+		want     []string // Lines the rendering should have
+		notWant  string
+	}{
+		{"collapsed", done, false, []string{"Calling get_node() (enter to expand)"}, `"id"`},
+		{"collapsed while running", running, false, []string{"Calling get_node()..."}, ""},
+		{"collapsed error", failed, false, []string{"Calling get_node() (enter to expand)"}, "Error"},
+		{"expanded", done, true, []string{"Called get_node with:", `  "id": 4`, "Result:", `{"ID":4,"Type":"city"}`}, ""},
+		{"expanded error", failed, true, []string{"Called get_node with:", "Error: node with ID 9 not found"}, "Result:"},
+		{"expanded while running", running, true, []string{"Called get_node with:", "Waiting for the result..."}, "Result:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderToolMsg(toolMessage(t, tt.msg), tt.expanded, 80, uiConfig{Plain: true})
+			lines := strings.Split(got, "\n")
+			for i := range lines {
+				lines[i] = strings.TrimRight(lines[i], " ")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(strings.Join(lines, "\n")+"\n", want+"\n") {
+					t.Errorf("rendering is missing %q:\n%s", want, got)
+				}
+			}
+			if tt.notWant != "" && strings.Contains(got, tt.notWant) {
+				t.Errorf("rendering has %q:\n%s", tt.notWant, got)
+			}
+		})
+	}
+}
+
+func TestToggleToolMsg(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("tools", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "uta")
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{Plain: true})
+	m.focus = "viewport"
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+	expanded := func() bool { return strings.Contains(m.vp.View(), "Called list_nodes with:") }
+
+	// Enter only expands tool calls
+	m.sel = 0
+	m.Update(enter)
+	if expanded() || len(m.expanded) != 0 {
+		t.Error("enter on a user message expanded something")
+	}
+
+	m.sel = 1
+	m.Update(enter)
+	if !expanded() {
+		t.Errorf("enter didn't expand the tool call:\n%s", m.vp.View())
+	}
+	m.Update(enter)
+	if expanded() {
+		t.Errorf("enter again didn't collapse it:\n%s", m.vp.View())
+	}
+}
+
+func TestRenderAgentText(t *testing.T) {
+	withColor(t)
+	text := "Here's the fix:\n```go\nfunc main() {\n\tfmt.Println(\"" + strings.Repeat("x", 40) + "\")\n}\n```\nThat should do it."
+
+	// Code is set apart from the prose, and colored
+	got := renderAgentText(text, 30, uiConfig{})
+	lines := strings.Split(got, "\n")
+	if !strings.Contains(lines[1], "┌ go") {
+		t.Errorf("code block starts with %q, want its language", lines[1])
+	}
+	var code []string
+	for _, l := range lines {
+		if strings.Contains(l, "│ ") {
+			if !strings.Contains(l, "\x1b[") {
+				t.Errorf("code line %q isn't colored", l)
+			}
+			code = append(code, l)
+		}
+	}
+	if len(code) != 5 {
+		t.Errorf("code lines = %q, want the long one wrapped onto 3", code)
+	}
+	if strings.Contains(got, "```") {
+		t.Errorf("fences are still shown:\n%s", got)
+	}
+	for _, l := range lines {
+		if w := lipgloss.Width(l); w > 30 {
+			t.Errorf("line %q is %d wide, want at most 30", l, w)
+		}
+	}
+	if !strings.HasSuffix(got, "That should do it.") {
+		t.Errorf("prose after the code is missing:\n%s", got)
+	}
+
+	// Plain mode keeps the fences, and doesn't color anything
+	plain := renderAgentText(text, 30, uiConfig{Plain: true})
+	if strings.Contains(plain, "\x1b[") {
+		t.Errorf("plain rendering is styled: %q", plain)
+	}
+	if want := "```go\nfunc main() {\n"; !strings.Contains(plain, want) {
+		t.Errorf("plain rendering = %q, want the fenced code", plain)
+	}
+
+	// Text without code is just wrapped
+	if got := renderAgentText("no code here", 30, uiConfig{}); got != "no code here" {
+		t.Errorf("renderAgentText() = %q", got)
+	}
+}
+
+// malformedRecords are stored messages missing the payload their
+// type calls for (or with a type we don't know).
+var malformedRecords = []string{
+	`{"MType": "user"}`,
+	`{"MType": "agent", "UserMsg": {"Text": "wrong payload"}}`,
+	`{"MType": "tool"}`,
+	`{"MType": "summary"}`,
+	`{"MType": "error"}`,
+	`{"MType": "telepathy"}`,
+}
+
+// addRawMessages stores messages in a chat exactly as given, bypassing
+// CreateMessage (like a corrupt or hand-edited record would).
+func addRawMessages(t *testing.T, c *client, cid int, records ...string) {
+	t.Helper()
+	ci, err := c.GetChat(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ci.MessageBucketName())
+		for _, r := range records {
+			id, _ := b.NextSequence()
+			var m map[string]any
+			if err := json.Unmarshal([]byte(r), &m); err != nil {
+				return err
+			}
+			m["MessageID"], m["ChatID"] = id, cid
+			data, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(itob(int(id)), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderMalformedMessages(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("malformed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "before")
+	addRawMessages(t, c, ci.ID, malformedRecords...)
+	addUserMessage(t, c, ci.ID, "after")
+
+	// Each bad record shows a placeholder instead of crashing
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{Plain: true})
+	m.Update(tea.WindowSizeMsg{Width: 80, Height: 40})
+	for i := range m.hist {
+		m.sel = i
+		m.updteVP()
+	}
+	view := m.vp.View()
+	if n := strings.Count(view, "[malformed message]"); n != len(malformedRecords) {
+		t.Errorf("view has %d placeholders, want %d:\n%s", n, len(malformedRecords), view)
+	}
+	for _, text := range []string{"before", "after"} {
+		if !strings.Contains(view, text) {
+			t.Errorf("view is missing %q:\n%s", text, view)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resultsDir is the directory (in the config directory) tool
+// results are saved to by default.
+const resultsDir = "results"
+
+// toolResultJSON returns a finished tool call's full result,
+// indented if it's JSON.
+func toolResultJSON(m Message) ([]byte, error) {
+	if m.MType != "tool" || m.ToolMsg == nil {
+		return nil, fmt.Errorf("message %d is not a tool call", m.MessageID)
+	}
+	switch tm := m.ToolMsg; {
+	case tm.ToolError != "":
+		return nil, fmt.Errorf("tool call %d failed, so there's no result to save", m.MessageID)
+	case tm.ToolResult == "":
+		return nil, fmt.Errorf("tool call %d doesn't have a result yet", m.MessageID)
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(m.ToolMsg.ToolResult), "", "  "); err != nil {
+		return []byte(m.ToolMsg.ToolResult), nil // Not JSON; save it as it is
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// resultFileName returns the default name of the file a tool
+// call's result is saved to, e.g. "chat3-message12-list_nodes.json"
+// (or ".txt" if the result isn't JSON).
+func resultFileName(m Message) string {
+	tool := "tool"
+	if m.ToolMsg != nil {
+		// Keep the name safe for a file name (the model
+		// can call tools that don't exist)
+		tool = strings.Map(func(r rune) rune {
+			switch {
+			case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_', r == '-':
+				return r
+			}
+			return '_'
+		}, m.ToolMsg.ToolName)
+	}
+	ext := ".txt"
+	if m.ToolMsg != nil && json.Valid([]byte(m.ToolMsg.ToolResult)) {
+		ext = ".json"
+	}
+	return fmt.Sprintf("chat%d-message%d-%s%s", m.ChatID, m.MessageID, tool, ext)
+}
+
+// saveToolResult writes a tool call's full result to a new file at p
+// (expanding a leading ~ to the home directory), creating its directory
+// if needed. It won't overwrite a file that's already there.
+func saveToolResult(m Message, p string) error {
+	data, err := toolResultJSON(m)
+	if err != nil {
+		return err
+	}
+	if p, err = expandHome(p); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s already exists", p)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to save result: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to save result: %w", err)
+	}
+	return nil
+}
+
+// expandHome replaces a leading "~" (alone or followed
+// by a separator) in p with the user's home directory.
+func expandHome(p string) (string, error) {
+	if p != "~" && !strings.HasPrefix(p, "~"+string(filepath.Separator)) && !strings.HasPrefix(p, "~/") {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, p[1:]), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// toolMessage makes a tool call message from its JSON.
+func toolMessage(t *testing.T, data string) Message {
+	t.Helper()
+	var m Message
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestToolResultJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "indents json",
+			msg:  `{"MessageID": 2, "MType": "tool", "ToolMsg": {"ToolDone": true, "ToolResult": "{\"ID\":1,\"Type\":\"city\"}"}}`,
+			want: "{\n  \"ID\": 1,\n  \"Type\": \"city\"\n}\n",
+		},
+		{
+			name: "keeps other results as they are",
+			msg:  `{"MessageID": 2, "MType": "tool", "ToolMsg": {"ToolDone": true, "ToolResult": "not json"}}`,
+			want: "not json",
+		},
+		{
+			name:    "failed call",
+			msg:     `{"MessageID": 2, "MType": "tool", "ToolMsg": {"ToolDone": true, "ToolError": "boom"}}`,
+			wantErr: "tool call 2 failed",
+		},
+		{
+			name:    "unfinished call",
+			msg:     `{"MessageID": 2, "MType": "tool", "ToolMsg": {"ToolName": "list_nodes"}}`,
+			wantErr: "tool call 2 doesn't have a result yet",
+		},
+		{
+			name:    "not a tool call",
+			msg:     `{"MessageID": 2, "MType": "agent", "AgentMsg": {"Text": "hi"}}`,
+			wantErr: "message 2 is not a tool call",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toolResultJSON(toolMessage(t, tt.msg))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("toolResultJSON() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("toolResultJSON() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultFileName(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{
+			`{"ChatID": 3, "MessageID": 12, "MType": "tool", "ToolMsg": {"ToolName": "list_nodes", "ToolResult": "[]"}}`,
+			"chat3-message12-list_nodes.json",
+		},
+		{
+			`{"ChatID": 3, "MessageID": 12, "MType": "tool", "ToolMsg": {"ToolName": "get_node", "ToolResult": "plain text"}}`,
+			"chat3-message12-get_node.txt",
+		},
+		{
+			`{"ChatID": 3, "MessageID": 12, "MType": "tool", "ToolMsg": {"ToolName": "../../etc/passwd", "ToolResult": "{}"}}`,
+			"chat3-message12-______etc_passwd.json",
+		},
+		{
+			`{"ChatID": 1, "MessageID": 2, "MType": "tool"}`,
+			"chat1-message2-tool.txt",
+		},
+	}
+	for _, tt := range tests {
+		if got := resultFileName(toolMessage(t, tt.msg)); got != tt.want {
+			t.Errorf("resultFileName(%s) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestSaveToolResult(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	m := toolMessage(t, `{"MessageID": 2, "MType": "tool", "ToolMsg": {"ToolDone": true, "ToolResult": "[1,2]"}}`)
+
+	// A leading ~ is the home directory, and missing directories are created
+	if err := saveToolResult(m, "~/results/out.json"); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(home, "results", "out.json")
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[\n  1,\n  2\n]\n"; string(data) != want {
+		t.Errorf("saved %q, want %q", data, want)
+	}
+
+	// It won't overwrite a file
+	if err := os.WriteFile(p, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveToolResult(m, p); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("saveToolResult() over a file = %v, want an error", err)
+	}
+	if data, _ := os.ReadFile(p); string(data) != "keep me" {
+		t.Errorf("existing file was changed to %q", data)
+	}
+}
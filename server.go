@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// server exposes the client (and agent) over a JSON HTTP API.
+type server struct {
+	c *client
+	a *agent
+}
+
+// newServer returns the HTTP handler for the API.
+func newServer(c *client, a *agent) http.Handler {
+	s := &server{c: c, a: a}
+	mux := http.NewServeMux()
+
+	// Chats
+	mux.HandleFunc("GET /chats", s.listChats)
+	mux.HandleFunc("POST /chats", s.createChat)
+	mux.HandleFunc("GET /chats/{id}", s.getChat)
+	mux.HandleFunc("DELETE /chats/{id}", s.deleteChat)
+	mux.HandleFunc("GET /chats/{id}/messages", s.listMessages)
+	mux.HandleFunc("POST /chats/{id}/messages", s.createMessage)
+	mux.HandleFunc("POST /chats/{id}/generate", s.generate)
+	mux.HandleFunc("POST /chats/{id}/answer", s.answer)
+	mux.HandleFunc("POST /chats/{id}/approve", s.approve)
+
+	// Graph
+	mux.HandleFunc("GET /nodes", s.listNodes)
+	mux.HandleFunc("POST /nodes", s.createNode)
+	mux.HandleFunc("GET /nodes/{id}", s.getNode)
+	mux.HandleFunc("DELETE /nodes/{id}", s.deleteNode)
+	mux.HandleFunc("GET /edges", s.listEdges)
+	mux.HandleFunc("POST /edges", s.createEdge)
+	mux.HandleFunc("GET /edges/{id}", s.getEdge)
+	mux.HandleFunc("DELETE /edges/{id}", s.deleteEdge)
+	return mux
+}
+
+func (s *server) listChats(w http.ResponseWriter, r *http.Request) {
+	chats, err := s.c.ListChats()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, chats)
+}
+
+func (s *server) createChat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name         string `json:"name"`
+		SystemPrompt string `json:"system_prompt"`
+		PrivateGraph bool   `json:"private_graph"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	create := s.c.CreateChat
+	if req.PrivateGraph {
+		create = s.c.CreatePrivateChat
+	}
+	ci, err := create(req.Name, req.SystemPrompt)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ci)
+}
+
+func (s *server) getChat(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	ci, err := s.c.GetChat(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ci)
+}
+
+func (s *server) deleteChat(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := s.c.GetChat(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.c.DeleteChat(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) listMessages(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := s.c.GetChat(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	ms, err := s.c.ListMessages(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ms)
+}
+
+func (s *server) createMessage(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Text string `json:"text"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Text == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{"text is required"})
+		return
+	}
+	if _, err := s.c.GetChat(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	m, err := s.c.CreateMessage(Message{
+		ChatID:  id,
+		MType:   "user",
+		UserMsg: &struct{ Text string }{Text: req.Text},
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, m)
+}
+
+// generate runs the agent on a chat until it replies (or hits the step
+// limit), returning the messages it added.
+func (s *server) generate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	ci, err := s.c.GetChat(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if ci.State == "running" {
+		writeJSON(w, http.StatusConflict, apiError{fmt.Sprintf("chat %d is already running", id)})
+		return
+	}
+
+	var ms []Message
+	for range maxGenerateSteps {
+		m, err := s.a.generate(r.Context(), id, "")
+		if err != nil {
+			s.a.recordError(id, err)
+			writeError(w, err)
+			return
+		}
+		ms = append(ms, *m)
+		if m.MType != "tool" {
+			break
+		}
+		if _, ok := pendingQuestion(ms); ok {
+			break // Needs an answer from the caller first
+		}
+		if _, ok := pendingApproval(ms); ok {
+			break // Needs the caller's approval first
+		}
+	}
+	writeJSON(w, http.StatusOK, ms)
+}
+
+// answer answers (or, with an empty answer, declines) the question a
+// chat is waiting on. Generate again afterwards to carry on.
+func (s *server) answer(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Answer string `json:"answer"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	var err error
+	if req.Answer == "" {
+		err = s.c.DeclineQuestion(id)
+	} else {
+		err = s.c.AnswerQuestion(id, req.Answer)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// approve runs (or, if approve is false, rejects) the tool call a
+// chat is waiting on. Generate again afterwards to carry on.
+func (s *server) approve(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Approve bool `json:"approve"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	var err error
+	if req.Approve {
+		err = s.a.ApproveToolCall(r.Context(), id)
+	} else {
+		err = s.c.RejectToolCall(id)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) listNodes(w http.ResponseWriter, r *http.Request) {
+	page, ok := queryPage(w, r)
+	if !ok {
+		return
+	}
+	nodes, more, err := s.c.ListNodesPage(r.URL.Query().Get("type"), page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pageResult("nodes", nodes, len(nodes), more, page))
+}
+
+func (s *server) createNode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Type  string         `json:"type"`
+		Props map[string]any `json:"props"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Type == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{"type is required"})
+		return
+	}
+	node, err := s.c.CreateNode(req.Type, req.Props)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, node)
+}
+
+func (s *server) getNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	node, err := s.c.GetNode(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, node)
+}
+
+func (s *server) deleteNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := s.c.GetNode(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.c.DeleteNode(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) listEdges(w http.ResponseWriter, r *http.Request) {
+	page, ok := queryPage(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	filter := EdgeFilter{Type: q.Get("type")}
+	for key, dst := range map[string]*int{"from_id": &filter.FromID, "to_id": &filter.ToID} {
+		if v := q.Get(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, apiError{fmt.Sprintf("invalid %s %q", key, v)})
+				return
+			}
+			*dst = n
+		}
+	}
+	edges, more, err := s.c.ListEdgesPage(filter, page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pageResult("edges", edges, len(edges), more, page))
+}
+
+func (s *server) createEdge(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Type   string         `json:"type"`
+		FromID int            `json:"from_id"`
+		ToID   int            `json:"to_id"`
+		Props  map[string]any `json:"props"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Type == "" || req.FromID == 0 || req.ToID == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{"type, from_id, and to_id are required"})
+		return
+	}
+	for _, id := range []int{req.FromID, req.ToID} {
+		if _, err := s.c.GetNode(id); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	edge, err := s.c.CreateEdgeWithProps(req.Type, req.FromID, req.ToID, req.Props)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, edge)
+}
+
+func (s *server) getEdge(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	edge, err := s.c.GetEdge(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, edge)
+}
+
+func (s *server) deleteEdge(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := s.c.GetEdge(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.c.DeleteEdge(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiError is the body of an error response.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a 404 if something wasn't found, a 400
+// if it broke a graph rule, or a 500 otherwise.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errSelfLoop), errors.Is(err, errContextLimit):
+		status = http.StatusBadRequest
+	case errors.Is(err, errBusy), errors.Is(err, errAwaitingAnswer), errors.Is(err, errAwaitingApproval):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, apiError{err.Error()})
+}
+
+// readJSON decodes the request body (if there is one) into v,
+// writing a 400 and returning false if it isn't valid.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, apiError{fmt.Sprintf("invalid request body: %v", err)})
+		return false
+	}
+	return true
+}
+
+// pathID parses the {id} path value, writing a 400
+// and returning false if it isn't a valid ID.
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.PathValue("id")
+	id, err := strconv.Atoi(v)
+	if err != nil || id <= 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{fmt.Sprintf("invalid ID %q", v)})
+		return 0, false
+	}
+	return id, true
+}
+
+// queryPage parses the optional limit and offset query params, writing
+// a 400 and returning false if they aren't valid.
+func queryPage(w http.ResponseWriter, r *http.Request) (Page, bool) {
+	args := map[string]any{}
+	for _, key := range []string{"limit", "offset"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			args[key] = v
+		}
+	}
+	page, err := argPage(args)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return Page{}, false
+	}
+	return page, true
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+func TestServer(t *testing.T) {
+	url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+		return textReply("hi there")
+	})
+	c := newTestClient(t)
+	a := newTestAgent(t, c, url, agentConfig{})
+	srv := httptest.NewServer(newServer(c, a))
+	t.Cleanup(srv.Close)
+
+	// The steps run in order, each building on the ones before
+	tests := []struct {
+		method, path, body string
+		wantStatus         int
+		wantBody           string // Something the response should contain
+	}{
+		// Chats
+		{"POST", "/chats", `{"name": "test"}`, http.StatusCreated, `"Name":"test"`},
+		{"POST", "/chats", `{"nmae": "typo"}`, http.StatusBadRequest, "invalid request body"},
+		{"GET", "/chats", "", http.StatusOK, `"Name":"test"`},
+		{"GET", "/chats/1", "", http.StatusOK, `"ID":1`},
+		{"GET", "/chats/99", "", http.StatusNotFound, "not found"},
+		{"GET", "/chats/abc", "", http.StatusBadRequest, "invalid ID"},
+		{"GET", "/chats/0", "", http.StatusBadRequest, "invalid ID"},
+
+		// Messages
+		{"POST", "/chats/1/messages", `{"text": ""}`, http.StatusBadRequest, "text is required"},
+		{"POST", "/chats/99/messages", `{"text": "hi"}`, http.StatusNotFound, "not found"},
+		{"POST", "/chats/1/messages", `{"text": "hello"}`, http.StatusCreated, `"Text":"hello"`},
+		{"POST", "/chats/1/generate", "", http.StatusOK, `"Text":"hi there"`},
+		{"GET", "/chats/1/messages", "", http.StatusOK, `"Text":"hi there"`},
+		{"GET", "/chats/99/messages", "", http.StatusNotFound, "not found"},
+
+		// Nodes
+		{"POST", "/nodes", `{"props": {"name": "Alice"}}`, http.StatusBadRequest, "type is required"},
+		{"POST", "/nodes", `{"type": "person", "props": {"name": "Alice"}}`, http.StatusCreated, `"ID":1`},
+		{"POST", "/nodes", `{"type": "person", "props": {"name": "Bob"}}`, http.StatusCreated, `"ID":2`},
+		{"GET", "/nodes/1", "", http.StatusOK, `"name":"Alice"`},
+		{"GET", "/nodes/42", "", http.StatusNotFound, "not found"},
+		{"GET", "/nodes?type=person&limit=1", "", http.StatusOK, `"more":true`},
+		{"GET", "/nodes?limit=x", "", http.StatusBadRequest, "limit"},
+
+		// Edges
+		{"POST", "/edges", `{"type": "knows", "from_id": 1}`, http.StatusBadRequest, "required"},
+		{"POST", "/edges", `{"type": "knows", "from_id": 1, "to_id": 42}`, http.StatusNotFound, "not found"},
+		{"POST", "/edges", `{"type": "knows", "from_id": 1, "to_id": 2}`, http.StatusCreated, `"ID":1`},
+		{"GET", "/edges/1", "", http.StatusOK, `"Type":"knows"`},
+		{"GET", "/edges?from_id=1", "", http.StatusOK, `"Type":"knows"`},
+		{"GET", "/edges?from_id=x", "", http.StatusBadRequest, "invalid from_id"},
+		{"DELETE", "/edges/1", "", http.StatusNoContent, ""},
+		{"DELETE", "/edges/1", "", http.StatusNotFound, "not found"},
+
+		// Cleaning up
+		{"DELETE", "/nodes/1", "", http.StatusNoContent, ""},
+		{"GET", "/nodes/1", "", http.StatusNotFound, "not found"},
+		{"DELETE", "/chats/1", "", http.StatusNoContent, ""},
+		{"DELETE", "/chats/1", "", http.StatusNotFound, "not found"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.wantStatus {
+			t.Errorf("%s %s: status %d, want %d (%s)", tt.method, tt.path, res.StatusCode, tt.wantStatus, body)
+		}
+		if !strings.Contains(string(body), tt.wantBody) {
+			t.Errorf("%s %s: body %s doesn't contain %s", tt.method, tt.path, body, tt.wantBody)
+		}
+	}
+}
+
+func TestServerSelfLoop(t *testing.T) {
+	c, err := newClient(context.Background(), t.TempDir(), clientConfig{NoSelfLoops: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if _, err := c.CreateNode("person", nil); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newServer(c, newTestAgent(t, c, fakeOllama(t, nil), agentConfig{})))
+	t.Cleanup(srv.Close)
+
+	res, err := http.Post(srv.URL+"/edges", "application/json", strings.NewReader(`{"type": "likes", "from_id": 1, "to_id": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+	if !strings.Contains(string(body), errSelfLoop.Error()) {
+		t.Errorf("body %s doesn't explain the error", body)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// reopen checks the database in dir isn't still locked, by
+// opening it again (without waiting long for the lock).
+func reopen(t *testing.T, dir string) *client {
+	t.Helper()
+	c, err := newClient(context.Background(), dir, clientConfig{LockTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestShutdownStopsGenerations(t *testing.T) {
+	// A model that never answers (until the request is cancelled)
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			return
+		}
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) }) // Runs first
+
+	dir := t.TempDir()
+	c, err := newClient(context.Background(), dir, clientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAgent(t, c, srv.URL, agentConfig{})
+	ci, err := c.CreateChat("slow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, "hi")
+
+	wctx, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+	workersDone := make(chan struct{})
+	go func() {
+		defer close(workersDone)
+		a.work(wctx, 1, func(genRequest, error) {})
+	}()
+	a.gc <- genRequest{cid: ci.ID}
+	<-started
+	if ci, err := c.GetChat(ci.ID); err != nil || ci.State != "running" {
+		t.Fatalf("chat state = %q (%v), want running", ci.State, err)
+	}
+
+	stopWork()
+	shutdown(c, workersDone, 5*time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	select {
+	case <-workersDone:
+	default:
+		t.Error("shutdown returned before the workers stopped")
+	}
+
+	c = reopen(t, dir)
+	if ci, err := c.GetChat(ci.ID); err != nil || ci.State != "idle" {
+		t.Errorf("chat state after shutdown = %q (%v), want idle", ci.State, err)
+	}
+}
+
+func TestShutdownGivesUp(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newClient(context.Background(), dir, clientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci, err := c.CreateChat("stuck", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetChatState(ci.ID, "running"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Workers that never stop don't hold up shutdown past the grace period
+	start := time.Now()
+	shutdown(c, make(chan struct{}), 20*time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("shutdown took %v", d)
+	}
+
+	c = reopen(t, dir)
+	if ci, err := c.GetChat(ci.ID); err != nil || ci.State != "idle" {
+		t.Errorf("chat state after shutdown = %q (%v), want idle", ci.State, err)
+	}
+}
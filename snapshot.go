@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// snapshotBucket holds the metadata for each graph snapshot.
+// The snapshots' contents are kept in buckets of their own.
+const snapshotBucket = "graph:snapshots"
+
+// Snapshot describes a saved copy of a graph.
+type Snapshot struct {
+	ID      string
+	Label   string
+	Graph   string `json:",omitempty"` // Private graph it was taken of (empty for the shared graph)
+	Created time.Time
+	Nodes   int
+	Edges   int
+}
+
+// snapshotBuckets returns the graph buckets a snapshot copies.
+func snapshotBuckets() []string {
+	return []string{nodeBucket, edgeBucket, embeddingBucket}
+}
+
+// snapshotDataBucket returns the name of the bucket a snapshot
+// keeps its copy of one of the graph's buckets in.
+func snapshotDataBucket(id, base string) []byte {
+	return []byte("snapshot:" + id + ":" + base)
+}
+
+// SnapshotGraph saves a copy of the graph's nodes, edges, and
+// embeddings under a label, returning the snapshot's ID.
+func (c *client) SnapshotGraph(label string) (string, error) {
+	var id string
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		sb, err := tx.CreateBucketIfNotExists([]byte(snapshotBucket))
+		if err != nil {
+			return fmt.Errorf("failed to get/create snapshot bucket: %w", err)
+		}
+		seq, err := sb.NextSequence()
+		if err != nil {
+			return fmt.Errorf("failed to get next sequence: %w", err)
+		}
+		id = strconv.FormatUint(seq, 10)
+
+		// Copy each of the graph's buckets
+		s := Snapshot{
+			ID:      id,
+			Label:   label,
+			Graph:   c.graph,
+			Created: time.Now().UTC(),
+		}
+		for _, base := range snapshotBuckets() {
+			n, err := copyBucket(tx, c.graphBucket(base), snapshotDataBucket(id, base))
+			if err != nil {
+				return err
+			}
+			switch base {
+			case nodeBucket:
+				s.Nodes = n
+			case edgeBucket:
+				s.Edges = n
+			}
+		}
+
+		data, err := json.Marshal(s)
+		if err != nil {
+			return fmt.Errorf("failed to marshal snapshot: %w", err)
+		}
+		if err := sb.Put([]byte(id), data); err != nil {
+			return fmt.Errorf("failed to put snapshot into db: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("failed to snapshot graph: %w", err)
+	}
+	return id, nil
+}
+
+// ListSnapshots returns the graph snapshots, oldest first.
+func (c *client) ListSnapshots() ([]Snapshot, error) {
+	var ss []Snapshot
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		sb := tx.Bucket([]byte(snapshotBucket))
+		if sb == nil {
+			return nil // No snapshots yet
+		}
+		return sb.ForEach(func(k, v []byte) error {
+			var s Snapshot
+			if err := json.Unmarshal(v, &s); err != nil {
+				return fmt.Errorf("failed to unmarshal snapshot: %w", err)
+			}
+			ss = append(ss, s)
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	// Keys are strings, so put them back in numeric order
+	sort.Slice(ss, func(i, j int) bool {
+		a, _ := strconv.Atoi(ss[i].ID)
+		b, _ := strconv.Atoi(ss[j].ID)
+		return a < b
+	})
+	return ss, nil
+}
+
+// RestoreGraph replaces the graph a snapshot was taken of with the
+// snapshot's copy. Anything added since is lost, so take another
+// snapshot first to be able to come back. The snapshot is kept.
+func (c *client) RestoreGraph(id string) error {
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		sb := tx.Bucket([]byte(snapshotBucket))
+		if sb == nil {
+			return fmt.Errorf("snapshot %s %w", id, errNotFound)
+		}
+		data := sb.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("snapshot %s %w", id, errNotFound)
+		}
+		var s Snapshot
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("failed to unmarshal snapshot: %w", err)
+		}
+
+		// Swap each of the graph's buckets for the snapshot's copy
+		g := c.withGraph(s.Graph)
+		for _, base := range snapshotBuckets() {
+			live := g.graphBucket(base)
+			if err := tx.DeleteBucket(live); err != nil && !errors.Is(err, berrors.ErrBucketNotFound) {
+				return fmt.Errorf("failed to delete graph bucket: %w", err)
+			}
+			if _, err := copyBucket(tx, snapshotDataBucket(id, base), live); err != nil {
+				return err
+			}
+		}
+		return c.writeAudit(tx, "restore", "graph", 0, s)
+	}); err != nil {
+		return fmt.Errorf("failed to restore graph: %w", err)
+	}
+	return nil
+}
+
+// DeleteSnapshot deletes a graph snapshot.
+func (c *client) DeleteSnapshot(id string) error {
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		sb := tx.Bucket([]byte(snapshotBucket))
+		if sb == nil || sb.Get([]byte(id)) == nil {
+			return fmt.Errorf("snapshot %s %w", id, errNotFound)
+		}
+		for _, base := range snapshotBuckets() {
+			if err := tx.DeleteBucket(snapshotDataBucket(id, base)); err != nil && !errors.Is(err, berrors.ErrBucketNotFound) {
+				return fmt.Errorf("failed to delete snapshot bucket: %w", err)
+			}
+		}
+		return sb.Delete([]byte(id))
+	}); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	return nil
+}
+
+// copyBucket copies the keys (and sequence) of one bucket into a new
+// bucket within the transaction, returning how many keys it copied.
+// A missing source (e.g. no embeddings yet) is copied as empty.
+func copyBucket(tx *bolt.Tx, from, to []byte) (int, error) {
+	dst, err := tx.CreateBucket(to)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create bucket %q: %w", to, err)
+	}
+	src := tx.Bucket(from)
+	if src == nil {
+		return 0, nil
+	}
+	var n int
+	if err := src.ForEach(func(k, v []byte) error {
+		n++
+		return dst.Put(k, v)
+	}); err != nil {
+		return 0, fmt.Errorf("failed to copy bucket %q: %w", from, err)
+	}
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return 0, fmt.Errorf("failed to set sequence: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(t *testing.T, c *client)
+	}{
+		{"added node", func(t *testing.T, c *client) {
+			if _, err := c.CreateNode("person", map[string]any{"name": "Dave"}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"deleted node and its edges", func(t *testing.T, c *client) {
+			if err := c.DeleteNode(1); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"updated node", func(t *testing.T, c *client) {
+			if _, err := c.UpdateNode(2, map[string]any{"name": "Robert"}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"deleted edge", func(t *testing.T, c *client) {
+			if err := c.DeleteEdge(3); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"embedded node", func(t *testing.T, c *client) {
+			if err := c.SetNodeEmbedding(1, []float32{1, 0}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testGraph(t)
+			before := graphState(t, c)
+
+			id, err := c.SnapshotGraph("before")
+			if err != nil {
+				t.Fatalf("SnapshotGraph() failed: %v", err)
+			}
+			tt.mutate(t, c)
+			if err := c.RestoreGraph(id); err != nil {
+				t.Fatalf("RestoreGraph() failed: %v", err)
+			}
+			if after := graphState(t, c); after != before {
+				t.Errorf("restored graph is\n%s\nwant\n%s", after, before)
+			}
+
+			// New nodes carry on from the snapshot's IDs
+			n, err := c.CreateNode("person", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.ID != 6 {
+				t.Errorf("new node has ID %d, want 6", n.ID)
+			}
+		})
+	}
+}
+
+// graphState renders the graph's nodes, edges, and
+// embeddings, for comparing before and after.
+func graphState(t *testing.T, c *client) string {
+	t.Helper()
+	nodes, err := c.ListNodes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges, err := c.ListEdges(EdgeFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded, err := c.EmbeddedNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	for _, n := range nodes {
+		s += fmt.Sprintf("node %d %s %v embedded=%v\n", n.ID, n.Type, n.Props, embedded[n.ID])
+	}
+	for _, e := range edges {
+		s += fmt.Sprintf("edge %d %s %d->%d %v\n", e.ID, e.Type, e.FromID, e.ToID, e.Props)
+	}
+	return s
+}
+
+func TestSnapshots(t *testing.T) {
+	c := testGraph(t)
+	var ids []string
+	for _, label := range []string{"one", "two", "three"} {
+		id, err := c.SnapshotGraph(label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := c.DeleteSnapshot(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := c.ListSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for _, s := range ss {
+		labels = append(labels, s.Label)
+		if s.Nodes != 5 || s.Edges != 4 {
+			t.Errorf("snapshot %s has %d nodes and %d edges, want 5 and 4", s.Label, s.Nodes, s.Edges)
+		}
+	}
+	if !slices.Equal(labels, []string{"one", "three"}) {
+		t.Errorf("snapshots = %v, want [one three]", labels)
+	}
+
+	for _, err := range []error{c.RestoreGraph(ids[1]), c.DeleteSnapshot(ids[1])} {
+		if !errors.Is(err, errNotFound) {
+			t.Errorf("using a deleted snapshot: err = %v, want %v", err, errNotFound)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DBStats summarizes how big the database is.
+type DBStats struct {
+	Path     string
+	FileSize int64 // Size of the database file on disk, in bytes
+	Buckets  int   // Number of top-level buckets
+	Chats    int
+	Messages int // Across all chats
+	Nodes    int
+	Edges    int
+}
+
+// Stats reports the database's size on disk and how many
+// chats, messages, nodes, and edges it holds.
+func (c *client) Stats() (DBStats, error) {
+	s := DBStats{Path: c.dbp}
+	fi, err := os.Stat(c.dbp)
+	if err != nil {
+		return s, fmt.Errorf("failed to stat database file: %w", err)
+	}
+	s.FileSize = fi.Size()
+
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		count := func(name []byte) int {
+			if b := tx.Bucket(name); b != nil {
+				return b.Stats().KeyN
+			}
+			return 0
+		}
+		s.Chats = count([]byte(chatBucket))
+		s.Nodes = count(c.graphBucket(nodeBucket))
+		s.Edges = count(c.graphBucket(edgeBucket))
+
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			s.Buckets++
+			if bytes.HasPrefix(name, []byte(messagesPrefix)) {
+				s.Messages += b.Stats().KeyN
+			}
+			return nil
+		})
+	}); err != nil {
+		return s, fmt.Errorf("failed to get database stats: %w", err)
+	}
+	return s, nil
+}
+
+// humanBytes formats a number of bytes using binary
+// units, e.g. "512 B" or "1.5 MiB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
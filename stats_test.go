@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	c := testGraph(t)
+	for _, n := range []int{3, 2} {
+		ci, err := c.CreateChat("chat", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range n {
+			addUserMessage(t, c, ci.ID, "hi")
+		}
+	}
+
+	s, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Path != c.dbp || s.FileSize <= 0 {
+		t.Errorf("Stats() file = %q (%d bytes)", s.Path, s.FileSize)
+	}
+	want := DBStats{Path: s.Path, FileSize: s.FileSize, Buckets: s.Buckets, Chats: 2, Messages: 5, Nodes: 5, Edges: 4}
+	if s != want {
+		t.Errorf("Stats() = %+v, want %+v", s, want)
+	}
+
+	// Meta, chats, nodes, edges, audit, and the chats' messages
+	if s.Buckets != 7 {
+		t.Errorf("Stats() buckets = %d, want 7", s.Buckets)
+	}
+
+	// A private graph's stats count its own nodes and edges
+	ci, err := c.CreatePrivateChat("private", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := c.ForChat(ci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.CreateNode("city", nil); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = g.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Chats != 3 || s.Nodes != 1 || s.Edges != 0 {
+		t.Errorf("private graph's Stats() = %+v, want 3 chats, 1 node and no edges", s)
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{512, "512 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := humanBytes(tt.n); got != tt.want {
+			t.Errorf("humanBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
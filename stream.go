@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	ollama "github.com/ollama/ollama/api"
+	bolt "go.etcd.io/bbolt"
+)
+
+// streamFlushInterval is how often streamed text is written
+// to the agent's message.
+const streamFlushInterval = 100 * time.Millisecond
+
+// AppendToMessageText appends delta to a message's text (the user's,
+// agent's, or summary's text, depending on its type) and saves it,
+// letting subscribers know. Each call is its own write transaction,
+// so callers streaming many small deltas should batch them with a
+// textAppender rather than calling this for every token.
+func (c *client) AppendToMessageText(chatID, messageID int, delta string) error {
+	var msg Message
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ChatInfo{ID: chatID}.MessageBucketName())
+		if bucket == nil {
+			return fmt.Errorf("chat messages bucket not found")
+		}
+		data := bucket.Get(itob(messageID))
+		if data == nil {
+			return fmt.Errorf("message with ID %d %w", messageID, errNotFound)
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		if !msg.Valid() {
+			return fmt.Errorf("message %d is malformed", messageID)
+		}
+
+		// Append to whichever text the message has
+		switch msg.MType {
+		case "user":
+			msg.UserMsg.Text += delta
+		case "agent":
+			msg.AgentMsg.Text += delta
+		case "summary":
+			msg.SummaryMsg.Text += delta
+		default:
+			return fmt.Errorf("can't append text to a %q message", msg.MType)
+		}
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+		if err := bucket.Put(itob(messageID), data); err != nil {
+			return fmt.Errorf("failed to put message into db: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to append to message: %w", err)
+	}
+
+	c.publish(msg)
+	return nil
+}
+
+// textAppender buffers streamed text for a message, writing it to the
+// database at most once per interval (plus once more when finalized),
+// so a fast stream of tokens doesn't turn into a write per token.
+type textAppender struct {
+	c                 *client
+	chatID, messageID int
+	interval          time.Duration
+	mu                sync.Mutex
+	buf               strings.Builder
+	last              time.Time
+}
+
+// newTextAppender returns an appender for a message that flushes
+// at most once per interval.
+func (c *client) newTextAppender(chatID, messageID int, interval time.Duration) *textAppender {
+	return &textAppender{
+		c:         c,
+		chatID:    chatID,
+		messageID: messageID,
+		interval:  interval,
+		last:      time.Now(),
+	}
+}
+
+// Append adds delta to the buffer, flushing it if the
+// interval has passed since the last flush.
+func (t *textAppender) Append(delta string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf.WriteString(delta)
+	if time.Since(t.last) < t.interval {
+		return nil
+	}
+	return t.flush()
+}
+
+// Finalize writes whatever is still buffered. The
+// appender shouldn't be used afterwards.
+func (t *textAppender) Finalize() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.flush()
+}
+
+// flush writes the buffer to the message. The caller must hold mu.
+func (t *textAppender) flush() error {
+	t.last = time.Now()
+	if t.buf.Len() == 0 {
+		return nil
+	}
+	if err := t.c.AppendToMessageText(t.chatID, t.messageID, t.buf.String()); err != nil {
+		return err
+	}
+	t.buf.Reset()
+	return nil
+}
+
+// responseStream turns a streamed response into messages as it
+// arrives. Text goes on an agent message, and each tool call is saved
+// and run as soon as it has streamed in, rather than once the whole
+// response is done. Text after a tool call starts a new agent message,
+// so the chat's history stays in the order the model wrote it.
+// Once a tool call is left waiting on the user (a question or an
+// approval), the rest of the response is dropped, so that call stays
+// the chat's last message until the user gets to it.
+type responseStream struct {
+	a       *agent
+	ctx     context.Context // For the tool calls (carrying the chat's graph)
+	cid     int
+	model   string        // The model generating the response
+	text    *textAppender // Writes the current agent message's text
+	last    *Message      // The message the stream last created
+	blocked bool          // Whether the last message waits on the user
+}
+
+// add handles one chunk of the response.
+func (s *responseStream) add(resp ollama.ChatResponse) error {
+	// Waiting on the user? Then only the usage is kept.
+	if s.blocked {
+		resp.Message.Content = ""
+		resp.Message.ToolCalls = nil
+	}
+
+	// Text goes on the current agent message, starting one if needed
+	if c := resp.Message.Content; c != "" {
+		if s.last != nil && s.last.MType == "agent" {
+			s.last.AgentMsg.Text += c
+			if err := s.text.Append(c); err != nil {
+				return err
+			}
+		} else {
+			m, err := s.a.c.CreateMessage(Message{
+				ChatID: s.cid,
+				MType:  "agent",
+				Model:  s.model,
+				AgentMsg: &struct {
+					Text         string
+					StopReason   string
+					CitedNodeIDs []int `json:",omitempty"`
+				}{Text: c},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create message: %w", err)
+			}
+			s.last = m
+			s.text = s.a.c.newTextAppender(s.cid, m.MessageID, streamFlushInterval)
+		}
+	}
+
+	// Run each tool call as soon as it's here
+	for _, tc := range resp.Message.ToolCalls {
+		if err := s.finishText(); err != nil {
+			return err
+		}
+		m, err := s.a.c.CreateMessage(Message{
+			ChatID: s.cid,
+			MType:  "tool",
+			Model:  s.model,
+			ToolMsg: &struct {
+				ToolDone    bool
+				ToolName    string
+				ToolArgs    map[string]any
+				ToolResult  string
+				ToolError   string
+				ToolPreview string `json:",omitempty"`
+			}{
+				ToolDone: true, // Tool calls arrive whole
+				ToolName: tc.Function.Name,
+				ToolArgs: tc.Function.Arguments,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create message: %w", err)
+		}
+		s.last = m
+
+		// Cancelled? Leave the call for rollback to clean up.
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		s.a.log.Debug("calling the tool mid-stream", "chat", s.cid, "tool", tc.Function.Name)
+		if err := s.a.handleToolCall(s.ctx, m); err != nil {
+			return fmt.Errorf("failed to handle tool call: %w", err)
+		}
+		if waitsOnUser(*m) {
+			s.a.log.Debug("tool call waits on the user, dropping the rest of the stream", "chat", s.cid, "tool", tc.Function.Name)
+			s.blocked = true
+			break
+		}
+	}
+
+	if !resp.Done || s.last == nil {
+		return nil
+	}
+
+	// Done: note the usage (and how the reply ended) on the last message
+	if err := s.finishText(); err != nil {
+		return err
+	}
+	s.last.InputTokens += resp.PromptEvalCount
+	s.last.OutputTokens += resp.EvalCount
+	if s.last.MType == "agent" {
+		s.last.AgentMsg.StopReason = resp.DoneReason
+		ms, err := s.a.c.ListMessages(s.cid)
+		if err != nil {
+			return fmt.Errorf("failed to list messages: %w", err)
+		}
+		s.last.AgentMsg.CitedNodeIDs = citedNodeIDs(ms, s.last.AgentMsg.Text)
+	}
+	if s.a.cfg.Debug {
+		raw, err := json.Marshal(resp)
+		if err != nil {
+			return fmt.Errorf("failed to marshal raw response: %w", err)
+		}
+		s.last.RawMeta = raw
+	}
+	return s.a.c.UpdateMessage(*s.last)
+}
+
+// finishText writes the rest of the current agent message's text.
+func (s *responseStream) finishText() error {
+	if s.text == nil {
+		return nil
+	}
+	err := s.text.Finalize()
+	s.text = nil
+	return err
+}
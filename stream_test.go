@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+// fakeOllamaStream serves ollama's chat endpoint, streaming
+// chunks back as the response (marking the last one done).
+func fakeOllamaStream(t *testing.T, chunks ...ollama.ChatResponse) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for i, c := range chunks {
+			c.Done = i == len(chunks)-1
+			enc.Encode(c)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestStreamStopsAtBlockingCall(t *testing.T) {
+	del := toolCall("delete_node", map[string]any{"id": 1})
+	tests := []struct {
+		name   string
+		chunks []ollama.ChatResponse
+		cfg    agentConfig
+		want   string // Types of the chat's messages
+	}{
+		{
+			name:   "text after a question",
+			chunks: []ollama.ChatResponse{textReply("Let me check. "), toolCall("ask_user", map[string]any{"question": "Which one?"}), textReply("I'll go with the first.")},
+			want:   "user,agent,tool",
+		},
+		{
+			name:   "tool call after a question",
+			chunks: []ollama.ChatResponse{toolCall("ask_user", map[string]any{"question": "Which one?"}), toolCall("list_nodes", map[string]any{})},
+			want:   "user,tool",
+		},
+		{
+			name:   "text after an approval",
+			chunks: []ollama.ChatResponse{del, textReply("Deleted it.")},
+			cfg:    agentConfig{ConfirmDel: true},
+			want:   "user,tool",
+		},
+		{
+			name:   "text after a call that ran",
+			chunks: []ollama.ChatResponse{toolCall("list_nodes", map[string]any{}), textReply("Here they are.")},
+			want:   "user,tool,agent",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			if _, err := c.CreateNode("person", map[string]any{"name": "Alice"}); err != nil {
+				t.Fatal(err)
+			}
+			cfg := tt.cfg
+			cfg.Stream = true
+			a := newTestAgent(t, c, fakeOllamaStream(t, tt.chunks...), cfg)
+			ci, err := c.CreateChat("stream", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addUserMessage(t, c, ci.ID, "go")
+
+			if _, err := a.generate(context.Background(), ci.ID, ""); err != nil {
+				t.Fatalf("generate failed: %v", err)
+			}
+			ms, err := c.ListMessages(ci.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var types []string
+			for _, m := range ms {
+				types = append(types, m.MType)
+			}
+			if got := strings.Join(types, ","); got != tt.want {
+				t.Errorf("messages = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendToMessageText(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("append", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentMsg := toolMessage(t, `{"MType": "agent", "AgentMsg": {"Text": ""}}`)
+	toolMsg := toolMessage(t, `{"MType": "tool", "ToolMsg": {"ToolName": "list_nodes"}}`)
+	for _, m := range []*Message{&agentMsg, &toolMsg} {
+		m.ChatID = ci.ID
+		saved, err := c.CreateMessage(*m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*m = *saved
+	}
+
+	for _, delta := range []string{"Hel", "lo", "", " world"} {
+		if err := c.AppendToMessageText(ci.ID, agentMsg.MessageID, delta); err != nil {
+			t.Fatalf("failed to append %q: %v", delta, err)
+		}
+	}
+	m, err := c.GetMessage(ci.ID, agentMsg.MessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.AgentMsg.Text != "Hello world" {
+		t.Errorf("text = %q, want %q", m.AgentMsg.Text, "Hello world")
+	}
+
+	// Only messages with text can be appended to
+	if err := c.AppendToMessageText(ci.ID, toolMsg.MessageID, "x"); err == nil {
+		t.Error("appended to a tool message")
+	}
+	if err := c.AppendToMessageText(ci.ID, 99, "x"); !errors.Is(err, errNotFound) {
+		t.Errorf("appending to a missing message: error = %v, want errNotFound", err)
+	}
+}
+
+func TestTextAppender(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("append", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := c.CreateMessage(Message{ChatID: ci.ID, MType: "user", UserMsg: &struct{ Text string }{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := func() string {
+		t.Helper()
+		got, err := c.GetMessage(ci.ID, m.MessageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.UserMsg.Text
+	}
+
+	// Appends are held until the interval passes (or it's finalized)
+	ta := c.newTextAppender(ci.ID, m.MessageID, time.Hour)
+	for _, delta := range []string{"b", "c", "d"} {
+		if err := ta.Append(delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := text(); got != "a" {
+		t.Errorf("text before finalizing = %q, want it unchanged", got)
+	}
+	if err := ta.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if got := text(); got != "abcd" {
+		t.Errorf("text after finalizing = %q, want %q", got, "abcd")
+	}
+
+	// Without an interval, each one is written straight away
+	ta = c.newTextAppender(ci.ID, m.MessageID, 0)
+	if err := ta.Append("e"); err != nil {
+		t.Fatal(err)
+	}
+	if got := text(); got != "abcde" {
+		t.Errorf("text = %q, want %q", got, "abcde")
+	}
+}
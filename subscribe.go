@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// subBuffer is how many events a subscriber can fall
+// behind by before new ones are dropped.
+const subBuffer = 64
+
+// MessageEvent is a change to one of a chat's messages.
+type MessageEvent struct {
+	Message Message // The message as it now is (only its IDs, if it was deleted)
+	Deleted bool
+}
+
+// subscribers fans out message events to the listeners for each chat.
+type subscribers struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]map[int]chan MessageEvent // Listeners, by chat ID then subscription ID
+}
+
+// Subscribe returns a channel that receives an event for each message
+// created, updated, or deleted in a chat (after the change has been
+// committed), along with a func that ends the subscription and closes
+// the channel. Sends never block the writer, so a subscriber that
+// falls too far behind misses events and should reload the chat to
+// catch up.
+func (c *client) Subscribe(chatID int) (<-chan MessageEvent, func()) {
+	s := c.subs
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = map[int]map[int]chan MessageEvent{}
+	}
+	if s.subs[chatID] == nil {
+		s.subs[chatID] = map[int]chan MessageEvent{}
+	}
+	id := s.next
+	s.next++
+	ch := make(chan MessageEvent, subBuffer)
+	s.subs[chatID][id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.subs[chatID], id)
+			if len(s.subs[chatID]) == 0 {
+				delete(s.subs, chatID)
+			}
+			close(ch)
+		})
+	}
+}
+
+// publish lets everyone subscribed to the messages' chats know
+// they were created or updated.
+func (c *client) publish(msgs ...Message) {
+	for _, msg := range msgs {
+		c.send(MessageEvent{Message: msg})
+	}
+}
+
+// publishDeleted lets everyone subscribed to a chat
+// know some of its messages were deleted.
+func (c *client) publishDeleted(chatID int, ids ...int) {
+	for _, id := range ids {
+		c.send(MessageEvent{Message: Message{ChatID: chatID, MessageID: id}, Deleted: true})
+	}
+}
+
+// send sends an event to everyone subscribed to its chat.
+func (c *client) send(ev MessageEvent) {
+	s := c.subs
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ch := range s.subs[ev.Message.ChatID] {
+		select {
+		case ch <- ev:
+		default: // Subscriber is full; drop it rather than block
+		}
+	}
+}
+
+// messageIDs returns the IDs of the messages in a chat's bucket.
+func messageIDs(b *bolt.Bucket) []int {
+	var ids []int
+	b.ForEach(func(k, _ []byte) error {
+		ids = append(ids, int(binary.BigEndian.Uint64(k)))
+		return nil
+	})
+	return ids
+}
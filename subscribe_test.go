@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// event describes a MessageEvent for comparing in tests.
+type event struct {
+	id      int
+	mtype   string
+	deleted bool
+}
+
+// drain returns the events waiting on a subscription.
+func drain(events <-chan MessageEvent) []event {
+	var evs []event
+	for {
+		select {
+		case ev := <-events:
+			evs = append(evs, event{ev.Message.MessageID, ev.Message.MType, ev.Deleted})
+		default:
+			return evs
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	tests := []struct {
+		name   string
+		types  string // Messages in the chat to start with (see testMessages)
+		mutate func(t *testing.T, c *client, cid int)
+		want   []event
+	}{
+		{"create", "", func(t *testing.T, c *client, cid int) {
+			addUserMessage(t, c, cid, "hi")
+		}, []event{{1, "user", false}}},
+		{"update", "u", func(t *testing.T, c *client, cid int) {
+			m, err := c.GetMessage(cid, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.UserMsg.Text = "edited"
+			if err := c.UpdateMessage(*m); err != nil {
+				t.Fatal(err)
+			}
+		}, []event{{1, "user", false}}},
+		{"pin", "ua", func(t *testing.T, c *client, cid int) {
+			if err := c.SetMessagePinned(cid, 2, true); err != nil {
+				t.Fatal(err)
+			}
+		}, []event{{2, "agent", false}}},
+		{"delete", "ua", func(t *testing.T, c *client, cid int) {
+			if err := c.DeleteMessage(cid, 2); err != nil {
+				t.Fatal(err)
+			}
+		}, []event{{2, "", true}}},
+		{"clear an error", "ue", func(t *testing.T, c *client, cid int) {
+			if _, err := c.ClearErrorMessage(cid, 2); err != nil {
+				t.Fatal(err)
+			}
+		}, []event{{2, "", true}}},
+		{"delete replies", "uatua", func(t *testing.T, c *client, cid int) {
+			if _, err := c.DeleteTrailingReplies(cid); err != nil {
+				t.Fatal(err)
+			}
+		}, []event{{5, "", true}}},
+		{"compact", "uaua", func(t *testing.T, c *client, cid int) {
+			if _, err := c.CompactMessages(cid, []int{1, 2}, "they talked"); err != nil {
+				t.Fatal(err)
+			}
+		}, []event{{1, "user", false}, {2, "agent", false}, {5, "summary", false}}},
+		{"clear", "ua", func(t *testing.T, c *client, cid int) {
+			if err := c.ClearChat(cid); err != nil {
+				t.Fatal(err)
+			}
+		}, []event{{1, "", true}, {2, "", true}}},
+		{"merge", "u", func(t *testing.T, c *client, cid int) {
+			other, err := c.CreateChat("other", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addUserMessage(t, c, other.ID, "from the other chat")
+			if err := c.MergeChats(cid, other.ID, false); err != nil {
+				t.Fatal(err)
+			}
+		}, []event{{2, "user", false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			ci, err := c.CreateChat("test", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range testMessages(tt.types) {
+				m.ChatID = ci.ID
+				payload(&m)
+				if _, err := c.CreateMessage(m); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			events, cancel := c.Subscribe(ci.ID)
+			defer cancel()
+			others, cancelOthers := c.Subscribe(ci.ID + 1000)
+			defer cancelOthers()
+
+			tt.mutate(t, c, ci.ID)
+			got := drain(events)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got events %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d is %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+			if evs := drain(others); len(evs) > 0 {
+				t.Errorf("another chat's subscriber got %v", evs)
+			}
+		})
+	}
+}
+
+// payload fills in an empty payload for a message's type.
+func payload(m *Message) {
+	var raw string
+	switch m.MType {
+	case "user":
+		raw = `{"UserMsg": {"Text": "hi"}}`
+	case "agent":
+		raw = `{"AgentMsg": {"Text": "hello"}}`
+	case "tool":
+		raw = `{"ToolMsg": {"ToolName": "list_nodes", "ToolDone": true, "ToolResult": "[]"}}`
+	case "summary":
+		raw = `{"SummaryMsg": {"Text": "summary"}}`
+	case "error":
+		raw = `{"ErrorMsg": {"Text": "oops"}}`
+	}
+	json.Unmarshal([]byte(raw), m)
+}
+
+func TestSubscribeCancel(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, cancelFirst := c.Subscribe(ci.ID)
+	second, cancelSecond := c.Subscribe(ci.ID)
+	defer cancelSecond()
+
+	cancelFirst()
+	cancelFirst() // Cancelling twice is fine
+	if _, ok := <-first; ok {
+		t.Fatal("cancelled subscription's channel is still open")
+	}
+
+	// The other subscriber still gets events
+	addUserMessage(t, c, ci.ID, "hi")
+	if got := drain(second); len(got) != 1 {
+		t.Errorf("remaining subscriber got %v, want one event", got)
+	}
+}
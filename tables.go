@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// maxCellWidth is the most characters of a value shown in a
+// table cell (nested values like props can be long).
+const maxCellWidth = 40
+
+// resultTable renders a list-shaped tool result as a table, fitting
+// it in width. The result can be an array of objects, or an object
+// holding one (like list_nodes' {"nodes": [...], "more": true}), in
+// which case its other fields are listed under the table. It returns
+// false for results that aren't lists of objects.
+func resultTable(result string, width int) (string, bool) {
+	var v any
+	if err := json.Unmarshal([]byte(result), &v); err != nil {
+		return "", false
+	}
+
+	// Find the list
+	var rows []any
+	var extra []string
+	switch v := v.(type) {
+	case []any:
+		rows = v
+	case map[string]any:
+		for k, f := range v {
+			if l, ok := f.([]any); ok && rows == nil {
+				rows = l
+				continue
+			}
+			extra = append(extra, fmt.Sprintf("%s: %s", k, cellText(f)))
+		}
+		sort.Strings(extra)
+	}
+	if len(rows) == 0 {
+		return "", false
+	}
+
+	// Every row has to be an object. The columns are all their keys,
+	// since empty fields may be left out of some of them.
+	seen := map[string]bool{}
+	var cols []string
+	for _, r := range rows {
+		obj, ok := r.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		for k := range obj {
+			if !seen[k] {
+				seen[k] = true
+				cols = append(cols, k)
+			}
+		}
+	}
+	sort.Slice(cols, func(i, j int) bool {
+		// IDs first, then in alphabetical order
+		if (cols[i] == "ID") != (cols[j] == "ID") {
+			return cols[i] == "ID"
+		}
+		return cols[i] < cols[j]
+	})
+
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		Headers(cols...).
+		Width(width)
+	for _, r := range rows {
+		obj := r.(map[string]any)
+		cells := make([]string, len(cols))
+		for i, c := range cols {
+			if f, ok := obj[c]; ok {
+				cells[i] = cellText(f)
+			}
+		}
+		t.Row(cells...)
+	}
+	return strings.Join(append([]string{t.String()}, extra...), "\n"), true
+}
+
+// cellText formats a value for a table cell: strings as they
+// are, and anything else as (shortened) JSON.
+func cellText(v any) string {
+	s, ok := v.(string)
+	if !ok {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		s = string(data)
+	}
+	if r := []rune(s); len(r) > maxCellWidth {
+		s = string(r[:maxCellWidth-3]) + "..."
+	}
+	return s
+}
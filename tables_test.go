@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResultTable(t *testing.T) {
+	c := testGraph(t)
+	a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{})
+	ci, err := c.CreateChat("tables", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := callTool(t, a, ci.ID, "list_nodes", map[string]any{"node_type": "person", "limit": 2})
+
+	got, ok := resultTable(m.ToolMsg.ToolResult, 100)
+	if !ok {
+		t.Fatalf("list_nodes result %q wasn't shown as a table", m.ToolMsg.ToolResult)
+	}
+	lines := strings.Split(got, "\n")
+	header := strings.Join(strings.FieldsFunc(lines[1], func(r rune) bool {
+		return r == '│' || r == ' '
+	}), " ")
+	if want := "ID LastAccessedAt Props Type"; header != want {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	for _, want := range []string{"Alice", "Bob"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("table is missing %s:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Carol") {
+		t.Errorf("table has a node past the limit:\n%s", got)
+	}
+	// The paging fields go under it
+	if tail := lines[len(lines)-2:]; tail[0] != "more: true" || tail[1] != "next_offset: 2" {
+		t.Errorf("table ends with %q, want the paging fields", tail)
+	}
+	for i, l := range lines {
+		if w := len([]rune(l)); w > 100 {
+			t.Errorf("line %d is %d wide, want at most 100", i, w)
+		}
+	}
+}
+
+func TestResultTableFallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+	}{
+		{"not json", "not json"},
+		{"a single object", `{"ID": 1, "Type": "city"}`},
+		{"an empty list", `[]`},
+		{"a list of strings", `["a", "b"]`},
+		{"a mixed list", `[{"ID": 1}, 2]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, ok := resultTable(tt.result, 80); ok {
+				t.Errorf("resultTable() made a table:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestCellText(t *testing.T) {
+	long := strings.Repeat("x", maxCellWidth+5)
+	tests := []struct {
+		v    any
+		want string
+	}{
+		{"Paris", "Paris"},
+		{float64(3), "3"},
+		{map[string]any{"name": "Bob"}, `{"name":"Bob"}`},
+		{long, long[:maxCellWidth-3] + "..."},
+	}
+	for _, tt := range tests {
+		if got := cellText(tt.v); got != tt.want {
+			t.Errorf("cellText(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
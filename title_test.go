@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+func TestTitleFromText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"hello", "hello"},
+		{"  plan a   trip\nto Paris ", "plan a trip to Paris"},
+		{"one two three four five six", "one two three four five six"},
+		{"one two three four five six seven", "one two three four five six..."},
+	}
+	for _, tt := range tests {
+		if got := titleFromText(tt.text); got != tt.want {
+			t.Errorf("titleFromText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMaybeTitleChat(t *testing.T) {
+	tests := []struct {
+		name      string
+		chatName  string // Name to create the chat with ("" for none)
+		rename    string // Name to rename it to before the first message (if any)
+		llm       bool
+		wantTitle string
+	}{
+		{"untitled", "", "", false, "Plan a trip to Paris"},
+		{"untitled with llm", "", "", true, "Paris trip"},
+		{"named", "Travel", "", false, "Travel"},
+		{"named like the placeholder", untitledChat, "", false, untitledChat},
+		{"renamed", "", "Holidays", false, "Holidays"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fakeOllama(t, func(req ollama.ChatRequest) ollama.ChatResponse {
+				if req.Messages[0].Role == "system" && len(req.Messages) == 2 {
+					return textReply(`"Paris trip"`)
+				}
+				return textReply("ok")
+			})
+			c := newTestClient(t)
+			a := newTestAgent(t, c, url, agentConfig{LLMTitles: tt.llm})
+			ci, err := c.CreateChat(tt.chatName, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.rename != "" {
+				if err := c.RenameChat(ci.ID, tt.rename); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Titled once, from the first message only
+			for _, text := range []string{"Plan a trip to Paris", "Something else entirely"} {
+				addUserMessage(t, c, ci.ID, text)
+				a.maybeTitleChat(context.Background(), ci.ID)
+			}
+			got, err := c.GetChat(ci.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != tt.wantTitle {
+				t.Errorf("chat name = %q, want %q", got.Name, tt.wantTitle)
+			}
+		})
+	}
+}
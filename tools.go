@@ -0,0 +1,714 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+// Tool is a function the model can call, along with the
+// schema that describes its arguments to the model.
+type Tool struct {
+	Name        string
+	Description string
+	Required    []string             // Names of the required params
+	Params      map[string]ToolParam // Params, by name
+	Write       bool                 // Whether the tool modifies data (disabled in read-only mode)
+	Handler     func(ctx context.Context, args map[string]any) (any, error)
+
+	// Preview returns what a call would change without changing it,
+	// for the user to approve first (when deletes are confirmed)
+	Preview func(ctx context.Context, args map[string]any) (any, error)
+
+	// Ready reports whether the tool is worth offering to the model
+	// right now (e.g. searching needs embeddings). Nil means always.
+	Ready func(ctx context.Context) bool
+}
+
+// ToolParam describes one of a tool's arguments.
+type ToolParam struct {
+	Type        string // JSON schema type (e.g. "string", "integer", "object")
+	Description string
+}
+
+// toolProperty is the (unnamed) property type in ollama's tool schema.
+type toolProperty = struct {
+	Type        ollama.PropertyType `json:"type"`
+	Items       any                 `json:"items,omitempty"`
+	Description string              `json:"description"`
+	Enum        []any               `json:"enum,omitempty"`
+}
+
+// toOllama converts the tool to the format sent to the model.
+func (t Tool) toOllama() ollama.Tool {
+	fn := ollama.ToolFunction{
+		Name:        t.Name,
+		Description: t.Description,
+	}
+	fn.Parameters.Type = "object"
+	fn.Parameters.Required = t.Required
+	fn.Parameters.Properties = make(map[string]toolProperty, len(t.Params))
+	for name, p := range t.Params {
+		fn.Parameters.Properties[name] = toolProperty{
+			Type:        []string{p.Type},
+			Description: p.Description,
+		}
+	}
+	return ollama.Tool{
+		Type:     "function",
+		Function: fn,
+	}
+}
+
+// registerTool adds a tool to the agent, replacing any existing tool
+// with the same name. Write tools are skipped in read-only mode.
+func (a *agent) registerTool(t Tool) {
+	if t.Write && a.cfg.ReadOnly {
+		return
+	}
+	a.tools[t.Name] = t
+}
+
+// allowed reports whether the tool allowlist (if there is one)
+// lets the model use a tool.
+func (a *agent) allowed(name string) bool {
+	if a.cfg.AllowedTools == nil {
+		return true
+	}
+	return slices.Contains(a.cfg.AllowedTools, name)
+}
+
+// getTools returns the registered tools the model is allowed to use
+// (and that are ready), in the format sent to the model and sorted by
+// name.
+func (a *agent) getTools(ctx context.Context) []ollama.Tool {
+	names := make([]string, 0, len(a.tools))
+	for name, t := range a.tools {
+		if a.allowed(name) && (t.Ready == nil || t.Ready(ctx)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	ts := make([]ollama.Tool, len(names))
+	for i, name := range names {
+		ts[i] = a.tools[name].toOllama()
+	}
+	return ts
+}
+
+// graphKey is the context key for the client working
+// on the graph of the chat being generated for.
+type graphKey struct{}
+
+// graph returns the client for the graph the tools should work on: the
+// chat's private graph, if the chat being generated for has one, or
+// the shared graph otherwise.
+func (a *agent) graph(ctx context.Context) *client {
+	if g, ok := ctx.Value(graphKey{}).(*client); ok {
+		return g
+	}
+	return a.c
+}
+
+// toolCallKey is the context key for the message
+// whose tool call is being handled.
+type toolCallKey struct{}
+
+// toolCallFrom returns the message whose tool call is being
+// handled, if ctx came from handleToolCall.
+func toolCallFrom(ctx context.Context) (*Message, bool) {
+	m, ok := ctx.Value(toolCallKey{}).(*Message)
+	return m, ok
+}
+
+func (a *agent) handleToolCall(ctx context.Context, m *Message) error {
+	if m.MType != "tool" || m.ToolMsg == nil {
+		return fmt.Errorf("not a tool message")
+	}
+
+	// Mark as handled
+	m.ToolMsg.ToolDone = true
+
+	a.log.Info("handling tool call", "chat", m.ChatID, "tool", m.ToolMsg.ToolName)
+
+	// A tool the model made up? Tell it, so it can try again.
+	t, ok := a.tools[m.ToolMsg.ToolName]
+	if !ok {
+		a.log.Warn("model called an unknown tool", "chat", m.ChatID, "tool", m.ToolMsg.ToolName)
+		m.ToolMsg.ToolError = fmt.Sprintf("unknown tool: %s", m.ToolMsg.ToolName)
+		return a.c.UpdateMessage(*m)
+	}
+
+	// Make sure the model is allowed to use it (it may have
+	// called a tool it was never offered)
+	if !a.allowed(t.Name) {
+		m.ToolMsg.ToolError = fmt.Sprintf("tool %s is not allowed", t.Name)
+		return a.c.UpdateMessage(*m)
+	}
+
+	// Double check we're allowed to make changes
+	if t.Write && a.cfg.ReadOnly {
+		m.ToolMsg.ToolError = fmt.Sprintf("tool %s is disabled in read-only mode", t.Name)
+		return a.c.UpdateMessage(*m)
+	}
+
+	// In dry-run mode, say what would have happened instead
+	if t.Write && a.cfg.DryRun {
+		data, err := json.Marshal(dryRunResult(t, m.ToolMsg.ToolArgs))
+		if err != nil {
+			m.ToolMsg.ToolError = fmt.Sprintf("failed to encode result: %v", err)
+			return a.c.UpdateMessage(*m)
+		}
+		m.ToolMsg.ToolResult = string(data)
+		return a.c.UpdateMessage(*m)
+	}
+
+	// Questions for the user wait for their answer
+	if t.Name == askUserTool {
+		if _, err := argString(m.ToolMsg.ToolArgs, "question"); err != nil {
+			m.ToolMsg.ToolError = err.Error()
+		}
+		return a.c.UpdateMessage(*m)
+	}
+
+	// Destructive calls wait for the user to approve what they'd change
+	if t.Preview != nil && a.cfg.ConfirmDel {
+		preview, err := t.Preview(ctx, m.ToolMsg.ToolArgs)
+		if err != nil {
+			m.ToolMsg.ToolError = err.Error()
+			return a.c.UpdateMessage(*m)
+		}
+		data, err := json.Marshal(preview)
+		if err != nil {
+			m.ToolMsg.ToolError = fmt.Sprintf("failed to encode preview: %v", err)
+			return a.c.UpdateMessage(*m)
+		}
+		m.ToolMsg.ToolPreview = string(data)
+		return a.c.UpdateMessage(*m)
+	}
+	return a.runTool(ctx, t, m)
+}
+
+// runTool runs a tool call's handler and saves the result on m.
+func (a *agent) runTool(ctx context.Context, t Tool, m *Message) error {
+	ctx = context.WithValue(ctx, toolCallKey{}, m)
+	result, err := t.Handler(ctx, m.ToolMsg.ToolArgs)
+	if err != nil {
+		m.ToolMsg.ToolError = err.Error()
+		return a.c.UpdateMessage(*m)
+	}
+
+	// Fill in any environment variables the props refer to
+	if a.cfg.ExpandEnv {
+		if result, err = expandResultEnv(result, os.LookupEnv); err != nil {
+			m.ToolMsg.ToolError = err.Error()
+			return a.c.UpdateMessage(*m)
+		}
+	}
+
+	// Convert result to JSON-encoded string
+	jsonResult, err := json.Marshal(result)
+	if err != nil {
+		m.ToolMsg.ToolError = fmt.Sprintf("failed to encode result: %v", err)
+		return a.c.UpdateMessage(*m)
+	}
+
+	m.ToolMsg.ToolResult = string(jsonResult)
+	return a.c.UpdateMessage(*m)
+}
+
+// dryRunResult is the result given for a write tool in dry-run mode,
+// making it clear to the model that nothing was changed.
+func dryRunResult(t Tool, args map[string]any) map[string]any {
+	return map[string]any{
+		"dry_run": true,
+		"message": fmt.Sprintf("Dry run: %s was not run and nothing was saved. Had it run, it would have been called with the given arguments.", t.Name),
+		"tool":    t.Name,
+		"args":    args,
+	}
+}
+
+// summaryTypes is the most node (and edge) types
+// graph_summary lists, to keep its result small.
+const summaryTypes = 20
+
+// defaultPageSize is the number of results the list tools return
+// when the model doesn't ask for a specific number.
+const defaultPageSize = 50
+
+// argPage reads the optional "limit" and "offset" args.
+func argPage(args map[string]any) (Page, error) {
+	page := Page{Limit: defaultPageSize}
+	var err error
+	if hasArg(args, "limit") {
+		if page.Limit, err = argInt(args, "limit"); err != nil {
+			return Page{}, err
+		}
+		if page.Limit <= 0 {
+			return Page{}, fmt.Errorf("limit must be positive, got %d", page.Limit)
+		}
+	}
+	if hasArg(args, "offset") {
+		if page.Offset, err = argInt(args, "offset"); err != nil {
+			return Page{}, err
+		}
+		if page.Offset < 0 {
+			return Page{}, fmt.Errorf("offset must not be negative, got %d", page.Offset)
+		}
+	}
+	return page, nil
+}
+
+// pageResult wraps a page of results for the model, noting
+// where the next page starts if there are more.
+func pageResult(key string, items any, n int, more bool, page Page) map[string]any {
+	res := map[string]any{key: items}
+	if more {
+		res["more"] = true
+		res["next_offset"] = page.Offset + n
+	}
+	return res
+}
+
+// graphTools returns the tools for reading and editing the graph.
+func (a *agent) graphTools() []Tool {
+	return []Tool{
+		{
+			Name:        "get_node",
+			Description: "Retrieves a single graph node by its ID. Returns the node's ID, type, and properties.",
+			Required:    []string{"id"},
+			Params: map[string]ToolParam{
+				"id": {Type: "integer", Description: "The unique identifier of the node to retrieve."},
+			},
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				id, err := argInt(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				return a.graph(ctx).GetNode(id)
+			},
+		},
+		{
+			Name:        "list_nodes",
+			Description: fmt.Sprintf("Lists graph nodes of a specific type. If no type is provided, lists nodes of every type. Returns at most %d nodes at a time; if there are more, the result includes the offset to pass to get the next page.", defaultPageSize),
+			Params: map[string]ToolParam{
+				"node_type": {Type: "string", Description: "The type of nodes to list. If empty, all nodes will be returned."},
+				"limit":     {Type: "integer", Description: fmt.Sprintf("The maximum number of nodes to return. Defaults to %d.", defaultPageSize)},
+				"offset":    {Type: "integer", Description: "The number of matching nodes to skip. Defaults to 0."},
+			},
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				var nodeType string
+				if hasArg(args, "node_type") {
+					var err error
+					if nodeType, err = argString(args, "node_type"); err != nil {
+						return nil, err
+					}
+				}
+				page, err := argPage(args)
+				if err != nil {
+					return nil, err
+				}
+				g := a.graph(ctx)
+				nodes, more, err := g.ListNodesPage(nodeType, page)
+				if err != nil {
+					return nil, err
+				}
+				if err := g.TouchNodes(nodes); err != nil {
+					return nil, err
+				}
+				return pageResult("nodes", nodes, len(nodes), more, page), nil
+			},
+		},
+		{
+			Name:        "create_node",
+			Description: "Creates a new graph node with the specified type and properties. Returns the created node with its assigned ID.",
+			Required:    []string{"type"},
+			Params: map[string]ToolParam{
+				"type":  {Type: "string", Description: "The type of the node to create. For example, 'person', 'document', etc."},
+				"props": {Type: "object", Description: "A map of properties to store with the node. For example, {\"name\": \"John\", \"age\": 30}."},
+			},
+			Write: true,
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				typ, err := argString(args, "type")
+				if err != nil {
+					return nil, err
+				}
+				var props map[string]any
+				if hasArg(args, "props") {
+					if props, err = argMap(args, "props"); err != nil {
+						return nil, err
+					}
+				}
+				// Record where the node came from
+				var n *GraphNode
+				if m, ok := toolCallFrom(ctx); ok {
+					n, err = a.graph(ctx).CreateNodeFromChat(typ, props, m.ChatID, m.MessageID)
+				} else {
+					n, err = a.graph(ctx).CreateNode(typ, props)
+				}
+				if err != nil {
+					return nil, err
+				}
+				a.embedWritten(ctx, n)
+				return n, nil
+			},
+		},
+		{
+			Name:        "update_node",
+			Description: "Updates a graph node's properties. By default the given properties are merged into the existing ones: each given key is set, a key set to null is removed, and other properties are kept. With merge set to false, the node's properties are replaced entirely. Returns the updated node.",
+			Required:    []string{"id", "props"},
+			Params: map[string]ToolParam{
+				"id":    {Type: "integer", Description: "The unique identifier of the node to update."},
+				"props": {Type: "object", Description: "The properties to set. For example, {\"age\": 31, \"nickname\": null}."},
+				"merge": {Type: "boolean", Description: "Whether to merge the properties into the existing ones (true, the default) or replace them (false)."},
+			},
+			Write: true,
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				id, err := argInt(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				props, err := argMap(args, "props")
+				if err != nil {
+					return nil, err
+				}
+				merge := true
+				if hasArg(args, "merge") {
+					if merge, err = argBool(args, "merge"); err != nil {
+						return nil, err
+					}
+				}
+				var n *GraphNode
+				if merge {
+					n, err = a.graph(ctx).UpdateNode(id, props)
+				} else {
+					n, err = a.graph(ctx).ReplaceNodeProps(id, props)
+				}
+				if err != nil {
+					return nil, err
+				}
+				a.embedWritten(ctx, n)
+				return n, nil
+			},
+		},
+		{
+			Name:        "delete_node",
+			Description: "Deletes a graph node by its ID. Note that this will also delete all edges connected to this node.",
+			Required:    []string{"id"},
+			Params: map[string]ToolParam{
+				"id": {Type: "integer", Description: "The unique identifier of the node to delete."},
+			},
+			Write: true,
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				id, err := argInt(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				if err := a.graph(ctx).DeleteNode(id); err != nil {
+					return nil, err
+				}
+				return map[string]bool{"success": true}, nil
+			},
+			Preview: func(ctx context.Context, args map[string]any) (any, error) {
+				id, err := argInt(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				return a.graph(ctx).PreviewDeleteNode(id)
+			},
+		},
+		{
+			Name:        "node_degree",
+			Description: "Counts the edges connected to a graph node. Returns the number of incoming edges (in) and outgoing edges (out).",
+			Required:    []string{"id"},
+			Params: map[string]ToolParam{
+				"id": {Type: "integer", Description: "The unique identifier of the node to count the edges of."},
+			},
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				id, err := argInt(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				in, out, err := a.graph(ctx).NodeDegree(id)
+				if err != nil {
+					return nil, err
+				}
+				return map[string]int{"in": in, "out": out}, nil
+			},
+		},
+		{
+			Name:        "graph_summary",
+			Description: "Summarizes the graph without listing it: the number of nodes and edges, and the most common node and edge types with their counts. Use it to get oriented before listing or searching.",
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				s, err := a.graph(ctx).GraphStats()
+				if err != nil {
+					return nil, err
+				}
+				return map[string]any{
+					"nodes":      s.Nodes,
+					"edges":      s.Edges,
+					"node_types": topTypes(s.NodeTypes, summaryTypes),
+					"edge_types": topTypes(s.EdgeTypes, summaryTypes),
+				}, nil
+			},
+		},
+		{
+			Name:        "search_nodes",
+			Description: "Searches for the graph nodes most relevant to a query by semantic similarity. Only nodes with stored embeddings are searched. Returns the matching nodes with their similarity scores, most similar first.",
+			Required:    []string{"query"},
+			Params: map[string]ToolParam{
+				"query": {Type: "string", Description: "What to search for, in natural language."},
+				"k":     {Type: "integer", Description: "The maximum number of nodes to return. Defaults to 5."},
+			},
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				query, err := argString(args, "query")
+				if err != nil {
+					return nil, err
+				}
+				k := 5
+				if hasArg(args, "k") {
+					if k, err = argInt(args, "k"); err != nil {
+						return nil, err
+					}
+					if k <= 0 {
+						return nil, fmt.Errorf("k must be positive, got %d", k)
+					}
+				}
+				vec, err := a.embed(ctx, query)
+				if err != nil {
+					return nil, err
+				}
+				g := a.graph(ctx)
+				scored, err := g.SearchNodesByVector(vec, k)
+				if err != nil {
+					return nil, err
+				}
+				nodes := make([]GraphNode, len(scored))
+				for i, s := range scored {
+					nodes[i] = s.Node
+				}
+				if err := g.TouchNodes(nodes); err != nil {
+					return nil, err
+				}
+				for i := range scored {
+					scored[i].Node.LastAccessedAt = nodes[i].LastAccessedAt
+				}
+				return scored, nil
+			},
+			Ready: func(ctx context.Context) bool {
+				ok, err := a.graph(ctx).HasEmbeddings()
+				return err == nil && ok
+			},
+		},
+		{
+			Name:        "graph_query",
+			Description: `Runs a query over the graph and returns the matching nodes or edges. Queries use a small subset of Cypher: MATCH (n:type)-[e:type]->(m:type) WHERE n.key = "value" AND m.key != 3 RETURN m. Types and the edge are optional (e.g. MATCH (n:person) RETURN n). Edges can point either way (<-[...]-) or be undirected (-[...]-). Conditions only support = and != on strings, numbers, true, false, and null.`,
+			Required:    []string{"query"},
+			Params: map[string]ToolParam{
+				"query": {Type: "string", Description: "The query to run."},
+			},
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				q, err := argString(args, "query")
+				if err != nil {
+					return nil, err
+				}
+				g := a.graph(ctx)
+				res, err := g.Query(q)
+				if err != nil {
+					return nil, err
+				}
+				if err := g.TouchNodes(res.Nodes); err != nil {
+					return nil, err
+				}
+				return res, nil
+			},
+		},
+		{
+			Name:        "get_edge",
+			Description: "Retrieves a single graph edge by its ID. Returns the edge's ID, type, and the IDs of its connected nodes.",
+			Required:    []string{"id"},
+			Params: map[string]ToolParam{
+				"id": {Type: "integer", Description: "The unique identifier of the edge to retrieve."},
+			},
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				id, err := argInt(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				return a.graph(ctx).GetEdge(id)
+			},
+		},
+		{
+			Name:        "list_edges",
+			Description: fmt.Sprintf("Lists graph edges based on optional filters. Can filter by edge type, source node ID, and/or target node ID. Returns at most %d edges at a time; if there are more, the result includes the offset to pass to get the next page.", defaultPageSize),
+			Params: map[string]ToolParam{
+				"type":    {Type: "string", Description: "Filter edges by this type. For example, 'knows', 'contains', etc."},
+				"from_id": {Type: "integer", Description: "Filter edges that originate from this node ID."},
+				"to_id":   {Type: "integer", Description: "Filter edges that point to this node ID."},
+				"limit":   {Type: "integer", Description: fmt.Sprintf("The maximum number of edges to return. Defaults to %d.", defaultPageSize)},
+				"offset":  {Type: "integer", Description: "The number of matching edges to skip. Defaults to 0."},
+			},
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				var err error
+				filter := EdgeFilter{}
+				if hasArg(args, "type") {
+					if filter.Type, err = argString(args, "type"); err != nil {
+						return nil, err
+					}
+				}
+				if hasArg(args, "from_id") {
+					if filter.FromID, err = argInt(args, "from_id"); err != nil {
+						return nil, err
+					}
+				}
+				if hasArg(args, "to_id") {
+					if filter.ToID, err = argInt(args, "to_id"); err != nil {
+						return nil, err
+					}
+				}
+				page, err := argPage(args)
+				if err != nil {
+					return nil, err
+				}
+				edges, more, err := a.graph(ctx).ListEdgesPage(filter, page)
+				if err != nil {
+					return nil, err
+				}
+				return pageResult("edges", edges, len(edges), more, page), nil
+			},
+		},
+		{
+			Name:        "create_edge",
+			Description: "Creates a new graph edge connecting two nodes. Specify the edge type and the IDs of the source and target nodes.",
+			Required:    []string{"type", "from_id", "to_id"},
+			Params: map[string]ToolParam{
+				"type":    {Type: "string", Description: "The type of the edge to create. For example, 'knows', 'contains', etc."},
+				"from_id": {Type: "integer", Description: "The ID of the source node where the edge starts."},
+				"to_id":   {Type: "integer", Description: "The ID of the target node where the edge ends."},
+				"props":   {Type: "object", Description: "An optional map of properties to store with the edge. For example, {\"since\": 2020, \"weight\": 0.5}."},
+			},
+			Write: true,
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				typ, err := argString(args, "type")
+				if err != nil {
+					return nil, err
+				}
+				fromID, err := argInt(args, "from_id")
+				if err != nil {
+					return nil, err
+				}
+				toID, err := argInt(args, "to_id")
+				if err != nil {
+					return nil, err
+				}
+				var props map[string]any
+				if hasArg(args, "props") {
+					if props, err = argMap(args, "props"); err != nil {
+						return nil, err
+					}
+				}
+				return a.graph(ctx).CreateEdgeWithProps(typ, fromID, toID, props)
+			},
+		},
+		{
+			Name:        "create_bidirectional_edge",
+			Description: "Creates a pair of graph edges of the same type between two nodes, one in each direction, for symmetric relationships like 'married_to' or 'sibling_of'. Either both edges are created or neither is. Returns both edges.",
+			Required:    []string{"type", "a_id", "b_id"},
+			Params: map[string]ToolParam{
+				"type": {Type: "string", Description: "The type of the edges to create. For example, 'married_to', 'sibling_of', etc."},
+				"a_id": {Type: "integer", Description: "The ID of one of the nodes."},
+				"b_id": {Type: "integer", Description: "The ID of the other node."},
+			},
+			Write: true,
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				typ, err := argString(args, "type")
+				if err != nil {
+					return nil, err
+				}
+				aID, err := argInt(args, "a_id")
+				if err != nil {
+					return nil, err
+				}
+				bID, err := argInt(args, "b_id")
+				if err != nil {
+					return nil, err
+				}
+				ab, ba, err := a.graph(ctx).CreateBidirectionalEdge(typ, aID, bID)
+				if err != nil {
+					return nil, err
+				}
+				return []*GraphEdge{ab, ba}, nil
+			},
+		},
+		{
+			Name:        "delete_edge",
+			Description: "Deletes a graph edge by its ID.",
+			Required:    []string{"id"},
+			Params: map[string]ToolParam{
+				"id": {Type: "integer", Description: "The unique identifier of the edge to delete."},
+			},
+			Write: true,
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				id, err := argInt(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				if err := a.graph(ctx).DeleteEdge(id); err != nil {
+					return nil, err
+				}
+				return map[string]bool{"success": true}, nil
+			},
+			Preview: func(ctx context.Context, args map[string]any) (any, error) {
+				id, err := argInt(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				return a.graph(ctx).PreviewDeleteEdge(id)
+			},
+		},
+	}
+}
+
+// chatTools returns the tools for creating and listing chats. They
+// let the agent start its own conversations, so they're only
+// registered when enabled.
+func (a *agent) chatTools() []Tool {
+	return []Tool{
+		{
+			Name:        "create_chat",
+			Description: "Creates a new, empty chat thread. Returns the chat's info, including its assigned ID.",
+			Required:    []string{"name"},
+			Params: map[string]ToolParam{
+				"name":          {Type: "string", Description: "The name of the chat."},
+				"system_prompt": {Type: "string", Description: "A system prompt for the chat. If empty, the default is used."},
+			},
+			Write: true,
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				name, err := argString(args, "name")
+				if err != nil {
+					return nil, err
+				}
+				var prompt string
+				if hasArg(args, "system_prompt") {
+					if prompt, err = argString(args, "system_prompt"); err != nil {
+						return nil, err
+					}
+				}
+				return a.c.CreateChat(name, prompt)
+			},
+		},
+		{
+			Name:        "list_chats",
+			Description: "Lists every chat thread. Returns each chat's info (ID, name, state, and system prompt).",
+			Handler: func(ctx context.Context, args map[string]any) (any, error) {
+				return a.c.ListChats()
+			},
+		},
+	}
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+// callTool has the agent handle a call to a tool in a chat (on the
+// chat's graph, like generate), returning the saved tool message.
+func callTool(t *testing.T, a *agent, cid int, name string, args map[string]any) Message {
+	t.Helper()
+	g, err := a.c.ForChat(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), graphKey{}, g)
+	resp := toolCall(name, args)
+	m, err := a.c.CreateMessage(Message{
+		ChatID: cid,
+		MType:  "tool",
+		ToolMsg: &struct {
+			ToolDone    bool
+			ToolName    string
+			ToolArgs    map[string]any
+			ToolResult  string
+			ToolError   string
+			ToolPreview string `json:",omitempty"`
+		}{
+			ToolDone: true,
+			ToolName: resp.Message.ToolCalls[0].Function.Name,
+			ToolArgs: resp.Message.ToolCalls[0].Function.Arguments,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.handleToolCall(ctx, m); err != nil {
+		t.Fatalf("failed to handle %s call: %v", name, err)
+	}
+	return *m
+}
+
+// newToolTest creates an agent (with cfg) and a chat to call its
+// tools in, returning the agent and the chat's ID.
+func newToolTest(t *testing.T, cfg agentConfig) (*agent, int) {
+	t.Helper()
+	c := newTestClient(t)
+	a := newTestAgent(t, c, fakeOllama(t, nil), cfg)
+	ci, err := c.CreateChat("tools", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, ci.ID
+}
+
+func TestReadToolsTrackAccess(t *testing.T) {
+	c := testGraph(t)
+	if err := c.SetNodeEmbedding(3, fakeEmbedding("Carol")); err != nil {
+		t.Fatal(err)
+	}
+	url := fakeOllama(t, nil)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		tool string
+		args map[string]any
+		want []int // Nodes left stale
+	}{
+		{"list_nodes", "list_nodes", map[string]any{"node_type": "city"}, []int{1, 2, 3, 5}},
+		{"search_nodes", "search_nodes", map[string]any{"query": "Carol", "k": 1}, []int{1, 2, 4, 5}},
+		{"graph_query", "graph_query", map[string]any{"query": `MATCH (n:person) WHERE n.name = "Bob" RETURN n`}, []int{1, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without tracking, reads don't change anything
+			a := newTestAgent(t, c, url, agentConfig{EmbedModel: "embed"})
+			stale := staleSince(t, c)
+			if _, err := a.tools[tt.tool].Handler(ctx, tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if got := stale(); len(got) != 5 {
+				t.Errorf("untracked %s touched nodes: stale = %v", tt.tool, got)
+			}
+
+			a = newTestAgent(t, trackingClient(c), url, agentConfig{EmbedModel: "embed"})
+			stale = staleSince(t, c)
+			if _, err := a.tools[tt.tool].Handler(ctx, tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if got := stale(); !slices.Equal(slices.Sorted(slices.Values(got)), tt.want) {
+				t.Errorf("stale after %s = %v, want %v", tt.tool, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphSummaryTool(t *testing.T) {
+	c := testGraph(t)
+	a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{})
+	ci, err := c.CreateChat("summary", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := callTool(t, a, ci.ID, "graph_summary", nil)
+	if m.ToolMsg.ToolError != "" {
+		t.Fatalf("graph_summary failed: %s", m.ToolMsg.ToolError)
+	}
+	var got struct {
+		Nodes     int         `json:"nodes"`
+		Edges     int         `json:"edges"`
+		NodeTypes []TypeCount `json:"node_types"`
+		EdgeTypes []TypeCount `json:"edge_types"`
+	}
+	if err := json.Unmarshal([]byte(m.ToolMsg.ToolResult), &got); err != nil {
+		t.Fatalf("failed to decode result %q: %v", m.ToolMsg.ToolResult, err)
+	}
+	if got.Nodes != 5 || got.Edges != 4 {
+		t.Errorf("counts = %d nodes, %d edges; want 5, 4", got.Nodes, got.Edges)
+	}
+	wantNodes := []TypeCount{{"person", 4}, {"city", 1}}
+	if !slices.Equal(got.NodeTypes, wantNodes) {
+		t.Errorf("node_types = %v, want %v", got.NodeTypes, wantNodes)
+	}
+	wantEdges := []TypeCount{{"knows", 3}, {"lives_in", 1}}
+	if !slices.Equal(got.EdgeTypes, wantEdges) {
+		t.Errorf("edge_types = %v, want %v", got.EdgeTypes, wantEdges)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	c := testGraph(t)
+	a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{DryRun: true, ChatTools: true})
+	ci, err := c.CreateChat("dry run", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything the write tools could change
+	type state struct {
+		Nodes []GraphNode
+		Edges []GraphEdge
+		Chats []int // IDs only, since the calls themselves update this chat
+	}
+	snapshot := func() state {
+		t.Helper()
+		var s state
+		var err error
+		if s.Nodes, err = c.ListNodes(""); err != nil {
+			t.Fatal(err)
+		}
+		if s.Edges, err = c.ListEdges(EdgeFilter{}); err != nil {
+			t.Fatal(err)
+		}
+		chats, err := c.ListChats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ci := range chats {
+			s.Chats = append(s.Chats, ci.ID)
+		}
+		return s
+	}
+	before := snapshot()
+
+	tests := []struct {
+		tool string
+		args map[string]any
+	}{
+		{"create_node", map[string]any{"type": "city", "props": map[string]any{"name": "Rome"}}},
+		{"update_node", map[string]any{"id": 1, "props": map[string]any{"name": "Alicia"}}},
+		{"delete_node", map[string]any{"id": 2}},
+		{"create_edge", map[string]any{"type": "knows", "from_id": 3, "to_id": 5}},
+		{"create_bidirectional_edge", map[string]any{"type": "knows", "a_id": 2, "b_id": 5}},
+		{"delete_edge", map[string]any{"id": 1}},
+		{"create_chat", map[string]any{"name": "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tool, func(t *testing.T) {
+			m := callTool(t, a, ci.ID, tt.tool, tt.args)
+			if m.ToolMsg.ToolError != "" {
+				t.Fatalf("%s failed: %s", tt.tool, m.ToolMsg.ToolError)
+			}
+			var res struct {
+				DryRun bool   `json:"dry_run"`
+				Tool   string `json:"tool"`
+			}
+			if err := json.Unmarshal([]byte(m.ToolMsg.ToolResult), &res); err != nil {
+				t.Fatal(err)
+			}
+			if !res.DryRun || res.Tool != tt.tool {
+				t.Errorf("result = %s, want it marked as a dry run of %s", m.ToolMsg.ToolResult, tt.tool)
+			}
+			if after := snapshot(); !reflect.DeepEqual(after, before) {
+				t.Errorf("%s changed the database", tt.tool)
+			}
+		})
+	}
+
+	// Read tools still run
+	m := callTool(t, a, ci.ID, "get_node", map[string]any{"id": 1})
+	var n GraphNode
+	if err := json.Unmarshal([]byte(m.ToolMsg.ToolResult), &n); err != nil {
+		t.Fatalf("failed to decode get_node result %q: %v", m.ToolMsg.ToolResult, err)
+	}
+	if n.Props["name"] != "Alice" {
+		t.Errorf("get_node = %+v, want Alice", n)
+	}
+}
+
+func TestAllowedTools(t *testing.T) {
+	c := testGraph(t)
+	allowed := []string{"create_node", "get_node", "list_nodes"}
+	a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{AllowedTools: allowed})
+	ci, err := c.CreateChat("allowed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the allowed tools are offered
+	var names []string
+	for _, tool := range a.getTools(context.Background()) {
+		names = append(names, tool.Function.Name)
+	}
+	if !slices.Equal(names, allowed) {
+		t.Errorf("offered tools = %v, want %v", names, allowed)
+	}
+
+	// And others are refused, even if the model calls them anyway
+	m := callTool(t, a, ci.ID, "delete_node", map[string]any{"id": 2})
+	if want := "tool delete_node is not allowed"; m.ToolMsg.ToolError != want {
+		t.Errorf("error = %q, want %q", m.ToolMsg.ToolError, want)
+	}
+	if _, err := c.GetNode(2); err != nil {
+		t.Errorf("disallowed delete_node ran: %v", err)
+	}
+	if m := callTool(t, a, ci.ID, "get_node", map[string]any{"id": 2}); m.ToolMsg.ToolError != "" {
+		t.Errorf("allowed get_node failed: %s", m.ToolMsg.ToolError)
+	}
+
+	// Allowing a tool that doesn't exist is a mistake
+	cfg := agentConfig{BaseURL: fakeOllama(t, nil), AllowedTools: []string{"drop_graph"}}
+	if _, err := newAgent(context.Background(), c, cfg, a.log); err == nil {
+		t.Error("newAgent() allowed an unknown tool")
+	}
+}
+
+func TestUpdateNodeTool(t *testing.T) {
+	a, cid := newToolTest(t, agentConfig{})
+	n, err := a.c.CreateNode("person", map[string]any{"name": "Alice", "city": "Paris"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args map[string]any
+		want map[string]any
+	}{
+		{"merges by default", map[string]any{"props": map[string]any{"city": nil, "job": "chef"}}, map[string]any{"name": "Alice", "job": "chef"}},
+		{"replaces without merge", map[string]any{"props": map[string]any{"name": "Al"}, "merge": false}, map[string]any{"name": "Al"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.args["id"] = n.ID
+			m := callTool(t, a, cid, "update_node", tt.args)
+			if m.ToolMsg.ToolError != "" {
+				t.Fatalf("update_node failed: %s", m.ToolMsg.ToolError)
+			}
+			got, err := a.c.GetNode(n.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got.Props, tt.want) {
+				t.Errorf("props = %v, want %v", got.Props, tt.want)
+			}
+		})
+	}
+}
+
+func TestListToolsPage(t *testing.T) {
+	c := testGraph(t)
+	a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{})
+	ci, err := c.CreateChat("pages", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		tool    string
+		args    map[string]any
+		wantIDs []int
+		next    int // The next page's offset (0 if there are no more)
+		wantErr string
+	}{
+		{"first nodes", "list_nodes", map[string]any{"limit": 2}, []int{1, 2}, 2, ""},
+		{"more nodes", "list_nodes", map[string]any{"limit": 2, "offset": 2}, []int{3, 4}, 4, ""},
+		{"last nodes", "list_nodes", map[string]any{"limit": 2, "offset": 4}, []int{5}, 0, ""},
+		{"default page", "list_nodes", map[string]any{"node_type": "person"}, []int{1, 2, 3, 5}, 0, ""},
+		{"first edges", "list_edges", map[string]any{"limit": 3}, []int{1, 2, 3}, 3, ""},
+		{"bad limit", "list_nodes", map[string]any{"limit": 0}, nil, 0, "limit must be positive"},
+		{"bad offset", "list_edges", map[string]any{"offset": -1}, nil, 0, "offset must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := callTool(t, a, ci.ID, tt.tool, tt.args)
+			if tt.wantErr != "" {
+				if !strings.Contains(m.ToolMsg.ToolError, tt.wantErr) {
+					t.Errorf("error = %q, want %q", m.ToolMsg.ToolError, tt.wantErr)
+				}
+				return
+			}
+			if m.ToolMsg.ToolError != "" {
+				t.Fatalf("%s failed: %s", tt.tool, m.ToolMsg.ToolError)
+			}
+			var res struct {
+				Nodes      []GraphNode `json:"nodes"`
+				Edges      []GraphEdge `json:"edges"`
+				More       bool        `json:"more"`
+				NextOffset int         `json:"next_offset"`
+			}
+			if err := json.Unmarshal([]byte(m.ToolMsg.ToolResult), &res); err != nil {
+				t.Fatal(err)
+			}
+			ids := append(nodeIDs(res.Nodes), edgeIDs(res.Edges)...)
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("IDs = %v, want %v", ids, tt.wantIDs)
+			}
+			if res.More != (tt.next != 0) || res.NextOffset != tt.next {
+				t.Errorf("more = %v, next_offset = %d; want next_offset %d", res.More, res.NextOffset, tt.next)
+			}
+		})
+	}
+}
+
+func TestCreateBidirectionalEdgeTool(t *testing.T) {
+	a, cid := newToolTest(t, agentConfig{})
+	for _, name := range []string{"Alice", "Bob"} {
+		if _, err := a.c.CreateNode("person", map[string]any{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := callTool(t, a, cid, "create_bidirectional_edge", map[string]any{"type": "married_to", "a_id": 1, "b_id": 2})
+	if m.ToolMsg.ToolError != "" {
+		t.Fatalf("create_bidirectional_edge failed: %s", m.ToolMsg.ToolError)
+	}
+	var es []GraphEdge
+	if err := json.Unmarshal([]byte(m.ToolMsg.ToolResult), &es); err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 || es[0].FromID != 1 || es[0].ToID != 2 || es[1].FromID != 2 || es[1].ToID != 1 {
+		t.Errorf("result = %s, want edges 1->2 and 2->1", m.ToolMsg.ToolResult)
+	}
+
+	m = callTool(t, a, cid, "create_bidirectional_edge", map[string]any{"type": "married_to", "a_id": 1, "b_id": 1})
+	if m.ToolMsg.ToolError == "" {
+		t.Error("create_bidirectional_edge linked a node to itself")
+	}
+}
+
+func TestNodeSource(t *testing.T) {
+	c := newTestClient(t)
+	a := newTestAgent(t, c, fakeOllama(t, nil), agentConfig{})
+	var chats []int
+	for _, name := range []string{"first", "second"} {
+		ci, err := c.CreateChat(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		chats = append(chats, ci.ID)
+	}
+
+	// Nodes made by the agent record the chat and message they came from
+	var calls []Message
+	for _, cid := range []int{chats[0], chats[1], chats[0]} {
+		calls = append(calls, callTool(t, a, cid, "create_node", map[string]any{"type": "note"}))
+	}
+	// Others don't
+	if _, err := c.CreateNode("note", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err := c.ListNodes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range calls {
+		if n := ns[i]; n.SourceChatID != m.ChatID || n.SourceMessageID != m.MessageID {
+			t.Errorf("node %d came from chat %d message %d, want chat %d message %d", n.ID, n.SourceChatID, n.SourceMessageID, m.ChatID, m.MessageID)
+		}
+	}
+	if n := ns[3]; n.SourceChatID != 0 || n.SourceMessageID != 0 {
+		t.Errorf("node %d made outside a chat has a source: chat %d message %d", n.ID, n.SourceChatID, n.SourceMessageID)
+	}
+
+	for _, tt := range []struct {
+		cid  int
+		want []int
+	}{
+		{chats[0], []int{1, 3}},
+		{chats[1], []int{2}},
+		{99, []int{}},
+	} {
+		ns, err := c.ListNodesByChat(tt.cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := nodeIDs(ns); !slices.Equal(got, tt.want) {
+			t.Errorf("ListNodesByChat(%d) = %v, want %v", tt.cid, got, tt.want)
+		}
+	}
+}
+
+func TestChatTools(t *testing.T) {
+	// They're only offered when enabled
+	a, _ := newToolTest(t, agentConfig{})
+	for _, name := range []string{"create_chat", "list_chats"} {
+		if _, ok := a.tools[name]; ok {
+			t.Errorf("%s is registered without ChatTools", name)
+		}
+	}
+
+	a, cid := newToolTest(t, agentConfig{ChatTools: true})
+	m := callTool(t, a, cid, "create_chat", map[string]any{"name": "research", "system_prompt": "Be thorough."})
+	if m.ToolMsg.ToolError != "" {
+		t.Fatalf("create_chat failed: %s", m.ToolMsg.ToolError)
+	}
+	var created ChatInfo
+	if err := json.Unmarshal([]byte(m.ToolMsg.ToolResult), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == 0 || created.Name != "research" || created.SystemPrompt != "Be thorough." {
+		t.Errorf("created %+v, want the research chat", created)
+	}
+
+	m = callTool(t, a, cid, "list_chats", nil)
+	var chats []ChatInfo
+	if err := json.Unmarshal([]byte(m.ToolMsg.ToolResult), &chats); err != nil {
+		t.Fatalf("failed to decode list_chats result %q: %v", m.ToolMsg.ToolResult, err)
+	}
+	var names []string
+	for _, ci := range chats {
+		names = append(names, ci.Name)
+	}
+	if want := []string{"tools", "research"}; !slices.Equal(names, want) {
+		t.Errorf("list_chats = %v, want %v", names, want)
+	}
+
+	m = callTool(t, a, cid, "create_chat", map[string]any{})
+	if m.ToolMsg.ToolError == "" {
+		t.Error("create_chat without a name succeeded")
+	}
+}
+
+func TestCreateEdgeToolProps(t *testing.T) {
+	a, cid := newToolTest(t, agentConfig{})
+	for _, name := range []string{"Alice", "Bob"} {
+		if _, err := a.c.CreateNode("person", map[string]any{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Props are optional, and passed through when given
+	tests := []struct {
+		args map[string]any
+		want map[string]any
+	}{
+		{map[string]any{"type": "knows", "from_id": 1, "to_id": 2}, nil},
+		{map[string]any{"type": "knows", "from_id": 1, "to_id": 2, "props": map[string]any{"since": 2020, "weight": 0.5}}, map[string]any{"since": 2020.0, "weight": 0.5}},
+	}
+	for _, tt := range tests {
+		m := callTool(t, a, cid, "create_edge", tt.args)
+		if m.ToolMsg.ToolError != "" {
+			t.Fatalf("create_edge failed: %s", m.ToolMsg.ToolError)
+		}
+		var e GraphEdge
+		if err := json.Unmarshal([]byte(m.ToolMsg.ToolResult), &e); err != nil {
+			t.Fatal(err)
+		}
+		got, err := a.c.GetEdge(e.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Props, tt.want) {
+			t.Errorf("create_edge with %v stored props %v, want %v", tt.args, got.Props, tt.want)
+		}
+	}
+
+	m := callTool(t, a, cid, "create_edge", map[string]any{"type": "knows", "from_id": 1, "to_id": 2, "props": "since 2020"})
+	if m.ToolMsg.ToolError == "" {
+		t.Error("create_edge accepted props that aren't an object")
+	}
+}
+
+func TestRegisterTool(t *testing.T) {
+	a, cid := newToolTest(t, agentConfig{})
+	var got map[string]any
+	a.registerTool(Tool{
+		Name:        "echo",
+		Description: "Echo a message back.",
+		Required:    []string{"text"},
+		Params:      map[string]ToolParam{"text": {Type: "string", Description: "What to echo."}},
+		Handler: func(ctx context.Context, args map[string]any) (any, error) {
+			got = args
+			text, err := argString(args, "text")
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"echo": text}, nil
+		},
+	})
+
+	// It's advertised to the model alongside the built-in tools
+	var echo *ollama.Tool
+	var names []string
+	for _, tool := range a.getTools(context.Background()) {
+		names = append(names, tool.Function.Name)
+		if tool.Function.Name == "echo" {
+			echo = &tool
+		}
+	}
+	if echo == nil {
+		t.Fatalf("getTools() = %v, want echo", names)
+	}
+	if !slices.Contains(names, "get_node") {
+		t.Errorf("getTools() = %v, want the graph tools too", names)
+	}
+	fn := echo.Function
+	if fn.Description != "Echo a message back." || !slices.Equal(fn.Parameters.Required, []string{"text"}) || fn.Parameters.Properties["text"].Description != "What to echo." {
+		t.Errorf("echo is advertised as %+v", fn)
+	}
+
+	// And calls to it are dispatched to its handler
+	m := callTool(t, a, cid, "echo", map[string]any{"text": "hello"})
+	if m.ToolMsg.ToolError != "" {
+		t.Fatalf("echo failed: %s", m.ToolMsg.ToolError)
+	}
+	if got["text"] != "hello" {
+		t.Errorf("handler got args %v", got)
+	}
+	if want := `{"echo":"hello"}`; m.ToolMsg.ToolResult != want {
+		t.Errorf("echo result = %s, want %s", m.ToolMsg.ToolResult, want)
+	}
+
+	// Handler errors go back to the model
+	m = callTool(t, a, cid, "echo", map[string]any{})
+	if m.ToolMsg.ToolError == "" {
+		t.Error("echo without text didn't fail")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	// Find the write tools of a normal agent
+	rw, _ := newToolTest(t, agentConfig{ChatTools: true})
+	var writes []string
+	for name, tool := range rw.tools {
+		if tool.Write {
+			writes = append(writes, name)
+		}
+	}
+	if len(writes) == 0 {
+		t.Fatal("found no write tools")
+	}
+
+	// In read-only mode they're not offered, but the read tools are
+	a, cid := newToolTest(t, agentConfig{ReadOnly: true, ChatTools: true})
+	var names []string
+	for _, tool := range a.getTools(context.Background()) {
+		names = append(names, tool.Function.Name)
+	}
+	for _, name := range writes {
+		if slices.Contains(names, name) {
+			t.Errorf("read-only agent offers write tool %s", name)
+		}
+	}
+	for _, name := range []string{"get_node", "list_nodes", "get_edge", "list_edges", "node_degree", "graph_query", "graph_summary", "list_chats"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("read-only agent doesn't offer %s", name)
+		}
+	}
+
+	// Nor can they be called
+	m := callTool(t, a, cid, "create_node", map[string]any{"type": "person"})
+	if m.ToolMsg.ToolError == "" {
+		t.Error("read-only agent created a node")
+	}
+
+	// Even if one gets registered some other way
+	a.tools["create_node"] = rw.tools["create_node"]
+	m = callTool(t, a, cid, "create_node", map[string]any{"type": "person"})
+	if !strings.Contains(m.ToolMsg.ToolError, "read-only") {
+		t.Errorf("read-only agent ran a write tool: error = %q", m.ToolMsg.ToolError)
+	}
+	ns, err := a.c.ListNodes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 0 {
+		t.Errorf("read-only agent created nodes %v", ns)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Txn is a single transaction on the graph, handed to the function
+// passed to Batch. Its methods work like the client's, but everything
+// done through them is committed together, or not at all.
+//
+// A Txn is only valid until the function it was passed to returns.
+type Txn struct {
+	c  *client
+	tx *bolt.Tx
+}
+
+// Batch runs fn in a single read-write transaction. If fn (or any step
+// in it) returns an error, none of its changes are kept. Don't call the
+// client's own methods from fn; they'd wait on this transaction.
+func (c *client) Batch(fn func(tx *Txn) error) error {
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		return fn(&Txn{c: c, tx: tx})
+	}); err != nil {
+		return fmt.Errorf("failed to run batch: %w", err)
+	}
+	return nil
+}
+
+// GetNode retrieves a node by its ID.
+func (t *Txn) GetNode(id int) (*GraphNode, error) {
+	return t.c.getNode(t.tx, id)
+}
+
+// CreateNode adds a new node.
+func (t *Txn) CreateNode(nodeType string, props map[string]any) (*GraphNode, error) {
+	return t.c.createNode(t.tx, GraphNode{Type: nodeType, Props: props})
+}
+
+// UpdateNode merges props into a node's properties (see client.UpdateNode).
+func (t *Txn) UpdateNode(id int, props map[string]any) (*GraphNode, error) {
+	return t.c.editNode(t.tx, id, mergeProps(props))
+}
+
+// DeleteNode removes a node, along with its embedding and edges.
+func (t *Txn) DeleteNode(id int) error {
+	return t.c.deleteNode(t.tx, id)
+}
+
+// GetEdge retrieves an edge by its ID.
+func (t *Txn) GetEdge(id int) (*GraphEdge, error) {
+	return t.c.getEdge(t.tx, id)
+}
+
+// CreateEdge adds a new edge (with optional properties) between two
+// nodes, which may have been created earlier in the same batch.
+func (t *Txn) CreateEdge(edgeType string, fromID, toID int, props map[string]any) (*GraphEdge, error) {
+	return t.c.createEdge(t.tx, edgeType, fromID, toID, props)
+}
+
+// UpdateEdge changes an edge's type (unless edgeType is
+// empty) and replaces its properties.
+func (t *Txn) UpdateEdge(id int, edgeType string, props map[string]any) (*GraphEdge, error) {
+	return t.c.editEdge(t.tx, id, edgeType, props)
+}
+
+// DeleteEdge removes an edge.
+func (t *Txn) DeleteEdge(id int) error {
+	return t.c.deleteEdge(t.tx, id)
+}
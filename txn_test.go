@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	errStop := errors.New("stop")
+	tests := []struct {
+		name    string
+		fn      func(tx *Txn) error
+		wantErr bool
+	}{
+		{"all steps succeed", func(tx *Txn) error {
+			n, err := tx.CreateNode("person", map[string]any{"name": "Dave"})
+			if err != nil {
+				return err
+			}
+			if _, err := tx.CreateEdge("knows", 1, n.ID, nil); err != nil {
+				return err
+			}
+			_, err = tx.UpdateNode(2, map[string]any{"age": 26})
+			return err
+		}, false},
+		{"the function fails", func(tx *Txn) error {
+			if _, err := tx.CreateNode("person", nil); err != nil {
+				return err
+			}
+			if err := tx.DeleteNode(1); err != nil {
+				return err
+			}
+			return errStop
+		}, true},
+		{"a later step fails", func(tx *Txn) error {
+			n, err := tx.CreateNode("person", nil)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.CreateEdge("knows", n.ID, 2, nil); err != nil {
+				return err
+			}
+			if err := tx.DeleteEdge(1); err != nil {
+				return err
+			}
+			_, err = tx.CreateEdge("knows", n.ID, 42, nil) // No such node
+			return err
+		}, true},
+		{"updating a missing node fails", func(tx *Txn) error {
+			if _, err := tx.UpdateNode(1, map[string]any{"name": "Alicia"}); err != nil {
+				return err
+			}
+			_, err := tx.UpdateNode(42, map[string]any{"name": "Nobody"})
+			return err
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testGraph(t)
+			before := graphState(t, c)
+
+			err := c.Batch(tt.fn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Batch() err = %v, want error %v", err, tt.wantErr)
+			}
+			after := graphState(t, c)
+			if tt.wantErr && after != before {
+				t.Errorf("failed batch left changes:\n%s\nwant\n%s", after, before)
+			}
+			if !tt.wantErr && after == before {
+				t.Error("batch didn't change anything")
+			}
+		})
+	}
+}
+
+func TestBatchSeesItsOwnWrites(t *testing.T) {
+	c := testGraph(t)
+	if err := c.Batch(func(tx *Txn) error {
+		n, err := tx.CreateNode("person", map[string]any{"name": "Dave"})
+		if err != nil {
+			return err
+		}
+		got, err := tx.GetNode(n.ID)
+		if err != nil {
+			return err
+		}
+		if got.Props["name"] != "Dave" {
+			t.Errorf("read back %v, want Dave", got.Props)
+		}
+		e, err := tx.CreateEdge("knows", n.ID, 1, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.UpdateEdge(e.ID, "likes", map[string]any{"since": 2020}); err != nil {
+			return err
+		}
+		ge, err := tx.GetEdge(e.ID)
+		if err != nil {
+			return err
+		}
+		if ge.Type != "likes" {
+			t.Errorf("edge type = %q, want likes", ge.Type)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// withColor has lipgloss render colors for the rest of the test,
+// as it would in a color terminal.
+func withColor(t *testing.T) {
+	t.Helper()
+	old := lipgloss.ColorProfile()
+	lipgloss.SetColorProfile(termenv.TrueColor)
+	t.Cleanup(func() { lipgloss.SetColorProfile(old) })
+}
+
+// maxLineWidth returns the width of the widest line in s.
+func maxLineWidth(s string) int {
+	var w int
+	for _, l := range strings.Split(s, "\n") {
+		w = max(w, lipgloss.Width(strings.TrimRight(l, " ")))
+	}
+	return w
+}
+
+func TestWrap(t *testing.T) {
+	long := "https://example.com/" + strings.Repeat("a", 40)
+	text := "see " + long + " for more"
+
+	// Hard wrapping breaks up the long token to fit
+	hard := uiConfig{}.wrap(text, 20)
+	if w := maxLineWidth(hard); w > 20 {
+		t.Errorf("hard wrapped lines are %d wide, want at most 20:\n%s", w, hard)
+	}
+	if got := strings.Join(strings.Fields(hard), ""); got != strings.Join(strings.Fields(text), "") {
+		t.Errorf("hard wrapping lost text: %q", hard)
+	}
+
+	// Soft wrapping keeps it whole, on its own line
+	soft := uiConfig{SoftWrap: true}.wrap(text, 20)
+	want := "see\n" + long + "\nfor more"
+	if soft != want {
+		t.Errorf("soft wrap = %q, want %q", soft, want)
+	}
+
+	// Text that fits is left alone either way
+	for _, ui := range []uiConfig{{}, {SoftWrap: true}} {
+		if got := ui.wrap("short words", 20); got != "short words" {
+			t.Errorf("wrap (soft %v) = %q, want it unchanged", ui.SoftWrap, got)
+		}
+	}
+}
+
+func TestWrapMargin(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("wrap", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addUserMessage(t, c, ci.ID, `{"props":"`+strings.Repeat("x", 200)+`"}`)
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{Plain: true, WrapMargin: 10})
+
+	// The messages are rewrapped to fit whenever the window changes size
+	for _, width := range []int{80, 40} {
+		m.Update(tea.WindowSizeMsg{Width: width, Height: 30})
+		if w := maxLineWidth(m.vp.View()); w != width-10 {
+			t.Errorf("at width %d, lines are %d wide, want %d", width, w, width-10)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	custom := map[string]string{"user": "me"}
+	tests := []struct {
+		name  string
+		ui    uiConfig
+		mtype string
+		want  string
+	}{
+		{"emoji by default", uiConfig{}, "user", "👨‍💻: "},
+		{"agent", uiConfig{}, "agent", "🤖: "},
+		{"plain user", uiConfig{Plain: true}, "user", "You: "},
+		{"plain agent", uiConfig{Plain: true}, "agent", "Agent: "},
+		{"plain tool", uiConfig{Plain: true}, "tool", "Tool: "},
+		{"plain unknown type", uiConfig{Plain: true}, "bogus", "Warning: "},
+		{"custom", uiConfig{RolePrefixes: custom}, "user", "me: "},
+		{"custom beats plain", uiConfig{Plain: true, RolePrefixes: custom}, "user", "me: "},
+		{"custom falls back", uiConfig{RolePrefixes: custom}, "agent", "🤖: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ui.prefix(tt.mtype); got != tt.want {
+				t.Errorf("prefix(%q) = %q, want %q", tt.mtype, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlainColor(t *testing.T) {
+	withColor(t)
+	if got := (uiConfig{}).color("#E74C3C").Render("error"); !strings.Contains(got, "\x1b[") {
+		t.Errorf("color() = %q, want it colored", got)
+	}
+	if got := (uiConfig{Plain: true}).color("#E74C3C").Render("error"); got != "error" {
+		t.Errorf("plain color() = %q, want no styling", got)
+	}
+}
+
+func TestPlainMode(t *testing.T) {
+	withColor(t)
+	c := newTestClient(t)
+	ci, err := c.CreateChat("plain", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "uae", 1)
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, uiConfig{Plain: true})
+	view := m.vp.View()
+	for _, want := range []string{"You: hi", "[pinned] Agent: hello", "Error: Failed to generate a response: oops"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view is missing %q:\n%s", want, view)
+		}
+	}
+	if strings.Contains(view, "\x1b[") {
+		t.Errorf("plain view is styled: %q", view)
+	}
+	for _, p := range defaultRolePrefixes {
+		if strings.Contains(view, p) {
+			t.Errorf("plain view has the emoji %q", p)
+		}
+	}
+}
+
+func TestValidateUIConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		ui      uiConfig
+		wantErr string
+	}{
+		{"defaults", uiConfig{}, ""},
+		{"custom prefixes", uiConfig{RolePrefixes: map[string]string{"user": "🧑", "agent": "✨"}}, ""},
+		{"as wide as allowed", uiConfig{RolePrefixes: map[string]string{"agent": strings.Repeat("a", maxPrefixWidth)}}, ""},
+		{"too wide", uiConfig{RolePrefixes: map[string]string{"agent": strings.Repeat("a", maxPrefixWidth+1)}}, "13 cells wide, the most is 12"},
+		{"wide characters count twice", uiConfig{RolePrefixes: map[string]string{"user": strings.Repeat("🧑", 7)}}, "14 cells wide"},
+		{"unknown role", uiConfig{RolePrefixes: map[string]string{"assistant": "A"}}, `unknown role "assistant"`},
+		{"negative margin", uiConfig{WrapMargin: -1}, "wrap margin must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.ui.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCustomPrefixes(t *testing.T) {
+	c := newTestClient(t)
+	ci, err := c.CreateChat("prefixes", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedMessages(t, c, ci.ID, "ua")
+	ui := uiConfig{RolePrefixes: map[string]string{"user": "🧑", "agent": "✨"}}
+	m := newTestModel(t, c, fakeOllama(t, nil), ci.ID, ui)
+	view := m.vp.View()
+	for _, want := range []string{"🧑: hi", "✨: hello"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view is missing %q:\n%s", want, view)
+		}
+	}
+	if strings.Contains(view, defaultRolePrefixes["user"]) {
+		t.Errorf("view still has the default user prefix:\n%s", view)
+	}
+}
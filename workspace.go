@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultWorkspace is the workspace kept in the original database file.
+const defaultWorkspace = "default"
+
+// workspaceName matches the names workspaces can have.
+var workspaceName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// workspaceDBFile returns the name of the database file for a
+// workspace. The default workspace (or none) uses the original file, so
+// existing data stays where it is; others get their own "<name>.db".
+func workspaceDBFile(ws string) (string, error) {
+	if ws == "" || ws == defaultWorkspace {
+		return dbFile, nil
+	}
+	if !workspaceName.MatchString(ws) {
+		return "", fmt.Errorf("invalid workspace name %q: use only letters, numbers, - and _", ws)
+	}
+	if ws+".db" == dbFile {
+		return "", fmt.Errorf("invalid workspace name %q: reserved", ws)
+	}
+	return ws + ".db", nil
+}
+
+// listWorkspaces returns the names of the workspaces with databases
+// in the data directory, sorted.
+func listWorkspaces(d string) ([]string, error) {
+	es, err := os.ReadDir(d)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+	var ws []string
+	for _, e := range es {
+		name := e.Name()
+		if e.IsDir() || filepath.Ext(name) != ".db" {
+			continue
+		}
+		if name == dbFile {
+			ws = append(ws, defaultWorkspace)
+			continue
+		}
+		if n := strings.TrimSuffix(name, ".db"); workspaceName.MatchString(n) {
+			ws = append(ws, n)
+		}
+	}
+	sort.Strings(ws)
+	return ws, nil
+}
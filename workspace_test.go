@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWorkspaceDBFile(t *testing.T) {
+	tests := []struct {
+		ws      string
+		want    string
+		wantErr bool
+	}{
+		{"", dbFile, false},
+		{defaultWorkspace, dbFile, false},
+		{"work", "work.db", false},
+		{"side-project_2", "side-project_2.db", false},
+		{"../escape", "", true},
+		{"has space", "", true},
+		{"a/b", "", true},
+		{".hidden", "", true},
+		{dbFile[:len(dbFile)-len(".db")], "", true},
+	}
+	for _, tt := range tests {
+		got, err := workspaceDBFile(tt.ws)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("workspaceDBFile(%q) error = %v, want error: %v", tt.ws, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("workspaceDBFile(%q) = %q, want %q", tt.ws, got, tt.want)
+		}
+	}
+}
+
+func TestWorkspaceIsolation(t *testing.T) {
+	d := t.TempDir()
+	open := func(ws string) *client {
+		t.Helper()
+		c, err := newClient(context.Background(), d, clientConfig{Workspace: ws})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	// Each workspace gets its own chats and nodes
+	work, personal := open("work"), open("personal")
+	if _, err := work.CreateChat("standup", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := work.CreateNode("project", map[string]any{"name": "agnt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := personal.CreateNode("person", map[string]any{"name": "Mum"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		c         *client
+		wantChats []string
+		wantNodes []string
+	}{
+		{"work", work, []string{"standup"}, []string{"project"}},
+		{"personal", personal, nil, []string{"person"}},
+	} {
+		chats, err := tt.c.ListChats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, ci := range chats {
+			names = append(names, ci.Name)
+		}
+		if !slices.Equal(names, tt.wantChats) {
+			t.Errorf("%s chats = %v, want %v", tt.name, names, tt.wantChats)
+		}
+		ns, err := tt.c.ListNodes("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		for _, n := range ns {
+			types = append(types, n.Type)
+		}
+		if !slices.Equal(types, tt.wantNodes) {
+			t.Errorf("%s nodes = %v, want %v", tt.name, types, tt.wantNodes)
+		}
+	}
+
+	// The default workspace is the original database
+	def := open("")
+	if ns, err := def.ListNodes(""); err != nil || len(ns) != 0 {
+		t.Errorf("default workspace nodes = %v, %v; want none", ns, err)
+	}
+	if _, err := os.Stat(filepath.Join(d, dbFile)); err != nil {
+		t.Errorf("default workspace isn't in %s: %v", dbFile, err)
+	}
+
+	ws, err := listWorkspaces(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{defaultWorkspace, "personal", "work"}; !slices.Equal(ws, want) {
+		t.Errorf("listWorkspaces() = %v, want %v", ws, want)
+	}
+}